@@ -0,0 +1,125 @@
+package cmd
+
+/* Copyright © 2023 Judson Stevens oss@judsonstevens.dev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+with the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or significant portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"go-steg/cli/helpers"
+	"go-steg/go_steg/steganalysis"
+
+	"github.com/spf13/cobra"
+)
+
+var analyzeFileNames []string
+var analyzeJSON bool
+
+// analyzedImage pairs an image file name with its steganalysis report for output
+type analyzedImage struct {
+	File string
+	steganalysis.Report
+}
+
+// analyzeCmd represents the analyze command
+var analyzeCmd = &cobra.Command{
+	Use:   "analyze -c [image_files...]",
+	Short: "Estimate how detectable the data hidden in a photo or group of photos is",
+	Long: `Given a single or list of photos, run the chi-square attack, RS analysis and sample pair analysis on
+every channel and print the embedding rate each one estimates: the fraction of samples whose low bit looks
+like it carries data. Run it on encoded carriers to see which bit depth and mask settings stay below what
+these attacks can detect, and on the original carriers to see what a clean photo scores. The coefficients of
+baseline JPEGs are checked with the chi-square attack as well.
+Example:
+go-steg analyze -c [image_files...] --json`,
+	Run: func(cmd *cobra.Command, args []string) {
+		analyzed := make([]analyzedImage, 0, len(analyzeFileNames))
+		for _, name := range analyzeFileNames {
+			err := helpers.ValidateIsValidFile(name)
+			if err != nil {
+				panic(err)
+			}
+			file, err := os.Open(name)
+			if err != nil {
+				panic(err)
+			}
+			report, err := steganalysis.Analyze(file)
+			file.Close()
+			if err != nil {
+				panic(err)
+			}
+			analyzed = append(analyzed, analyzedImage{File: name, Report: report})
+		}
+
+		if analyzeJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(analyzed); err != nil {
+				panic(err)
+			}
+			return
+		}
+
+		for i, a := range analyzed {
+			if i > 0 {
+				fmt.Println()
+			}
+			printAnalysis(a)
+		}
+	},
+}
+
+// printAnalysis prints one image's report as a table with a row per channel
+func printAnalysis(a analyzedImage) {
+	fmt.Printf("%s (%dx%d)\n", a.File, a.Width, a.Height)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CHANNEL\tCHI-SQUARE P\tCHI-SQUARE RATE\tRS RATE\tSPA RATE\tESTIMATED RATE")
+	for _, c := range a.Channels {
+		fmt.Fprintf(w, "%s\t%.3f\t%.2f\t%.3f\t%.3f\t%.3f\n", c.Channel, c.ChiSquareProbability, c.ChiSquareRate, c.RSRate, c.SamplePairRate, c.EmbeddingRate)
+	}
+	if c := a.Coefficients; c != nil {
+		fmt.Fprintf(w, "DCT\t%.3f\t%.2f\t-\t-\t-\n", c.ChiSquareProbability, c.ChiSquareRate)
+	}
+	if err := w.Flush(); err != nil {
+		panic(err)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(analyzeCmd)
+
+	analyzeCmd.PersistentFlags().StringSliceVarP(
+		&analyzeFileNames,
+		"carrierFileNames",
+		"c",
+		[]string{},
+		"A single name, or a comma separate list of names, of the image file(s) to analyze")
+	err := analyzeCmd.MarkPersistentFlagRequired("carrierFileNames")
+	if err != nil {
+		panic(err)
+	}
+
+	analyzeCmd.PersistentFlags().BoolVar(&analyzeJSON, "json", false,
+		"Print the reports as JSON instead of tables")
+}
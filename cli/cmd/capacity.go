@@ -0,0 +1,213 @@
+package cmd
+
+/* Copyright © 2023 Judson Stevens oss@judsonstevens.dev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+with the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or significant portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"go-steg/cli/helpers"
+	"go-steg/go_steg/image_processing"
+	"go-steg/go_steg/pipeline"
+	"go-steg/go_steg/reed_solomon"
+
+	"github.com/spf13/cobra"
+)
+
+var capacityCarrierFileNames []string
+var capacityEmbedFileName string
+var capacityPassword string
+var capacityUseMask bool
+var capacityMaskDensity uint8
+var capacityBitDepth int
+var capacityHuffman bool
+var capacityRS bool
+var capacityRSLevel string
+var capacityEncrypt bool
+var capacityUseAlpha bool
+var capacityMatrixEmbedding bool
+var capacityJPEGNative bool
+var capacityAdaptive bool
+var capacityAdaptiveLow uint8
+var capacityAdaptiveHigh uint8
+
+// capacityCmd represents the capacity command
+var capacityCmd = &cobra.Command{
+	Use:   "capacity -c [carrier_files...] -p [password] -u",
+	Short: "Show how much data a carrier photo or group of photos can hold",
+	Long: `Given a single or list of "carrier" photos and the settings you intend to encode with, print the
+number of payload bytes each carrier can hold. The mask is applied at the chosen bit depth, so the numbers
+are exact. With the mask they are an upper bound: they are for the password's mask candidate that selects the
+most channels, while encode takes the first candidate that holds the payload. When an embed file is given, the pipeline is run on it and the result says whether it fits.
+Example:
+go-steg capacity -c [carrier_files...] -p [password] -u -b 2 --rs -e [embed_file]`,
+	Run: func(cmd *cobra.Command, args []string) {
+		for _, fileName := range capacityCarrierFileNames {
+			err := helpers.ValidateIsValidFile(fileName)
+			if err != nil {
+				panic(err)
+			}
+		}
+
+		if capacityBitDepth < 1 || capacityBitDepth > 4 {
+			panic("bitDepth must be between 1 and 4")
+		}
+		if capacityMatrixEmbedding && capacityBitDepth != 1 {
+			panic("--matrix needs bitDepth 1")
+		}
+
+		if capacityMaskDensity > 6 {
+			panic("--maskDensity must be between 0 and 6")
+		}
+
+		rsLevelVal := reed_solomon.Standard
+		if capacityRSLevel == "high" {
+			rsLevelVal = reed_solomon.High
+		}
+
+		opts := image_processing.Options{
+			UseMask:            capacityUseMask,
+			MaskDensity:        capacityMaskDensity,
+			UseAlpha:           capacityUseAlpha,
+			MatrixEmbedding:    capacityMatrixEmbedding,
+			JPEGNative:         capacityJPEGNative,
+			Adaptive:           capacityAdaptive,
+			AdaptiveThresholds: [2]uint8{capacityAdaptiveLow, capacityAdaptiveHigh},
+			Config: pipeline.Config{
+				BitDepth:       capacityBitDepth,
+				HuffmanEnabled: capacityHuffman,
+				RSEnabled:      capacityRS,
+				RSLevel:        rsLevelVal,
+				Encrypted:      capacityEncrypt,
+				Password:       capacityPassword,
+			},
+		}
+
+		carriers := make([]io.Reader, 0, len(capacityCarrierFileNames))
+		for _, name := range capacityCarrierFileNames {
+			carrier, err := os.Open(name)
+			if err != nil {
+				panic(err)
+			}
+			defer carrier.Close()
+			carriers = append(carriers, carrier)
+		}
+
+		capacities, err := image_processing.Capacity(carriers, capacityPassword, opts)
+		if err != nil {
+			panic(err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "CARRIER\tSIZE\tSLOTS\tBYTES")
+		var total int64
+		for i, c := range capacities {
+			fmt.Fprintf(w, "%s\t%dx%d\t%d\t%d\n", capacityCarrierFileNames[i], c.Width, c.Height, c.Slots, c.Bytes)
+			total += c.Bytes
+		}
+		fmt.Fprintf(w, "TOTAL\t\t\t%d\n", total)
+		if err := w.Flush(); err != nil {
+			panic(err)
+		}
+		if capacityUseMask && len(capacities) > 0 {
+			fmt.Printf("Measured with mask candidate %d\n", capacities[0].MaskCandidate)
+		}
+
+		if capacityEmbedFileName == "" {
+			return
+		}
+		// encode streams the embed file through the pipeline, so measure it the same way
+		data, err := os.Open(capacityEmbedFileName)
+		if err != nil {
+			panic(err)
+		}
+		defer data.Close()
+		sizes, err := pipeline.MeasureSizes(data, opts.Config)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("\n%s: %d bytes, %d bytes after the pipeline\n", capacityEmbedFileName, sizes.Data, sizes.Output)
+		if image_processing.Fits(capacities, sizes.Output) {
+			fmt.Println("The embed file fits in these carriers")
+		} else {
+			fmt.Println("The embed file does NOT fit in these carriers")
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(capacityCmd)
+
+	capacityCmd.PersistentFlags().StringSliceVarP(
+		&capacityCarrierFileNames,
+		"carrierFileNames",
+		"c",
+		[]string{},
+		"A single name, or a comma separate list of names, of the carrier file(s) to measure")
+	err := capacityCmd.MarkPersistentFlagRequired("carrierFileNames")
+	if err != nil {
+		panic(err)
+	}
+
+	capacityCmd.PersistentFlags().StringVarP(
+		&capacityPassword,
+		"password",
+		"p",
+		"",
+		"The password the carrier file(s) will be encoded with. It decides which channels the mask selects")
+
+	capacityCmd.PersistentFlags().StringVarP(
+		&capacityEmbedFileName,
+		"embedFileName",
+		"e",
+		"",
+		"Optional file to check against the capacity of the carrier file(s)")
+
+	capacityCmd.PersistentFlags().BoolVarP(&capacityUseMask, "useMask", "u", false,
+		"Measure with the discernability mask applied")
+	capacityCmd.PersistentFlags().Uint8Var(&capacityMaskDensity, "maskDensity", 0,
+		"Measure with the mask selecting this many eighths of the channel values (1-6)")
+	capacityCmd.PersistentFlags().IntVarP(&capacityBitDepth, "bitDepth", "b", 2,
+		"Bits per channel (1-4)")
+	capacityCmd.PersistentFlags().BoolVar(&capacityHuffman, "huffman", false,
+		"Account for Huffman compression of the embed file")
+	capacityCmd.PersistentFlags().BoolVar(&capacityRS, "rs", false,
+		"Account for Reed-Solomon error correction of the embed file")
+	capacityCmd.PersistentFlags().StringVar(&capacityRSLevel, "rsLevel", "standard",
+		"RS redundancy level: 'standard' (~14%) or 'high' (~34%)")
+	capacityCmd.PersistentFlags().BoolVar(&capacityEncrypt, "encrypt", false,
+		"Account for encryption of the embed file")
+	capacityCmd.PersistentFlags().BoolVar(&capacityUseAlpha, "alpha", false,
+		"Measure with the alpha channel of nearly opaque pixels carrying payload too (bit depth 2 or less)")
+	capacityCmd.PersistentFlags().BoolVar(&capacityMatrixEmbedding, "matrix", false,
+		"Measure for matrix embedding, which holds the most at the Hamming code's smallest group (needs bitDepth 1)")
+	capacityCmd.PersistentFlags().BoolVar(&capacityJPEGNative, "jpegNative", false,
+		"Measure JPEG carriers by the DCT coefficients that can carry payload")
+	capacityCmd.PersistentFlags().BoolVar(&capacityAdaptive, "adaptive", false,
+		"Measure with adaptive embedding, which only uses textured regions")
+	capacityCmd.PersistentFlags().Uint8Var(&capacityAdaptiveLow, "adaptiveLow", 8,
+		"Texture level (0-255) at which a channel starts to carry one bit with --adaptive")
+	capacityCmd.PersistentFlags().Uint8Var(&capacityAdaptiveHigh, "adaptiveHigh", 32,
+		"Texture level (0-255) at which a channel carries the full bit depth with --adaptive")
+}
@@ -31,6 +31,8 @@ import (
 var decodeCarrierFileNames []string
 var decodePassword string
 var decodeOutputFileDir string
+var decodeUseMask bool
+var decodeStealthHeader bool
 
 // decodeCmd represents the decode command
 var decodeCmd = &cobra.Command{
@@ -38,7 +40,8 @@ var decodeCmd = &cobra.Command{
 	Short: "Decode a single or multiple carrier photos to produce the embed photo",
 	Long: `Given one more more "carrier" photos and a password, decode the hidden information in
 the carrier photos to produce the "embed" photo. The password will be used to regenerate the mask
-and decode the information from the carrier photos.`,
+and decode the information from the carrier photos. Carriers encoded with --stealthHeader are
+recognised by their password-keyed header.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		err := helpers.ValidateIsValidDirectory(decodeOutputFileDir)
 		if err != nil {
@@ -52,7 +55,9 @@ and decode the information from the carrier photos.`,
 			}
 		}
 
-		err = image_processing.MultiCarrierDecodeByFileNames(decodeCarrierFileNames, decodePassword, decodeOutputFileDir)
+		opts := image_processing.Options{UseMask: decodeUseMask, StealthHeader: decodeStealthHeader}
+
+		err = image_processing.MultiCarrierDecodeByFileNames(decodeCarrierFileNames, decodePassword, decodeOutputFileDir, opts)
 		if err != nil {
 			panic(err)
 		}
@@ -102,15 +107,15 @@ func init() {
 		panic(err)
 	}
 
-	encodeCmd.PersistentFlags().BoolVarP(
-		&helpers.UseMask,
+	decodeCmd.PersistentFlags().BoolVarP(
+		&decodeUseMask,
 		"useMask",
 		"u",
 		false,
-		"Use a discernability mask to embed the embed file into the carrier file(s)")
-	err = encodeCmd.MarkPersistentFlagRequired("useMask")
-	if err != nil {
-		panic(err)
+		"Apply the discernability mask when decoding. Mask usage is read from the carrier header, so this is "+
+			"only needed for carriers encoded before it was recorded there")
 
-	}
+	decodeCmd.PersistentFlags().BoolVar(&decodeStealthHeader, "stealthHeader", false,
+		"Fail when the carriers hold no stealth header for the password instead of reading the header in column 0. "+
+			"Stealth headers are found without it")
 }
@@ -22,6 +22,7 @@ THE SOFTWARE.
 */
 
 import (
+	"fmt"
 	"path/filepath"
 	"strings"
 
@@ -41,6 +42,18 @@ var bitDepth int
 var huffmanEnabled bool
 var rsEnabled bool
 var rsLevel string
+var encrypt bool
+var useMask bool
+var maskDensity uint8
+var scatter bool
+var useAlpha bool
+var jpegNative bool
+var stealthHeader bool
+var lsbMatching bool
+var matrixEmbedding bool
+var adaptive bool
+var adaptiveLow uint8
+var adaptiveHigh uint8
 
 // encodeCmd represents the encode command
 var encodeCmd = &cobra.Command{
@@ -49,8 +62,8 @@ var encodeCmd = &cobra.Command{
 	Long: `Given an "embed" photo, a single or list of "carrier" photos, and a password,
 embed the "embed" photo into the "carrier" photo(s) and output the resulting altered files with the mask information.
 This method will use the passed in password to attempt to generate a mask to use to secure the embedded information.
-Depending on the mask generated, we may need to generate a new mask to use to secure the embedded information,
-as the size of embed information may be larger than the mask can handle.
+The password yields a series of mask candidates. When the first one selects too few channels for the embed
+information, the next candidate is tried, and the one used is recorded in the carrier headers for decoding.
 Example:
 go-steg encode -e [embed_file] -c [carrier_files...] -p [password] -o [output_dir] -u`,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -71,6 +84,15 @@ go-steg encode -e [embed_file] -c [carrier_files...] -p [password] -o [output_di
 		if bitDepth < 1 || bitDepth > 4 {
 			panic("bitDepth must be between 1 and 4")
 		}
+		if matrixEmbedding && bitDepth != 1 {
+			panic("--matrix needs bitDepth 1")
+		}
+		if maskDensity > 6 {
+			panic("--maskDensity must be between 0 and 6")
+		}
+		if adaptive && adaptiveLow > adaptiveHigh {
+			panic("--adaptiveLow must not be above --adaptiveHigh")
+		}
 
 		ext := strings.TrimPrefix(filepath.Ext(embedFileName), ".")
 
@@ -84,12 +106,33 @@ go-steg encode -e [embed_file] -c [carrier_files...] -p [password] -o [output_di
 			HuffmanEnabled: huffmanEnabled,
 			RSEnabled:      rsEnabled,
 			RSLevel:        rsLevelVal,
+			Encrypted:      encrypt,
 			FileExtension:  ext,
 			Password:       password,
 		}
 
+		opts := image_processing.Options{
+			UseMask:            useMask,
+			MaskDensity:        maskDensity,
+			Scatter:            scatter,
+			UseAlpha:           useAlpha,
+			LSBMatching:        lsbMatching,
+			MatrixEmbedding:    matrixEmbedding,
+			Adaptive:           adaptive,
+			AdaptiveThresholds: [2]uint8{adaptiveLow, adaptiveHigh},
+			JPEGNative:         jpegNative,
+			StealthHeader:      stealthHeader,
+			Config:             cfg,
+		}
+
+		photoID, err := image_processing.NewPhotoID()
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("Photo ID for this carrier set: %d\n", photoID)
+
 		err = image_processing.EncodeByFileNames(
-			carrierFileNames, embedFileName, 1, password, encodeOutputFileDir, cfg)
+			carrierFileNames, embedFileName, photoID, password, encodeOutputFileDir, opts)
 		if err != nil {
 			panic(err)
 		}
@@ -146,7 +189,7 @@ func init() {
 	}
 
 	encodeCmd.PersistentFlags().BoolVarP(
-		&helpers.UseMask,
+		&useMask,
 		"useMask",
 		"u",
 		false,
@@ -170,4 +213,26 @@ func init() {
 		"Enable Reed-Solomon error correction")
 	encodeCmd.PersistentFlags().StringVar(&rsLevel, "rsLevel", "standard",
 		"RS redundancy level: 'standard' (~14%) or 'high' (~34%)")
+	encodeCmd.PersistentFlags().BoolVar(&encrypt, "encrypt", false,
+		"Encrypt the payload with AES-256-GCM using a key derived from the password")
+	encodeCmd.PersistentFlags().Uint8Var(&maskDensity, "maskDensity", 0,
+		"Eighths of the channel values the mask selects (1-6), 0 for the password's default of about a quarter or three quarters")
+	encodeCmd.PersistentFlags().BoolVar(&scatter, "scatter", false,
+		"Spread the payload over the whole carrier in a password-derived order instead of filling columns left to right")
+	encodeCmd.PersistentFlags().BoolVar(&useAlpha, "alpha", false,
+		"Also embed in the alpha channel of nearly opaque pixels, for up to a third more capacity (bit depth 2 or less)")
+	encodeCmd.PersistentFlags().BoolVar(&lsbMatching, "lsbMatching", false,
+		"Move each channel up or down by one instead of overwriting its low bits, which defeats chi-square and RS steganalysis")
+	encodeCmd.PersistentFlags().BoolVar(&matrixEmbedding, "matrix", false,
+		"Hide the payload with a Hamming code that changes fewer channels, using the spare capacity (needs bitDepth 1)")
+	encodeCmd.PersistentFlags().BoolVar(&adaptive, "adaptive", false,
+		"Embed only in textured regions: none in smooth ones, one bit in moderately textured ones and bitDepth bits in busy ones")
+	encodeCmd.PersistentFlags().Uint8Var(&adaptiveLow, "adaptiveLow", 8,
+		"Texture level (0-255) at which a channel starts to carry one bit with --adaptive")
+	encodeCmd.PersistentFlags().Uint8Var(&adaptiveHigh, "adaptiveHigh", 32,
+		"Texture level (0-255) at which a channel carries the full bit depth with --adaptive")
+	encodeCmd.PersistentFlags().BoolVar(&jpegNative, "jpegNative", false,
+		"Embed JPEG carriers in their DCT coefficients and write them back as JPEGs instead of PNGs (bit depth 1 recommended)")
+	encodeCmd.PersistentFlags().BoolVar(&stealthHeader, "stealthHeader", false,
+		"Hide the header whitened at password-derived places instead of in column 0, leaving no fixed marker (not with --jpegNative)")
 }
@@ -0,0 +1,155 @@
+package cmd
+
+/* Copyright © 2023 Judson Stevens oss@judsonstevens.dev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+with the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or significant portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+
+	"go-steg/cli/helpers"
+	"go-steg/go_steg/image_processing"
+
+	"github.com/spf13/cobra"
+)
+
+var inspectCarrierFileNames []string
+var inspectPassword string
+var inspectJSON bool
+
+// inspectedCarrier pairs a carrier file name with its header report for output
+type inspectedCarrier struct {
+	File string
+	image_processing.HeaderReport
+}
+
+// inspectCmd represents the inspect command
+var inspectCmd = &cobra.Command{
+	Use:   "inspect -c [carrier_files...]",
+	Short: "Print the header found in a carrier photo or group of photos",
+	Long: `Given a single or list of "carrier" photos, print every field of the header read from each one and
+flag anything inconsistent, such as an unknown version marker or a data count larger than the carrier can
+hold. This is the place to start when a decode fails. With a password the mask is applied when counting the
+slots of a masked carrier.
+Example:
+go-steg inspect -c [carrier_files...] --json`,
+	Run: func(cmd *cobra.Command, args []string) {
+		inspected := make([]inspectedCarrier, 0, len(inspectCarrierFileNames))
+		for _, name := range inspectCarrierFileNames {
+			err := helpers.ValidateIsValidFile(name)
+			if err != nil {
+				panic(err)
+			}
+			carrier, err := os.Open(name)
+			if err != nil {
+				panic(err)
+			}
+			report, err := image_processing.InspectCarrier(carrier, inspectPassword)
+			carrier.Close()
+			if err != nil {
+				panic(err)
+			}
+			inspected = append(inspected, inspectedCarrier{File: name, HeaderReport: report})
+		}
+
+		if inspectJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(inspected); err != nil {
+				panic(err)
+			}
+			return
+		}
+
+		for i, c := range inspected {
+			if i > 0 {
+				fmt.Println()
+			}
+			printHeaderReport(c)
+		}
+	},
+}
+
+// printHeaderReport prints one carrier's header as a FIELD/VALUE table followed by its problems
+func printHeaderReport(c inspectedCarrier) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "CARRIER\t%s\n", c.File)
+	fmt.Fprintf(w, "Size\t%dx%d\n", c.Width, c.Height)
+	fmt.Fprintf(w, "Model\t%v\n", c.Model)
+	fmt.Fprintf(w, "VersionMarker\t%v\n", c.VersionMarker)
+	fmt.Fprintf(w, "Slots\t%d\n", c.Slots)
+
+	// Print the header fields by reflection so new fields show up without changes here
+	header := reflect.ValueOf(c.Header)
+	for i := 0; i < header.NumField(); i++ {
+		name := header.Type().Field(i).Name
+		if name == "HeaderDamage" {
+			// Listed with the problems below
+			continue
+		}
+		value := header.Field(i).Interface()
+		if strings.HasSuffix(name, "CRC32") {
+			value = fmt.Sprintf("0x%08x", value)
+		}
+		fmt.Fprintf(w, "%s\t%v\n", name, value)
+	}
+	if err := w.Flush(); err != nil {
+		panic(err)
+	}
+
+	if len(c.Problems) == 0 {
+		fmt.Println("No problems found")
+		return
+	}
+	fmt.Println("Problems:")
+	for _, problem := range c.Problems {
+		fmt.Printf("  - %s\n", problem)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(inspectCmd)
+
+	inspectCmd.PersistentFlags().StringSliceVarP(
+		&inspectCarrierFileNames,
+		"carrierFileNames",
+		"c",
+		[]string{},
+		"A single name, or a comma separate list of names, of the carrier file(s) to inspect")
+	err := inspectCmd.MarkPersistentFlagRequired("carrierFileNames")
+	if err != nil {
+		panic(err)
+	}
+
+	inspectCmd.PersistentFlags().StringVarP(
+		&inspectPassword,
+		"password",
+		"p",
+		"",
+		"Optional password the carrier file(s) were encoded with, used to count the slots of masked carriers")
+
+	inspectCmd.PersistentFlags().BoolVar(&inspectJSON, "json", false,
+		"Print the headers as JSON instead of tables")
+}
@@ -6,8 +6,6 @@ import (
 	"os"
 )
 
-var UseMask bool
-
 // ValidateIsValidDirectory checks if the directory path is valid and exists
 func ValidateIsValidDirectory(directoryPath string) error {
 	fmt.Println("Validating directory path: ", directoryPath)
@@ -46,6 +46,22 @@ func GetLastNBits(b byte, n int) byte {
 	return b & byte((1<<n)-1)
 }
 
+// ClearLastNBits16 clears the last n bits of a 16-bit sample.
+func ClearLastNBits16(sample uint16, n int) uint16 {
+	return sample & (uint16(0xFFFF) << n)
+}
+
+// SetLastNBits16 clears the last n bits of a 16-bit sample and then sets them to valueToSet. Only the low
+// byte of a 16-bit sample is ever written, so n is at most 8.
+func SetLastNBits16(sample uint16, valueToSet byte, n int) uint16 {
+	return ClearLastNBits16(sample, n) | uint16(valueToSet)
+}
+
+// GetLastNBits16 returns the last n bits of a 16-bit sample, with n at most 8.
+func GetLastNBits16(sample uint16, n int) byte {
+	return byte(sample & ((1 << n) - 1))
+}
+
 // SplitByte splits a byte into chunks of bitsPerChunk bits, MSB-first.
 // For bitsPerChunk=3, produces 3 chunks (3+3+2 bits — last chunk has only 2 remaining bits).
 func SplitByte(b byte, bitsPerChunk int) []byte {
@@ -142,6 +158,20 @@ func ReturnMaskDifferenceN(maskInt int32, multiplier int32, firstIndex int16, se
 	return shiftedDataByte == 1 && secondShiftedDataByte == 1
 }
 
+// ReturnMaskDensitySelection reports whether a channel value is selected by a mask that picks roughly
+// eighths/8 of all values. The value's last bitDepth bits are cleared, as in ReturnMaskDifferenceN, and the
+// rest is mixed with maskInt and multiplier into a 64-bit hash whose top 3 bits are compared with eighths. Unlike
+// the index pair rule, whose ratio is fixed at about a quarter or three quarters, this lets the caller choose it.
+func ReturnMaskDensitySelection(maskInt int32, multiplier int32, colorInt uint8, bitDepth int, eighths uint8) bool {
+	hash := uint64(uint32(maskInt))<<32 | uint64(uint32(multiplier))
+	hash ^= uint64(ClearLastNBits(colorInt, bitDepth)) * 0x9e3779b97f4a7c15
+	// splitmix64 finalizer
+	hash = (hash ^ hash>>30) * 0xbf58476d1ce4e5b9
+	hash = (hash ^ hash>>27) * 0x94d049bb133111eb
+	hash ^= hash >> 31
+	return hash>>61 < uint64(eighths)
+}
+
 // ReturnMaskDifference will take in two bytes and identify if the data should be read or not by determining
 // if the data byte indexes are different from the mask byte indexes.
 //
@@ -468,6 +468,73 @@ func TestGetLastNBits(t *testing.T) {
 	}
 }
 
+func TestClearLastNBits16(t *testing.T) {
+	tests := []struct {
+		name   string
+		sample uint16
+		n      int
+		want   uint16
+	}{
+		{"clear 1 bit from 65535", 65535, 1, 65534},
+		{"clear 2 bits from 65535", 65535, 2, 65532},
+		{"clear 4 bits from 65535", 65535, 4, 65520},
+		{"clear 8 bits from 65535", 65535, 8, 65280},
+		{"clear 2 bits keeps the high byte", 0xAB03, 2, 0xAB00},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClearLastNBits16(tt.sample, tt.n); got != tt.want {
+				t.Errorf("ClearLastNBits16(%d, %d) = %d, want %d", tt.sample, tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetLastNBits16(t *testing.T) {
+	tests := []struct {
+		name       string
+		sample     uint16
+		valueToSet byte
+		n          int
+		want       uint16
+	}{
+		{"set 2 bits: 0 with 3", 0, 3, 2, 3},
+		{"set 2 bits: 65535 with 2", 65535, 2, 2, 65534},
+		{"set 3 bits: 0x1200 with 5", 0x1200, 5, 3, 0x1205},
+		{"set 4 bits: 65535 with 10", 65535, 10, 4, 65530},
+		{"set 8 bits: 0xAB00 with 0xCD", 0xAB00, 0xCD, 8, 0xABCD},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SetLastNBits16(tt.sample, tt.valueToSet, tt.n); got != tt.want {
+				t.Errorf("SetLastNBits16(%d, %d, %d) = %d, want %d", tt.sample, tt.valueToSet, tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetLastNBits16(t *testing.T) {
+	tests := []struct {
+		name   string
+		sample uint16
+		n      int
+		want   byte
+	}{
+		{"get 2 bits from 65535", 65535, 2, 3},
+		{"get 1 bit from 0x0100", 0x0100, 1, 0},
+		{"get 3 bits from 0xFF05", 0xFF05, 3, 5},
+		{"get 4 bits from 0x12AA", 0x12AA, 4, 10},
+		{"get 8 bits from 0x12AB", 0x12AB, 8, 0xAB},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GetLastNBits16(tt.sample, tt.n); got != tt.want {
+				t.Errorf("GetLastNBits16(%d, %d) = %d, want %d", tt.sample, tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
 func TestConstructByteFromQuartersAsSlice(t *testing.T) {
 	type args struct {
 		b []byte
@@ -685,3 +752,22 @@ func TestConstructByteFromQuarters(t *testing.T) {
 		})
 	}
 }
+
+func TestReturnMaskDensitySelection(t *testing.T) {
+	for eighths := uint8(1); eighths <= 6; eighths++ {
+		selected := 0
+		for colorInt := 0; colorInt < 256; colorInt++ {
+			if ReturnMaskDensitySelection(1234567, 7654321, uint8(colorInt), 1, eighths) {
+				selected++
+			}
+		}
+		// 128 distinct values at bit depth 1; allow for the spread of a hash
+		want := 128 * int(eighths) / 8
+		if selected/2 < want-20 || selected/2 > want+20 {
+			t.Errorf("%d/8: %d of 128 values selected, want about %d", eighths, selected/2, want)
+		}
+		if ReturnMaskDensitySelection(1234567, 7654321, 0xFE, 1, eighths) != ReturnMaskDensitySelection(1234567, 7654321, 0xFF, 1, eighths) {
+			t.Errorf("%d/8: the selection depends on the low bit", eighths)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	formatVersion = 1
+	saltLen       = 16
+	nonceLen      = 12
+	keyLen        = 32 // AES-256
+	// KDFIterations is the PBKDF2-SHA256 work factor used for new payloads. The count is stored with
+	// each payload, so raising it later does not break decoding of older carriers.
+	KDFIterations = 600_000
+	// maxKDFIterations bounds the count read back from a payload so a corrupted value cannot stall decoding
+	maxKDFIterations = 10_000_000
+	// headerLen is version(1) + iterations(4) + salt + nonce
+	headerLen = 1 + 4 + saltLen + nonceLen
+)
+
+// Overhead is the number of bytes Encrypt adds to the plaintext (envelope header plus GCM tag).
+const Overhead = headerLen + 16
+
+// ErrAuthenticationFailed is returned when the ciphertext was tampered with or the password is wrong.
+var ErrAuthenticationFailed = errors.New("encryption: authentication failed (wrong password or corrupted data)")
+
+// Encrypt seals data with AES-256-GCM using a key derived from the password with PBKDF2-SHA256.
+// A fresh random salt and nonce are generated on every call.
+// Format: [1-byte version][4-byte LE iterations][16-byte salt][12-byte nonce][ciphertext + 16-byte tag]
+func Encrypt(data []byte, password string) ([]byte, error) {
+	if password == "" {
+		return nil, errors.New("encryption: a password is required")
+	}
+
+	output := make([]byte, headerLen, headerLen+len(data)+16)
+	output[0] = formatVersion
+	binary.LittleEndian.PutUint32(output[1:5], KDFIterations)
+	salt := output[5 : 5+saltLen]
+	nonce := output[5+saltLen : headerLen]
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("encryption: generating salt: %w", err)
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("encryption: generating nonce: %w", err)
+	}
+
+	gcm, err := newGCM(password, salt, KDFIterations)
+	if err != nil {
+		return nil, err
+	}
+
+	// The envelope header is passed as additional data so the version and KDF parameters are authenticated too
+	return gcm.Seal(output, nonce, data, output[:headerLen]), nil
+}
+
+// Decrypt reverses Encrypt, returning ErrAuthenticationFailed if the tag does not verify. Envelopes
+// written by a streaming Writer are accepted as well.
+func Decrypt(data []byte, password string) ([]byte, error) {
+	if len(data) > 0 && data[0] == streamFormatVersion {
+		return io.ReadAll(NewReader(bytes.NewReader(data), password))
+	}
+	if len(data) < Overhead {
+		return nil, fmt.Errorf("encryption: data too short (%d bytes, need at least %d)", len(data), Overhead)
+	}
+	if data[0] != formatVersion {
+		return nil, fmt.Errorf("encryption: unsupported format version %d", data[0])
+	}
+
+	iterations := int(binary.LittleEndian.Uint32(data[1:5]))
+	if iterations < 1 || iterations > maxKDFIterations {
+		return nil, errors.New("encryption: invalid KDF iteration count")
+	}
+	salt := data[5 : 5+saltLen]
+	nonce := data[5+saltLen : headerLen]
+
+	gcm, err := newGCM(password, salt, iterations)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := gcm.Open(nil, nonce, data[headerLen:], data[:headerLen])
+	if err != nil {
+		return nil, ErrAuthenticationFailed
+	}
+	return plaintext, nil
+}
+
+// newGCM derives the AES key from the password and salt and wraps it in GCM mode.
+func newGCM(password string, salt []byte, iterations int) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, keyLen)
+	if err != nil {
+		return nil, fmt.Errorf("encryption: deriving key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("encryption: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("encryption: %w", err)
+	}
+	return gcm, nil
+}
@@ -0,0 +1,67 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestEncryptDecryptRoundtrip(t *testing.T) {
+	data := []byte("Hello, authenticated encryption!")
+	sealed, err := Encrypt(data, "password")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if len(sealed) != len(data)+Overhead {
+		t.Errorf("sealed length = %d, want %d", len(sealed), len(data)+Overhead)
+	}
+	if bytes.Contains(sealed, data) {
+		t.Error("sealed output contains the plaintext")
+	}
+
+	opened, err := Decrypt(sealed, "password")
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if !bytes.Equal(opened, data) {
+		t.Errorf("roundtrip failed: got %q, want %q", opened, data)
+	}
+}
+
+func TestDecryptWrongPassword(t *testing.T) {
+	sealed, err := Encrypt([]byte("secret"), "right")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	_, err = Decrypt(sealed, "wrong")
+	if !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("expected ErrAuthenticationFailed, got %v", err)
+	}
+}
+
+func TestDecryptTamperedCiphertext(t *testing.T) {
+	sealed, err := Encrypt([]byte("tamper with me"), "password")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	sealed[len(sealed)-20] ^= 0x01
+	_, err = Decrypt(sealed, "password")
+	if !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("expected ErrAuthenticationFailed, got %v", err)
+	}
+}
+
+func TestEncryptRandomSaltAndNonce(t *testing.T) {
+	data := []byte("same input twice")
+	a, err := Encrypt(data, "password")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	b, err := Encrypt(data, "password")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if bytes.Equal(a, b) {
+		t.Error("two encryptions of the same data produced identical output")
+	}
+}
@@ -0,0 +1,247 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	streamFormatVersion = 2
+	// StreamChunkSize is the amount of plaintext sealed in each chunk of the streaming format
+	StreamChunkSize = 64 * 1024
+	noncePrefixLen  = 7
+	tagLen          = 16
+	// streamHeaderLen is version(1) + iterations(4) + salt + nonce prefix + chunk size(4)
+	streamHeaderLen = 1 + 4 + saltLen + noncePrefixLen + 4
+)
+
+// StreamLen returns the length of the streaming envelope for size bytes of plaintext.
+func StreamLen(size int64) int64 {
+	return streamHeaderLen + (size/StreamChunkSize+1)*tagLen + size
+}
+
+// streamNonce builds the nonce for one chunk: the random prefix, the chunk counter and a final-chunk flag.
+// The flag stops a stream from being cut at a chunk boundary without detection.
+func streamNonce(prefix []byte, counter uint32, final bool) []byte {
+	nonce := make([]byte, 0, nonceLen)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, counter)
+	if final {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+// Writer encrypts a stream in fixed-size chunks so neither side has to hold the whole payload.
+// Format: [1-byte version 2][4-byte LE iterations][16-byte salt][7-byte nonce prefix][4-byte LE chunk size]
+// followed by chunks of [ciphertext + 16-byte tag]. Every chunk but the last holds a full chunk of plaintext;
+// the last one is flagged as final in its nonce and may be empty.
+type Writer struct {
+	w       io.Writer
+	gcm     cipher.AEAD
+	header  []byte
+	prefix  []byte
+	counter uint32
+	buf     []byte
+	sealed  []byte
+	closed  bool
+}
+
+// NewWriter writes the envelope header to w and returns a Writer that encrypts everything written to it.
+// Close must be called to seal the final chunk.
+func NewWriter(w io.Writer, password string) (*Writer, error) {
+	if password == "" {
+		return nil, errors.New("encryption: a password is required")
+	}
+
+	header := make([]byte, streamHeaderLen)
+	header[0] = streamFormatVersion
+	binary.LittleEndian.PutUint32(header[1:5], KDFIterations)
+	salt := header[5 : 5+saltLen]
+	prefix := header[5+saltLen : 5+saltLen+noncePrefixLen]
+	binary.LittleEndian.PutUint32(header[5+saltLen+noncePrefixLen:], StreamChunkSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("encryption: generating salt: %w", err)
+	}
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, fmt.Errorf("encryption: generating nonce: %w", err)
+	}
+
+	gcm, err := newGCM(password, salt, KDFIterations)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &Writer{
+		w:      w,
+		gcm:    gcm,
+		header: header,
+		prefix: prefix,
+		buf:    make([]byte, 0, StreamChunkSize),
+		sealed: make([]byte, 0, StreamChunkSize+tagLen),
+	}, nil
+}
+
+// sealChunk encrypts the buffered plaintext as the next chunk and writes it out
+func (ew *Writer) sealChunk(final bool) error {
+	if ew.counter == ^uint32(0) {
+		return errors.New("encryption: stream too long")
+	}
+	ew.sealed = ew.gcm.Seal(ew.sealed[:0], streamNonce(ew.prefix, ew.counter, final), ew.buf, ew.header)
+	ew.counter++
+	ew.buf = ew.buf[:0]
+	_, err := ew.w.Write(ew.sealed)
+	return err
+}
+
+// Write buffers p and seals every chunk that fills up.
+func (ew *Writer) Write(p []byte) (int, error) {
+	if ew.closed {
+		return 0, errors.New("encryption: write after close")
+	}
+	n := 0
+	for len(p) > 0 {
+		take := min(len(p), StreamChunkSize-len(ew.buf))
+		ew.buf = append(ew.buf, p[:take]...)
+		p = p[take:]
+		n += take
+		if len(ew.buf) == StreamChunkSize {
+			if err := ew.sealChunk(false); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Close seals the remaining plaintext as the final chunk. It does not close the underlying writer.
+func (ew *Writer) Close() error {
+	if ew.closed {
+		return nil
+	}
+	ew.closed = true
+	return ew.sealChunk(true)
+}
+
+// Reader decrypts either envelope format. Streaming envelopes are opened one chunk at a time; the older
+// single-shot format is read in full and opened with Decrypt.
+type Reader struct {
+	r         io.Reader
+	password  string
+	started   bool
+	gcm       cipher.AEAD
+	header    []byte
+	prefix    []byte
+	chunkSize int
+	counter   uint32
+	done      bool
+	sealed    []byte
+	plain     []byte
+	out       []byte
+}
+
+// NewReader returns a Reader that decrypts the envelope read from r.
+func NewReader(r io.Reader, password string) *Reader {
+	return &Reader{r: r, password: password}
+}
+
+// start reads the envelope header and sets up decryption
+func (er *Reader) start() error {
+	er.started = true
+	var version [1]byte
+	if _, err := io.ReadFull(er.r, version[:]); err != nil {
+		return fmt.Errorf("encryption: data too short for envelope header")
+	}
+	if version[0] == formatVersion {
+		data, err := io.ReadAll(io.MultiReader(bytes.NewReader(version[:]), er.r))
+		if err != nil {
+			return err
+		}
+		plaintext, err := Decrypt(data, er.password)
+		if err != nil {
+			return err
+		}
+		er.out = plaintext
+		er.done = true
+		return nil
+	}
+	if version[0] != streamFormatVersion {
+		return fmt.Errorf("encryption: unsupported format version %d", version[0])
+	}
+
+	er.header = make([]byte, streamHeaderLen)
+	er.header[0] = version[0]
+	if _, err := io.ReadFull(er.r, er.header[1:]); err != nil {
+		return fmt.Errorf("encryption: data too short for envelope header")
+	}
+	iterations := int(binary.LittleEndian.Uint32(er.header[1:5]))
+	if iterations < 1 || iterations > maxKDFIterations {
+		return errors.New("encryption: invalid KDF iteration count")
+	}
+	er.chunkSize = int(binary.LittleEndian.Uint32(er.header[5+saltLen+noncePrefixLen:]))
+	if er.chunkSize < 1 || er.chunkSize > StreamChunkSize {
+		return fmt.Errorf("encryption: invalid chunk size %d", er.chunkSize)
+	}
+	er.prefix = er.header[5+saltLen : 5+saltLen+noncePrefixLen]
+
+	gcm, err := newGCM(er.password, er.header[5:5+saltLen], iterations)
+	if err != nil {
+		return err
+	}
+	er.gcm = gcm
+	er.sealed = make([]byte, er.chunkSize+tagLen)
+	return nil
+}
+
+// openChunk reads and authenticates the next chunk. A full-length chunk is a middle chunk, a shorter one is
+// the final chunk; either way the nonce flag has to agree or the tag does not verify.
+func (er *Reader) openChunk() error {
+	n, err := io.ReadFull(er.r, er.sealed)
+	final := false
+	switch {
+	case err == nil:
+	case errors.Is(err, io.ErrUnexpectedEOF) && n >= tagLen:
+		final = true
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		return errors.New("encryption: stream truncated")
+	default:
+		return err
+	}
+
+	plain, err := er.gcm.Open(er.plain[:0], streamNonce(er.prefix, er.counter, final), er.sealed[:n], er.header)
+	if err != nil {
+		return ErrAuthenticationFailed
+	}
+	er.counter++
+	er.plain = plain
+	er.out = plain
+	er.done = final
+	return nil
+}
+
+// Read returns decrypted plaintext. Plaintext is only returned once its chunk has been authenticated.
+func (er *Reader) Read(p []byte) (int, error) {
+	if !er.started {
+		if err := er.start(); err != nil {
+			return 0, err
+		}
+	}
+	for len(er.out) == 0 {
+		if er.done {
+			return 0, io.EOF
+		}
+		if err := er.openChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, er.out)
+	er.out = er.out[n:]
+	return n, nil
+}
@@ -0,0 +1,87 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func sealStream(t *testing.T, data []byte, password string) []byte {
+	t.Helper()
+	var sealed bytes.Buffer
+	w, err := NewWriter(&sealed, password)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return sealed.Bytes()
+}
+
+func TestStreamRoundtrip(t *testing.T) {
+	for _, size := range []int{0, 1, StreamChunkSize - 1, StreamChunkSize, 2*StreamChunkSize + 17} {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(i * 7)
+		}
+		sealed := sealStream(t, data, "password")
+		if int64(len(sealed)) != StreamLen(int64(size)) {
+			t.Errorf("size %d: sealed length = %d, want %d", size, len(sealed), StreamLen(int64(size)))
+		}
+
+		opened, err := io.ReadAll(NewReader(iotest.HalfReader(bytes.NewReader(sealed)), "password"))
+		if err != nil {
+			t.Fatalf("size %d: ReadAll: %v", size, err)
+		}
+		if !bytes.Equal(opened, data) {
+			t.Errorf("size %d: roundtrip mismatch", size)
+		}
+
+		// The single-shot Decrypt accepts the streaming format too
+		opened, err = Decrypt(sealed, "password")
+		if err != nil || !bytes.Equal(opened, data) {
+			t.Errorf("size %d: Decrypt of stream failed: %v", size, err)
+		}
+	}
+}
+
+func TestStreamReaderAcceptsVersionOne(t *testing.T) {
+	sealed, err := Encrypt([]byte("single shot"), "password")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	opened, err := io.ReadAll(NewReader(bytes.NewReader(sealed), "password"))
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(opened) != "single shot" {
+		t.Errorf("got %q", opened)
+	}
+}
+
+func TestStreamTruncatedAtChunkBoundary(t *testing.T) {
+	sealed := sealStream(t, make([]byte, 2*StreamChunkSize), "password")
+	// Drop the empty final chunk, leaving two complete middle chunks
+	cut := sealed[:len(sealed)-tagLen]
+	if _, err := io.ReadAll(NewReader(bytes.NewReader(cut), "password")); err == nil {
+		t.Error("expected an error for a stream missing its final chunk")
+	}
+	// Drop a whole middle chunk as well: the final flag no longer matches
+	cut = sealed[:streamHeaderLen+StreamChunkSize+tagLen]
+	if _, err := io.ReadAll(NewReader(bytes.NewReader(cut), "password")); err == nil {
+		t.Error("expected an error for a stream cut after its first chunk")
+	}
+}
+
+func TestStreamWrongPassword(t *testing.T) {
+	sealed := sealStream(t, []byte("secret"), "password")
+	if _, err := io.ReadAll(NewReader(bytes.NewReader(sealed), "other")); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("expected ErrAuthenticationFailed, got %v", err)
+	}
+}
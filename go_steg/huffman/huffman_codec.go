@@ -26,24 +26,31 @@ func GenerateTreeFromPassword(password string) (*Node, [256]*Node) {
 	return root, leaves
 }
 
-// HuffmanEncode encodes data using a password-derived Huffman tree.
-// Format: [4-byte LE original length][packed huffman bits]
-func HuffmanEncode(data []byte, password string) []byte {
-	if len(data) == 0 {
-		return []byte{}
-	}
+// codeEntry is the Huffman code for one byte value, right-aligned in code
+type codeEntry struct {
+	code uint64
+	bits byte
+}
 
+// codeTableFromPassword returns the code for every byte value of the password-derived tree
+func codeTableFromPassword(password string) [256]codeEntry {
 	_, leaves := GenerateTreeFromPassword(password)
-
-	type codeEntry struct {
-		code uint64
-		bits byte
-	}
 	var codeTable [256]codeEntry
 	for i := 0; i < 256; i++ {
 		code, bits := leaves[i].ReturnCode()
 		codeTable[i] = codeEntry{code, bits}
 	}
+	return codeTable
+}
+
+// HuffmanEncode encodes data using a password-derived Huffman tree.
+// Format: [4-byte LE original length][packed huffman bits]
+func HuffmanEncode(data []byte, password string) []byte {
+	if len(data) == 0 {
+		return []byte{}
+	}
+
+	codeTable := codeTableFromPassword(password)
 
 	result := make([]byte, 4)
 	binary.LittleEndian.PutUint32(result, uint32(len(data)))
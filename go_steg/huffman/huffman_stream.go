@@ -0,0 +1,172 @@
+package huffman
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// lengthPrefixLen is the size of the original length prefix written by HuffmanEncode
+const lengthPrefixLen = 4
+
+// EncodedLen reads data from r to the end and returns its length together with the length HuffmanEncode
+// (or a Writer) produces for it. The code lengths depend on the byte values, so every byte has to be seen.
+func EncodedLen(r io.Reader, password string) (dataLen, encodedLen int64, err error) {
+	codeTable := codeTableFromPassword(password)
+	var bits int64
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		for _, b := range buf[:n] {
+			bits += int64(codeTable[b].bits)
+		}
+		dataLen += int64(n)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, 0, fmt.Errorf("huffman: reading data: %w", err)
+		}
+	}
+	if dataLen == 0 {
+		return 0, 0, nil
+	}
+	return dataLen, lengthPrefixLen + (bits+7)/8, nil
+}
+
+// Writer is the streaming form of HuffmanEncode. The original length goes at the front of the output, so
+// it has to be given up front; the output is byte-for-byte what HuffmanEncode produces for the same data.
+type Writer struct {
+	w         io.Writer
+	codeTable [256]codeEntry
+	size      int64
+	written   int64
+	current   byte
+	bits      byte
+	out       []byte
+}
+
+// NewWriter returns a Writer that encodes exactly size bytes into w.
+func NewWriter(w io.Writer, size int64, password string) *Writer {
+	return &Writer{w: w, codeTable: codeTableFromPassword(password), size: size}
+}
+
+// Write encodes p, writing whole output bytes to the underlying writer as they fill.
+func (hw *Writer) Write(p []byte) (int, error) {
+	if hw.written+int64(len(p)) > hw.size {
+		return 0, fmt.Errorf("huffman: writing more than the declared %d bytes", hw.size)
+	}
+	if hw.written == 0 && len(p) > 0 {
+		hw.out = binary.LittleEndian.AppendUint32(hw.out, uint32(hw.size))
+	}
+	for _, b := range p {
+		entry := hw.codeTable[b]
+		for i := int(entry.bits) - 1; i >= 0; i-- {
+			hw.current = (hw.current << 1) | byte((entry.code>>i)&1)
+			hw.bits++
+			if hw.bits == 8 {
+				hw.out = append(hw.out, hw.current)
+				hw.current = 0
+				hw.bits = 0
+			}
+		}
+	}
+	hw.written += int64(len(p))
+	if _, err := hw.w.Write(hw.out); err != nil {
+		return 0, err
+	}
+	hw.out = hw.out[:0]
+	return len(p), nil
+}
+
+// Close flushes the final partial byte. It does not close the underlying writer.
+func (hw *Writer) Close() error {
+	if hw.written != hw.size {
+		return fmt.Errorf("huffman: %d bytes written, %d declared", hw.written, hw.size)
+	}
+	if hw.bits == 0 {
+		return nil
+	}
+	_, err := hw.w.Write([]byte{hw.current << (8 - hw.bits)})
+	hw.bits = 0
+	return err
+}
+
+// Reader is the streaming form of HuffmanDecode.
+type Reader struct {
+	r         io.Reader
+	root      *Node
+	node      *Node
+	remaining int64
+	started   bool
+	in        []byte
+	inPos     int
+	bitPos    int
+}
+
+// NewReader returns a Reader that decodes HuffmanEncode output read from r.
+func NewReader(r io.Reader, password string) *Reader {
+	root, _ := GenerateTreeFromPassword(password)
+	return &Reader{r: r, root: root, node: root, in: make([]byte, 0, 32*1024)}
+}
+
+// Read decodes into p, returning io.EOF once the original length has been produced.
+func (hr *Reader) Read(p []byte) (int, error) {
+	if !hr.started {
+		var prefix [lengthPrefixLen]byte
+		switch _, err := io.ReadFull(hr.r, prefix[:]); {
+		case errors.Is(err, io.EOF):
+			// HuffmanEncode writes nothing at all for empty data
+			return 0, io.EOF
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return 0, fmt.Errorf("huffman: data too short for length prefix")
+		case err != nil:
+			return 0, err
+		}
+		hr.remaining = int64(binary.LittleEndian.Uint32(prefix[:]))
+		hr.started = true
+	}
+
+	n := 0
+	for n < len(p) && hr.remaining > 0 {
+		if hr.inPos == len(hr.in) {
+			m, err := hr.r.Read(hr.in[:cap(hr.in)])
+			hr.in = hr.in[:m]
+			hr.inPos = 0
+			if m == 0 {
+				if err == nil {
+					continue
+				}
+				if errors.Is(err, io.EOF) {
+					return n, fmt.Errorf("huffman: input ended with %d bytes still to decode", hr.remaining)
+				}
+				return n, err
+			}
+		}
+		bit := (hr.in[hr.inPos] >> (7 - hr.bitPos)) & 1
+		hr.bitPos++
+		if hr.bitPos == 8 {
+			hr.bitPos = 0
+			hr.inPos++
+		}
+		if bit == 0 {
+			hr.node = hr.node.Left
+		} else {
+			hr.node = hr.node.Right
+		}
+		if hr.node == nil {
+			return n, fmt.Errorf("huffman: invalid bit sequence")
+		}
+		if hr.node.Left == nil && hr.node.Right == nil {
+			p[n] = byte(hr.node.Value)
+			n++
+			hr.remaining--
+			hr.node = hr.root
+		}
+	}
+	if hr.remaining == 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
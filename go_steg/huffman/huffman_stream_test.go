@@ -0,0 +1,83 @@
+package huffman
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+)
+
+func TestWriterMatchesHuffmanEncode(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	for _, size := range []int{0, 1, 2, 100, 4096, 70000} {
+		data := make([]byte, size)
+		rng.Read(data)
+		want := HuffmanEncode(data, "stream")
+
+		var got bytes.Buffer
+		w := NewWriter(&got, int64(size), "stream")
+		// Write in uneven pieces to exercise partial bytes across calls
+		for rest := data; len(rest) > 0; {
+			n := min(len(rest), 1+rng.Intn(999))
+			if _, err := w.Write(rest[:n]); err != nil {
+				t.Fatalf("size %d: Write: %v", size, err)
+			}
+			rest = rest[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("size %d: Close: %v", size, err)
+		}
+		if !bytes.Equal(got.Bytes(), want) {
+			t.Errorf("size %d: Writer output differs from HuffmanEncode", size)
+		}
+
+		dataLen, encodedLen, err := EncodedLen(bytes.NewReader(data), "stream")
+		if err != nil {
+			t.Fatalf("EncodedLen: %v", err)
+		}
+		if dataLen != int64(size) || encodedLen != int64(len(want)) {
+			t.Errorf("size %d: EncodedLen = (%d, %d), want (%d, %d)", size, dataLen, encodedLen, size, len(want))
+		}
+	}
+}
+
+func TestReaderRoundtrip(t *testing.T) {
+	for _, size := range []int{0, 1, 513, 50000} {
+		data := make([]byte, size)
+		rand.New(rand.NewSource(int64(size))).Read(data)
+		encoded := HuffmanEncode(data, "stream")
+
+		got, err := io.ReadAll(NewReader(iotest.HalfReader(bytes.NewReader(encoded)), "stream"))
+		if err != nil {
+			t.Fatalf("size %d: ReadAll: %v", size, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("size %d: Reader roundtrip mismatch", size)
+		}
+	}
+}
+
+func TestReaderTruncatedInput(t *testing.T) {
+	encoded := HuffmanEncode(bytes.Repeat([]byte("truncate"), 50), "stream")
+	if _, err := io.ReadAll(NewReader(bytes.NewReader(encoded[:len(encoded)/2]), "stream")); err == nil {
+		t.Error("expected an error for truncated input")
+	}
+	if _, err := io.ReadAll(NewReader(bytes.NewReader(encoded[:2]), "stream")); err == nil {
+		t.Error("expected an error for a truncated length prefix")
+	}
+}
+
+func TestWriterRejectsWrongSize(t *testing.T) {
+	w := NewWriter(io.Discard, 3, "stream")
+	if _, err := w.Write([]byte("four")); err == nil {
+		t.Error("expected an error writing past the declared size")
+	}
+	w = NewWriter(io.Discard, 3, "stream")
+	if _, err := w.Write([]byte("ab")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err == nil {
+		t.Error("expected an error closing before the declared size was written")
+	}
+}
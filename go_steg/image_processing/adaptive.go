@@ -0,0 +1,146 @@
+package image_processing
+
+// Adaptive embedding varies the number of payload bits a slot holds with the texture around it. Changes to
+// the low bits stand out in smooth regions such as sky, so a channel whose neighbourhood is smooth carries
+// nothing, a moderately textured one a single bit and a busy one the full bit depth.
+//
+// The texture of a slot is the mean absolute difference between its sample and the same channel of the
+// pixels left, right, above and below it, measured on the bits embedding never changes: the sample with its
+// low bitDepth bits cleared, or the high byte of a 16-bit sample. Decode measures the same values, so it
+// finds the same slots holding the same number of bits. Neighbours in the reserved header rows are left out,
+// as the header is written after the payload. Adaptive embedding only replaces low bits, so it cannot be
+// combined with LSB matching or matrix embedding, whose changes reach above them, and it does not apply to
+// JPEG-native carriers.
+
+// defaultAdaptiveThresholds are the texture levels, on a 0-255 scale, at which a channel starts to carry one
+// bit and the full bit depth when the options leave them at zero
+var defaultAdaptiveThresholds = [2]uint8{8, 32}
+
+// level returns a sample on a 0-255 scale with every bit embedding may change cleared
+func (c *carrierImage) level(x, y, channel, bitDepth int) int {
+	if c.sampleBytes == 2 {
+		return int(c.sample(x, y, channel) >> 8)
+	}
+	return int(c.sample(x, y, channel)) >> bitDepth << bitDepth
+}
+
+// texture returns the mean absolute difference between the level of a sample and that of its neighbours in
+// the payload rows
+func (c *carrierImage) texture(x, y, channel, bitDepth int) int {
+	width, height := c.Bounds().Dx(), c.Bounds().Dy()
+	center := c.level(x, y, channel, bitDepth)
+	sum, count := 0, 0
+	for _, d := range [4][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+		nx, ny := x+d[0], y+d[1]
+		if nx < 0 || nx >= width || ny < totalReservedPixels || ny >= height {
+			continue
+		}
+		diff := center - c.level(nx, ny, channel, bitDepth)
+		sum += max(diff, -diff)
+		count++
+	}
+	if count == 0 {
+		return 0
+	}
+	return sum / count
+}
+
+// slotBits returns how many payload bits a slot holds: bitDepth, or with adaptive embedding 0, 1 or bitDepth
+// depending on the texture around it
+func (e embedding) slotBits(img *carrierImage, sl slot) int {
+	if !e.adaptive {
+		return e.bitDepth
+	}
+	switch texture := img.texture(sl.x, sl.y, sl.channel, e.bitDepth); {
+	case texture < int(e.thresholds[0]):
+		return 0
+	case texture < int(e.thresholds[1]):
+		return 1
+	default:
+		return e.bitDepth
+	}
+}
+
+// chunkBits returns the number of payload bits in each chunk the data is split into. Adaptive embedding
+// splits it into single bits and hands each slot as many as it holds.
+func (e embedding) chunkBits() int {
+	if e.adaptive {
+		return 1
+	}
+	return e.bitDepth
+}
+
+// capacityBits returns the number of payload bits the slots of img hold
+func (e embedding) capacityBits(img *carrierImage) int64 {
+	if !e.adaptive {
+		return e.count(img) * int64(e.bitDepth)
+	}
+	sequential := e
+	sequential.scattered = false
+	var bits int64
+	for sl := range sequential.slots(img) {
+		if e.carries(img, sl) {
+			bits += int64(e.slotBits(img, sl))
+		}
+	}
+	return bits
+}
+
+// adaptiveWriter writes payload bits into slots that hold a varying number of them
+type adaptiveWriter struct {
+	matcher matcher
+	img     *carrierImage
+	// next returns the next slot of img that carries payload
+	next  func() (slot, error)
+	slot  slot
+	size  int // bits the current slot holds, 0 until the next slot is taken
+	value int
+	bits  int
+}
+
+// writeBit adds one payload bit, writing the current slot once it holds as many as the slot does
+func (w *adaptiveWriter) writeBit(bit byte) error {
+	if w.size == 0 {
+		sl, err := w.next()
+		if err != nil {
+			return err
+		}
+		w.slot, w.size = sl, w.matcher.embed.slotBits(w.img, sl)
+	}
+	w.value = w.value<<1 | int(bit&1)
+	w.bits++
+	if w.bits == w.size {
+		return w.flush()
+	}
+	return nil
+}
+
+// flush writes the bits collected so far into the current slot, padding them with zero bits to its size
+func (w *adaptiveWriter) flush() error {
+	if w.bits == 0 {
+		return nil
+	}
+	w.matcher.writeBits(w.img, w.slot, w.size, byte(w.value<<(w.size-w.bits)))
+	w.size, w.value, w.bits = 0, 0, 0
+	return nil
+}
+
+// extractAdaptive reads dataCount payload bits back out of an adaptively embedded carrier, one bit per byte as
+// extractChunk expects them
+func extractAdaptive(img *carrierImage, dataCount int, embed embedding) []byte {
+	bits := make([]byte, 0, dataCount)
+	for sl := range embed.slots(img) {
+		if len(bits) >= dataCount {
+			break
+		}
+		if !embed.carries(img, sl) {
+			continue
+		}
+		n := embed.slotBits(img, sl)
+		value := img.lastBits(sl.x, sl.y, sl.channel, n)
+		for i := n - 1; i >= 0 && len(bits) < dataCount; i-- {
+			bits = append(bits, value>>i&1)
+		}
+	}
+	return bits
+}
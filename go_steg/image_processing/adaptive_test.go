@@ -0,0 +1,52 @@
+package image_processing
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+// TestAdaptiveSlotBits checks that slots in a flat region hold nothing, slots in a noisy region the full bit
+// depth, and that neither changes when random bits are written into the low bits of every sample
+func TestAdaptiveSlotBits(t *testing.T) {
+	rng := rand.New(rand.NewSource(2101))
+	nrgba := image.NewNRGBA(image.Rect(0, 0, 40, totalReservedPixels+20))
+	for y := 0; y < nrgba.Bounds().Dy(); y++ {
+		for x := 0; x < 40; x++ {
+			c := color.NRGBA{R: 120, G: 160, B: 200, A: 255}
+			if x >= 20 {
+				c = color.NRGBA{R: uint8(rng.Intn(256)), G: uint8(rng.Intn(256)), B: uint8(rng.Intn(256)), A: 255}
+			}
+			nrgba.SetNRGBA(x, y, c)
+		}
+	}
+	img := newCarrierImage(nrgba)
+	embed := embedding{bitDepth: 2, adaptive: true, thresholds: defaultAdaptiveThresholds}
+
+	bits := map[slot]int{}
+	for sl := range embed.slots(img) {
+		bits[sl] = embed.slotBits(img, sl)
+		if sl.x < 19 && bits[sl] != 0 {
+			t.Fatalf("flat slot %+v holds %d bits", sl, bits[sl])
+		}
+	}
+	noisy := 0
+	for sl, n := range bits {
+		if sl.x > 20 && n == 2 {
+			noisy++
+		}
+	}
+	if noisy == 0 {
+		t.Fatal("no noisy slot holds the full bit depth")
+	}
+
+	for sl := range bits {
+		img.setLastBits(sl.x, sl.y, sl.channel, 2, byte(rng.Intn(4)))
+	}
+	for sl, n := range bits {
+		if got := embed.slotBits(img, sl); got != n {
+			t.Fatalf("slot %+v held %d bits, %d after embedding", sl, n, got)
+		}
+	}
+}
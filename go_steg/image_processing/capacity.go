@@ -0,0 +1,160 @@
+package image_processing
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// CarrierCapacity describes how much pipeline output one carrier can hold with a given set of options.
+type CarrierCapacity struct {
+	Width  int
+	Height int
+	// Slots is the number of channels below the header that can carry payload bits, after mask selection
+	Slots int64
+	// MaskCandidate is the mask candidate the figures are for, 0 without the mask
+	MaskCandidate uint8
+	// Bytes is the number of whole pipeline output bytes those slots hold at the configured bit depth, or with
+	// adaptive embedding at the bits each slot's texture gives it
+	Bytes int64
+}
+
+// chunksPerByte returns how many channel slots one payload byte occupies at the given bit depth
+func chunksPerByte(bitDepth int) int64 {
+	return int64(math.Ceil(8.0 / float64(bitDepth)))
+}
+
+// measureCapacity returns the capacity of an already decoded carrier. Mask selection, the alpha rule and the
+// texture of adaptive embedding ignore the low bitDepth bits of a channel, so the count does not change as data
+// is embedded.
+func measureCapacity(img *carrierImage, mask Mask, opts Options) CarrierCapacity {
+	embed := opts.embedding(mask)
+	slots := embed.count(img)
+	bytes := slots / chunksPerByte(embed.bitDepth)
+	if embed.adaptive {
+		bytes = embed.capacityBits(img) / 8
+	}
+	return CarrierCapacity{
+		Width:         img.Bounds().Dx(),
+		Height:        img.Bounds().Dy(),
+		Slots:         slots,
+		MaskCandidate: mask.number,
+		Bytes:         bytes,
+	}
+}
+
+// chooseMask returns the first candidate of mask with which the carriers hold a payload of the given size,
+// along with their capacities and the total. Without UseMask every candidate selects the same slots, so only
+// the first is measured. When no candidate holds the payload it returns the one that holds the most.
+func chooseMask(images []*carrierImage, size int64, mask Mask, opts Options) (Mask, []CarrierCapacity, int64) {
+	candidates := uint8(1)
+	if opts.UseMask {
+		candidates = maskCandidates
+	}
+	var best Mask
+	var bestCapacities []CarrierCapacity
+	bestTotal := int64(-1)
+	mask = mask.withDensity(opts.MaskDensity)
+	for n := range candidates {
+		candidate := mask.candidate(n)
+		capacities := make([]CarrierCapacity, 0, len(images))
+		var total int64
+		for _, img := range images {
+			c := measureCapacity(img, candidate, opts)
+			capacities = append(capacities, c)
+			total += c.Bytes
+		}
+		if total >= size {
+			if opts.UseMask {
+				logger.Debugf("Mask candidate %v selects enough slots for the payload", n)
+			}
+			return candidate, capacities, total
+		}
+		if total > bestTotal {
+			best, bestCapacities, bestTotal = candidate, capacities, total
+		}
+	}
+	return best, bestCapacities, bestTotal
+}
+
+// Capacity returns the number of pipeline output bytes each carrier can hold when encoded with the
+// given password and options. The mask is applied at the configured bit depth, the same way Encode applies it,
+// and with UseAlpha the alpha channel of every nearly opaque pixel is counted too. With UseMask the figures are
+// an upper bound: they are for the mask candidate that gives the carriers the most capacity, while encode takes
+// the first candidate that holds the payload, which may split it over the carriers differently. Whether a
+// payload fits is the same either way. With MatrixEmbedding this is the capacity at k = 1, the most the
+// carriers hold; smaller payloads are embedded with a larger k.
+func Capacity(carriers []io.Reader, password string, opts Options) ([]CarrierCapacity, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	mask := generateMaskingInfo(password)
+	images := make([]*carrierImage, 0, len(carriers))
+	for i, carrier := range carriers {
+		img, format, err := getCarrierImage(carrier)
+		if err != nil {
+			return nil, fmt.Errorf("error reading carrier with index %d: %w", i, err)
+		}
+		if img, err = img.forEncoding(format, opts); err != nil {
+			return nil, fmt.Errorf("error reading carrier with index %d: %w", i, err)
+		}
+		if err := checkCarrierFormat(img, format, opts); err != nil {
+			return nil, fmt.Errorf("error reading carrier with index %d: %w", i, err)
+		}
+		// Encode lays the palette out before embedding, which moves the indices the mask looks at
+		if err := img.sortPalette(opts.bitDepth()); err != nil {
+			return nil, fmt.Errorf("error reading carrier with index %d: %w", i, err)
+		}
+		images = append(images, img)
+	}
+	_, capacities, _ := chooseMask(images, math.MaxInt64, mask, opts)
+	return capacities, nil
+}
+
+// splitSizes returns how many bytes of a payload of the given size go to each carrier. Every carrier gets a
+// share in proportion to its capacity, and the bytes left over from rounding down go one at a time to the
+// first carriers that still have room. A payload larger than the total capacity puts the excess on the last
+// carrier, where encoding will report it.
+func splitSizes(size int, capacities []CarrierCapacity) []int {
+	sizes := make([]int, len(capacities))
+	if len(capacities) == 0 {
+		return sizes
+	}
+	var total int64
+	for _, c := range capacities {
+		total += c.Bytes
+	}
+
+	remaining := size
+	if total > 0 {
+		for i, c := range capacities {
+			sizes[i] = int(min(int64(size), total) * c.Bytes / total)
+			remaining -= sizes[i]
+		}
+		for i, c := range capacities {
+			if remaining == 0 {
+				break
+			}
+			if int64(sizes[i]) < c.Bytes {
+				sizes[i]++
+				remaining--
+			}
+		}
+	}
+	sizes[len(sizes)-1] += remaining
+	return sizes
+}
+
+// Fits reports whether a payload of the given size fits into the carriers when split the way
+// MultiCarrierEncode splits it.
+func Fits(capacities []CarrierCapacity, payloadSize int64) bool {
+	if len(capacities) == 0 {
+		return false
+	}
+	for i, size := range splitSizes(int(payloadSize), capacities) {
+		if int64(size) > capacities[i].Bytes {
+			return false
+		}
+	}
+	return true
+}
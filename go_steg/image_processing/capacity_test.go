@@ -0,0 +1,119 @@
+package image_processing
+
+import (
+	"bytes"
+	"errors"
+	"go-steg/go_steg/pipeline"
+	"io"
+	"testing"
+)
+
+func TestCapacityUnmasked(t *testing.T) {
+	carrier := carrierPNGBytes(t, 60, 50, 801)
+	for bitDepth, wantBytes := range map[int]int64{1: 360, 2: 720, 3: 960, 4: 1440} {
+		capacities, err := Capacity([]io.Reader{bytes.NewReader(carrier)}, "", Options{Config: pipeline.Config{BitDepth: bitDepth}})
+		if err != nil {
+			t.Fatalf("Capacity: %v", err)
+		}
+		got := capacities[0]
+		if got.Width != 60 || got.Height != 50 {
+			t.Errorf("dimensions: got %dx%d, want 60x50", got.Width, got.Height)
+		}
+		if got.Slots != 60*16*3 {
+			t.Errorf("bit depth %d: Slots got %d, want %d", bitDepth, got.Slots, 60*16*3)
+		}
+		if got.Bytes != wantBytes {
+			t.Errorf("bit depth %d: Bytes got %d, want %d", bitDepth, got.Bytes, wantBytes)
+		}
+	}
+}
+
+// TestCapacityIsExact fills carriers to exactly the reported capacity and checks one more byte is rejected,
+// with the mask applied at every bit depth.
+func TestCapacityIsExact(t *testing.T) {
+	carrier := carrierPNGBytes(t, 60, 60, 802)
+	for bitDepth := 1; bitDepth <= 4; bitDepth++ {
+		opts := Options{UseMask: true, Config: pipeline.Config{BitDepth: bitDepth, FileExtension: "bin"}}
+		capacities, err := Capacity([]io.Reader{bytes.NewReader(carrier)}, "exact", opts)
+		if err != nil {
+			t.Fatalf("Capacity: %v", err)
+		}
+		size := int(capacities[0].Bytes)
+		if size == 0 {
+			t.Fatalf("bit depth %d: mask left no capacity", bitDepth)
+		}
+
+		data := bytes.Repeat([]byte{0x5A}, size)
+		var encoded bytes.Buffer
+		err = MultiCarrierEncode([]io.Reader{bytes.NewReader(carrier)}, bytes.NewReader(data), []io.Writer{&encoded}, 1, "exact", opts)
+		if err != nil {
+			t.Fatalf("bit depth %d: encoding %d bytes into a %d byte carrier failed: %v", bitDepth, size, size, err)
+		}
+		var decoded bytes.Buffer
+		if err := MultiCarrierDecode([]io.Reader{&encoded}, &decoded, "exact", Options{}); err != nil {
+			t.Fatalf("bit depth %d: decode: %v", bitDepth, err)
+		}
+		if !bytes.Equal(decoded.Bytes(), data) {
+			t.Errorf("bit depth %d: full carrier roundtrip mismatch", bitDepth)
+		}
+
+		data = append(data, 0x5A)
+		err = MultiCarrierEncode([]io.Reader{bytes.NewReader(carrier)}, bytes.NewReader(data), []io.Writer{io.Discard}, 1, "exact", opts)
+		var encErr *EncodingError
+		if !errors.As(err, &encErr) || encErr.Type != ErrDataTooLarge.Type {
+			t.Errorf("bit depth %d: expected %s for one byte over capacity, got %v", bitDepth, ErrDataTooLarge.Type, err)
+		}
+	}
+}
+
+func TestSplitSizes(t *testing.T) {
+	caps := func(bytes ...int64) []CarrierCapacity {
+		c := make([]CarrierCapacity, len(bytes))
+		for i, b := range bytes {
+			c[i].Bytes = b
+		}
+		return c
+	}
+	tests := []struct {
+		name       string
+		size       int
+		capacities []CarrierCapacity
+		want       []int
+	}{
+		{"single carrier", 10, caps(100), []int{10}},
+		{"equal carriers", 10, caps(100, 100), []int{5, 5}},
+		{"rounding leftover goes first", 11, caps(100, 100), []int{6, 5}},
+		{"proportional", 100, caps(300, 100), []int{75, 25}},
+		{"exactly full", 400, caps(300, 100), []int{300, 100}},
+		{"leftover to first carrier with room", 5, caps(1, 1, 10), []int{1, 0, 4}},
+		{"zero capacity carrier gets nothing", 8, caps(0, 10), []int{0, 8}},
+		{"over capacity lands on last", 12, caps(5, 5), []int{5, 7}},
+		{"no capacity at all", 3, caps(0, 0), []int{0, 3}},
+		{"empty payload", 0, caps(10, 10), []int{0, 0}},
+	}
+	for _, tt := range tests {
+		got := splitSizes(tt.size, tt.capacities)
+		if len(got) != len(tt.want) {
+			t.Fatalf("%s: splitSizes = %v, want %v", tt.name, got, tt.want)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: splitSizes = %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestFits(t *testing.T) {
+	capacities := []CarrierCapacity{{Bytes: 100}, {Bytes: 40}}
+	if !Fits(capacities, 140) {
+		t.Error("140 bytes should fit 100+40 bytes of capacity when split by capacity")
+	}
+	if Fits(capacities, 141) {
+		t.Error("141 bytes should not fit 140 bytes of capacity")
+	}
+	if Fits(nil, 1) {
+		t.Error("nothing fits without carriers")
+	}
+}
@@ -0,0 +1,372 @@
+package image_processing
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"go-steg/go_steg/bit_manipulation"
+	"go-steg/go_steg/jpeg_dct"
+	"image"
+	"image/color"
+	"io"
+	"slices"
+)
+
+// CarrierModel identifies the color model a carrier was embedded in. Carriers are written back out in the
+// model they were read in, and decode reads them in whatever model the image file has, so the model recorded
+// in the header shows when a carrier was converted after encoding.
+type CarrierModel uint8
+
+const (
+	// CarrierModelUnknown is reported for carriers whose header does not record a model
+	CarrierModelUnknown CarrierModel = iota
+	// CarrierModelRGBA covers every 8-bit color carrier, with or without an alpha channel
+	CarrierModelRGBA
+	// CarrierModelRGBA64 covers every 16-bit color carrier
+	CarrierModelRGBA64
+	CarrierModelGray
+	CarrierModelGray16
+	// CarrierModelPaletted carriers hold payload bits in their palette indices
+	CarrierModelPaletted
+	// CarrierModelJPEG carriers hold payload bits in their quantized DCT coefficients
+	CarrierModelJPEG
+)
+
+// String returns the name of the model
+func (m CarrierModel) String() string {
+	switch m {
+	case CarrierModelRGBA:
+		return "rgba"
+	case CarrierModelRGBA64:
+		return "rgba64"
+	case CarrierModelGray:
+		return "gray"
+	case CarrierModelGray16:
+		return "gray16"
+	case CarrierModelPaletted:
+		return "paletted"
+	case CarrierModelJPEG:
+		return "jpeg"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(m))
+	}
+}
+
+// carrierImage is a decoded carrier in the form its header and payload are written to. Samples are kept
+// non-premultiplied at the carrier's own precision, 8 or 16 bits, so writing the low bits of one sample never
+// disturbs another and the carrier is written back out in the model it came in. Color carriers have four
+// samples per pixel, R, G, B and A. Gray carriers have a single luminance sample and paletted carriers a
+// single palette index. JPEG-native carriers keep the decoded pixels for their dimensions only and hold
+// their bits in dct instead; see attachCoefficients.
+type carrierImage struct {
+	// Image is the *image.NRGBA, *image.NRGBA64, *image.Gray, *image.Gray16 or *image.Paletted holding the
+	// samples, with its origin at (0, 0). It is what gets encoded as the output PNG.
+	image.Image
+	model  CarrierModel
+	pix    []uint8
+	stride int
+	// samples is the number of samples per pixel
+	samples int
+	// sampleBytes is 1 for 8-bit carriers and 2 for 16-bit ones, whose samples are stored big-endian
+	sampleBytes int
+
+	// dct holds the coefficients of a JPEG-native carrier, nil for every other carrier
+	dct *jpeg_dct.Image
+	// headerCoefficients lists the coefficients holding the header samples, and payloadStart is the index of
+	// the first coefficient after them
+	headerCoefficients []int
+	payloadStart       int
+}
+
+// newCarrierImage converts a decoded image into a carrier. Gray and paletted images keep their model and
+// other images with 16-bit samples stay 16-bit; everything else becomes 8-bit NRGBA. Images of a model the
+// carrier keeps are used as they are, not copied.
+func newCarrierImage(img image.Image) *carrierImage {
+	bounds := img.Bounds()
+	origin := image.Rect(0, 0, bounds.Dx(), bounds.Dy())
+	switch src := img.(type) {
+	case *image.Gray:
+		gray := &image.Gray{Pix: src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y):], Stride: src.Stride, Rect: origin}
+		return &carrierImage{Image: gray, model: CarrierModelGray, pix: gray.Pix, stride: gray.Stride, samples: 1, sampleBytes: 1}
+	case *image.Gray16:
+		gray := &image.Gray16{Pix: src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y):], Stride: src.Stride, Rect: origin}
+		return &carrierImage{Image: gray, model: CarrierModelGray16, pix: gray.Pix, stride: gray.Stride, samples: 1, sampleBytes: 2}
+	case *image.Paletted:
+		paletted := &image.Paletted{Pix: src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y):], Stride: src.Stride, Rect: origin, Palette: src.Palette}
+		return &carrierImage{Image: paletted, model: CarrierModelPaletted, pix: paletted.Pix, stride: paletted.Stride, samples: 1, sampleBytes: 1}
+	}
+	if sixteenBit(img.ColorModel()) {
+		deep, ok := img.(*image.NRGBA64)
+		if !ok || deep.Rect.Min != (image.Point{}) {
+			deep = toNRGBA64(img)
+		}
+		return &carrierImage{Image: deep, model: CarrierModelRGBA64, pix: deep.Pix, stride: deep.Stride, samples: 4, sampleBytes: 2}
+	}
+	nrgba, ok := img.(*image.NRGBA)
+	if !ok || nrgba.Rect.Min != (image.Point{}) {
+		nrgba = toNRGBA(img)
+	}
+	return &carrierImage{Image: nrgba, model: CarrierModelRGBA, pix: nrgba.Pix, stride: nrgba.Stride, samples: 4, sampleBytes: 1}
+}
+
+// sixteenBit reports whether images of the given color model hold 16 bits per sample
+func sixteenBit(model color.Model) bool {
+	return model == color.RGBA64Model || model == color.NRGBA64Model || model == color.Gray16Model
+}
+
+// getCarrierImage receives a reader object and decodes it into a carrier. Baseline JPEGs are read as
+// JPEG-native carriers, as those are the only JPEGs encode writes; forEncoding turns them back into pixel
+// carriers when they are to be embedded the usual way.
+func getCarrierImage(reader io.Reader) (*carrierImage, string, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, "", fmt.Errorf("Error reading carrier image: %v", err)
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, format, fmt.Errorf("Error decoding carrier image: %v", err)
+	}
+	carrier := newCarrierImage(img)
+	if format == "jpeg" {
+		if dct, err := jpeg_dct.Decode(bytes.NewReader(data)); err == nil {
+			carrier.attachCoefficients(dct)
+		}
+	}
+	return carrier, format, nil
+}
+
+// offset returns the index in pix of the least significant byte of a sample. Header and payload bits only
+// ever go into that byte.
+func (c *carrierImage) offset(x, y, channel int) int {
+	return y*c.stride + (x*c.samples+channel+1)*c.sampleBytes - 1
+}
+
+// colorChannels returns the number of samples per pixel that hold header and payload bits without the alpha
+// channel: R, G and B for color carriers and the only sample for the others
+func (c *carrierImage) colorChannels() int {
+	if c.samples == 1 || c.dct != nil {
+		return 1
+	}
+	return channelsPerPixel
+}
+
+// sample returns a sample at the carrier's own precision
+func (c *carrierImage) sample(x, y, channel int) uint16 {
+	i := c.offset(x, y, channel)
+	if c.sampleBytes == 2 {
+		return binary.BigEndian.Uint16(c.pix[i-1:])
+	}
+	return uint16(c.pix[i])
+}
+
+// maxSample returns the largest value a sample can hold: fully opaque, in the case of alpha
+func (c *carrierImage) maxSample() uint16 {
+	return uint16(1)<<(8*c.sampleBytes) - 1
+}
+
+// alpha returns the alpha of a pixel on the scale of maxSample. Gray carriers are always opaque and paletted
+// ones take it from the palette entry; sortPalette keeps that stable while the index changes.
+func (c *carrierImage) alpha(x, y int) uint16 {
+	switch c.model {
+	case CarrierModelGray, CarrierModelGray16:
+		return c.maxSample()
+	case CarrierModelPaletted:
+		palette := c.Image.(*image.Paletted).Palette
+		index := int(c.pix[c.offset(x, y, 0)])
+		if index >= len(palette) {
+			return 0
+		}
+		_, _, _, a := palette[index].RGBA()
+		return uint16(a >> 8)
+	default:
+		return c.sample(x, y, alphaChannel)
+	}
+}
+
+// opaque reports whether every pixel of the carrier is fully opaque
+func (c *carrierImage) opaque() bool {
+	o, ok := c.Image.(interface{ Opaque() bool })
+	return ok && o.Opaque()
+}
+
+// reservedSamples returns the number of samples in the reserved header rows, across every column
+func (c *carrierImage) reservedSamples() int {
+	if c.dct != nil {
+		return len(c.headerCoefficients)
+	}
+	if c.Bounds().Dy() < totalReservedPixels {
+		return 0
+	}
+	return c.Bounds().Dx() * totalReservedPixels * c.colorChannels()
+}
+
+// reservedSample maps an index into the reserved header rows to the pixel and channel it addresses. The rows
+// are read column by column, top to bottom, R then G then B on each row. A color carrier holds the header's
+// headerSamples samples in column 0; a gray or paletted carrier needs the first three columns. On a
+// JPEG-native carrier it returns the coefficient as x.
+func (c *carrierImage) reservedSample(index int) (x, y, channel int) {
+	if c.dct != nil {
+		return c.headerCoefficients[index], 0, 0
+	}
+	channels := c.colorChannels()
+	perColumn := totalReservedPixels * channels
+	within := index % perColumn
+	return index / perColumn, within / channels, within % channels
+}
+
+// lowByte returns the least significant byte of a sample. The mask looks at this byte, as it is the one
+// payload bits are written to.
+func (c *carrierImage) lowByte(x, y, channel int) uint8 {
+	if c.dct != nil {
+		return c.coefficientMaskByte(x)
+	}
+	return c.pix[c.offset(x, y, channel)]
+}
+
+// lastBits returns the last n bits of a sample
+func (c *carrierImage) lastBits(x, y, channel, n int) byte {
+	if c.dct != nil {
+		return c.coefficientBits(x, n)
+	}
+	if c.sampleBytes == 2 {
+		return bit_manipulation.GetLastNBits16(c.sample(x, y, channel), n)
+	}
+	return bit_manipulation.GetLastNBits(c.pix[c.offset(x, y, channel)], n)
+}
+
+// setLastBits sets the last n bits of a sample to value, leaving the rest of it as it was
+func (c *carrierImage) setLastBits(x, y, channel, n int, value byte) {
+	if c.dct != nil {
+		c.setCoefficientBits(x, n, value)
+		return
+	}
+	i := c.offset(x, y, channel)
+	if c.sampleBytes == 2 {
+		binary.BigEndian.PutUint16(c.pix[i-1:], bit_manipulation.SetLastNBits16(c.sample(x, y, channel), value, n))
+		return
+	}
+	c.pix[i] = bit_manipulation.SetLastNBits(c.pix[i], value, n)
+}
+
+// matchRange returns the value of a sample and the lowest and highest values LSB matching may move it to.
+// Palette indices stay within their group, as the next group can hold a quite different color.
+func (c *carrierImage) matchRange(x, y, channel int) (value, lowest, highest int) {
+	if c.dct != nil {
+		return c.coefficientMatchRange(x)
+	}
+	value = int(c.sample(x, y, channel))
+	if c.model == CarrierModelPaletted {
+		return value, value, value
+	}
+	return value, 0, int(c.maxSample())
+}
+
+// setSampleValue replaces the whole value of a sample
+func (c *carrierImage) setSampleValue(x, y, channel, value int) {
+	if c.dct != nil {
+		c.setCoefficientMagnitude(x, value)
+		return
+	}
+	i := c.offset(x, y, channel)
+	if c.sampleBytes == 2 {
+		binary.BigEndian.PutUint16(c.pix[i-1:], uint16(value))
+		return
+	}
+	c.pix[i] = uint8(value)
+}
+
+// toNRGBA copies img into an NRGBA image starting at the origin. NRGBA sources are copied byte for byte and
+// every other source is converted one pixel at a time. draw.Draw is not used because for most source types
+// it goes through premultiplied values, which drops the low color bits of translucent pixels (a 16-bit PNG
+// with an alpha channel, for instance) and the whole color of transparent ones.
+func toNRGBA(img image.Image) *image.NRGBA {
+	bounds := img.Bounds()
+	NRGBAImage := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	if src, ok := img.(*image.NRGBA); ok {
+		for y := 0; y < bounds.Dy(); y++ {
+			start := src.PixOffset(bounds.Min.X, bounds.Min.Y+y)
+			copy(NRGBAImage.Pix[NRGBAImage.PixOffset(0, y):], src.Pix[start:start+bounds.Dx()*4])
+		}
+		return NRGBAImage
+	}
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			c := img.At(bounds.Min.X+x, bounds.Min.Y+y)
+			if deep, ok := c.(color.NRGBA64); ok {
+				// NRGBAModel would premultiply, so keep the high byte of each channel instead
+				NRGBAImage.SetNRGBA(x, y, color.NRGBA{R: uint8(deep.R >> 8), G: uint8(deep.G >> 8), B: uint8(deep.B >> 8), A: uint8(deep.A >> 8)})
+				continue
+			}
+			NRGBAImage.SetNRGBA(x, y, color.NRGBAModel.Convert(c).(color.NRGBA))
+		}
+	}
+	return NRGBAImage
+}
+
+// toNRGBA64 is toNRGBA for 16-bit carriers. Opaque sources convert exactly; a premultiplied RGBA64 source
+// has already lost the low bits of its translucent pixels.
+func toNRGBA64(img image.Image) *image.NRGBA64 {
+	bounds := img.Bounds()
+	NRGBA64Image := image.NewNRGBA64(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			c := color.NRGBA64Model.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA64)
+			NRGBA64Image.SetNRGBA64(x, y, c)
+		}
+	}
+	return NRGBA64Image
+}
+
+// sortPalette reorders the palette of a paletted carrier so that changing the low bits of a pixel's index
+// swaps its color for a similar one: entries are sorted by opacity and then luminance. The palette is laid
+// out in groups of 2^bitDepth entries (at least 4, as the header writes 2 bits), and fully transparent entries
+// get groups of their own, padded by repeating the group's last entry. No index change can then turn a visible
+// pixel transparent or the other way round, which keeps the transparency rule stable while embedding. Other
+// carriers are left as they are.
+func (c *carrierImage) sortPalette(bitDepth int) error {
+	paletted, ok := c.Image.(*image.Paletted)
+	if !ok {
+		return nil
+	}
+	group := 1 << max(bitDepth, 2)
+
+	var visible, transparent []int
+	for i, entry := range paletted.Palette {
+		if _, _, _, a := entry.RGBA(); a == 0 {
+			transparent = append(transparent, i)
+		} else {
+			visible = append(visible, i)
+		}
+	}
+	slices.SortStableFunc(visible, func(i, j int) int {
+		a, b := color.NRGBAModel.Convert(paletted.Palette[i]).(color.NRGBA), color.NRGBAModel.Convert(paletted.Palette[j]).(color.NRGBA)
+		return cmp.Or(cmp.Compare(a.A, b.A), cmp.Compare(luminance(a), luminance(b)))
+	})
+
+	palette := make(color.Palette, 0, 256)
+	remap := make([]uint8, 256)
+	for _, entries := range [][]int{visible, transparent} {
+		for _, i := range entries {
+			remap[i] = uint8(len(palette))
+			palette = append(palette, paletted.Palette[i])
+		}
+		for len(entries) > 0 && len(palette)%group != 0 {
+			palette = append(palette, palette[len(palette)-1])
+		}
+	}
+	if len(palette) > 256 {
+		return wrapError(nil, ErrInvalidFormat, fmt.Sprintf("a palette of %d colors leaves no room for embedding at bit depth %d", len(paletted.Palette), bitDepth))
+	}
+
+	for i, index := range paletted.Pix {
+		paletted.Pix[i] = remap[index]
+	}
+	paletted.Palette = palette
+	return nil
+}
+
+// luminance returns the Rec. 601 luma of a color, scaled by 1000
+func luminance(c color.NRGBA) int {
+	return 299*int(c.R) + 587*int(c.G) + 114*int(c.B)
+}
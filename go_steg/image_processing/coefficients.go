@@ -0,0 +1,108 @@
+package image_processing
+
+import (
+	"go-steg/go_steg/jpeg_dct"
+	"math/bits"
+)
+
+// JPEG-native carriers hold their header and payload in the quantized DCT coefficients of the JPEG instead of
+// its pixels, and are written back as JPEGs with the same quantization and Huffman tables. A slot addresses a
+// coefficient by its index in jpeg_dct.Image.Coefficients, held in slot.x.
+//
+// Bits go into the low bits of a coefficient's magnitude, and only coefficients whose magnitude is at least
+// 2^bitDepth carry them, so the magnitude never drops below that and keeps its bit length. The JPEG size
+// category of every coefficient, and so the Huffman symbol that codes it, stays the same: zero coefficients
+// stay zero and the original Huffman tables still code every block. DC coefficients are left alone, as
+// changing them shifts the brightness of whole blocks. At bit depth 1 this is JSteg.
+
+// attachCoefficients turns c into a JPEG-native carrier holding its bits in the coefficients of dct. The header
+// goes into the first coefficientHeaderSamples AC coefficients whose magnitude is at least 4, 2 bits each, and
+// the payload into the coefficients after the last of them.
+func (c *carrierImage) attachCoefficients(dct *jpeg_dct.Image) {
+	c.dct = dct
+	c.model = CarrierModelJPEG
+	c.headerCoefficients = c.headerCoefficients[:0]
+	c.payloadStart = len(dct.Coefficients)
+	for i, v := range dct.Coefficients {
+		if i%jpeg_dct.BlockSize == 0 || coefficientMagnitude(v)>>2 == 0 {
+			continue
+		}
+		c.headerCoefficients = append(c.headerCoefficients, i)
+		if len(c.headerCoefficients) == coefficientHeaderSamples {
+			c.payloadStart = i + 1
+			break
+		}
+	}
+}
+
+// forEncoding returns the carrier to embed into with opts. JPEG carriers are only embedded in their
+// coefficients when opts.JPEGNative is set; otherwise they are embedded in their pixels and written as PNGs.
+func (c *carrierImage) forEncoding(format string, opts Options) (*carrierImage, error) {
+	if format != "jpeg" {
+		return c, nil
+	}
+	if !opts.JPEGNative {
+		return newCarrierImage(c.Image), nil
+	}
+	if c.dct == nil {
+		return nil, wrapError(nil, ErrInvalidFormat, "only baseline JPEG carriers can be embedded in their DCT coefficients")
+	}
+	return c, nil
+}
+
+// coefficientCarries reports whether a coefficient carries payload bits at the given bit depth. Like carries
+// for pixels it only looks at the bits above the low bitDepth bits of the magnitude.
+func (e embedding) coefficientCarries(img *carrierImage, index int) bool {
+	if index%jpeg_dct.BlockSize == 0 || coefficientMagnitude(img.dct.Coefficients[index])>>e.bitDepth == 0 {
+		return false
+	}
+	return !e.useMask || e.mask.selects(img.lowByte(index, 0, 0), e.bitDepth)
+}
+
+// coefficientMaskByte returns the byte the mask looks at for a coefficient. Most AC magnitudes are small, so
+// the magnitude alone takes only a handful of values and a mask could pass or reject nearly all of them; the
+// coefficient's position in its block is mixed into the bits above the lowest two to spread the selection
+// out. The position never changes and clearing the low bits of an XOR clears them in both halves, so the
+// selection is as stable as the magnitude's high bits.
+func (c *carrierImage) coefficientMaskByte(index int) uint8 {
+	return uint8(coefficientMagnitude(c.dct.Coefficients[index])) ^ uint8(index%jpeg_dct.BlockSize)<<2
+}
+
+// coefficientMatchRange returns the magnitude of a coefficient and the range LSB matching may move it in: the
+// magnitudes with the same bit length, which keep its size category and Huffman symbol
+func (c *carrierImage) coefficientMatchRange(index int) (value, lowest, highest int) {
+	magnitude := coefficientMagnitude(c.dct.Coefficients[index])
+	length := bits.Len32(magnitude)
+	return int(magnitude), 1 << (length - 1), 1<<length - 1
+}
+
+// setCoefficientMagnitude sets the magnitude of a coefficient, keeping its sign
+func (c *carrierImage) setCoefficientMagnitude(index, magnitude int) {
+	if c.dct.Coefficients[index] < 0 {
+		magnitude = -magnitude
+	}
+	c.dct.Coefficients[index] = int32(magnitude)
+}
+
+// coefficientMagnitude returns the absolute value of a coefficient
+func coefficientMagnitude(v int32) uint32 {
+	if v < 0 {
+		return uint32(-v)
+	}
+	return uint32(v)
+}
+
+// coefficientBits returns the last n bits of the magnitude of a coefficient
+func (c *carrierImage) coefficientBits(index, n int) byte {
+	return byte(coefficientMagnitude(c.dct.Coefficients[index]) & (1<<n - 1))
+}
+
+// setCoefficientBits sets the last n bits of the magnitude of a coefficient to value, keeping its sign
+func (c *carrierImage) setCoefficientBits(index, n int, value byte) {
+	v := c.dct.Coefficients[index]
+	magnitude := int32(coefficientMagnitude(v)&^(1<<n-1) | uint32(value)&(1<<n-1))
+	if v < 0 {
+		magnitude = -magnitude
+	}
+	c.dct.Coefficients[index] = magnitude
+}
@@ -12,16 +12,43 @@ const fileExtensionPixels = 11
 const encodingFlagsPixels = 1
 const checksumPixels = 2
 const byteCountModuloPixels = 2
-const reservedPixels = 3
+const maskInfoPixels = 1
+const pipelineFlagsPixels = 1
+const reservedPixels = 1
 
 const totalReservedPixels = legacyTotalReservedPixels + versionMarkerPixels + fileExtensionPixels +
-	encodingFlagsPixels + checksumPixels + byteCountModuloPixels + reservedPixels // 34
+	encodingFlagsPixels + checksumPixels + byteCountModuloPixels + maskInfoPixels + pipelineFlagsPixels +
+	reservedPixels // 34
 
 // Version marker magic: 101010 110011 across 2 pixels (12 bits)
 // Pixel 13 R/G/B last-2-bits: 10, 10, 10
 // Pixel 14 R/G/B last-2-bits: 11, 00, 11
 var versionMarkerBytes = [6]byte{2, 2, 2, 3, 0, 3}
 
+// Format version 2 marker: the same first 8 bits, with the last 4 bits changed from 0011 to 0100
+var versionMarkerV2Bytes = [6]byte{2, 2, 2, 3, 1, 0}
+
+// currentFormatVersion is the header format written by Encode
+const currentFormatVersion = 2
+
+// headerSamples is the number of 2-bit samples the column 0 header takes: pixels 0-33, R, G and B. Gray and
+// paletted carriers have one sample per pixel and spread them over the reserved rows of columns 0-2.
+const headerSamples = totalReservedPixels * channelsPerPixel
+
+// coefficientHeaderSamples is the number of 2-bit samples a JPEG-native carrier reserves for its header: the
+// header samples and room for a 42 byte extension record
+const coefficientHeaderSamples = headerSamples + 4*42
+
+// Format version 2 extension block: a length-prefixed byte record stored 2 bits per channel in the reserved
+// header rows (y=0..33) after the header samples, which the payload never touches. On color carriers it
+// starts at column 1.
+
+// maxCarriers is the largest carrier set the 6-bit photo number can address
+const maxCarriers = 1 << 6
+
+// photoIDBits is the width of the photo ID field in the header (24 two-bit channels)
+const photoIDBits = 48
+
 const instagramMaxImageWidth = 1080
 const instagramMaxImageHeight = 1350
 const instagramHalfMaxWidth = instagramMaxImageWidth / 2
@@ -1,21 +1,23 @@
 package image_processing
 
 import (
+	"cmp"
 	"fmt"
-	"go-steg/cli/helpers"
 	"go-steg/go_steg/bit_manipulation"
 	"go-steg/go_steg/pipeline"
-	"image"
+	"hash/crc32"
 	"io"
 	"math"
 	"os"
+	"slices"
+	"strings"
 	"time"
 )
 
 // MultiCarrierDecodeByFileNames performs steganography decoding of data previously encoded by the MultiCarrierEncode function.
 // The data is decoded from carrier files, and it is saved in a new file.
-// NOTE: The order of the carriers MUST be the same as the one when encoding.
-func MultiCarrierDecodeByFileNames(carrierFileNames []string, password string, outputFileDir string) (err error) {
+// The carriers may be given in any order. The payload is streamed to the output file rather than held in memory.
+func MultiCarrierDecodeByFileNames(carrierFileNames []string, password string, outputFileDir string, opts Options) (err error) {
 	if len(carrierFileNames) == 0 {
 		return fmt.Errorf("missing carriers names")
 	}
@@ -41,12 +43,15 @@ func MultiCarrierDecodeByFileNames(carrierFileNames []string, password string, o
 	if err != nil {
 		return fmt.Errorf("error opening first carrier for header: %v", err)
 	}
-	firstRGBA, _, err := getImageAsRGBA(firstCarrierForHeader)
+	firstRGBA, _, err := getCarrierImage(firstCarrierForHeader)
 	firstCarrierForHeader.Close()
 	if err != nil {
 		return fmt.Errorf("error reading first carrier image: %v", err)
 	}
-	header := readHeader(firstRGBA)
+	header, err := readCarrierHeader(firstRGBA, generateMaskingInfo(password), opts)
+	if err != nil {
+		return err
+	}
 
 	// Determine file extension for output
 	ext := "png" // default for legacy
@@ -75,7 +80,7 @@ func MultiCarrierDecodeByFileNames(carrierFileNames []string, password string, o
 		return fmt.Errorf("issue closing the result file: %w", err)
 	}
 
-	err = MultiCarrierDecode(carriers, result, password)
+	err = MultiCarrierDecodeStream(carriers, result, password, opts)
 	if err != nil {
 		logger.Errorf("Error decoding files: %v", err)
 		_ = os.Remove(resultName)
@@ -86,26 +91,43 @@ func MultiCarrierDecodeByFileNames(carrierFileNames []string, password string, o
 // MultiCarrierDecode performs steganography decoding of Readers with previously encoded data chunks by the
 // MultiCarrierEncode function and writes to result Writer.
 //
-// NOTE: The order of the carriers MUST be the same as the one when encoding.
-func MultiCarrierDecode(carriers []io.Reader, result io.Writer, password string) error {
+// The carriers may be given in any order: every header is read first and the chunks are reassembled by their
+// photo number. Carriers from a different encode (another photo ID), duplicated parts and gaps in the part
+// numbers are rejected with an error naming them.
+func MultiCarrierDecode(carriers []io.Reader, result io.Writer, password string, opts Options) error {
 	mask := generateMaskingInfo(password)
 
-	fmt.Println("Masking info: ", mask)
-
-	// Collect raw decoded bytes from each carrier
-	var allBytes []byte
-	var firstHeader HeaderInfo
-
+	// Collect raw decoded bytes and headers from each carrier
+	parts := make([]carrierPart, 0, len(carriers))
 	for i := 0; i < len(carriers); i++ {
-		decoded, header, err := DecodeRaw(carriers[i], mask)
+		decoded, header, err := DecodeRaw(carriers[i], mask, opts)
 		if err != nil {
 			logger.Errorf("Error decoding chunk: %v", err)
 			return fmt.Errorf("error decoding chunk with index %d: %v", i, err)
 		}
-		if i == 0 {
-			firstHeader = header
+		parts = append(parts, carrierPart{index: i, header: header, data: decoded})
+	}
+
+	logHeaderDamage(parts)
+	if err := orderCarrierParts(parts); err != nil {
+		return err
+	}
+
+	var allBytes []byte
+	for _, part := range parts {
+		if part.header.HasLayout && int(part.header.ChunkOffset) != len(allBytes) {
+			return wrapError(nil, ErrIntegrityCheck, fmt.Sprintf("chunk of part %d starts at byte %d, header records %d",
+				part.header.PhotoNumber, len(allBytes), part.header.ChunkOffset))
+		}
+		if err := verifyChunk(part.data, part.header, part.index); err != nil {
+			return err
 		}
-		allBytes = append(allBytes, decoded...)
+		allBytes = append(allBytes, part.data...)
+	}
+	firstHeader := parts[0].header
+
+	if err := verifyPayload(allBytes, firstHeader); err != nil {
+		return err
 	}
 
 	// If new format, run pipeline decode
@@ -115,6 +137,7 @@ func MultiCarrierDecode(carriers []io.Reader, result io.Writer, password string)
 			HuffmanEnabled: firstHeader.HuffmanEnabled,
 			RSEnabled:      firstHeader.RSEnabled,
 			RSLevel:        firstHeader.RSLevel,
+			Encrypted:      firstHeader.Encrypted,
 			Password:       password,
 		}
 		decoded, err := pipeline.Decode(allBytes, cfg)
@@ -124,6 +147,10 @@ func MultiCarrierDecode(carriers []io.Reader, result io.Writer, password string)
 		allBytes = decoded
 	}
 
+	if firstHeader.HasIntegrity && crc32.ChecksumIEEE(allBytes) != firstHeader.DataCRC32 {
+		return wrapError(nil, ErrIntegrityCheck, "CRC-32 of the decoded data does not match the header")
+	}
+
 	if _, err := result.Write(allBytes); err != nil {
 		logger.Errorf("Error writing result file: %v", err)
 		return err
@@ -132,70 +159,242 @@ func MultiCarrierDecode(carriers []io.Reader, result io.Writer, password string)
 	return nil
 }
 
+// carrierPart is the raw chunk extracted from one carrier together with its header and its position in the
+// caller's carrier list
+type carrierPart struct {
+	index  int
+	header HeaderInfo
+	data   []byte
+	// img holds the decoded carrier when the chunk is extracted later, as the streaming decoder does
+	img *carrierImage
+}
+
+// logHeaderDamage warns about every carrier whose header had damaged copies or was read from its copies
+func logHeaderDamage(parts []carrierPart) {
+	for _, part := range parts {
+		for _, damage := range part.header.HeaderDamage {
+			logger.Warnf("Carrier %d: %s", part.index, damage)
+		}
+	}
+}
+
+// orderCarrierParts sorts parts by photo number in place. It fails if the parts carry different photo IDs or
+// total part counts, or if any part number is duplicated or missing. The set runs up to the total part count
+// recorded in the header, or up to the highest number seen for carriers that do not record it.
+func orderCarrierParts(parts []carrierPart) error {
+	if len(parts) == 0 {
+		return wrapError(nil, ErrCarrierSet, "no carriers given")
+	}
+
+	first := parts[0]
+	for _, part := range parts[1:] {
+		if part.header.PhotoID != first.header.PhotoID {
+			return wrapError(nil, ErrCarrierSet, fmt.Sprintf("carrier %d has photo ID %d, carrier %d has photo ID %d",
+				part.index, part.header.PhotoID, first.index, first.header.PhotoID))
+		}
+		if part.header.TotalParts != first.header.TotalParts {
+			return wrapError(nil, ErrCarrierSet, fmt.Sprintf("carrier %d belongs to a set of %d, carrier %d to a set of %d",
+				part.index, part.header.TotalParts, first.index, first.header.TotalParts))
+		}
+	}
+
+	slices.SortStableFunc(parts, func(a, b carrierPart) int {
+		return cmp.Compare(a.header.PhotoNumber, b.header.PhotoNumber)
+	})
+
+	total := first.header.TotalParts
+	if last := parts[len(parts)-1]; total != 0 && last.header.PhotoNumber >= total {
+		return wrapError(nil, ErrCarrierSet, fmt.Sprintf("carrier %d has part number %d in a set of %d",
+			last.index, last.header.PhotoNumber, total))
+	}
+
+	var missing, duplicate []uint16
+	next := uint16(0)
+	for i, part := range parts {
+		number := part.header.PhotoNumber
+		if i > 0 && number == parts[i-1].header.PhotoNumber {
+			if len(duplicate) == 0 || duplicate[len(duplicate)-1] != number {
+				duplicate = append(duplicate, number)
+			}
+			continue
+		}
+		for ; next < number; next++ {
+			missing = append(missing, next)
+		}
+		next = number + 1
+	}
+	for ; next < total; next++ {
+		missing = append(missing, next)
+	}
+
+	if len(missing) == 0 && len(duplicate) == 0 {
+		return nil
+	}
+	var problems []string
+	if len(missing) > 0 {
+		problems = append(problems, fmt.Sprintf("missing part numbers %v", missing))
+	}
+	if len(duplicate) > 0 {
+		problems = append(problems, fmt.Sprintf("duplicate part numbers %v", duplicate))
+	}
+	if total != 0 {
+		problems = append(problems, fmt.Sprintf("set has %d parts", total))
+	}
+	return wrapError(nil, ErrCarrierSet, strings.Join(problems, ", "))
+}
+
+// verifyChunk checks the bytes extracted from one carrier against the chunk CRC-32 in its header. When the
+// payload is Reed-Solomon protected a mismatch is only logged, since the pipeline may still correct it.
+func verifyChunk(chunk []byte, header HeaderInfo, index int) error {
+	return verifyChunkCRC(crc32.ChecksumIEEE(chunk), header, index)
+}
+
+// verifyChunkCRC is verifyChunk for a chunk whose CRC-32 has already been computed
+func verifyChunkCRC(sum uint32, header HeaderInfo, index int) error {
+	if header.FormatVersion >= 2 && !header.HasIntegrity {
+		return wrapError(nil, ErrIntegrityCheck, fmt.Sprintf("header extension of carrier %d is unreadable", index))
+	}
+	if !header.HasIntegrity || sum == header.ChunkCRC32 {
+		return nil
+	}
+	if header.RSEnabled {
+		logger.Warnf("Carrier %d failed its CRC-32 check, relying on Reed-Solomon correction", index)
+		return nil
+	}
+	return wrapError(nil, ErrIntegrityCheck, fmt.Sprintf("CRC-32 of carrier %d does not match its header", index))
+}
+
+// verifyPayload checks the reassembled pipeline output against the length and CRC-32 recorded in the header
+// before it is handed to the pipeline. As with verifyChunk, a CRC mismatch is tolerated when Reed-Solomon
+// correction is enabled, but a length mismatch never is.
+func verifyPayload(payload []byte, header HeaderInfo) error {
+	if err := verifyPayloadLength(int64(len(payload)), header); err != nil {
+		return err
+	}
+	return verifyPayloadCRC(crc32.ChecksumIEEE(payload), header)
+}
+
+// verifyPayloadLength checks the length half of verifyPayload
+func verifyPayloadLength(length int64, header HeaderInfo) error {
+	if header.HasIntegrity && length != int64(header.PayloadLength) {
+		return wrapError(nil, ErrIntegrityCheck, fmt.Sprintf("decoded %d bytes, header records %d", length, header.PayloadLength))
+	}
+	return nil
+}
+
+// verifyPayloadCRC checks the CRC-32 half of verifyPayload
+func verifyPayloadCRC(sum uint32, header HeaderInfo) error {
+	if header.HasIntegrity && sum != header.PayloadCRC32 && !header.RSEnabled {
+		return wrapError(nil, ErrIntegrityCheck, "CRC-32 of the reassembled payload does not match the header")
+	}
+	return nil
+}
+
+// headerEmbedding returns the embedding settings of a carrier with the given header. Older carriers do not
+// record mask usage, so opts.UseMask decides for them.
+func headerEmbedding(header HeaderInfo, opts Options, mask Mask) (embedding, error) {
+	e := embedding{
+		// Legacy carriers are always 2-bit; the new format records its bit depth
+		bitDepth:  2,
+		useMask:   opts.UseMask,
+		mask:      mask,
+		scattered: header.Scattered,
+		alpha:     header.AlphaChannel,
+	}
+	if header.IsNewFormat && header.BitDepth >= 1 && header.BitDepth <= 4 {
+		e.bitDepth = header.BitDepth
+	}
+	if header.IsNewFormat && header.HasExtendedFlags {
+		if header.MaskAlgorithm > maxMaskAlgorithm {
+			return e, fmt.Errorf("unsupported mask algorithm %d in carrier header", header.MaskAlgorithm)
+		}
+		e.useMask = header.MaskEnabled
+	}
+	if header.MaskCandidate >= maskCandidates {
+		return e, fmt.Errorf("unsupported mask candidate %d in carrier header", header.MaskCandidate)
+	}
+	if header.MaskCandidate != 0 {
+		e.mask = mask.candidate(header.MaskCandidate)
+	}
+	if e.useMask && header.MaskAlgorithm == MaskAlgorithmDensity {
+		if header.MaskDensity < 1 || header.MaskDensity > maxMaskDensity {
+			return e, fmt.Errorf("unsupported mask density %d/8 in carrier header", header.MaskDensity)
+		}
+		e.mask = e.mask.withDensity(header.MaskDensity)
+	}
+	if header.Adaptive {
+		if header.AdaptiveThresholds[1] == 0 || header.AdaptiveThresholds[0] > header.AdaptiveThresholds[1] {
+			return e, fmt.Errorf("unsupported adaptive embedding thresholds %v in carrier header", header.AdaptiveThresholds)
+		}
+		e.adaptive = true
+		e.thresholds = header.AdaptiveThresholds
+	}
+	if header.MatrixEmbedding {
+		if header.MatrixK < 1 || header.MatrixK > maxMatrixK || e.bitDepth != 1 {
+			return e, fmt.Errorf("unsupported matrix embedding with k = %d at bit depth %d in carrier header", header.MatrixK, e.bitDepth)
+		}
+		e.matrixK = int(header.MatrixK)
+	}
+	return e, nil
+}
+
 // DecodeRaw extracts the raw embedded bytes from a single carrier, returning the bytes and the header info.
-func DecodeRaw(carrier io.Reader, mask Mask) ([]byte, HeaderInfo, error) {
-	RGBAImage, _, err := getImageAsRGBA(carrier)
+// The bit depth and mask usage are taken from the carrier header; opts.UseMask is only consulted for
+// carriers written before mask usage was recorded in the header.
+func DecodeRaw(carrier io.Reader, mask Mask, opts Options) ([]byte, HeaderInfo, error) {
+	RGBAImage, _, err := getCarrierImage(carrier)
 	if err != nil {
 		logger.Errorf("Error parsing carrier image: %v", err)
 		return nil, HeaderInfo{}, fmt.Errorf("error parsing carrier image: %w", err)
 	}
 
-	dx := RGBAImage.Bounds().Dx()
-	dy := RGBAImage.Bounds().Dy()
+	header, err := readCarrierHeader(RGBAImage, mask, opts)
+	if err != nil {
+		return nil, header, err
+	}
 
-	header := readHeader(RGBAImage)
+	fmt.Printf("Data count for this carrier: %v\n", header.DataCount)
 
-	dataCount := int(header.DataCount)
-	fmt.Printf("Data count for this carrier: %v\n", dataCount)
+	embed, err := headerEmbedding(header, opts, mask)
+	if err != nil {
+		return nil, header, err
+	}
 
-	if helpers.UseMask {
-		openSlots := DetermineOpenSlotsWithMask(RGBAImage, dx, dy, mask)
-		fmt.Printf("Number of slots availabe with mask: %v\n", openSlots)
+	if embed.useMask {
+		fmt.Printf("Number of slots availabe with mask: %v\n", embed.count(RGBAImage))
 	}
 
 	if !header.IsNewFormat {
 		// Legacy 2-bit extraction
-		return decodeLegacy(RGBAImage, dx, dy, dataCount, mask), header, nil
+		return decodeLegacy(RGBAImage, int(header.DataCount), mask, embed.useMask), header, nil
 	}
 
-	// New format: use variable bit depth
-	bitDepth := header.BitDepth
-	if bitDepth < 1 || bitDepth > 4 {
-		bitDepth = 2
-	}
+	return extractChunk(RGBAImage, int(header.DataCount), embed), header, nil
+}
 
+// extractChunk reads dataCount payload slots of a new-format carrier back into bytes
+func extractChunk(RGBAImage *carrierImage, dataCount int, embed embedding) []byte {
+	bitDepth := embed.chunkBits()
 	dataBytes := make([]byte, 0, dataCount)
 
-	for x := 0; x < dx && dataCount > 0; x++ {
-		for y := totalReservedPixels; y < dy && dataCount > 0; y++ {
-			c := RGBAImage.RGBAAt(x, y)
-			if helpers.UseMask && bit_manipulation.ReturnMaskDifferenceN(mask.maskInt, mask.multiplier, mask.firstIndex, mask.secondIndex, c.R, bitDepth) == mask.changeBoolean {
-				dataBytes = append(dataBytes, bit_manipulation.GetLastNBits(c.R, bitDepth))
-				dataCount--
-			} else if !helpers.UseMask {
-				dataBytes = append(dataBytes, bit_manipulation.GetLastNBits(c.R, bitDepth))
-				dataCount--
-			}
-			if dataCount > 0 {
-				if helpers.UseMask && bit_manipulation.ReturnMaskDifferenceN(mask.maskInt, mask.multiplier, mask.firstIndex, mask.secondIndex, c.G, bitDepth) == mask.changeBoolean {
-					dataBytes = append(dataBytes, bit_manipulation.GetLastNBits(c.G, bitDepth))
-					dataCount--
-				} else if !helpers.UseMask {
-					dataBytes = append(dataBytes, bit_manipulation.GetLastNBits(c.G, bitDepth))
-					dataCount--
-				}
+	if embed.matrixK > 0 {
+		dataBytes = extractGroups(RGBAImage, dataCount, embed)
+		dataCount -= len(dataBytes)
+	} else if embed.adaptive {
+		dataBytes = extractAdaptive(RGBAImage, dataCount, embed)
+		dataCount -= len(dataBytes)
+	} else {
+		for sl := range embed.slots(RGBAImage) {
+			if dataCount <= 0 {
+				break
 			}
-			if dataCount > 0 {
-				if helpers.UseMask && bit_manipulation.ReturnMaskDifferenceN(mask.maskInt, mask.multiplier, mask.firstIndex, mask.secondIndex, c.B, bitDepth) == mask.changeBoolean {
-					dataBytes = append(dataBytes, bit_manipulation.GetLastNBits(c.B, bitDepth))
-					dataCount--
-				} else if !helpers.UseMask {
-					dataBytes = append(dataBytes, bit_manipulation.GetLastNBits(c.B, bitDepth))
-					dataCount--
-				}
+			if !embed.carries(RGBAImage, sl) {
+				continue
 			}
-			if dataCount <= 0 {
-				fmt.Printf("Last decoded pixel location - (%v, %v)\n", x, y)
+			dataBytes = append(dataBytes, RGBAImage.lastBits(sl.x, sl.y, sl.channel, bitDepth))
+			dataCount--
+			if dataCount == 0 {
+				fmt.Printf("Last decoded pixel location - (%v, %v)\n", sl.x, sl.y)
 			}
 		}
 	}
@@ -219,13 +418,13 @@ func DecodeRaw(carrier io.Reader, mask Mask) ([]byte, HeaderInfo, error) {
 
 	fmt.Printf("Result bytes length - %v\n\n", len(resultBytes))
 
-	return resultBytes, header, nil
+	return resultBytes
 }
 
 // Decode reverses the Encode method and extracts the embed image data from the carrier file.
 // This is kept for backward compatibility; it calls DecodeRaw internally.
-func Decode(carrier io.Reader, result io.Writer, mask Mask) error {
-	decoded, _, err := DecodeRaw(carrier, mask)
+func Decode(carrier io.Reader, result io.Writer, mask Mask, opts Options) error {
+	decoded, _, err := DecodeRaw(carrier, mask, opts)
 	if err != nil {
 		return err
 	}
@@ -239,36 +438,22 @@ func Decode(carrier io.Reader, result io.Writer, mask Mask) error {
 }
 
 // decodeLegacy extracts data using the legacy 2-bit method with legacyTotalReservedPixels bounds.
-func decodeLegacy(RGBAImage *image.RGBA, dx, dy, dataCount int, mask Mask) []byte {
+func decodeLegacy(RGBAImage *carrierImage, dataCount int, mask Mask, useMask bool) []byte {
+	dx := RGBAImage.Bounds().Dx()
+	dy := RGBAImage.Bounds().Dy()
 	dataBytes := make([]byte, 0, 100000)
 
 	for x := 0; x < dx && dataCount > 0; x++ {
 		for y := totalReservedPixels; y < dy && dataCount > 0; y++ {
-			c := RGBAImage.RGBAAt(x, y)
-			if helpers.UseMask && bit_manipulation.ReturnMaskDifference(mask.maskInt, mask.multiplier, mask.firstIndex, mask.secondIndex, c.R) == mask.changeBoolean {
-				dataBytes = append(dataBytes, bit_manipulation.GetLastTwoBits(c.R))
-				dataCount--
-			} else if !helpers.UseMask {
-				dataBytes = append(dataBytes, bit_manipulation.GetLastTwoBits(c.R))
-				dataCount--
-			}
-			if dataCount > 0 {
-				if helpers.UseMask && bit_manipulation.ReturnMaskDifference(mask.maskInt, mask.multiplier, mask.firstIndex, mask.secondIndex, c.G) == mask.changeBoolean {
-					dataBytes = append(dataBytes, bit_manipulation.GetLastTwoBits(c.G))
-					dataCount--
-				} else if !helpers.UseMask {
-					dataBytes = append(dataBytes, bit_manipulation.GetLastTwoBits(c.G))
-					dataCount--
+			for channel := 0; channel < RGBAImage.colorChannels(); channel++ {
+				if dataCount <= 0 {
+					break
 				}
-			}
-			if dataCount > 0 {
-				if helpers.UseMask && bit_manipulation.ReturnMaskDifference(mask.maskInt, mask.multiplier, mask.firstIndex, mask.secondIndex, c.B) == mask.changeBoolean {
-					dataBytes = append(dataBytes, bit_manipulation.GetLastTwoBits(c.B))
-					dataCount--
-				} else if !helpers.UseMask {
-					dataBytes = append(dataBytes, bit_manipulation.GetLastTwoBits(c.B))
-					dataCount--
+				if useMask && !mask.selects(RGBAImage.lowByte(x, y, channel), 2) {
+					continue
 				}
+				dataBytes = append(dataBytes, RGBAImage.lastBits(x, y, channel, 2))
+				dataCount--
 			}
 			if dataCount <= 0 {
 				fmt.Printf("Last decoded pixel location - (%v, %v)\n", x, y)
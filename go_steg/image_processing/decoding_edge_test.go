@@ -73,7 +73,7 @@ func TestAlignNIdempotent(t *testing.T) {
 }
 
 func TestMultiCarrierDecodeByFileNamesEmptyCarriers(t *testing.T) {
-	err := MultiCarrierDecodeByFileNames([]string{}, "password", "/tmp")
+	err := MultiCarrierDecodeByFileNames([]string{}, "password", "/tmp", Options{})
 	if err == nil {
 		t.Error("expected error for empty carrier list")
 	}
@@ -1,7 +1,6 @@
 package image_processing
 
 import (
-	"go-steg/cli/helpers"
 	"os"
 	"reflect"
 	"testing"
@@ -28,7 +27,6 @@ func TestMultiCarrierDecodeByFileNames(t *testing.T) {
 		},
 	}
 	for _, tt := range tests {
-		helpers.UseMask = true
 		t.Run(tt.name, func(t *testing.T) {
 			// Skip if fixture files don't exist (they are gitignored)
 			for _, f := range tt.args.carrierFileNames {
@@ -36,7 +34,7 @@ func TestMultiCarrierDecodeByFileNames(t *testing.T) {
 					t.Skipf("fixture file %s not found, skipping", f)
 				}
 			}
-			if err := MultiCarrierDecodeByFileNames(tt.args.carrierFileNames, tt.args.password, tt.args.outputFileDir); (err != nil) != tt.wantErr {
+			if err := MultiCarrierDecodeByFileNames(tt.args.carrierFileNames, tt.args.password, tt.args.outputFileDir, Options{UseMask: true}); (err != nil) != tt.wantErr {
 				t.Errorf("MultiCarrierDecodeByFileNames() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
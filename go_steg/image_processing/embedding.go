@@ -0,0 +1,126 @@
+package image_processing
+
+import (
+	"iter"
+)
+
+// embedding holds the settings that decide which channels of a carrier carry payload bits and how many bits
+// each one holds. Encode takes them from Options and decode from the carrier header, so both sides walk the
+// same channels.
+type embedding struct {
+	bitDepth  int
+	useMask   bool
+	mask      Mask
+	scattered bool
+	alpha     bool
+	matching  bool
+	// matrixK is the Hamming code parameter of matrix embedding, 0 when every slot holds bitDepth bits
+	matrixK int
+	// adaptive varies the bits a slot holds with the texture around it, at the levels in thresholds
+	adaptive   bool
+	thresholds [2]uint8
+}
+
+// embedding returns the embedding settings the options ask for
+func (o Options) embedding(mask Mask) embedding {
+	return embedding{
+		bitDepth:   o.bitDepth(),
+		useMask:    o.UseMask,
+		mask:       mask,
+		scattered:  o.Scatter,
+		alpha:      o.UseAlpha,
+		matching:   o.LSBMatching,
+		adaptive:   o.Adaptive,
+		thresholds: o.adaptiveThresholds(),
+	}
+}
+
+// channels returns how many channels of each pixel of img are visited. Gray and paletted carriers have a
+// single one and no alpha channel to add.
+func (e embedding) channels(img *carrierImage) int {
+	if e.alpha && img.colorChannels() == channelsPerPixel {
+		return alphaChannelsPerPixel
+	}
+	return img.colorChannels()
+}
+
+// slots returns every payload slot of img in the order the payload is written
+func (e embedding) slots(img *carrierImage) iter.Seq[slot] {
+	if img.dct != nil {
+		return coefficientSlots(img.payloadStart, len(img.dct.Coefficients), e.scattered, e.mask)
+	}
+	return payloadSlots(img.Bounds().Dx(), img.Bounds().Dy(), e.channels(img), e.scattered, e.mask)
+}
+
+// carries reports whether a slot carries payload. Fully transparent pixels never do: their color is
+// invisible, and editors and optimizers commonly discard it. The other tests only look at the bits above the
+// bitDepth low bits, so the answer does not change as data is embedded.
+func (e embedding) carries(img *carrierImage, sl slot) bool {
+	if img.dct != nil {
+		return e.coefficientCarries(img, sl.x)
+	}
+	alpha := img.alpha(sl.x, sl.y)
+	if alpha == 0 {
+		return false
+	}
+	if sl.channel == alphaChannel && !nearlyOpaque(alpha, img.maxSample(), e.bitDepth) {
+		return false
+	}
+	if e.useMask && !e.mask.selects(img.lowByte(sl.x, sl.y, sl.channel), e.bitDepth) {
+		return false
+	}
+	if e.adaptive && e.slotBits(img, sl) == 0 {
+		return false
+	}
+	return true
+}
+
+// count returns the number of channels of img that carry payload
+func (e embedding) count(img *carrierImage) int64 {
+	bounds := img.Bounds()
+	if img.dct == nil && !e.useMask && !e.adaptive && e.channels(img) == img.colorChannels() && img.opaque() {
+		return int64(payloadSlotCount(bounds.Dx(), bounds.Dy(), img.colorChannels()))
+	}
+	// Every order visits the same slots, so count them in the cheapest one
+	sequential := e
+	sequential.scattered = false
+	var count int64
+	for sl := range sequential.slots(img) {
+		if e.carries(img, sl) {
+			count++
+		}
+	}
+	return count
+}
+
+// bitWriter embeds payload bits one at a time where a slot does not simply take one chunk
+type bitWriter interface {
+	writeBit(bit byte) error
+	// flush embeds any bits still held back, padding them with zero bits
+	flush() error
+}
+
+// bitWriter returns the bitWriter for img with matrix or adaptive embedding, nil when every slot takes one
+// chunk. next returns the next slot of img that carries payload.
+func (e embedding) bitWriter(m matcher, img *carrierImage, next func() (slot, error)) bitWriter {
+	switch {
+	case e.matrixK > 0:
+		return newGroupWriter(m, img, next)
+	case e.adaptive:
+		return &adaptiveWriter{matcher: m, img: img, next: next}
+	default:
+		return nil
+	}
+}
+
+// maxAlphaBitDepth is the largest bit depth the alpha channel carries payload at. Above it a nearly opaque
+// pixel of an 8-bit carrier could drop to 240/255, which shows.
+const maxAlphaBitDepth = 2
+
+// nearlyOpaque reports whether an alpha value stays within the top 2^bitDepth values whatever is written to
+// its low bits, max being fully opaque. Only those pixels carry payload in their alpha channel, so at bit
+// depth 2 the opacity of an 8-bit carrier pixel never drops below 252/255 and the image looks the same.
+func nearlyOpaque(alpha, max uint16, bitDepth int) bool {
+	low := uint16(1)<<bitDepth - 1
+	return alpha|low == max
+}
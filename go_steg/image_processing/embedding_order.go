@@ -0,0 +1,110 @@
+package image_processing
+
+import (
+	"iter"
+	mathrand "math/rand"
+)
+
+// channelsPerPixel is the number of color channels (R, G, B) that can carry payload bits
+const channelsPerPixel = 3
+
+// alphaChannelsPerPixel is the number of channels visited when the alpha channel carries payload too
+const alphaChannelsPerPixel = 4
+
+// alphaChannel is the slot channel index of the alpha channel
+const alphaChannel = 3
+
+// slot addresses one color channel of one pixel in the payload region, or one coefficient of a JPEG-native
+// carrier
+type slot struct {
+	x, y    int
+	channel int // 0 = R, 1 = G, 2 = B, 3 = A
+}
+
+// payloadSlotCount returns how many channel slots sit below the reserved header rows
+func payloadSlotCount(width, height, channels int) int {
+	if height <= totalReservedPixels || width <= 0 {
+		return 0
+	}
+	return width * (height - totalReservedPixels) * channels
+}
+
+// slotAt converts a column-major slot index into pixel coordinates and a channel
+func slotAt(index, height, channels int) slot {
+	rows := height - totalReservedPixels
+	pixel := index / channels
+	return slot{
+		x:       pixel / rows,
+		y:       totalReservedPixels + pixel%rows,
+		channel: index % channels,
+	}
+}
+
+// sequentialSlots walks the payload region column by column, top to bottom, R then G then B (then A).
+// This is the original embedding order and is still used for carriers without the scattered flag.
+func sequentialSlots(width, height, channels int) iter.Seq[slot] {
+	return func(yield func(slot) bool) {
+		n := payloadSlotCount(width, height, channels)
+		for i := 0; i < n; i++ {
+			if !yield(slotAt(i, height, channels)) {
+				return
+			}
+		}
+	}
+}
+
+// scatteredSlots walks every payload slot exactly once in a pseudorandom order keyed by seed, so a short
+// payload is spread over the whole carrier instead of being packed into the left-hand columns.
+//
+// The order is a Fisher-Yates shuffle of the slot indices driven by a seeded math/rand source, which is
+// deterministic for a given seed, so the decoder regenerates the same order from the password.
+func scatteredSlots(width, height, channels int, seed uint64) iter.Seq[slot] {
+	return func(yield func(slot) bool) {
+		for _, index := range shuffledOrder(payloadSlotCount(width, height, channels), seed) {
+			if !yield(slotAt(int(index), height, channels)) {
+				return
+			}
+		}
+	}
+}
+
+// shuffledOrder returns the numbers 0 to n-1 in the pseudorandom order keyed by seed
+func shuffledOrder(n int, seed uint64) []uint32 {
+	order := make([]uint32, n)
+	for i := range order {
+		order[i] = uint32(i)
+	}
+	rng := mathrand.New(mathrand.NewSource(int64(seed)))
+	rng.Shuffle(n, func(i, j int) {
+		order[i], order[j] = order[j], order[i]
+	})
+	return order
+}
+
+// payloadSlots returns the slot order for a carrier, scattered by the mask's order seed when requested.
+func payloadSlots(width, height, channels int, scattered bool, mask Mask) iter.Seq[slot] {
+	if scattered {
+		return scatteredSlots(width, height, channels, mask.orderSeed)
+	}
+	return sequentialSlots(width, height, channels)
+}
+
+// coefficientSlots returns the slot order of a JPEG-native carrier: the coefficients from start up to end, in
+// file order or scattered by the mask's order seed.
+func coefficientSlots(start, end int, scattered bool, mask Mask) iter.Seq[slot] {
+	return func(yield func(slot) bool) {
+		if !scattered {
+			for i := start; i < end; i++ {
+				if !yield(slot{x: i}) {
+					return
+				}
+			}
+			return
+		}
+		for _, index := range shuffledOrder(max(end-start, 0), mask.orderSeed) {
+			if !yield(slot{x: start + int(index)}) {
+				return
+			}
+		}
+	}
+}
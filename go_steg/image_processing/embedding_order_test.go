@@ -0,0 +1,98 @@
+package image_processing
+
+import (
+	"testing"
+)
+
+func TestSequentialSlotsColumnMajor(t *testing.T) {
+	width, height := 3, totalReservedPixels+2
+	var got []slot
+	for sl := range sequentialSlots(width, height, channelsPerPixel) {
+		got = append(got, sl)
+	}
+	if len(got) != payloadSlotCount(width, height, channelsPerPixel) {
+		t.Fatalf("got %d slots, want %d", len(got), payloadSlotCount(width, height, channelsPerPixel))
+	}
+
+	// The original walk: x outer, y inner starting below the header, then R, G, B
+	i := 0
+	for x := 0; x < width; x++ {
+		for y := totalReservedPixels; y < height; y++ {
+			for ch := 0; ch < channelsPerPixel; ch++ {
+				want := slot{x: x, y: y, channel: ch}
+				if got[i] != want {
+					t.Fatalf("slot %d = %+v, want %+v", i, got[i], want)
+				}
+				i++
+			}
+		}
+	}
+}
+
+func TestScatteredSlotsIsPermutation(t *testing.T) {
+	width, height := 17, totalReservedPixels+13
+	seen := make(map[slot]bool)
+	for sl := range scatteredSlots(width, height, channelsPerPixel, 42) {
+		if sl.x < 0 || sl.x >= width || sl.y < totalReservedPixels || sl.y >= height {
+			t.Fatalf("slot %+v outside the payload region", sl)
+		}
+		if seen[sl] {
+			t.Fatalf("slot %+v visited twice", sl)
+		}
+		seen[sl] = true
+	}
+	if len(seen) != payloadSlotCount(width, height, channelsPerPixel) {
+		t.Errorf("visited %d slots, want %d", len(seen), payloadSlotCount(width, height, channelsPerPixel))
+	}
+}
+
+func TestScatteredSlotsDeterministicPerSeed(t *testing.T) {
+	width, height := 20, totalReservedPixels+20
+	collect := func(seed uint64) []slot {
+		var out []slot
+		for sl := range scatteredSlots(width, height, channelsPerPixel, seed) {
+			out = append(out, sl)
+		}
+		return out
+	}
+	a, b, c := collect(7), collect(7), collect(8)
+	sameAB, sameAC := true, true
+	for i := range a {
+		if a[i] != b[i] {
+			sameAB = false
+		}
+		if a[i] != c[i] {
+			sameAC = false
+		}
+	}
+	if !sameAB {
+		t.Error("same seed produced different orders")
+	}
+	if sameAC {
+		t.Error("different seeds produced the same order")
+	}
+}
+
+func TestScatteredSlotsSpreadAcrossColumns(t *testing.T) {
+	width, height := 100, totalReservedPixels+100
+	// A payload filling 5% of the slots would occupy only the first 5 columns sequentially
+	limit := payloadSlotCount(width, height, channelsPerPixel) / 20
+	columns := make(map[int]bool)
+	n := 0
+	for sl := range scatteredSlots(width, height, channelsPerPixel, 1234) {
+		if n == limit {
+			break
+		}
+		columns[sl.x] = true
+		n++
+	}
+	if len(columns) < width*9/10 {
+		t.Errorf("first %d scattered slots touched only %d of %d columns", limit, len(columns), width)
+	}
+}
+
+func TestPayloadSlotCountTooShort(t *testing.T) {
+	if got := payloadSlotCount(10, totalReservedPixels, channelsPerPixel); got != 0 {
+		t.Errorf("payloadSlotCount at header height = %d, want 0", got)
+	}
+}
@@ -0,0 +1,57 @@
+package image_processing
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestNearlyOpaque(t *testing.T) {
+	tests := []struct {
+		alpha    uint16
+		max      uint16
+		bitDepth int
+		want     bool
+	}{
+		{255, 255, 2, true},
+		{252, 255, 2, true},
+		{251, 255, 2, false},
+		{254, 255, 1, true},
+		{253, 255, 1, false},
+		{240, 255, 4, true},
+		{239, 255, 4, false},
+		{0, 255, 2, false},
+		{65532, 65535, 2, true},
+		{65531, 65535, 2, false},
+		{255, 65535, 2, false},
+	}
+	for _, tt := range tests {
+		if got := nearlyOpaque(tt.alpha, tt.max, tt.bitDepth); got != tt.want {
+			t.Errorf("nearlyOpaque(%d, %d, %d) = %v, want %v", tt.alpha, tt.max, tt.bitDepth, got, tt.want)
+		}
+	}
+}
+
+func TestEmbeddingAlphaChannelSelection(t *testing.T) {
+	nrgba := image.NewNRGBA(image.Rect(0, 0, 2, totalReservedPixels+1))
+	nrgba.SetNRGBA(0, totalReservedPixels, color.NRGBA{R: 10, G: 20, B: 30, A: 253})
+	nrgba.SetNRGBA(1, totalReservedPixels, color.NRGBA{R: 10, G: 20, B: 30, A: 128})
+	img := newCarrierImage(nrgba)
+
+	embed := embedding{bitDepth: 2, alpha: true}
+	if !embed.carries(img, slot{x: 0, y: totalReservedPixels, channel: alphaChannel}) {
+		t.Error("alpha of a nearly opaque pixel should carry payload")
+	}
+	if embed.carries(img, slot{x: 1, y: totalReservedPixels, channel: alphaChannel}) {
+		t.Error("alpha of a translucent pixel must not carry payload")
+	}
+	// 2 pixels x 3 color channels, plus the one usable alpha channel
+	if got := embed.count(img); got != 7 {
+		t.Errorf("count = %d, want 7", got)
+	}
+
+	embed.alpha = false
+	if got := embed.count(img); got != 6 {
+		t.Errorf("count without alpha = %d, want 6", got)
+	}
+}
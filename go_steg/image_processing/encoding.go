@@ -6,20 +6,22 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"go-steg/cli/helpers"
 	"go-steg/go_steg/bit_manipulation"
 	"go-steg/go_steg/logging"
-	"go-steg/go_steg/pipeline"
 	"hash/crc32"
 	"image"
 	"image/draw"
 	"io"
+	"iter"
 	mathrand "math/rand"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/google/uuid"
 	"go.uber.org/zap"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
 
 	// Blank to justify
 	_ "image/jpeg"
@@ -35,6 +37,42 @@ type Mask struct {
 	firstIndex    int16
 	secondIndex   int16
 	changeBoolean bool
+	// orderSeed keys the scattered embedding order; it comes from a different part of the password hash
+	// than the mask values so the two are independent
+	orderSeed uint64
+	// matchSeed keys the direction LSB matching moves a sample in, from yet another part of the hash
+	matchSeed uint64
+	// selectionSeed seeds the mask values of candidate 0 and candidateSeed those of the others, see candidate
+	selectionSeed uint64
+	candidateSeed uint64
+	// number is the candidate this mask is
+	number uint8
+	// density is the share of channel values the mask selects in eighths, 0 for the index pair rule
+	density uint8
+	// headerKey keys the position, whitening and tag of a stealth header, see stealth.go
+	headerKey [32]byte
+}
+
+// maskCandidates is the number of masks one password yields. Encode tries them in order until one selects
+// enough slots for the payload, and records which one it used in the header.
+const maskCandidates = 16
+
+// maxMaskDensity is the largest share of channel values, in eighths, Options.MaskDensity can ask the mask to
+// select. Beyond it the mask hardly hides which channels were changed.
+const maxMaskDensity = 6
+
+// String describes the mask by its candidate number and density only, so printing it never shows the seeds
+// or the header key derived from the password
+func (m Mask) String() string {
+	return fmt.Sprintf("mask candidate %d, density %d/8", m.number, m.density)
+}
+
+// selects reports whether the mask picks the given channel value for embedding at the given bit depth
+func (m Mask) selects(colorInt uint8, bitDepth int) bool {
+	if m.density != 0 {
+		return bit_manipulation.ReturnMaskDensitySelection(m.maskInt, m.multiplier, colorInt, bitDepth, m.density)
+	}
+	return bit_manipulation.ReturnMaskDifferenceN(m.maskInt, m.multiplier, m.firstIndex, m.secondIndex, colorInt, bitDepth) == m.changeBoolean
 }
 
 type EncodingError struct {
@@ -58,7 +96,7 @@ var (
 	}
 	ErrInvalidFormat = &EncodingError{
 		Type:    "FormatError",
-		Message: "unsupported carrier image format, must be PNG or JPEG",
+		Message: "unsupported carrier image format, must be PNG, JPEG, BMP or TIFF",
 	}
 	ErrDataTooLarge = &EncodingError{
 		Type:    "DataSizeError",
@@ -76,6 +114,18 @@ var (
 		Type:    "IOError",
 		Message: "error during file read/write operation",
 	}
+	ErrIntegrityCheck = &EncodingError{
+		Type:    "IntegrityError",
+		Message: "embedded data failed its integrity check",
+	}
+	ErrCarrierSet = &EncodingError{
+		Type:    "CarrierSetError",
+		Message: "carriers do not form a complete set",
+	}
+	ErrHeaderNotFound = &EncodingError{
+		Type:    "HeaderError",
+		Message: "no header found for this password",
+	}
 )
 
 func wrapError(err error, errType *EncodingError, context string) error {
@@ -101,9 +151,46 @@ func computeChecksum(data []byte) uint16 {
 	return uint16(crc & 0x0FFF)
 }
 
+// PayloadInfo describes the whole pipeline output shared by a set of carriers. Every carrier's header
+// carries a copy so the decoder can verify the reassembled payload; only ChunkOffset differs per carrier.
+type PayloadInfo struct {
+	Checksum     uint16 // legacy 12-bit checksum, see computeChecksum
+	ByteCountMod uint16 // legacy pipeline output length modulo 4096
+	Length       uint32 // full pipeline output length
+	CRC32        uint32 // CRC-32 (IEEE) of the full pipeline output
+	DataCRC32    uint32 // CRC-32 (IEEE) of the original data before the pipeline
+	TotalParts   uint16 // number of carriers the payload is split across
+	ChunkOffset  uint32 // offset of the carrier's own chunk within the pipeline output
+	MatrixK      uint8  // Hamming code parameter of matrix embedding; 0 is taken as 1 when it is enabled
+}
+
+// NewPhotoID returns a random photo ID for a new carrier set. The ID comes from a random (version 4) UUID
+// and is cut down to the 48 bits the header stores, so carriers of different payloads can be told apart.
+func NewPhotoID() (uint64, error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return 0, fmt.Errorf("error generating photo ID: %w", err)
+	}
+	return binary.BigEndian.Uint64(id[:8]) >> (64 - photoIDBits), nil
+}
+
 // EncodeByFileNames will take in a list of carrier file names, a data image, and a list of the resulting image file names
-func EncodeByFileNames(carrierFileNames []string, dataFileName string, uniquePhotoID uint64, password string, outputFileDir string, cfg pipeline.Config) (err error) {
-	return MultiCarrierEncodeByFileNames(carrierFileNames, dataFileName, uniquePhotoID, password, outputFileDir, cfg)
+func EncodeByFileNames(carrierFileNames []string, dataFileName string, uniquePhotoID uint64, password string, outputFileDir string, opts Options) (err error) {
+	return MultiCarrierEncodeByFileNames(carrierFileNames, dataFileName, uniquePhotoID, password, outputFileDir, opts)
+}
+
+// embeddedExtension returns the file extension for an embedded carrier read from a file with the given one.
+// Every format is written back as itself except JPEG, which is written as a PNG unless opts.JPEGNative is set.
+func embeddedExtension(fileExtension string, opts Options) string {
+	switch strings.ToLower(fileExtension) {
+	case ".jpg", ".jpeg":
+		if opts.JPEGNative {
+			return fileExtension
+		}
+		return ".png"
+	default:
+		return fileExtension
+	}
 }
 
 // MultiCarrierEncodeByFileNames takes in a series of files, a data file, and a series of strings to name the resulting files
@@ -114,7 +201,7 @@ func MultiCarrierEncodeByFileNames(
 	uniquePhotoID uint64,
 	password string,
 	outputFileDir string,
-	cfg pipeline.Config) (err error) {
+	opts Options) (err error) {
 	if len(carrierFileNames) == 0 {
 		logger.Errorf("Missing carrier file names")
 		return fmt.Errorf("missing carrier file names")
@@ -137,7 +224,7 @@ func MultiCarrierEncodeByFileNames(
 		fileName := filepath.Base(name)
 		fileExtension := filepath.Ext(fileName)
 		baseFileName := strings.TrimSuffix(fileName, fileExtension)
-		embeddedCarrierName := fmt.Sprintf("%s/%s-%d-embedded%s", outputFileDir, baseFileName, idx, fileExtension)
+		embeddedCarrierName := fmt.Sprintf("%s/%s-%d-embedded%s", outputFileDir, baseFileName, idx, embeddedExtension(fileExtension, opts))
 		embeddedCarrierFileNames = append(embeddedCarrierFileNames, embeddedCarrierName)
 		if err != nil {
 			logger.Errorf("Error opening carrier file: %v", err)
@@ -193,9 +280,9 @@ func MultiCarrierEncodeByFileNames(
 		embeddedCarrierWriters = append(embeddedCarrierWriters, result)
 	}
 
-	//Here is where we encode the data into multiple carriers
+	//Here is where we encode the data into multiple carriers, streaming it from the data file
 	// If we receive an error, make sure to remove all the result files
-	err = MultiCarrierEncode(carriers, embedFile, embeddedCarrierWriters, uniquePhotoID, password, cfg)
+	err = MultiCarrierEncodeStream(carriers, embedFile, embeddedCarrierWriters, uniquePhotoID, password, opts)
 	if err != nil {
 		for _, name := range embeddedCarrierFileNames {
 			_ = os.Remove(name)
@@ -208,86 +295,89 @@ func MultiCarrierEncodeByFileNames(
 
 // MultiCarrierEncode will split the information into pieces and then use the encode
 // function to encode that information into separate files.
-// It does this by splitting the dataBytes reader into separate io.Readers based on how many
-// carrier files there are
-func MultiCarrierEncode(carriers []io.Reader, data io.Reader, results []io.Writer, uniquePhotoID uint64, password string, cfg pipeline.Config) error {
-	// Read all the data from the embed file
-	dataBytes, err := io.ReadAll(data)
-	if err != nil {
-		return fmt.Errorf("Error reading data %w\n", err)
+// It does this by splitting the pipeline output into one chunk per carrier, sized in proportion to how much
+// each carrier can hold, so carriers of different sizes can be mixed. Each header records the offset of its
+// chunk so the decoder can check the layout.
+//
+// It is MultiCarrierEncodeStream for data that cannot seek, which is read into memory first, so both write
+// the same carriers for the same data.
+func MultiCarrierEncode(carriers []io.Reader, data io.Reader, results []io.Writer, uniquePhotoID uint64, password string, opts Options) error {
+	seeker, ok := data.(io.ReadSeeker)
+	if !ok {
+		dataBytes, err := io.ReadAll(data)
+		if err != nil {
+			return fmt.Errorf("Error reading data %w\n", err)
+		}
+		seeker = bytes.NewReader(dataBytes)
 	}
+	return MultiCarrierEncodeStream(carriers, seeker, results, uniquePhotoID, password, opts)
+}
 
-	// Run the pipeline encoding (huffman, reed-solomon, etc.)
-	pipelineOutput, err := pipeline.Encode(dataBytes, cfg)
-	if err != nil {
-		return fmt.Errorf("error in pipeline encode: %w", err)
+// loadCarriers decodes every carrier and checks it can be used for embedding
+func loadCarriers(carriers []io.Reader, opts Options) (images []*carrierImage, formats []string, err error) {
+	images = make([]*carrierImage, 0, len(carriers))
+	formats = make([]string, 0, len(carriers))
+	for i, carrier := range carriers {
+		img, format, err := loadCarrier(carrier, opts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error encoding chunk with index %d: %w", i, err)
+		}
+		images = append(images, img)
+		formats = append(formats, format)
 	}
+	return images, formats, nil
+}
 
-	// Compute checksum and byte count modulo for the header
-	checksum := computeChecksum(pipelineOutput)
-	byteCountMod := uint16(len(pipelineOutput) % 4096)
-
-	//Make the chunk size the length of the byte slices divided by the number of carrier files
-	chunkSize := len(pipelineOutput) / len(carriers)
-	if chunkSize == 0 {
-		chunkSize = len(pipelineOutput)
+// loadCarrier decodes a carrier image, checks it can be used for embedding and lays out the palette of a
+// paletted carrier for the configured bit depth
+func loadCarrier(carrier io.Reader, opts Options) (*carrierImage, string, error) {
+	// Open the carrier image at its own sample depth, along with getting the format of the carrier image
+	RGBAImage, format, err := getCarrierImage(carrier)
+	if err != nil {
+		return nil, "", fmt.Errorf("Error parsing carrier image: %w\n", err)
 	}
-
-	//Split pipeline output into chunks, one per carrier
-	dataChunks := make([]io.Reader, 0, len(carriers))
-	for i := 0; i < len(carriers); i++ {
-		start := i * chunkSize
-		var end int
-		if i == len(carriers)-1 {
-			// Last carrier gets everything remaining (handles uneven splits)
-			end = len(pipelineOutput)
-		} else {
-			end = start + chunkSize
-		}
-		dataChunks = append(dataChunks, bytes.NewReader(pipelineOutput[start:end]))
+	if RGBAImage, err = RGBAImage.forEncoding(format, opts); err != nil {
+		return nil, "", err
+	}
+	if err := checkCarrierFormat(RGBAImage, format, opts); err != nil {
+		return nil, "", err
 	}
 
-	//Generate the mask information
-	mask := generateMaskingInfo(password)
-
-	fmt.Println("Masking info: ", mask)
-
-	//Initialize a variable for the count since we need it to be an uint16
-	var photoNumber uint16
-	//Use another loop to actually encode everything
-	for i := 0; i < len(carriers); i++ {
-		if err := Encode(carriers[i], dataChunks[i], results[i], photoNumber, uniquePhotoID, mask, cfg, checksum, byteCountMod); err != nil {
-			return fmt.Errorf("error encoding chunk with index %d: %w", i, err)
-		}
-		photoNumber++
+	// Validate carrier height; JPEG-native carriers keep their header in coefficients, not pixel rows
+	if RGBAImage.dct == nil && RGBAImage.Bounds().Dy() < minCarrierHeight {
+		return nil, "", wrapError(nil, ErrCarrierTooSmall, fmt.Sprintf("carrier height %d < minimum %d", RGBAImage.Bounds().Dy(), minCarrierHeight))
 	}
-	return err
+	if err := RGBAImage.sortPalette(opts.bitDepth()); err != nil {
+		return nil, "", err
+	}
+	return RGBAImage, format, nil
 }
 
 // Encode will take in a carrier reader, data reader, and a result file writer and encode the data reader into the
-// carrier, writing the result to the result file
-func Encode(carrier io.Reader, data io.Reader, result io.Writer, photoNumber uint16, uniquePhotoID uint64, mask Mask, cfg pipeline.Config, checksum uint16, byteCountMod uint16) error {
-	bitDepth := cfg.BitDepth
-	if bitDepth < 1 || bitDepth > 4 {
-		bitDepth = 2
+// carrier, writing the result to the result file. payload describes the full pipeline output that data is a chunk of.
+func Encode(carrier io.Reader, data io.Reader, result io.Writer, photoNumber uint16, uniquePhotoID uint64, mask Mask, opts Options, payload PayloadInfo) error {
+	if err := opts.validate(); err != nil {
+		return err
 	}
-
-	// Open the carrier image as an RGBA image, along with getting the format of the carrier image
-	RGBAImage, format, err := getImageAsRGBA(carrier)
+	RGBAImage, format, err := loadCarrier(carrier, opts)
 	if err != nil {
-		return fmt.Errorf("Error parsing carrier image: %w\n", err)
-	}
-	if format != "png" && format != "jpeg" {
-		return fmt.Errorf("Unsupported carrier format\n")
+		return err
 	}
+	return encodeImage(RGBAImage, format, data, result, photoNumber, uniquePhotoID, mask, opts, payload)
+}
 
-	//Get the bounds of the image
-	bounds := RGBAImage.Bounds()
+// encodeImage embeds data into an already decoded carrier and writes it back in its own format, see writeCarrier
+func encodeImage(RGBAImage *carrierImage, format string, data io.Reader, result io.Writer, photoNumber uint16, uniquePhotoID uint64, mask Mask, opts Options, payload PayloadInfo) error {
+	var err error
 
-	// Validate carrier height
-	if bounds.Dy() < minCarrierHeight {
-		return wrapError(nil, ErrCarrierTooSmall, fmt.Sprintf("carrier height %d < minimum %d", bounds.Dy(), minCarrierHeight))
+	if opts.MatrixEmbedding && payload.MatrixK == 0 {
+		payload.MatrixK = 1
+	}
+	embed := opts.embedding(mask)
+	if opts.MatrixEmbedding {
+		embed.matrixK = int(payload.MatrixK)
 	}
+	writer := embed.matcher(photoNumber)
 
 	//Open a buffered channel for the data - if the channel is full it will block until there's space
 	dataBytesChannel := make(chan byte, 128)
@@ -295,8 +385,11 @@ func Encode(carrier io.Reader, data io.Reader, result io.Writer, photoNumber uin
 	//Open an unbuffered channel for errors we encounter
 	errChannel := make(chan error)
 
+	// Hash the chunk as it is read so its CRC can go in the header
+	chunkHash := crc32.NewIEEE()
+
 	//Read the image data to make sure it's good and fill the channel
-	go readData(data, dataBytesChannel, errChannel, bitDepth)
+	go readData(io.TeeReader(data, chunkHash), dataBytesChannel, errChannel, embed.chunkBits())
 
 	//Set a boolean to tell if we have more data in the for loop
 	hasMoreBytes := true
@@ -304,134 +397,191 @@ func Encode(carrier io.Reader, data io.Reader, result io.Writer, photoNumber uin
 	//dataCount keeps track of the data size to store that information in the header
 	var dataCount uint32
 
-	if helpers.UseMask {
-		openSlots := DetermineOpenSlotsWithMask(RGBAImage, bounds.Dx(), bounds.Dy(), mask)
-		fmt.Printf("Number of slots availabe with mask: %v\n", openSlots)
-	}
-
-	// Iterate over every pixel starting at the reserved header pixels.
-	for x := 0; x < bounds.Dx() && hasMoreBytes; x++ {
-		for y := totalReservedPixels; y < bounds.Dy() && hasMoreBytes; y++ {
-			c := RGBAImage.RGBAAt(x, y)
-			if helpers.UseMask && bit_manipulation.ReturnMaskDifferenceN(mask.maskInt, mask.multiplier, mask.firstIndex, mask.secondIndex, c.R, bitDepth) == mask.changeBoolean {
-				hasMoreBytes, err = setColorSegment(&c.R, dataBytesChannel, errChannel, bitDepth)
-				if err != nil {
-					logger.Errorf("Error in setting red color segment: %v", err)
-					return err
-				}
-				if hasMoreBytes {
-					dataCount++
-				}
-			} else if !helpers.UseMask {
-				hasMoreBytes, err = setColorSegment(&c.R, dataBytesChannel, errChannel, bitDepth)
-				if err != nil {
-					logger.Errorf("Error in setting red color segment: %v", err)
-					return err
-				}
-				if hasMoreBytes {
-					dataCount++
-				}
-			}
-			if hasMoreBytes {
-				if helpers.UseMask && bit_manipulation.ReturnMaskDifferenceN(mask.maskInt, mask.multiplier, mask.firstIndex, mask.secondIndex, c.G, bitDepth) == mask.changeBoolean {
-					hasMoreBytes, err = setColorSegment(&c.G, dataBytesChannel, errChannel, bitDepth)
-					if err != nil {
-						logger.Errorf("Error in setting green color segment: %v", err)
-						return err
-					}
-					if hasMoreBytes {
-						dataCount++
-					}
-				} else if !helpers.UseMask {
-					hasMoreBytes, err = setColorSegment(&c.G, dataBytesChannel, errChannel, bitDepth)
-					if err != nil {
-						logger.Errorf("Error in setting green color segment: %v", err)
-						return err
-					}
-					if hasMoreBytes {
-						dataCount++
-					}
-				}
+	if opts.UseMask {
+		fmt.Printf("Number of slots availabe with mask: %v\n", embed.count(RGBAImage))
+	}
+
+	if embed.matrixK > 0 || embed.adaptive {
+		if dataCount, err = embedBits(writer, RGBAImage, photoNumber, dataBytesChannel, errChannel); err != nil {
+			logger.Errorf("Error in embedding bits: %v", err)
+			return err
+		}
+		hasMoreBytes = false
+	} else {
+		// Walk the payload slots below the reserved header rows, either column by column or in the
+		// password-keyed scattered order, skipping any channel the mask or the alpha rule leaves out.
+		for sl := range embed.slots(RGBAImage) {
+			if !embed.carries(RGBAImage, sl) {
+				continue
 			}
-			if hasMoreBytes {
-				if helpers.UseMask && bit_manipulation.ReturnMaskDifferenceN(mask.maskInt, mask.multiplier, mask.firstIndex, mask.secondIndex, c.B, bitDepth) == mask.changeBoolean {
-					hasMoreBytes, err = setColorSegment(&c.B, dataBytesChannel, errChannel, bitDepth)
-					if err != nil {
-						logger.Errorf("Error in setting blue color segment: %v", err)
-						return err
-					}
-					if hasMoreBytes {
-						dataCount++
-					}
-				} else if !helpers.UseMask {
-					hasMoreBytes, err = setColorSegment(&c.B, dataBytesChannel, errChannel, bitDepth)
-					if err != nil {
-						logger.Errorf("Error in setting blue color segment: %v", err)
-						return err
-					}
-					if hasMoreBytes {
-						dataCount++
-					}
-				}
+			hasMoreBytes, err = setColorSegment(writer, RGBAImage, sl, dataBytesChannel, errChannel)
+			if err != nil {
+				logger.Errorf("Error in setting color segment: %v", err)
+				return err
 			}
-			RGBAImage.SetRGBA(x, y, c)
-
 			if !hasMoreBytes {
-				fmt.Printf("Last encoded pixel - (%v, %v)\n", x, y)
-				openSlots := DetermineOpenSlotsWithMask(RGBAImage, bounds.Dx(), bounds.Dy(), mask)
-				fmt.Printf("Number of slots availabe with mask: %v\n", openSlots)
+				fmt.Printf("Last encoded pixel - (%v, %v)\n", sl.x, sl.y)
+				break
 			}
+			dataCount++
 		}
 	}
 	fmt.Printf("Picture number - %v - Data count for encoding - %v\n\n", photoNumber, dataCount)
 
-	select {
-	case _, ok := <-dataBytesChannel:
-		if ok {
-			fmt.Printf("Length of data left - %v\n", len(dataBytesChannel))
-			return fmt.Errorf("Data file is too large for this carrier file\n")
+	// If the slots ran out before the data did, anything still coming from readData does not fit
+	if hasMoreBytes {
+		select {
+		case _, ok := <-dataBytesChannel:
+			if ok {
+				fmt.Printf("Length of data left - %v\n", len(dataBytesChannel))
+				return wrapError(nil, ErrDataTooLarge, fmt.Sprintf("carrier for part %d is full", photoNumber))
+			}
+		case err := <-errChannel:
+			return err
 		}
-	default:
 	}
 
 	// Write the new header with all metadata
-	headerInfo := HeaderInfo{
-		PhotoID:        uniquePhotoID,
-		PhotoNumber:    photoNumber,
-		DataCount:      dataCount,
-		IsNewFormat:    true,
-		FileExtension:  cfg.FileExtension,
-		BitDepth:       bitDepth,
-		HuffmanEnabled: cfg.HuffmanEnabled,
-		RSEnabled:      cfg.RSEnabled,
-		RSLevel:        cfg.RSLevel,
-		Checksum:       checksum,
-		ByteCountMod:   byteCountMod,
-	}
-	writeHeader(RGBAImage, headerInfo)
+	header := newHeaderInfo(photoNumber, uniquePhotoID, dataCount, chunkHash.Sum32(), RGBAImage.model, opts, payload)
+	header.MaskCandidate = mask.number
+	if err := writeCarrierHeader(RGBAImage, header, mask); err != nil {
+		return err
+	}
+	return writeCarrier(RGBAImage, format, result)
+}
+
+// newHeaderInfo builds the header of one carrier from the payload description and the carrier's own chunk
+func newHeaderInfo(photoNumber uint16, uniquePhotoID uint64, dataCount, chunkCRC uint32, model CarrierModel, opts Options, payload PayloadInfo) HeaderInfo {
+	cfg := opts.Config
+	info := HeaderInfo{
+		PhotoID:          uniquePhotoID,
+		PhotoNumber:      photoNumber,
+		DataCount:        dataCount,
+		IsNewFormat:      true,
+		FileExtension:    cfg.FileExtension,
+		BitDepth:         opts.bitDepth(),
+		HuffmanEnabled:   cfg.HuffmanEnabled,
+		RSEnabled:        cfg.RSEnabled,
+		RSLevel:          cfg.RSLevel,
+		Checksum:         payload.Checksum,
+		ByteCountMod:     payload.ByteCountMod,
+		HasExtendedFlags: true,
+		MaskEnabled:      opts.UseMask,
+		MaskAlgorithm:    MaskAlgorithmXORIndexPair,
+		Encrypted:        cfg.Encrypted,
+		Scattered:        opts.Scatter,
+		AlphaChannel:     opts.UseAlpha,
+		LSBMatching:      opts.LSBMatching,
+		MatrixEmbedding:  opts.MatrixEmbedding,
+		Adaptive:         opts.Adaptive,
+		FormatVersion:    currentFormatVersion,
+		HasIntegrity:     true,
+		PayloadLength:    payload.Length,
+		PayloadCRC32:     payload.CRC32,
+		ChunkCRC32:       chunkCRC,
+		DataCRC32:        payload.DataCRC32,
+		TotalParts:       payload.TotalParts,
+		ChunkOffset:      payload.ChunkOffset,
+		CarrierModel:     model,
+		MatrixK:          payload.MatrixK,
+	}
+	if opts.UseMask && opts.MaskDensity != 0 {
+		info.MaskAlgorithm = MaskAlgorithmDensity
+		info.MaskDensity = opts.MaskDensity
+	}
+	if opts.Adaptive {
+		info.AdaptiveThresholds = opts.adaptiveThresholds()
+	}
+	info.Stealth = opts.StealthHeader
+	return info
+}
 
+// checkCarrierFormat checks that a carrier read in the given format can be written back in it with the
+// payload intact
+func checkCarrierFormat(img *carrierImage, format string, opts Options) error {
+	switch format {
+	case "png", "jpeg", "tiff":
+		return nil
+	case "bmp":
+		// The BMP writer has no alpha channel, so transparency and alpha payload bits would not survive it
+		if opts.UseAlpha || !img.opaque() {
+			return wrapError(nil, ErrInvalidFormat, "BMP carriers cannot hold transparency or an alpha-channel payload")
+		}
+		return nil
+	default:
+		return wrapError(nil, ErrInvalidFormat, fmt.Sprintf("carrier format %q", format))
+	}
+}
+
+// writeCarrier writes an embedded carrier back in the format it was read in: BMP carriers as uncompressed
+// BMPs and TIFF carriers as Deflate-compressed TIFFs, both lossless. JPEG compression would destroy bits
+// embedded in pixels, so JPEG carriers are written as PNGs; JPEG-native carriers are written as JPEGs, with
+// their coefficients as they are. The carrier keeps its color model in every format.
+func writeCarrier(RGBAImage *carrierImage, format string, result io.Writer) error {
 	switch format {
 	case "png", "jpeg":
-		return png.Encode(result, RGBAImage)
+		if RGBAImage.dct != nil {
+			return RGBAImage.dct.Encode(result)
+		}
+		return png.Encode(result, RGBAImage.Image)
+	case "bmp":
+		return bmp.Encode(result, RGBAImage.Image)
+	case "tiff":
+		return tiff.Encode(result, RGBAImage.Image, &tiff.Options{Compression: tiff.Deflate})
 	default:
-		return fmt.Errorf("Unsupported carrier format\n")
+		return wrapError(nil, ErrInvalidFormat, fmt.Sprintf("carrier format %q", format))
 	}
 }
 
-// setColorSegment will set the last N bits to the values pulled from the embed image.
-func setColorSegment(colorSegment *byte, dataChannel <-chan byte, errChan <-chan error, bitDepth int) (hasMoreBytes bool, err error) {
+// setColorSegment will set the last N bits of the slot's sample to the values pulled from the embed image,
+// replacing them or by LSB matching as m says.
+func setColorSegment(m matcher, img *carrierImage, sl slot, dataChannel <-chan byte, errChan <-chan error) (hasMoreBytes bool, err error) {
 	select {
 	case chanByte, ok := <-dataChannel:
 		if !ok {
 			return false, nil
 		}
-		*colorSegment = bit_manipulation.SetLastNBits(*colorSegment, chanByte, bitDepth)
+		m.write(img, sl, chanByte)
 		return true, nil
 	case err := <-errChan:
 		return false, err
 	}
 }
 
+// embedBits embeds the single bits pulled from the embed image through the bitWriter of matrix or adaptive
+// embedding. It returns the number of bits embedded.
+func embedBits(m matcher, img *carrierImage, photoNumber uint16, dataChannel <-chan byte, errChan <-chan error) (uint32, error) {
+	next, stop := iter.Pull(m.embed.slots(img))
+	defer stop()
+	w := m.embed.bitWriter(m, img, func() (slot, error) {
+		for {
+			sl, ok := next()
+			if !ok {
+				return slot{}, wrapError(nil, ErrDataTooLarge, fmt.Sprintf("carrier for part %d is full", photoNumber))
+			}
+			if m.embed.carries(img, sl) {
+				return sl, nil
+			}
+		}
+	})
+
+	var dataCount uint32
+	for {
+		select {
+		case bit, ok := <-dataChannel:
+			if !ok {
+				return dataCount, w.flush()
+			}
+			if err := w.writeBit(bit); err != nil {
+				return dataCount, err
+			}
+			dataCount++
+		case err := <-errChan:
+			return dataCount, err
+		}
+	}
+}
+
 // readData reads the data from the reader and splits each byte into chunks based on bitDepth,
 // sending the chunks through the bytesChannel.
 func readData(reader io.Reader, bytesChannel chan<- byte, errChan chan<- error, bitDepth int) {
@@ -461,31 +611,49 @@ func getImageAsRGBA(reader io.Reader) (*image.RGBA, string, error) {
 	return RGBAImage, format, nil
 }
 
-// DetermineOpenSlotsWithMask returns the number of open slots for a given carrier image by applying a mask
-func DetermineOpenSlotsWithMask(RGBAImage *image.RGBA, dx, dy int, mask Mask) (openSlotCount int64) {
-	for x := 0; x < dx; x++ {
-		for y := totalReservedPixels; y < dy; y++ {
-			c := RGBAImage.RGBAAt(x, y)
-			if bit_manipulation.ReturnMaskDifference(mask.maskInt, mask.multiplier, mask.firstIndex, mask.secondIndex, c.R) == mask.changeBoolean {
-				openSlotCount++
-			}
-			if bit_manipulation.ReturnMaskDifference(mask.maskInt, mask.multiplier, mask.firstIndex, mask.secondIndex, c.B) == mask.changeBoolean {
-				openSlotCount++
-			}
-			if bit_manipulation.ReturnMaskDifference(mask.maskInt, mask.multiplier, mask.firstIndex, mask.secondIndex, c.G) == mask.changeBoolean {
-				openSlotCount++
-			}
-		}
-	}
-	return openSlotCount
+// generateMaskingInfo will generate masking information from the password. The mask is candidate 0; see
+// candidate for the others.
+func generateMaskingInfo(password string) Mask {
+	hashedPassword := hashPassword(password)
+	seedValue := generateNumbersFromHash(hashedPassword)
+
+	mask := newMask(seedValue)
+	mask.orderSeed = binary.BigEndian.Uint64(hashedPassword[8:16])
+	mask.matchSeed = binary.BigEndian.Uint64(hashedPassword[16:24])
+	mask.selectionSeed = seedValue
+	mask.candidateSeed = binary.BigEndian.Uint64(hashedPassword[24:32])
+	mask.headerKey = stealthHeaderKey(hashedPassword)
+	return mask
 }
 
-// generateMaskingInfo will generate masking information from the password
-func generateMaskingInfo(password string) Mask {
+// candidate returns mask candidate n of the password m was generated from. The candidates differ in the
+// values that select channels; the embedding order and the LSB matching directions stay the same. Candidate 0
+// is the mask generateMaskingInfo returns, so carriers written before there were candidates decode with it.
+func (m Mask) candidate(n uint8) Mask {
+	seed := m.selectionSeed
+	if n > 0 {
+		seed = m.candidateSeed + uint64(n)
+	}
+	c := newMask(seed)
+	c.orderSeed, c.matchSeed = m.orderSeed, m.matchSeed
+	c.selectionSeed, c.candidateSeed = m.selectionSeed, m.candidateSeed
+	c.number = n
+	c.density = m.density
+	c.headerKey = m.headerKey
+	return c
+}
+
+// withDensity returns the mask selecting eighths/8 of all channel values instead of using the index pair rule,
+// or the index pair mask when eighths is 0
+func (m Mask) withDensity(eighths uint8) Mask {
+	m.density = eighths
+	return m
+}
+
+// newMask draws the values that select channels from a random source with the given seed
+func newMask(seedValue uint64) Mask {
 	var indexRange = make([]int16, 30)
 
-	hashedPassword := hashPassword(password)
-	seedValue := generateNumbersFromHash(hashedPassword)
 	rng := mathrand.New(mathrand.NewSource(int64(seedValue)))
 
 	var i int16
@@ -505,7 +673,7 @@ func generateMaskingInfo(password string) Mask {
 		changeBoolean = true
 	}
 
-	return Mask{mask, multiplier, firstIndex, secondIndex, changeBoolean}
+	return Mask{maskInt: mask, multiplier: multiplier, firstIndex: firstIndex, secondIndex: secondIndex, changeBoolean: changeBoolean}
 }
 
 // hashPassword will take in a password and hash it using the sha256 hashing algorithm
@@ -1,7 +1,7 @@
 package image_processing
 
 import (
-	"go-steg/cli/helpers"
+	"fmt"
 	"go-steg/go_steg/pipeline"
 	"os"
 	"testing"
@@ -41,10 +41,54 @@ func TestEncodeByFileNames(t *testing.T) {
 					t.Skipf("fixture file %s not found, skipping", f)
 				}
 			}
-			helpers.UseMask = true
-			if err := EncodeByFileNames(tt.args.carrierFileNames, tt.args.dataFileName, tt.args.uniquePhotoID, tt.args.password, tt.args.outputFileDir, tt.args.cfg); (err != nil) != tt.wantErr {
+			if err := EncodeByFileNames(tt.args.carrierFileNames, tt.args.dataFileName, tt.args.uniquePhotoID, tt.args.password, tt.args.outputFileDir, Options{UseMask: true, Config: tt.args.cfg}); (err != nil) != tt.wantErr {
 				t.Errorf("EncodeByFileNames() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
+
+func TestNewPhotoID(t *testing.T) {
+	seen := make(map[uint64]bool)
+	for i := 0; i < 100; i++ {
+		id, err := NewPhotoID()
+		if err != nil {
+			t.Fatalf("NewPhotoID: %v", err)
+		}
+		if id>>photoIDBits != 0 {
+			t.Fatalf("photo ID %#x does not fit in %d bits", id, photoIDBits)
+		}
+		if seen[id] {
+			t.Fatalf("photo ID %#x generated twice", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestMaskStringHidesSecrets(t *testing.T) {
+	mask := generateMaskingInfo("secret").candidate(3).withDensity(2)
+	if got, want := fmt.Sprint(mask), "mask candidate 3, density 2/8"; got != want {
+		t.Errorf("printed mask %q, want %q", got, want)
+	}
+}
+
+func TestMaskCandidates(t *testing.T) {
+	mask := generateMaskingInfo("candidates")
+	if first := mask.candidate(0); first != mask {
+		t.Errorf("candidate 0 is %+v, want the password's mask %+v", first, mask)
+	}
+	seen := make(map[int32]uint8)
+	for n := uint8(0); n < maskCandidates; n++ {
+		c := mask.candidate(n)
+		if c.number != n {
+			t.Errorf("candidate %d is numbered %d", n, c.number)
+		}
+		if c.orderSeed != mask.orderSeed || c.matchSeed != mask.matchSeed {
+			t.Errorf("candidate %d changes the embedding order or matching seeds", n)
+		}
+		if prev, ok := seen[c.maskInt]; ok {
+			t.Errorf("candidates %d and %d select with the same value", prev, n)
+		}
+		seen[c.maskInt] = n
+	}
+}
@@ -2,9 +2,9 @@ package image_processing
 
 import (
 	"encoding/binary"
+	"fmt"
 	"go-steg/go_steg/bit_manipulation"
 	"go-steg/go_steg/reed_solomon"
-	"image"
 	"strings"
 )
 
@@ -24,51 +24,140 @@ type HeaderInfo struct {
 	RSLevel        reed_solomon.RedundancyLevel
 	Checksum       uint16 // low 12 bits of CRC-16
 	ByteCountMod   uint16 // pipeline output byte count modulo 4096
+
+	// Extended flags (pixels 31-32). Carriers written before these existed leave HasExtendedFlags false,
+	// in which case the decoder falls back to the caller's Options and the sequential embedding order.
+	HasExtendedFlags bool
+	MaskEnabled      bool
+	MaskAlgorithm    MaskAlgorithm
+	Encrypted        bool
+	Scattered        bool
+	AlphaChannel     bool // the alpha channel of nearly opaque pixels carries payload as well
+	LSBMatching      bool // payload bits were written by LSB matching rather than replacement
+	MatrixEmbedding  bool // payload bits were hidden with a Hamming code; MatrixK holds its parameter
+	Adaptive         bool // slots hold 0, 1 or BitDepth bits by texture; AdaptiveThresholds holds the levels
+
+	// Format version 2 fields, stored in the extension block. FormatVersion is 1 for headers that only
+	// have the column 0 layout and 0 for legacy headers.
+	FormatVersion int
+	HasIntegrity  bool         // the extension block was read and holds the fields below
+	PayloadLength uint32       // full pipeline output length in bytes
+	PayloadCRC32  uint32       // CRC-32 (IEEE) of the full pipeline output
+	ChunkCRC32    uint32       // CRC-32 (IEEE) of the pipeline output bytes embedded in this carrier
+	DataCRC32     uint32       // CRC-32 (IEEE) of the original data before the pipeline ran
+	TotalParts    uint16       // number of carriers in the set, 0 when the header does not record it
+	HasLayout     bool         // the extension block holds ChunkOffset
+	ChunkOffset   uint32       // offset of this carrier's chunk within the full pipeline output
+	CarrierModel  CarrierModel // color model the carrier was embedded in, CarrierModelUnknown when not recorded
+	MatrixK       uint8        // Hamming code parameter of matrix embedding, 0 when not recorded
+	// AdaptiveThresholds are the texture levels of adaptive embedding at which a slot holds one bit and
+	// BitDepth bits, zero when not recorded
+	AdaptiveThresholds [2]uint8
+	MaskCandidate      uint8 // which of the password's mask candidates selected the slots, 0 when not recorded
+	MaskDensity        uint8 // eighths of the channel values a MaskAlgorithmDensity mask selects
+	HeaderCopies       uint8 // number of protected header copies the carrier holds, 0 when it has none
+
+	// Stealth is set when the header was written as a stealth header rather than in column 0. It is not
+	// stored in the header itself: a reader only finds a stealth header with the password.
+	Stealth bool
+
+	// HeaderDamage describes every damaged, repaired or disagreeing header copy found when the header was
+	// read, empty when they all agreed. It is not stored in the carrier.
+	HeaderDamage []string
 }
 
-// writeHeader writes all header metadata into the first 34 pixels of column 0.
-// Header always uses 2-bit operations.
-func writeHeader(img *image.RGBA, info HeaderInfo) {
+// integrityFieldsLen is the size of the integrity fields at the start of the extension record
+const integrityFieldsLen = 16
+
+// totalPartsFieldsLen is the size of the extension fields up to and including the total part count
+const totalPartsFieldsLen = integrityFieldsLen + 2
+
+// chunkOffsetFieldsLen is the size of the extension fields up to and including the chunk offset
+const chunkOffsetFieldsLen = totalPartsFieldsLen + 4
+
+// carrierModelFieldsLen is the size of the extension fields up to and including the carrier model
+const carrierModelFieldsLen = chunkOffsetFieldsLen + 1
+
+// matrixFieldsLen is the size of the extension fields up to and including the matrix embedding parameter
+const matrixFieldsLen = carrierModelFieldsLen + 1
+
+// adaptiveFieldsLen is the size of the extension fields up to and including the adaptive embedding thresholds
+const adaptiveFieldsLen = matrixFieldsLen + 2
+
+// maskCandidateFieldsLen is the size of the extension fields up to and including the mask candidate
+const maskCandidateFieldsLen = adaptiveFieldsLen + 1
+
+// maskDensityFieldsLen is the size of the extension fields up to and including the mask density
+const maskDensityFieldsLen = maskCandidateFieldsLen + 1
+
+// headerCopiesFieldsLen is the size of the extension fields up to and including the header copy count
+const headerCopiesFieldsLen = maskDensityFieldsLen + 1
+
+// MaskAlgorithm identifies the channel selection method used by the indiscernibility mask.
+type MaskAlgorithm uint8
+
+const (
+	// MaskAlgorithmXORIndexPair selects a channel when two password-chosen bits of
+	// (maskInt XOR value*multiplier) are both set. See bit_manipulation.ReturnMaskDifferenceN.
+	MaskAlgorithmXORIndexPair MaskAlgorithm = 0
+	// MaskAlgorithmDensity selects a channel when a hash of the value and the mask falls in the lowest
+	// MaskDensity eighths of its range. See bit_manipulation.ReturnMaskDensitySelection.
+	MaskAlgorithmDensity MaskAlgorithm = 1
+)
+
+// maxMaskAlgorithm is the highest mask algorithm id this version knows how to apply.
+const maxMaskAlgorithm = MaskAlgorithmDensity
+
+// writeHeader writes all header metadata into the first 34 pixels of column 0 (the reserved rows of columns
+// 0-2 on gray and paletted carriers), plus the extension block and the protected header copies when
+// info.FormatVersion is 2 or higher. Header always uses 2-bit operations.
+func writeHeader(img *carrierImage, info HeaderInfo) error {
+	if img.reservedSamples() < headerSamples {
+		return wrapError(nil, ErrHeaderSpace, fmt.Sprintf("carrier width %d too small for the header", img.Bounds().Dx()))
+	}
+	marker := versionMarkerBytes
+	var extension []byte
+	if info.FormatVersion >= 2 {
+		info.HeaderCopies = uint8(len(headerCopyStarts(img)))
+		extension = encodeExtension(info)
+		if err := writeExtension(img, extension); err != nil {
+			return err
+		}
+		marker = versionMarkerV2Bytes
+	}
+	encodeHeaderSamples(img, info, marker)
+
+	if info.FormatVersion >= 2 {
+		return writeHeaderCopies(img, extension)
+	}
+	return nil
+}
+
+// encodeHeaderSamples writes the header fields and the given version marker into the 2-bit header samples
+// of dst. The reserved pixel 33 is left as it is.
+func encodeHeaderSamples(img headerSampleSink, info HeaderInfo, marker [6]byte) {
 	// y=0..7: photo ID (24 quarter-values across 8 pixels, 3 per pixel)
 	photoIDQuarters := bit_manipulation.QuartersOfBytes64(info.PhotoID)
 	for y := 0; y < 8; y++ {
-		c := img.RGBAAt(0, y)
 		idx := y * 3
-		c.R = bit_manipulation.SetLastTwoBits(c.R, photoIDQuarters[idx])
-		c.G = bit_manipulation.SetLastTwoBits(c.G, photoIDQuarters[idx+1])
-		c.B = bit_manipulation.SetLastTwoBits(c.B, photoIDQuarters[idx+2])
-		img.SetRGBA(0, y, c)
+		setHeaderPixel(img, y, photoIDQuarters[idx], photoIDQuarters[idx+1], photoIDQuarters[idx+2])
 	}
 
 	// y=8: photo number (6 bits across 3 channels: R=bits[5:4], G=bits[3:2], B=bits[1:0])
-	{
-		c := img.RGBAAt(0, 8)
-		pn := info.PhotoNumber
-		c.R = bit_manipulation.SetLastTwoBits(c.R, byte((pn>>4)&0x3))
-		c.G = bit_manipulation.SetLastTwoBits(c.G, byte((pn>>2)&0x3))
-		c.B = bit_manipulation.SetLastTwoBits(c.B, byte(pn&0x3))
-		img.SetRGBA(0, 8, c)
-	}
+	pn := info.PhotoNumber
+	setHeaderPixel(img, 8, byte((pn>>4)&0x3), byte((pn>>2)&0x3), byte(pn&0x3))
 
 	// y=9..12: data count (16 quarter-values across 4 pixels)
 	dataCountQuarters := bit_manipulation.QuartersOfBytes32(info.DataCount)
 	for y := 9; y < 13; y++ {
-		c := img.RGBAAt(0, y)
 		idx := (y - 9) * 3
-		c.R = bit_manipulation.SetLastTwoBits(c.R, dataCountQuarters[idx])
-		c.G = bit_manipulation.SetLastTwoBits(c.G, dataCountQuarters[idx+1])
-		c.B = bit_manipulation.SetLastTwoBits(c.B, dataCountQuarters[idx+2])
-		img.SetRGBA(0, y, c)
+		setHeaderPixel(img, y, dataCountQuarters[idx], dataCountQuarters[idx+1], dataCountQuarters[idx+2])
 	}
 
 	// y=13..14: version marker
 	for y := 13; y < 15; y++ {
-		c := img.RGBAAt(0, y)
 		idx := (y - 13) * 3
-		c.R = bit_manipulation.SetLastTwoBits(c.R, versionMarkerBytes[idx])
-		c.G = bit_manipulation.SetLastTwoBits(c.G, versionMarkerBytes[idx+1])
-		c.B = bit_manipulation.SetLastTwoBits(c.B, versionMarkerBytes[idx+2])
-		img.SetRGBA(0, y, c)
+		setHeaderPixel(img, y, marker[idx], marker[idx+1], marker[idx+2])
 	}
 
 	// y=15..25: file extension (up to 8 bytes, each split into 4 quarters, written across 11 pixels = 33 channels)
@@ -80,33 +169,18 @@ func writeHeader(img *image.RGBA, info HeaderInfo) {
 		q := bit_manipulation.SplitByteIntoQuarters(b)
 		extQuarters = append(extQuarters, q[0], q[1], q[2], q[3])
 	}
-	qi := 0
-	for y := 15; y < 26; y++ {
-		c := img.RGBAAt(0, y)
-		if qi < len(extQuarters) {
-			c.R = bit_manipulation.SetLastTwoBits(c.R, extQuarters[qi])
-		}
-		qi++
-		if qi < len(extQuarters) {
-			c.G = bit_manipulation.SetLastTwoBits(c.G, extQuarters[qi])
-		}
-		qi++
-		if qi < len(extQuarters) {
-			c.B = bit_manipulation.SetLastTwoBits(c.B, extQuarters[qi])
-		}
-		qi++
-		img.SetRGBA(0, y, c)
+	for qi, q := range extQuarters {
+		img.setHeaderSample(15*3+qi, q)
 	}
 
 	// y=26: encoding flags
-	// R = (bitDepth-1) & 0x3, G = huffman(MSB) | rs(LSB), B = rsLevel(MSB) | 0(LSB)
+	// R = (bitDepth-1) & 0x3, G = huffman(MSB) | rs(LSB), B = rsLevel(MSB) | extendedFlags(LSB)
+	// The extendedFlags bit tells the reader that pixels 31-32 hold flags; older encoders always wrote 0 here.
 	{
-		c := img.RGBAAt(0, 26)
 		bd := byte(0)
 		if info.BitDepth >= 1 && info.BitDepth <= 4 {
 			bd = byte(info.BitDepth - 1)
 		}
-		c.R = bit_manipulation.SetLastTwoBits(c.R, bd&0x3)
 
 		var gVal byte
 		if info.HuffmanEnabled {
@@ -115,14 +189,13 @@ func writeHeader(img *image.RGBA, info HeaderInfo) {
 		if info.RSEnabled {
 			gVal |= 0x1
 		}
-		c.G = bit_manipulation.SetLastTwoBits(c.G, gVal)
 
 		var bVal byte
 		if info.RSLevel == reed_solomon.High {
 			bVal |= 0x2
 		}
-		c.B = bit_manipulation.SetLastTwoBits(c.B, bVal)
-		img.SetRGBA(0, 26, c)
+		bVal |= 0x1
+		setHeaderPixel(img, 26, bd&0x3, gVal, bVal)
 	}
 
 	// y=27..28: checksum (12 bits across 2 pixels, 6 channels)
@@ -131,11 +204,164 @@ func writeHeader(img *image.RGBA, info HeaderInfo) {
 	// y=29..30: byte count modulo (12 bits across 2 pixels)
 	writeU12(img, 29, info.ByteCountMod)
 
-	// y=31..33: reserved (leave as-is)
+	// y=31: mask info
+	// R = maskEnabled(MSB) | 0(LSB), G/B = mask algorithm id (4 bits)
+	{
+		var rVal byte
+		if info.MaskEnabled {
+			rVal |= 0x2
+		}
+		setHeaderPixel(img, 31, rVal, byte(info.MaskAlgorithm>>2)&0x3, byte(info.MaskAlgorithm)&0x3)
+	}
+
+	// y=32: pipeline and layout flags
+	// R = encrypted(MSB) | LSB matching(LSB), G = scattered(MSB) | matrix embedding(LSB), B = alpha channel(MSB) | adaptive(LSB)
+	{
+		var rVal byte
+		if info.Encrypted {
+			rVal |= 0x2
+		}
+		if info.LSBMatching {
+			rVal |= 0x1
+		}
+		var gVal byte
+		if info.Scattered {
+			gVal |= 0x2
+		}
+		if info.MatrixEmbedding {
+			gVal |= 0x1
+		}
+		var bVal byte
+		if info.AlphaChannel {
+			bVal |= 0x2
+		}
+		if info.Adaptive {
+			bVal |= 0x1
+		}
+		setHeaderPixel(img, 32, rVal, gVal, bVal)
+	}
+
+	// y=33: reserved (leave as-is)
+}
+
+// encodeExtension serializes the format version 2 fields into the extension record.
+// Format: [1-byte field length][4-byte LE payload length][4-byte LE payload CRC][4-byte LE chunk CRC]
+// [4-byte LE data CRC][2-byte LE total parts][4-byte LE chunk offset][1-byte carrier model][1-byte matrix k]
+// [2-byte adaptive thresholds][1-byte mask candidate][1-byte mask density][1-byte header copy count]
+// New fields are only ever appended, so a reader can use the length byte to tell which ones are present.
+func encodeExtension(info HeaderInfo) []byte {
+	record := make([]byte, 1+headerCopiesFieldsLen)
+	record[0] = headerCopiesFieldsLen
+	binary.LittleEndian.PutUint32(record[1:5], info.PayloadLength)
+	binary.LittleEndian.PutUint32(record[5:9], info.PayloadCRC32)
+	binary.LittleEndian.PutUint32(record[9:13], info.ChunkCRC32)
+	binary.LittleEndian.PutUint32(record[13:17], info.DataCRC32)
+	binary.LittleEndian.PutUint16(record[17:19], info.TotalParts)
+	binary.LittleEndian.PutUint32(record[19:23], info.ChunkOffset)
+	record[23] = byte(info.CarrierModel)
+	record[24] = info.MatrixK
+	copy(record[25:27], info.AdaptiveThresholds[:])
+	record[27] = info.MaskCandidate
+	record[28] = info.MaskDensity
+	record[29] = info.HeaderCopies
+	return record
+}
+
+// decodeExtension parses an extension record into info. Records shorter than the integrity fields are
+// treated as damaged and leave HasIntegrity false; fields past the recorded length are left at zero.
+func decodeExtension(record []byte, info *HeaderInfo) {
+	if len(record) < 1+integrityFieldsLen || int(record[0]) < integrityFieldsLen {
+		return
+	}
+	info.PayloadLength = binary.LittleEndian.Uint32(record[1:5])
+	info.PayloadCRC32 = binary.LittleEndian.Uint32(record[5:9])
+	info.ChunkCRC32 = binary.LittleEndian.Uint32(record[9:13])
+	info.DataCRC32 = binary.LittleEndian.Uint32(record[13:17])
+	info.HasIntegrity = true
+	if int(record[0]) >= totalPartsFieldsLen {
+		info.TotalParts = binary.LittleEndian.Uint16(record[17:19])
+	}
+	if int(record[0]) >= chunkOffsetFieldsLen {
+		info.ChunkOffset = binary.LittleEndian.Uint32(record[19:23])
+		info.HasLayout = true
+	}
+	if int(record[0]) >= carrierModelFieldsLen {
+		info.CarrierModel = CarrierModel(record[23])
+	}
+	if int(record[0]) >= matrixFieldsLen {
+		info.MatrixK = record[24]
+	}
+	if int(record[0]) >= adaptiveFieldsLen {
+		copy(info.AdaptiveThresholds[:], record[25:27])
+	}
+	if int(record[0]) >= maskCandidateFieldsLen {
+		info.MaskCandidate = record[27]
+	}
+	if int(record[0]) >= maskDensityFieldsLen {
+		info.MaskDensity = record[28]
+	}
+	if int(record[0]) >= headerCopiesFieldsLen {
+		info.HeaderCopies = record[29]
+	}
+}
+
+// extensionCapacity returns how many bytes the extension block can hold in this image
+func extensionCapacity(img *carrierImage) int {
+	return max(img.reservedSamples()-headerSamples, 0) / 4
+}
+
+// writeExtension writes the record into the extension block, one byte per four channels.
+func writeExtension(img *carrierImage, record []byte) error {
+	if len(record) > extensionCapacity(img) {
+		return wrapError(nil, ErrHeaderSpace, fmt.Sprintf("carrier width %d too small for a %d byte header extension", img.Bounds().Dx(), len(record)))
+	}
+	writeReservedBytes(img, headerSamples, record)
+	return nil
+}
+
+// readExtensionByte reads the byte stored at the given position in the extension block
+func readExtensionByte(img *carrierImage, index int) byte {
+	return readReservedByte(img, headerSamples, index)
+}
+
+// writeReservedBytes writes data into the reserved samples from start on, one byte per four samples
+func writeReservedBytes(img *carrierImage, start int, data []byte) {
+	for i, b := range data {
+		for j, q := range bit_manipulation.SplitByteIntoQuarters(b) {
+			img.setHeaderSample(start+i*4+j, q)
+		}
+	}
+}
+
+// readReservedByte reads byte index of the bytes stored in the reserved samples from start on
+func readReservedByte(img *carrierImage, start, index int) byte {
+	var quarters [4]byte
+	for j := range quarters {
+		quarters[j] = img.headerSample(start + index*4 + j)
+	}
+	return bit_manipulation.ConstructByteFromQuartersAsSlice(quarters[:])
+}
+
+// readExtension reads the length-prefixed record from the extension block, returning nil when the
+// length byte points past the end of the block.
+func readExtension(img *carrierImage) []byte {
+	capacity := extensionCapacity(img)
+	if capacity == 0 {
+		return nil
+	}
+	length := int(readExtensionByte(img, 0))
+	if 1+length > capacity {
+		return nil
+	}
+	record := make([]byte, 1+length)
+	for i := range record {
+		record[i] = readExtensionByte(img, i)
+	}
+	return record
 }
 
 // writeU12 writes a 12-bit value across 2 pixels (6 channels) starting at the given y.
-func writeU12(img *image.RGBA, startY int, val uint16) {
+func writeU12(img headerSampleSink, startY int, val uint16) {
 	// 12 bits => 6 two-bit values
 	vals := [6]byte{
 		byte((val >> 10) & 0x3),
@@ -146,24 +372,17 @@ func writeU12(img *image.RGBA, startY int, val uint16) {
 		byte(val & 0x3),
 	}
 	for i := 0; i < 2; i++ {
-		c := img.RGBAAt(0, startY+i)
 		idx := i * 3
-		c.R = bit_manipulation.SetLastTwoBits(c.R, vals[idx])
-		c.G = bit_manipulation.SetLastTwoBits(c.G, vals[idx+1])
-		c.B = bit_manipulation.SetLastTwoBits(c.B, vals[idx+2])
-		img.SetRGBA(0, startY+i, c)
+		setHeaderPixel(img, startY+i, vals[idx], vals[idx+1], vals[idx+2])
 	}
 }
 
 // readU12 reads a 12-bit value from 2 pixels (6 channels) starting at the given y.
-func readU12(img *image.RGBA, startY int) uint16 {
+func readU12(img headerSampleSource, startY int) uint16 {
 	var vals [6]byte
 	for i := 0; i < 2; i++ {
-		c := img.RGBAAt(0, startY+i)
 		idx := i * 3
-		vals[idx] = bit_manipulation.GetLastTwoBits(c.R)
-		vals[idx+1] = bit_manipulation.GetLastTwoBits(c.G)
-		vals[idx+2] = bit_manipulation.GetLastTwoBits(c.B)
+		vals[idx], vals[idx+1], vals[idx+2] = headerPixel(img, startY+i)
 	}
 	var val uint16
 	for i := 0; i < 6; i++ {
@@ -172,19 +391,67 @@ func readU12(img *image.RGBA, startY int) uint16 {
 	return val
 }
 
-// readHeader reads all header metadata from the first 34 pixels of column 0.
-func readHeader(img *image.RGBA) HeaderInfo {
+// setHeaderSample writes a 2-bit value into header sample index, which is channel index%3 of header pixel
+// index/3 on a color carrier
+func (c *carrierImage) setHeaderSample(index int, value byte) {
+	x, y, channel := c.reservedSample(index)
+	c.setLastBits(x, y, channel, 2, value)
+}
+
+// headerSample returns the 2-bit value held by header sample index
+func (c *carrierImage) headerSample(index int) byte {
+	x, y, channel := c.reservedSample(index)
+	return c.lastBits(x, y, channel, 2)
+}
+
+// setHeaderPixel writes 2-bit values into the R, G and B samples of header pixel y
+func setHeaderPixel(img headerSampleSink, y int, r, g, b byte) {
+	img.setHeaderSample(y*3, r)
+	img.setHeaderSample(y*3+1, g)
+	img.setHeaderSample(y*3+2, b)
+}
+
+// headerPixel returns the 2-bit values held by the R, G and B samples of header pixel y
+func headerPixel(img headerSampleSource, y int) (r, g, b byte) {
+	return img.headerSample(y * 3), img.headerSample(y*3 + 1), img.headerSample(y*3 + 2)
+}
+
+// readVersionMarker returns the 2-bit values of the version marker pixels (y=13..14)
+func readVersionMarker(img headerSampleSource) [6]byte {
+	var markerVals [6]byte
+	for i := 0; i < 2; i++ {
+		idx := i * 3
+		markerVals[idx], markerVals[idx+1], markerVals[idx+2] = headerPixel(img, 13+i)
+	}
+	return markerVals
+}
+
+// readHeader reads all header metadata from the first 34 pixels of column 0, or from the protected header
+// copies when they disagree with it. A carrier too narrow to hold a header reads as an empty legacy header.
+func readHeader(img *carrierImage) HeaderInfo {
+	if img.reservedSamples() < headerSamples {
+		return HeaderInfo{}
+	}
+	primary := headerRecord{samples: readHeaderSamples(img)}
+	if readVersionMarker(primary.samples) == versionMarkerV2Bytes {
+		primary.extension = readExtension(img)
+	}
+	record, damage := recoverHeader(img, primary)
+	info := parseHeader(record)
+	info.HeaderDamage = damage
+	return info
+}
+
+// parseHeader decodes the header metadata held by a header record
+func parseHeader(record headerRecord) HeaderInfo {
 	var info HeaderInfo
+	img := record.samples
 
 	// y=0..7: photo ID
 	photoIDQuarters := make([]byte, 0, 24)
 	for y := 0; y < 8; y++ {
-		c := img.RGBAAt(0, y)
-		photoIDQuarters = append(photoIDQuarters,
-			bit_manipulation.GetLastTwoBits(c.R),
-			bit_manipulation.GetLastTwoBits(c.G),
-			bit_manipulation.GetLastTwoBits(c.B),
-		)
+		r, g, b := headerPixel(img, y)
+		photoIDQuarters = append(photoIDQuarters, r, g, b)
 	}
 	// Reconstruct uint64 from 24 quarters (6 bytes)
 	idBytes := make([]byte, 8)
@@ -195,22 +462,15 @@ func readHeader(img *image.RGBA) HeaderInfo {
 
 	// y=8: photo number (6 bits from 3 channels)
 	{
-		c := img.RGBAAt(0, 8)
-		r := bit_manipulation.GetLastTwoBits(c.R)
-		g := bit_manipulation.GetLastTwoBits(c.G)
-		b := bit_manipulation.GetLastTwoBits(c.B)
+		r, g, b := headerPixel(img, 8)
 		info.PhotoNumber = uint16(r)<<4 | uint16(g)<<2 | uint16(b)
 	}
 
 	// y=9..12: data count
 	dataCountQuarters := make([]byte, 0, 12)
 	for y := 9; y < 13; y++ {
-		c := img.RGBAAt(0, y)
-		dataCountQuarters = append(dataCountQuarters,
-			bit_manipulation.GetLastTwoBits(c.R),
-			bit_manipulation.GetLastTwoBits(c.G),
-			bit_manipulation.GetLastTwoBits(c.B),
-		)
+		r, g, b := headerPixel(img, y)
+		dataCountQuarters = append(dataCountQuarters, r, g, b)
 	}
 	dcBytes := make([]byte, 4)
 	dcBytes[0] = bit_manipulation.ConstructByteFromQuartersAsSlice(dataCountQuarters[0:4])
@@ -219,37 +479,25 @@ func readHeader(img *image.RGBA) HeaderInfo {
 	info.DataCount = binary.LittleEndian.Uint32(dcBytes)
 
 	// y=13..14: check version marker
-	var markerVals [6]byte
-	for i := 0; i < 2; i++ {
-		c := img.RGBAAt(0, 13+i)
-		idx := i * 3
-		markerVals[idx] = bit_manipulation.GetLastTwoBits(c.R)
-		markerVals[idx+1] = bit_manipulation.GetLastTwoBits(c.G)
-		markerVals[idx+2] = bit_manipulation.GetLastTwoBits(c.B)
-	}
-
-	markerMatch := markerVals == versionMarkerBytes
-
-	// Read bit depth from y=26 to check validity
-	flagC := img.RGBAAt(0, 26)
-	bdRaw := bit_manipulation.GetLastTwoBits(flagC.R)
-
-	if markerMatch && bdRaw <= 3 {
-		info.IsNewFormat = true
-	} else {
+	switch readVersionMarker(img) {
+	case versionMarkerBytes:
+		info.FormatVersion = 1
+	case versionMarkerV2Bytes:
+		info.FormatVersion = 2
+	default:
 		// Legacy mode: only legacy fields populated
 		return info
 	}
+	info.IsNewFormat = true
+
+	// Read the encoding flags from y=26
+	bdRaw, gVal, bVal := headerPixel(img, 26)
 
 	// y=15..25: file extension
 	extQuarters := make([]byte, 0, 33)
 	for y := 15; y < 26; y++ {
-		c := img.RGBAAt(0, y)
-		extQuarters = append(extQuarters,
-			bit_manipulation.GetLastTwoBits(c.R),
-			bit_manipulation.GetLastTwoBits(c.G),
-			bit_manipulation.GetLastTwoBits(c.B),
-		)
+		r, g, b := headerPixel(img, y)
+		extQuarters = append(extQuarters, r, g, b)
 	}
 	extBuf := make([]byte, 8)
 	for i := 0; i < 8; i++ {
@@ -258,19 +506,18 @@ func readHeader(img *image.RGBA) HeaderInfo {
 	}
 	info.FileExtension = strings.TrimRight(string(extBuf), "\x00")
 
-	// y=26: encoding flags (already read flagC)
+	// y=26: encoding flags (already read)
 	info.BitDepth = int(bdRaw) + 1
 
-	gVal := bit_manipulation.GetLastTwoBits(flagC.G)
 	info.HuffmanEnabled = (gVal & 0x2) != 0
 	info.RSEnabled = (gVal & 0x1) != 0
 
-	bVal := bit_manipulation.GetLastTwoBits(flagC.B)
 	if (bVal & 0x2) != 0 {
 		info.RSLevel = reed_solomon.High
 	} else {
 		info.RSLevel = reed_solomon.Standard
 	}
+	info.HasExtendedFlags = (bVal & 0x1) != 0
 
 	// y=27..28: checksum
 	info.Checksum = readU12(img, 27)
@@ -278,5 +525,24 @@ func readHeader(img *image.RGBA) HeaderInfo {
 	// y=29..30: byte count modulo
 	info.ByteCountMod = readU12(img, 29)
 
+	// y=31..32: extended flags, only meaningful when the flag pixel says they were written
+	if info.HasExtendedFlags {
+		r, g, b := headerPixel(img, 31)
+		info.MaskEnabled = (r & 0x2) != 0
+		info.MaskAlgorithm = MaskAlgorithm(g<<2 | b)
+
+		r, g, b = headerPixel(img, 32)
+		info.Encrypted = (r & 0x2) != 0
+		info.LSBMatching = (r & 0x1) != 0
+		info.Scattered = (g & 0x2) != 0
+		info.MatrixEmbedding = (g & 0x1) != 0
+		info.AlphaChannel = (b & 0x2) != 0
+		info.Adaptive = (b & 0x1) != 0
+	}
+
+	if info.FormatVersion >= 2 {
+		decodeExtension(record.extension, &info)
+	}
+
 	return info
 }
@@ -0,0 +1,249 @@
+package image_processing
+
+// The header in column 0 and its extension block are stored once and unprotected, so a single flipped bit in
+// the version marker or the data count loses the whole carrier, even when the payload itself is Reed-Solomon
+// protected. Format version 2 carriers wide enough for it therefore also hold protected copies of the header:
+// its 2-bit samples packed four to a byte, followed by the extension record and a CRC-32, as one Reed-Solomon
+// codeword. The copies are spread evenly over the reserved rows, so damage to one part of the image leaves
+// the others readable.
+//
+// On read every copy RS corrects is checked against its CRC-32. The readable copies vote, the primary header
+// is compared with the winner, and anything that was damaged, repaired or outvoted is reported in
+// HeaderInfo.HeaderDamage. On carriers without copies, or when none can be read, the primary header is the
+// only source, as before. JPEG-native carriers have too few header coefficients for copies.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"go-steg/go_steg/bit_manipulation"
+	"go-steg/go_steg/reed_solomon"
+	"hash/crc32"
+)
+
+// headerCopies is the number of protected header copies written to carriers wide enough for them
+const headerCopies = 3
+
+// packedHeaderSamples is the number of bytes the header samples take packed four to a byte
+const packedHeaderSamples = (headerSamples + 3) / 4
+
+// headerCopyDataLen is the size of the data part of a header copy: the packed header samples, the extension
+// record padded with zeros, and a CRC-32 over both
+const headerCopyDataLen = 80
+
+// headerCopyParity is the number of Reed-Solomon parity bytes of a header copy, enough to repair 16 bytes
+const headerCopyParity = 32
+
+// headerCopyLen is the size of a header copy in bytes; each byte takes four reserved samples
+const headerCopyLen = headerCopyDataLen + headerCopyParity
+
+// headerRecord is a header as it is stored: its 2-bit samples and, on format version 2 carriers, its
+// extension record
+type headerRecord struct {
+	samples   headerSampleValues
+	extension []byte
+}
+
+// equal reports whether two records hold the same header
+func (r headerRecord) equal(other headerRecord) bool {
+	return bytes.Equal(r.samples, other.samples) && bytes.Equal(r.extension, other.extension)
+}
+
+// headerSampleSource is anything a header can be parsed from: a carrier, or the samples of a header copy
+type headerSampleSource interface {
+	headerSample(index int) byte
+}
+
+// headerSampleSink is anything a header can be written to: a carrier, or the samples of a header copy
+type headerSampleSink interface {
+	setHeaderSample(index int, value byte)
+}
+
+// headerSampleValues holds the 2-bit samples of a header read out of a carrier or a header copy
+type headerSampleValues []byte
+
+// headerSample returns the 2-bit value of header sample index
+func (s headerSampleValues) headerSample(index int) byte {
+	return s[index]
+}
+
+// setHeaderSample sets header sample index to a 2-bit value
+func (s headerSampleValues) setHeaderSample(index int, value byte) {
+	s[index] = value
+}
+
+// readHeaderSamples returns the 2-bit samples of the header in column 0
+func readHeaderSamples(img *carrierImage) headerSampleValues {
+	samples := make(headerSampleValues, headerSamples)
+	for i := range samples {
+		samples[i] = img.headerSample(i)
+	}
+	return samples
+}
+
+// headerCopyStarts returns the reserved sample each header copy starts at, or nil when the reserved rows
+// are too small to hold the copies apart from each other and from the header
+func headerCopyStarts(img *carrierImage) []int {
+	samples := img.reservedSamples()
+	if samples < (headerCopies+1)*headerCopyLen*4 {
+		return nil
+	}
+	starts := make([]int, headerCopies)
+	for i := range starts {
+		starts[i] = samples * (i + 1) / (headerCopies + 1)
+	}
+	return starts
+}
+
+// packHeaderRecord packs a header record into the data part of a header copy, leaving its last checkLen bytes
+// zero for the check value
+func packHeaderRecord(record headerRecord, checkLen int) ([]byte, error) {
+	if packedHeaderSamples+len(record.extension) > headerCopyDataLen-checkLen {
+		return nil, wrapError(nil, ErrHeaderSpace, fmt.Sprintf("%d byte header extension does not fit a header copy", len(record.extension)))
+	}
+	data := make([]byte, headerCopyDataLen)
+	for i := range packedHeaderSamples {
+		var quarters [4]byte
+		copy(quarters[:], record.samples[i*4:min(i*4+4, len(record.samples))])
+		data[i] = bit_manipulation.ConstructByteFromQuartersAsSlice(quarters[:])
+	}
+	copy(data[packedHeaderSamples:], record.extension)
+	return data, nil
+}
+
+// unpackHeaderRecord is the inverse of packHeaderRecord
+func unpackHeaderRecord(data []byte, checkLen int) (headerRecord, error) {
+	record := headerRecord{samples: make(headerSampleValues, 0, packedHeaderSamples*4)}
+	for _, b := range data[:packedHeaderSamples] {
+		q := bit_manipulation.SplitByteIntoQuarters(b)
+		record.samples = append(record.samples, q[0], q[1], q[2], q[3])
+	}
+	record.samples = record.samples[:headerSamples]
+	if length := int(data[packedHeaderSamples]); length > 0 {
+		end := packedHeaderSamples + 1 + length
+		if end > headerCopyDataLen-checkLen {
+			return headerRecord{}, fmt.Errorf("header copy extension length %d is out of range", length)
+		}
+		record.extension = data[packedHeaderSamples:end]
+	}
+	return record, nil
+}
+
+// writeHeaderCopies writes a protected copy of the primary header samples and the given extension record to
+// every copy location of the carrier
+func writeHeaderCopies(img *carrierImage, extension []byte) error {
+	starts := headerCopyStarts(img)
+	if len(starts) == 0 {
+		return nil
+	}
+	data, err := packHeaderRecord(headerRecord{samples: readHeaderSamples(img), extension: extension}, 4)
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(data[headerCopyDataLen-4:], crc32.ChecksumIEEE(data[:headerCopyDataLen-4]))
+
+	codeword, err := reed_solomon.EncodeCodeword(data, headerCopyParity)
+	if err != nil {
+		return err
+	}
+	for _, start := range starts {
+		writeReservedBytes(img, start, codeword)
+	}
+	return nil
+}
+
+// readHeaderCopy reads and repairs the header copy starting at the given reserved sample, returning the
+// header it holds and the number of bytes Reed-Solomon corrected
+func readHeaderCopy(img *carrierImage, start int) (headerRecord, int, error) {
+	codeword := make([]byte, headerCopyLen)
+	for i := range codeword {
+		codeword[i] = readReservedByte(img, start, i)
+	}
+	data, corrected, err := reed_solomon.DecodeCodeword(codeword, headerCopyParity)
+	if err != nil {
+		return headerRecord{}, 0, err
+	}
+	if binary.LittleEndian.Uint32(data[headerCopyDataLen-4:]) != crc32.ChecksumIEEE(data[:headerCopyDataLen-4]) {
+		return headerRecord{}, 0, fmt.Errorf("header copy fails its CRC-32 check")
+	}
+	record, err := unpackHeaderRecord(data, 4)
+	return record, corrected, err
+}
+
+// numberedCopy is a header copy that could be read, with its 1-based number
+type numberedCopy struct {
+	number int
+	record headerRecord
+}
+
+// readCopies reads count header copies with read, returning the readable ones, a note for every copy that
+// needed repair and the numbers of those that could not be read
+func readCopies(count int, read func(i int) (headerRecord, int, error)) ([]numberedCopy, []string, []int) {
+	var copies []numberedCopy
+	var damage []string
+	var unreadable []int
+	for i := range count {
+		record, corrected, err := read(i)
+		if err != nil {
+			unreadable = append(unreadable, i+1)
+			continue
+		}
+		if corrected > 0 {
+			damage = append(damage, fmt.Sprintf("header copy %d had %d damaged bytes, repaired", i+1, corrected))
+		}
+		copies = append(copies, numberedCopy{number: i + 1, record: record})
+	}
+	return copies, damage, unreadable
+}
+
+// voteCopies returns the record most of the copies agree on, along with a note for every copy that does not
+// and for every unreadable one. There must be at least one copy.
+func voteCopies(copies []numberedCopy, unreadable []int) (headerRecord, []string) {
+	chosen, votes := copies[0].record, 0
+	for _, c := range copies {
+		n := 0
+		for _, other := range copies {
+			if c.record.equal(other.record) {
+				n++
+			}
+		}
+		if n > votes {
+			chosen, votes = c.record, n
+		}
+	}
+	var damage []string
+	for _, c := range copies {
+		if !c.record.equal(chosen) {
+			damage = append(damage, fmt.Sprintf("header copy %d disagrees with the other copies, ignored", c.number))
+		}
+	}
+	for _, number := range unreadable {
+		damage = append(damage, fmt.Sprintf("header copy %d could not be read", number))
+	}
+	return chosen, damage
+}
+
+// recoverHeader returns the header record the carrier's header copies agree on, or the primary header when
+// none of them can be read, along with a description of every damaged or disagreeing copy
+func recoverHeader(img *carrierImage, primary headerRecord) (headerRecord, []string) {
+	starts := headerCopyStarts(img)
+	copies, damage, unreadable := readCopies(len(starts), func(i int) (headerRecord, int, error) {
+		return readHeaderCopy(img, starts[i])
+	})
+	if len(copies) == 0 {
+		// Carriers written before there were copies have none to read, so only report the loss when the
+		// header says there should be some
+		if expected := parseHeader(primary).HeaderCopies; expected > 0 {
+			return primary, []string{fmt.Sprintf("none of the %d header copies could be read", expected)}
+		}
+		return primary, nil
+	}
+
+	// The copies passed their CRC-32 check, so trust the one most of them agree with
+	chosen, votes := voteCopies(copies, unreadable)
+	damage = append(damage, votes...)
+	if !primary.equal(chosen) {
+		damage = append(damage, "primary header is damaged, read from its copies instead")
+	}
+	return chosen, damage
+}
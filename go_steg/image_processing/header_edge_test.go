@@ -9,7 +9,7 @@ import (
 func TestHeaderAllBitDepths(t *testing.T) {
 	for depth := 1; depth <= 4; depth++ {
 		t.Run("", func(t *testing.T) {
-			img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+			img := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
 			info := HeaderInfo{
 				IsNewFormat: true,
 				BitDepth:    depth,
@@ -42,7 +42,7 @@ func TestHeaderAllFlagCombinations(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+			img := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
 			info := HeaderInfo{
 				IsNewFormat:    true,
 				BitDepth:       2,
@@ -79,7 +79,7 @@ func TestHeaderU12BoundaryValues(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+			img := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
 			info := HeaderInfo{
 				IsNewFormat:  true,
 				BitDepth:     2,
@@ -99,7 +99,7 @@ func TestHeaderU12BoundaryValues(t *testing.T) {
 }
 
 func TestHeaderMaxPhotoID(t *testing.T) {
-	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	img := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
 	// Max value that can be encoded: 6 bytes = 48 bits (only 24 quarters used)
 	// The QuartersOfBytes64 only stores 6 bytes, so max is 2^48-1
 	maxID := uint64(1<<48 - 1)
@@ -116,7 +116,7 @@ func TestHeaderMaxPhotoID(t *testing.T) {
 }
 
 func TestHeaderMaxPhotoNumber(t *testing.T) {
-	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	img := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
 	// Photo number uses 6 bits (3 channels * 2 bits), max = 63
 	info := HeaderInfo{
 		IsNewFormat: true,
@@ -131,7 +131,7 @@ func TestHeaderMaxPhotoNumber(t *testing.T) {
 }
 
 func TestHeaderMaxDataCount(t *testing.T) {
-	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	img := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
 	// DataCount uses 12 quarters = 3 bytes (24 bits), encoded as uint32 with LE
 	// But only 3 bytes are reconstructed from 12 quarters, so max is 2^24-1
 	maxDC := uint32(1<<24 - 1)
@@ -148,7 +148,7 @@ func TestHeaderMaxDataCount(t *testing.T) {
 }
 
 func TestHeaderExtensionMaxLength(t *testing.T) {
-	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	img := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
 	info := HeaderInfo{
 		IsNewFormat:   true,
 		BitDepth:      2,
@@ -162,7 +162,7 @@ func TestHeaderExtensionMaxLength(t *testing.T) {
 }
 
 func TestHeaderExtensionSingleChar(t *testing.T) {
-	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	img := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
 	info := HeaderInfo{
 		IsNewFormat:   true,
 		BitDepth:      2,
@@ -179,7 +179,7 @@ func TestHeaderExtensionVariousFormats(t *testing.T) {
 	extensions := []string{"txt", "pdf", "png", "jpg", "bin", "dat", "go", "rs"}
 	for _, ext := range extensions {
 		t.Run(ext, func(t *testing.T) {
-			img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+			img := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
 			info := HeaderInfo{
 				IsNewFormat:   true,
 				BitDepth:      2,
@@ -195,7 +195,7 @@ func TestHeaderExtensionVariousFormats(t *testing.T) {
 }
 
 func TestHeaderVersionMarkerDetection(t *testing.T) {
-	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	img := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
 	info := HeaderInfo{
 		IsNewFormat: true,
 		BitDepth:    2,
@@ -209,7 +209,7 @@ func TestHeaderVersionMarkerDetection(t *testing.T) {
 	}
 
 	// Corrupt the version marker and verify legacy detection
-	img2 := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	img2 := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
 	got2 := readHeader(img2)
 	if got2.IsNewFormat {
 		t.Error("expected legacy format for blank image")
@@ -220,7 +220,7 @@ func TestWriteU12ReadU12Roundtrip(t *testing.T) {
 	// Test all 12-bit values at boundaries
 	values := []uint16{0, 1, 2, 3, 4, 0x3FF, 0x400, 0x7FF, 0x800, 0xFFF}
 	for _, val := range values {
-		img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+		img := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
 		writeU12(img, 0, val)
 		got := readU12(img, 0)
 		if got != val {
@@ -231,7 +231,7 @@ func TestWriteU12ReadU12Roundtrip(t *testing.T) {
 
 func TestHeaderMinimalImage(t *testing.T) {
 	// Minimum image that can hold a header: 1 pixel wide, 34 pixels tall
-	img := image.NewRGBA(image.Rect(0, 0, 1, 34))
+	img := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 1, 34)))
 	info := HeaderInfo{
 		IsNewFormat:   true,
 		BitDepth:      1,
@@ -254,7 +254,7 @@ func TestHeaderMinimalImage(t *testing.T) {
 
 func TestHeaderFullRoundtripAllFields(t *testing.T) {
 	// Test with all fields at non-zero, non-max values
-	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	img := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
 	info := HeaderInfo{
 		PhotoID:        999999,
 		PhotoNumber:    42,
@@ -302,3 +302,26 @@ func TestHeaderFullRoundtripAllFields(t *testing.T) {
 		t.Errorf("ByteCountMod: got %d, want %d", got.ByteCountMod, info.ByteCountMod)
 	}
 }
+
+func TestHeaderIntegrityExtensionNeedsSecondColumn(t *testing.T) {
+	img := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 1, 34)))
+	err := writeHeader(img, HeaderInfo{IsNewFormat: true, BitDepth: 2, FormatVersion: currentFormatVersion, HasIntegrity: true})
+	if err == nil {
+		t.Fatal("expected an error writing a version 2 header into a one column image")
+	}
+}
+
+func TestHeaderExtensionWithoutTotalParts(t *testing.T) {
+	// A record that ends after the integrity fields, as written before the total part count was added
+	record := encodeExtension(HeaderInfo{PayloadLength: 99, TotalParts: 5})[:1+integrityFieldsLen]
+	record[0] = integrityFieldsLen
+
+	var info HeaderInfo
+	decodeExtension(record, &info)
+	if !info.HasIntegrity || info.PayloadLength != 99 {
+		t.Errorf("integrity fields not read from short record: %+v", info)
+	}
+	if info.TotalParts != 0 {
+		t.Errorf("TotalParts: got %d, want 0 for a record without it", info.TotalParts)
+	}
+}
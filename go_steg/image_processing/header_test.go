@@ -3,11 +3,12 @@ package image_processing
 import (
 	"go-steg/go_steg/reed_solomon"
 	"image"
+	"strings"
 	"testing"
 )
 
 func TestHeaderRoundtrip(t *testing.T) {
-	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	img := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
 	info := HeaderInfo{
 		PhotoID:        12345,
 		PhotoNumber:    3,
@@ -60,7 +61,7 @@ func TestHeaderRoundtrip(t *testing.T) {
 }
 
 func TestHeaderLegacyDetection(t *testing.T) {
-	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	img := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
 	got := readHeader(img)
 	if got.IsNewFormat {
 		t.Error("expected legacy format for blank image")
@@ -68,7 +69,7 @@ func TestHeaderLegacyDetection(t *testing.T) {
 }
 
 func TestHeaderEmptyExtension(t *testing.T) {
-	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	img := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
 	info := HeaderInfo{
 		IsNewFormat:   true,
 		FileExtension: "",
@@ -82,7 +83,7 @@ func TestHeaderEmptyExtension(t *testing.T) {
 }
 
 func TestHeaderLongExtension(t *testing.T) {
-	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	img := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
 	info := HeaderInfo{
 		IsNewFormat:   true,
 		FileExtension: "markdown", // 8 chars, max length
@@ -94,3 +95,318 @@ func TestHeaderLongExtension(t *testing.T) {
 		t.Errorf("got %q, want %q", got.FileExtension, "markdown")
 	}
 }
+
+func TestHeaderMaskInfoRoundtrip(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		img := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
+		info := HeaderInfo{
+			IsNewFormat:   true,
+			BitDepth:      2,
+			MaskEnabled:   enabled,
+			MaskAlgorithm: MaskAlgorithmXORIndexPair,
+		}
+		writeHeader(img, info)
+		got := readHeader(img)
+
+		if !got.HasExtendedFlags {
+			t.Fatalf("mask=%v: expected HasExtendedFlags=true", enabled)
+		}
+		if got.MaskEnabled != enabled {
+			t.Errorf("MaskEnabled: got %v, want %v", got.MaskEnabled, enabled)
+		}
+		if got.MaskAlgorithm != MaskAlgorithmXORIndexPair {
+			t.Errorf("MaskAlgorithm: got %d, want %d", got.MaskAlgorithm, MaskAlgorithmXORIndexPair)
+		}
+	}
+}
+
+func TestHeaderExtendedFlagsAbsentOnOlderCarriers(t *testing.T) {
+	img := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
+	writeHeader(img, HeaderInfo{IsNewFormat: true, BitDepth: 2, MaskEnabled: true, Encrypted: true})
+
+	// Older encoders always left the low bit of the flag pixel's blue channel clear
+	img.setLastBits(0, 26, 2, 1, 0)
+
+	got := readHeader(img)
+	if got.HasExtendedFlags {
+		t.Error("expected HasExtendedFlags=false when the extended flags bit is clear")
+	}
+	if got.MaskEnabled || got.Encrypted {
+		t.Error("expected MaskEnabled and Encrypted to be false when the extended flags bit is clear")
+	}
+}
+
+func TestHeaderEncryptedFlagRoundtrip(t *testing.T) {
+	for _, encrypted := range []bool{true, false} {
+		img := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
+		writeHeader(img, HeaderInfo{IsNewFormat: true, BitDepth: 2, Encrypted: encrypted, MaskEnabled: true})
+		got := readHeader(img)
+		if got.Encrypted != encrypted {
+			t.Errorf("Encrypted: got %v, want %v", got.Encrypted, encrypted)
+		}
+		if !got.MaskEnabled {
+			t.Error("MaskEnabled should be unaffected by the encryption flag")
+		}
+	}
+}
+
+func TestHeaderScatteredFlagRoundtrip(t *testing.T) {
+	for _, scattered := range []bool{true, false} {
+		img := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
+		writeHeader(img, HeaderInfo{IsNewFormat: true, BitDepth: 2, Scattered: scattered, Encrypted: true})
+		got := readHeader(img)
+		if got.Scattered != scattered {
+			t.Errorf("Scattered: got %v, want %v", got.Scattered, scattered)
+		}
+		if !got.Encrypted {
+			t.Error("Encrypted should be unaffected by the scattered flag")
+		}
+	}
+}
+
+func TestHeaderLSBMatchingFlagRoundtrip(t *testing.T) {
+	for _, matching := range []bool{true, false} {
+		img := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
+		writeHeader(img, HeaderInfo{IsNewFormat: true, BitDepth: 1, LSBMatching: matching, Encrypted: true})
+		got := readHeader(img)
+		if got.LSBMatching != matching {
+			t.Errorf("LSBMatching: got %v, want %v", got.LSBMatching, matching)
+		}
+		if !got.Encrypted {
+			t.Error("Encrypted should be unaffected by the LSB matching flag")
+		}
+	}
+}
+
+func TestHeaderMatrixEmbeddingRoundtrip(t *testing.T) {
+	img := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
+	info := HeaderInfo{IsNewFormat: true, BitDepth: 1, Scattered: true, MatrixEmbedding: true, FormatVersion: currentFormatVersion, MatrixK: 5}
+	if err := writeHeader(img, info); err != nil {
+		t.Fatalf("writeHeader: %v", err)
+	}
+	got := readHeader(img)
+	if !got.MatrixEmbedding || got.MatrixK != 5 {
+		t.Errorf("matrix embedding: got %v with k = %d, want true with k = 5", got.MatrixEmbedding, got.MatrixK)
+	}
+	if !got.Scattered {
+		t.Error("Scattered should be unaffected by the matrix embedding flag")
+	}
+}
+
+func TestHeaderAdaptiveRoundtrip(t *testing.T) {
+	img := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
+	info := HeaderInfo{IsNewFormat: true, BitDepth: 3, AlphaChannel: true, Adaptive: true, FormatVersion: currentFormatVersion, AdaptiveThresholds: [2]uint8{6, 40}}
+	if err := writeHeader(img, info); err != nil {
+		t.Fatalf("writeHeader: %v", err)
+	}
+	got := readHeader(img)
+	if !got.Adaptive || got.AdaptiveThresholds != info.AdaptiveThresholds {
+		t.Errorf("adaptive: got %v with thresholds %v, want true with %v", got.Adaptive, got.AdaptiveThresholds, info.AdaptiveThresholds)
+	}
+	if !got.AlphaChannel {
+		t.Error("AlphaChannel should be unaffected by the adaptive flag")
+	}
+}
+
+func TestHeaderMaskCandidateRoundtrip(t *testing.T) {
+	img := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
+	info := HeaderInfo{IsNewFormat: true, BitDepth: 2, MaskEnabled: true, FormatVersion: currentFormatVersion, MaskCandidate: 11}
+	if err := writeHeader(img, info); err != nil {
+		t.Fatalf("writeHeader: %v", err)
+	}
+	if got := readHeader(img); got.MaskCandidate != 11 {
+		t.Errorf("MaskCandidate: got %d, want 11", got.MaskCandidate)
+	}
+}
+
+func TestHeaderMaskDensityRoundtrip(t *testing.T) {
+	img := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
+	info := HeaderInfo{IsNewFormat: true, BitDepth: 2, HasExtendedFlags: true, MaskEnabled: true, MaskAlgorithm: MaskAlgorithmDensity, FormatVersion: currentFormatVersion, MaskDensity: 5}
+	if err := writeHeader(img, info); err != nil {
+		t.Fatalf("writeHeader: %v", err)
+	}
+	got := readHeader(img)
+	if got.MaskAlgorithm != MaskAlgorithmDensity || got.MaskDensity != 5 {
+		t.Errorf("mask: got algorithm %d with density %d, want %d with 5", got.MaskAlgorithm, got.MaskDensity, MaskAlgorithmDensity)
+	}
+}
+
+func TestHeaderIntegrityExtensionRoundtrip(t *testing.T) {
+	img := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
+	info := HeaderInfo{
+		IsNewFormat:   true,
+		BitDepth:      2,
+		FormatVersion: currentFormatVersion,
+		HasIntegrity:  true,
+		PayloadLength: 123456,
+		PayloadCRC32:  0xDEADBEEF,
+		ChunkCRC32:    0x01234567,
+		DataCRC32:     0xFFFFFFFF,
+		TotalParts:    12,
+		ChunkOffset:   4096,
+	}
+	if err := writeHeader(img, info); err != nil {
+		t.Fatalf("writeHeader: %v", err)
+	}
+	got := readHeader(img)
+	if got.FormatVersion != currentFormatVersion {
+		t.Errorf("FormatVersion: got %d, want %d", got.FormatVersion, currentFormatVersion)
+	}
+	if !got.HasIntegrity {
+		t.Fatal("expected integrity fields to be read back")
+	}
+	if got.PayloadLength != info.PayloadLength || got.PayloadCRC32 != info.PayloadCRC32 ||
+		got.ChunkCRC32 != info.ChunkCRC32 || got.DataCRC32 != info.DataCRC32 || got.TotalParts != info.TotalParts ||
+		!got.HasLayout || got.ChunkOffset != info.ChunkOffset {
+		t.Errorf("integrity fields: got %+v, want %+v", got, info)
+	}
+}
+
+func TestHeaderVersionOneHasNoIntegrity(t *testing.T) {
+	img := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
+	if err := writeHeader(img, HeaderInfo{IsNewFormat: true, BitDepth: 2, HasIntegrity: true, PayloadCRC32: 7}); err != nil {
+		t.Fatalf("writeHeader: %v", err)
+	}
+	got := readHeader(img)
+	if got.FormatVersion != 1 {
+		t.Errorf("FormatVersion: got %d, want 1", got.FormatVersion)
+	}
+	if got.HasIntegrity {
+		t.Error("version 1 header should not report integrity fields")
+	}
+}
+
+func TestHeaderCopiesRepairDamage(t *testing.T) {
+	img := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
+	info := HeaderInfo{IsNewFormat: true, BitDepth: 2, DataCount: 123456, FileExtension: "txt", HasExtendedFlags: true, FormatVersion: currentFormatVersion, PayloadLength: 4321}
+	if err := writeHeader(img, info); err != nil {
+		t.Fatalf("writeHeader: %v", err)
+	}
+	got := readHeader(img)
+	if got.HeaderCopies != headerCopies || len(got.HeaderDamage) != 0 {
+		t.Fatalf("fresh header: %d copies with damage %v, want %d copies and none", got.HeaderCopies, got.HeaderDamage, headerCopies)
+	}
+
+	starts := headerCopyStarts(img)
+	// A few flipped bits in the first copy are repaired by Reed-Solomon
+	for i := 0; i < 5; i++ {
+		img.setHeaderSample(starts[0]+i*20, img.headerSample(starts[0]+i*20)^1)
+	}
+	// The second copy is destroyed outright
+	for i := 0; i < headerCopyLen*4; i++ {
+		img.setHeaderSample(starts[1]+i, byte(i*7)&0x3)
+	}
+	// And the data count in column 0 loses a bit
+	img.setHeaderSample(9*3, img.headerSample(9*3)^2)
+
+	got = readHeader(img)
+	if got.DataCount != info.DataCount || got.FileExtension != "txt" || got.PayloadLength != info.PayloadLength {
+		t.Errorf("recovered header: data count %d, extension %q, payload length %d", got.DataCount, got.FileExtension, got.PayloadLength)
+	}
+	want := []string{
+		"header copy 1 had 5 damaged bytes, repaired",
+		"header copy 2 could not be read",
+		"primary header is damaged, read from its copies instead",
+	}
+	if strings.Join(got.HeaderDamage, "; ") != strings.Join(want, "; ") {
+		t.Errorf("damage: got %q, want %q", got.HeaderDamage, want)
+	}
+}
+
+func TestHeaderCopiesNeedRoom(t *testing.T) {
+	narrow := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 10, 100)))
+	info := HeaderInfo{IsNewFormat: true, BitDepth: 2, FormatVersion: currentFormatVersion}
+	if err := writeHeader(narrow, info); err != nil {
+		t.Fatalf("writeHeader: %v", err)
+	}
+	if got := readHeader(narrow); got.HeaderCopies != 0 || len(got.HeaderDamage) != 0 {
+		t.Errorf("narrow carrier: %d copies with damage %v, want none", got.HeaderCopies, got.HeaderDamage)
+	}
+
+	// Version 1 headers have no copies, and a wide carrier of them is not reported as damaged
+	wide := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
+	info.FormatVersion = 1
+	if err := writeHeader(wide, info); err != nil {
+		t.Fatalf("writeHeader: %v", err)
+	}
+	if got := readHeader(wide); got.HeaderCopies != 0 || len(got.HeaderDamage) != 0 {
+		t.Errorf("version 1 header: %d copies with damage %v, want none", got.HeaderCopies, got.HeaderDamage)
+	}
+}
+
+func TestStealthHeaderRepairDamage(t *testing.T) {
+	img := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
+	mask := generateMaskingInfo("stealth")
+	info := HeaderInfo{IsNewFormat: true, BitDepth: 2, DataCount: 123456, FileExtension: "txt", HasExtendedFlags: true, FormatVersion: currentFormatVersion, PayloadLength: 4321, Stealth: true}
+	if err := writeCarrierHeader(img, info, mask); err != nil {
+		t.Fatalf("writeCarrierHeader: %v", err)
+	}
+	if marker := readVersionMarker(img); marker == versionMarkerBytes || marker == versionMarkerV2Bytes {
+		t.Errorf("column 0 holds the version marker %v", marker)
+	}
+	if _, ok := readStealthHeader(img, generateMaskingInfo("other")); ok {
+		t.Error("stealth header found with another password")
+	}
+	got, err := readCarrierHeader(img, mask, Options{StealthHeader: true})
+	if err != nil {
+		t.Fatalf("readCarrierHeader: %v", err)
+	}
+	if !got.Stealth || got.HeaderCopies != headerCopies || len(got.HeaderDamage) != 0 {
+		t.Fatalf("fresh stealth header: stealth %v, %d copies with damage %v", got.Stealth, got.HeaderCopies, got.HeaderDamage)
+	}
+
+	layout := newStealthLayout(img, mask)
+	// A few flipped bits in the first copy are repaired by Reed-Solomon
+	for j := 0; j < 5; j++ {
+		p := layout.position(0, j*20, 0)
+		img.setHeaderSample(p, img.headerSample(p)^1)
+	}
+	// The second copy is destroyed outright
+	for j := 0; j < headerCopyLen; j++ {
+		for k := 0; k < 4; k++ {
+			img.setHeaderSample(layout.position(1, j, k), byte(j*7+k)&0x3)
+		}
+	}
+
+	got, err = readCarrierHeader(img, mask, Options{})
+	if err != nil {
+		t.Fatalf("readCarrierHeader: %v", err)
+	}
+	if got.DataCount != info.DataCount || got.FileExtension != "txt" || got.PayloadLength != info.PayloadLength {
+		t.Errorf("recovered header: data count %d, extension %q, payload length %d", got.DataCount, got.FileExtension, got.PayloadLength)
+	}
+	want := []string{
+		"header copy 1 had 5 damaged bytes, repaired",
+		"header copy 2 could not be read",
+	}
+	if strings.Join(got.HeaderDamage, "; ") != strings.Join(want, "; ") {
+		t.Errorf("damage: got %q, want %q", got.HeaderDamage, want)
+	}
+}
+
+func TestStealthHeaderLayout(t *testing.T) {
+	// Carriers with different reserved rows get different positions and keystreams from the same password
+	mask := generateMaskingInfo("stealth")
+	a := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
+	b := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
+	b.setLastBits(50, 10, 1, 4, 0xc)
+	la, lb := newStealthLayout(a, mask), newStealthLayout(b, mask)
+	if la.positions[0] == lb.positions[0] && la.positions[1] == lb.positions[1] || string(la.keystream) == string(lb.keystream) {
+		t.Error("carriers with different reserved rows share a stealth header layout")
+	}
+
+	// Writing the header only changes the two bits the layout is not derived from
+	info := HeaderInfo{IsNewFormat: true, BitDepth: 2, FormatVersion: currentFormatVersion, Stealth: true}
+	if err := writeCarrierHeader(a, info, mask); err != nil {
+		t.Fatalf("writeCarrierHeader: %v", err)
+	}
+	if after := newStealthLayout(a, mask); string(after.keystream) != string(la.keystream) {
+		t.Error("writing the stealth header changed its layout")
+	}
+
+	// A carrier too small for a single copy is rejected
+	narrow := newCarrierImage(image.NewNRGBA(image.Rect(0, 0, 4, 100)))
+	if err := writeCarrierHeader(narrow, info, mask); err == nil {
+		t.Error("stealth header written to a carrier too small for it")
+	}
+}
@@ -0,0 +1,102 @@
+package image_processing
+
+import (
+	"fmt"
+	"io"
+)
+
+// ReadHeader decodes a carrier image and returns the header metadata found in it. It does not check the
+// header; use InspectCarrier to have it checked against the carrier.
+func ReadHeader(carrier io.Reader) (HeaderInfo, error) {
+	img, _, err := getCarrierImage(carrier)
+	if err != nil {
+		return HeaderInfo{}, fmt.Errorf("error parsing carrier image: %w", err)
+	}
+	return readHeader(img), nil
+}
+
+// HeaderReport is the header of one carrier together with what the carrier itself can hold, and any
+// inconsistencies between the two.
+type HeaderReport struct {
+	Header HeaderInfo
+	Width  int
+	Height int
+	// Model is the color model the carrier image has, which decode reads it in
+	Model CarrierModel
+	// VersionMarker holds the raw 2-bit values of the version marker pixels
+	VersionMarker [6]byte
+	// Slots is the number of payload channels the header's settings can use. The mask is only applied when
+	// the carrier was inspected with a password.
+	Slots int64
+	// Problems lists every inconsistency found, empty when the header looks sound
+	Problems []string
+}
+
+// InspectCarrier reads the header of a carrier and checks it against the carrier dimensions and against
+// itself. Without a password the mask cannot be regenerated, so a masked carrier is checked against the
+// unmasked slot count, which is an upper bound, and a stealth header cannot be found.
+func InspectCarrier(carrier io.Reader, password string) (HeaderReport, error) {
+	img, _, err := getCarrierImage(carrier)
+	if err != nil {
+		return HeaderReport{}, fmt.Errorf("error parsing carrier image: %w", err)
+	}
+	mask := generateMaskingInfo(password)
+	header, err := readCarrierHeader(img, mask, Options{})
+	if err != nil {
+		return HeaderReport{}, err
+	}
+	report := HeaderReport{
+		Header:        header,
+		Width:         img.Bounds().Dx(),
+		Height:        img.Bounds().Dy(),
+		Model:         img.model,
+		VersionMarker: readVersionMarker(img),
+	}
+
+	if !header.IsNewFormat {
+		report.Problems = append(report.Problems, fmt.Sprintf("unknown version marker %v, read as a legacy header", report.VersionMarker))
+	}
+
+	report.Problems = append(report.Problems, header.HeaderDamage...)
+
+	embed, err := headerEmbedding(header, Options{}, mask)
+	if err != nil {
+		// Keep checking the counts against the unmasked slots
+		report.Problems = append(report.Problems, err.Error())
+		embed.useMask = false
+	}
+	embed.useMask = embed.useMask && password != ""
+	report.Slots = embed.count(img)
+	if embed.adaptive {
+		if bits := embed.capacityBits(img); int64(header.DataCount) > bits {
+			report.Problems = append(report.Problems, fmt.Sprintf("data count %d is larger than the %d bits the carrier's textured slots hold", header.DataCount, bits))
+		}
+	} else if used := embed.slotsUsed(int64(header.DataCount)); used > report.Slots {
+		if used == int64(header.DataCount) {
+			report.Problems = append(report.Problems, fmt.Sprintf("data count %d is larger than the %d slots the carrier has", header.DataCount, report.Slots))
+		} else {
+			report.Problems = append(report.Problems, fmt.Sprintf("data count %d takes %d slots in matrix groups, more than the %d the carrier has", header.DataCount, used, report.Slots))
+		}
+	}
+
+	if header.FormatVersion >= 2 && !header.HasIntegrity {
+		report.Problems = append(report.Problems, "version 2 marker but the header extension is unreadable")
+	}
+	if header.HasIntegrity {
+		per := chunksPerByte(embed.chunkBits())
+		chunkLen := (int64(header.DataCount) + per - 1) / per
+		if chunkLen > int64(header.PayloadLength) {
+			report.Problems = append(report.Problems, fmt.Sprintf("carrier holds %d bytes, more than the %d byte payload", chunkLen, header.PayloadLength))
+		}
+		if header.HasLayout && int64(header.ChunkOffset)+chunkLen > int64(header.PayloadLength) {
+			report.Problems = append(report.Problems, fmt.Sprintf("chunk at offset %d runs past the end of the %d byte payload", header.ChunkOffset, header.PayloadLength))
+		}
+	}
+	if header.CarrierModel != CarrierModelUnknown && header.CarrierModel != img.model {
+		report.Problems = append(report.Problems, fmt.Sprintf("header records a %v carrier but the image is %v; it was converted after encoding", header.CarrierModel, img.model))
+	}
+	if header.TotalParts != 0 && header.PhotoNumber >= header.TotalParts {
+		report.Problems = append(report.Problems, fmt.Sprintf("part number %d in a set of %d", header.PhotoNumber, header.TotalParts))
+	}
+	return report, nil
+}
@@ -0,0 +1,105 @@
+package image_processing
+
+import (
+	"bytes"
+	"go-steg/go_steg/pipeline"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestReadHeader(t *testing.T) {
+	opts := Options{UseMask: true, Config: pipeline.Config{BitDepth: 3, HuffmanEnabled: true, FileExtension: "txt"}}
+	encoded := encodeToBytes(t, carrierPNGBytes(t, 100, 100, 901), []byte("header to inspect"), opts)
+
+	header, err := ReadHeader(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatalf("ReadHeader: %v", err)
+	}
+	if header.FormatVersion != currentFormatVersion || header.PhotoID != 1 || header.FileExtension != "txt" ||
+		header.BitDepth != 3 || !header.HuffmanEnabled || !header.MaskEnabled || header.TotalParts != 1 {
+		t.Errorf("unexpected header %+v", header)
+	}
+
+	report, err := InspectCarrier(bytes.NewReader(encoded), "integrity")
+	if err != nil {
+		t.Fatalf("InspectCarrier: %v", err)
+	}
+	if len(report.Problems) != 0 {
+		t.Errorf("expected no problems, got %v", report.Problems)
+	}
+	embed := embedding{bitDepth: 3, useMask: true, mask: generateMaskingInfo("integrity")}
+	if report.Slots != embed.count(mustCarrier(t, encoded)) {
+		t.Errorf("Slots = %d, expected the masked count", report.Slots)
+	}
+}
+
+func TestReadHeaderNotAnImage(t *testing.T) {
+	if _, err := ReadHeader(strings.NewReader("not an image")); err == nil {
+		t.Error("expected an error for a non-image carrier")
+	}
+}
+
+func TestInspectUnknownVersionMarker(t *testing.T) {
+	img := mustCarrier(t, carrierPNGBytes(t, 60, 60, 902))
+	for y := 13; y < 15; y++ {
+		for ch := 0; ch < 3; ch++ {
+			img.setLastBits(0, y, ch, 2, 0x03)
+		}
+	}
+
+	report, err := InspectCarrier(pngReader(t, img.Image), "")
+	if err != nil {
+		t.Fatalf("InspectCarrier: %v", err)
+	}
+	if report.VersionMarker != [6]byte{3, 3, 3, 3, 3, 3} {
+		t.Errorf("VersionMarker = %v", report.VersionMarker)
+	}
+	if !hasProblem(report, "unknown version marker") {
+		t.Errorf("expected an unknown marker problem, got %v", report.Problems)
+	}
+}
+
+func TestInspectDataCountTooLarge(t *testing.T) {
+	img := mustCarrier(t, carrierPNGBytes(t, 60, 60, 903))
+	header := HeaderInfo{DataCount: 1 << 20, IsNewFormat: true, BitDepth: 2, FormatVersion: 1}
+	if err := writeHeader(img, header); err != nil {
+		t.Fatalf("writeHeader: %v", err)
+	}
+
+	report, err := InspectCarrier(pngReader(t, img.Image), "")
+	if err != nil {
+		t.Fatalf("InspectCarrier: %v", err)
+	}
+	if !hasProblem(report, "data count 1048576 is larger than") {
+		t.Errorf("expected a data count problem, got %v", report.Problems)
+	}
+}
+
+func mustCarrier(t *testing.T, encoded []byte) *carrierImage {
+	t.Helper()
+	img, _, err := getCarrierImage(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatalf("getCarrierImage: %v", err)
+	}
+	return img
+}
+
+func pngReader(t *testing.T, img image.Image) *bytes.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func hasProblem(report HeaderReport, want string) bool {
+	for _, p := range report.Problems {
+		if strings.Contains(p, want) {
+			return true
+		}
+	}
+	return false
+}
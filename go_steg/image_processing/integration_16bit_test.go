@@ -0,0 +1,134 @@
+package image_processing
+
+import (
+	"bytes"
+	"go-steg/go_steg/pipeline"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// carrier16 builds a 16-bit carrier with random samples. With translucent set the payload pixels get random
+// alpha values, some of them fully transparent, and the PNG is written with an alpha channel.
+func carrier16(width, height int, seed int64, translucent bool) *image.NRGBA64 {
+	img := image.NewNRGBA64(image.Rect(0, 0, width, height))
+	rng := rand.New(rand.NewSource(seed))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			alpha := uint16(0xFFFF)
+			if translucent && y >= totalReservedPixels {
+				alpha = uint16(rng.Intn(0x10000))
+				if rng.Intn(4) == 0 {
+					alpha = 0
+				}
+			}
+			img.SetNRGBA64(x, y, color.NRGBA64{
+				R: uint16(rng.Intn(0x10000)),
+				G: uint16(rng.Intn(0x10000)),
+				B: uint16(rng.Intn(0x10000)),
+				A: alpha,
+			})
+		}
+	}
+	return img
+}
+
+func TestSixteenBitRoundtrip(t *testing.T) {
+	data := make([]byte, 500)
+	rand.New(rand.NewSource(1401)).Read(data)
+
+	for _, translucent := range []bool{false, true} {
+		carrier := pngBytes(t, carrier16(140, 140, 1402, translucent))
+		for _, opts := range []Options{
+			{Config: pipeline.Config{BitDepth: 2}},
+			{Config: pipeline.Config{BitDepth: 4, HuffmanEnabled: true, Password: "integrity"}},
+			{UseMask: true, Scatter: true, Config: pipeline.Config{BitDepth: 2, RSEnabled: true}},
+			{UseAlpha: true, Config: pipeline.Config{BitDepth: 1}},
+		} {
+			encoded := encodeToBytes(t, carrier, data, opts)
+
+			config, err := png.DecodeConfig(bytes.NewReader(encoded))
+			if err != nil {
+				t.Fatalf("png.DecodeConfig: %v", err)
+			}
+			if !sixteenBit(config.ColorModel) {
+				t.Errorf("a 16-bit carrier was written with an 8-bit color model (translucent %v, %+v)", translucent, opts)
+			}
+
+			var decoded bytes.Buffer
+			if err := MultiCarrierDecode([]io.Reader{bytes.NewReader(encoded)}, &decoded, "integrity", Options{}); err != nil {
+				t.Fatalf("MultiCarrierDecode (translucent %v, %+v): %v", translucent, opts, err)
+			}
+			if !bytes.Equal(decoded.Bytes(), data) {
+				t.Errorf("decoded data does not match the original (translucent %v, %+v)", translucent, opts)
+			}
+
+			decoded.Reset()
+			if err := MultiCarrierDecodeStream([]io.Reader{bytes.NewReader(encoded)}, &decoded, "integrity", Options{}); err != nil {
+				t.Fatalf("MultiCarrierDecodeStream (translucent %v, %+v): %v", translucent, opts, err)
+			}
+			if !bytes.Equal(decoded.Bytes(), data) {
+				t.Errorf("streamed data does not match the original (translucent %v, %+v)", translucent, opts)
+			}
+		}
+	}
+}
+
+// TestSixteenBitOnlyLowBitsChange checks that embedding only touches the low bits of each 16-bit sample, so
+// the high byte, which is all an 8-bit view of the image shows, is left exactly as it was.
+func TestSixteenBitOnlyLowBitsChange(t *testing.T) {
+	original := carrier16(80, 80, 1403, true)
+	data := make([]byte, 400)
+	rand.New(rand.NewSource(1404)).Read(data)
+	encoded := encodeToBytes(t, pngBytes(t, original), data, Options{UseAlpha: true, Config: pipeline.Config{BitDepth: 2}})
+
+	img, err := png.Decode(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatalf("png.Decode: %v", err)
+	}
+	result, ok := img.(*image.NRGBA64)
+	if !ok {
+		t.Fatalf("encoded carrier decoded as %T, want *image.NRGBA64", img)
+	}
+	for i := 0; i < len(original.Pix); i += 2 {
+		if original.Pix[i] != result.Pix[i] {
+			t.Fatalf("high byte of sample %d changed from %#x to %#x", i/2, original.Pix[i], result.Pix[i])
+		}
+		if original.Pix[i+1]&0xFC != result.Pix[i+1]&0xFC {
+			t.Fatalf("sample %d changed above the low 2 bits: %#x to %#x", i/2, original.Pix[i+1], result.Pix[i+1])
+		}
+	}
+}
+
+func TestSixteenBitCapacity(t *testing.T) {
+	carrier := pngBytes(t, carrier16(60, 50, 1405, false))
+	capacities, err := Capacity([]io.Reader{bytes.NewReader(carrier)}, "", Options{Config: pipeline.Config{BitDepth: 2}})
+	if err != nil {
+		t.Fatalf("Capacity: %v", err)
+	}
+	// One slot per 16-bit sample, the same count as an 8-bit carrier of the same size
+	if got := capacities[0]; got.Slots != 60*16*3 || got.Bytes != 720 {
+		t.Errorf("got %d slots and %d bytes, want %d and 720", got.Slots, got.Bytes, 60*16*3)
+	}
+}
+
+func TestCarrierImageSixteenBitSamples(t *testing.T) {
+	deep := image.NewNRGBA64(image.Rect(0, 0, 1, 1))
+	deep.SetNRGBA64(0, 0, color.NRGBA64{R: 0xAB00, G: 0x12FF, B: 0, A: 0xFFFF})
+	img := newCarrierImage(deep)
+
+	img.setLastBits(0, 0, 0, 2, 3)
+	img.setLastBits(0, 0, 1, 4, 0)
+	if got := deep.NRGBA64At(0, 0); got.R != 0xAB03 || got.G != 0x12F0 {
+		t.Errorf("samples after setLastBits = %#x, %#x, want 0xab03, 0x12f0", got.R, got.G)
+	}
+	if got := img.lastBits(0, 0, 1, 8); got != 0xF0 {
+		t.Errorf("lastBits = %#x, want 0xf0", got)
+	}
+	if img.maxSample() != 0xFFFF || img.sample(0, 0, alphaChannel) != 0xFFFF {
+		t.Error("16-bit alpha should read as fully opaque")
+	}
+}
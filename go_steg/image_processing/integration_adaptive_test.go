@@ -0,0 +1,160 @@
+package image_processing
+
+import (
+	"bytes"
+	"go-steg/go_steg/pipeline"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// texturedCarrier builds a carrier whose left half is a smooth gradient and whose right half is noise
+func texturedCarrier(width, height int, seed int64) *image.NRGBA {
+	rng := rand.New(rand.NewSource(seed))
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBA{R: uint8(80 + y/8), G: uint8(140 + x/8), B: 220, A: 255}
+			if x >= width/2 {
+				c = color.NRGBA{R: uint8(rng.Intn(256)), G: uint8(rng.Intn(256)), B: uint8(rng.Intn(256)), A: 255}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestAdaptiveRoundtrip(t *testing.T) {
+	data := make([]byte, 500)
+	rand.New(rand.NewSource(2111)).Read(data)
+
+	carriers := []struct {
+		name    string
+		carrier []byte
+	}{
+		{"textured", pngBytes(t, texturedCarrier(160, 160, 2112))},
+		{"translucent", pngBytes(t, transparentCarrier(140, 140, 2113))},
+		{"16-bit", pngBytes(t, carrier16(120, 120, 2114, false))},
+		{"gray", pngBytes(t, grayCarrier(150, 150, 2115))},
+	}
+	for _, carrier := range carriers {
+		for _, opts := range []Options{
+			{Adaptive: true, Config: pipeline.Config{BitDepth: 2}},
+			{Adaptive: true, AdaptiveThresholds: [2]uint8{4, 64}, UseMask: true, Scatter: true, UseAlpha: true, Config: pipeline.Config{BitDepth: 2, RSEnabled: true}},
+			{Adaptive: true, Config: pipeline.Config{BitDepth: 1, HuffmanEnabled: true, Encrypted: true, Password: "integrity"}},
+		} {
+			encoded := encodeToBytes(t, carrier.carrier, data, opts)
+
+			header, err := ReadHeader(bytes.NewReader(encoded))
+			if err != nil {
+				t.Fatalf("ReadHeader (%s): %v", carrier.name, err)
+			}
+			if !header.Adaptive || header.AdaptiveThresholds != opts.adaptiveThresholds() {
+				t.Errorf("%s: header records adaptive embedding %v with thresholds %v", carrier.name, header.Adaptive, header.AdaptiveThresholds)
+			}
+
+			var decoded bytes.Buffer
+			if err := MultiCarrierDecode([]io.Reader{bytes.NewReader(encoded)}, &decoded, "integrity", Options{}); err != nil {
+				t.Fatalf("MultiCarrierDecode (%s, %+v): %v", carrier.name, opts, err)
+			}
+			if !bytes.Equal(decoded.Bytes(), data) {
+				t.Errorf("decoded data does not match the original (%s, %+v)", carrier.name, opts)
+			}
+
+			decoded.Reset()
+			if err := MultiCarrierDecodeStream([]io.Reader{bytes.NewReader(encoded)}, &decoded, "integrity", Options{}); err != nil {
+				t.Fatalf("MultiCarrierDecodeStream (%s, %+v): %v", carrier.name, opts, err)
+			}
+			if !bytes.Equal(decoded.Bytes(), data) {
+				t.Errorf("streamed data does not match the original (%s, %+v)", carrier.name, opts)
+			}
+		}
+	}
+}
+
+func TestAdaptiveStreamRoundtrip(t *testing.T) {
+	data := make([]byte, 3000)
+	rand.New(rand.NewSource(2116)).Read(data)
+	opts := Options{Adaptive: true, Scatter: true, Config: pipeline.Config{BitDepth: 2}}
+
+	encoded := streamCarrierSet(t, [][2]int{{100, 100}, {90, 120}}, data, opts)
+	var decoded bytes.Buffer
+	if err := MultiCarrierDecodeStream(readers(encoded), &decoded, "stream", Options{}); err != nil {
+		t.Fatalf("MultiCarrierDecodeStream: %v", err)
+	}
+	if !bytes.Equal(decoded.Bytes(), data) {
+		t.Error("streamed data does not match the original")
+	}
+}
+
+// TestAdaptiveLeavesSmoothRegions fills the capacity of a half smooth, half noisy carrier and checks that the
+// smooth half is left as it was
+func TestAdaptiveLeavesSmoothRegions(t *testing.T) {
+	original := texturedCarrier(120, 120, 2117)
+	carrier := pngBytes(t, original)
+	opts := Options{Adaptive: true, Config: pipeline.Config{BitDepth: 2}}
+
+	capacities, err := Capacity([]io.Reader{bytes.NewReader(carrier)}, "", opts)
+	if err != nil {
+		t.Fatalf("Capacity: %v", err)
+	}
+	plain, err := Capacity([]io.Reader{bytes.NewReader(carrier)}, "", Options{Config: pipeline.Config{BitDepth: 2}})
+	if err != nil {
+		t.Fatalf("Capacity: %v", err)
+	}
+	if capacities[0].Bytes <= 0 || capacities[0].Bytes >= plain[0].Bytes*3/5 {
+		t.Fatalf("adaptive capacity %d bytes, plain capacity %d bytes", capacities[0].Bytes, plain[0].Bytes)
+	}
+
+	full := make([]byte, capacities[0].Bytes)
+	rand.New(rand.NewSource(2118)).Read(full)
+	var encoded bytes.Buffer
+	if err := MultiCarrierEncode([]io.Reader{bytes.NewReader(carrier)}, bytes.NewReader(full), []io.Writer{&encoded}, 1, "", opts); err != nil {
+		t.Fatalf("a payload of exactly the capacity should fit: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(encoded.Bytes()))
+	if err != nil {
+		t.Fatalf("png.Decode: %v", err)
+	}
+	changed := 0
+	for y := totalReservedPixels; y < 120; y++ {
+		for x := 0; x < 120; x++ {
+			if color.NRGBAModel.Convert(img.At(x, y)) == color.Color(original.NRGBAAt(x, y)) {
+				continue
+			}
+			if x < 59 {
+				t.Fatalf("smooth pixel (%d, %d) changed", x, y)
+			}
+			changed++
+		}
+	}
+	if changed == 0 {
+		t.Error("no pixel of the noisy half changed")
+	}
+
+	var decoded bytes.Buffer
+	if err := MultiCarrierDecode([]io.Reader{bytes.NewReader(encoded.Bytes())}, &decoded, "", Options{}); err != nil {
+		t.Fatalf("MultiCarrierDecode: %v", err)
+	}
+	if !bytes.Equal(decoded.Bytes(), full) {
+		t.Error("decoded data does not match the original")
+	}
+}
+
+func TestAdaptiveRejectedCombinations(t *testing.T) {
+	carrier := carrierPNGBytes(t, 60, 60, 2119)
+	for _, opts := range []Options{
+		{Adaptive: true, LSBMatching: true, Config: pipeline.Config{BitDepth: 2}},
+		{Adaptive: true, MatrixEmbedding: true, Config: pipeline.Config{BitDepth: 1}},
+		{Adaptive: true, JPEGNative: true, Config: pipeline.Config{BitDepth: 1}},
+		{Adaptive: true, AdaptiveThresholds: [2]uint8{40, 20}, Config: pipeline.Config{BitDepth: 2}},
+	} {
+		var encoded bytes.Buffer
+		if err := MultiCarrierEncode([]io.Reader{bytes.NewReader(carrier)}, bytes.NewReader([]byte("data")), []io.Writer{&encoded}, 1, "", opts); err == nil {
+			t.Errorf("options %+v should be rejected", opts)
+		}
+	}
+}
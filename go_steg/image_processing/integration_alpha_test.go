@@ -0,0 +1,100 @@
+package image_processing
+
+import (
+	"bytes"
+	"go-steg/go_steg/pipeline"
+	"image/png"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestAlphaCapacity(t *testing.T) {
+	carrier := carrierPNGBytes(t, 60, 50, 1201)
+	opts := Options{UseAlpha: true, Config: pipeline.Config{BitDepth: 2}}
+	capacities, err := Capacity([]io.Reader{bytes.NewReader(carrier)}, "", opts)
+	if err != nil {
+		t.Fatalf("Capacity: %v", err)
+	}
+	// Every pixel of an opaque carrier gives a fourth channel: a third more than RGB alone
+	if got := capacities[0]; got.Slots != 60*16*4 || got.Bytes != 960 {
+		t.Errorf("got %d slots and %d bytes, want %d and 960", got.Slots, got.Bytes, 60*16*4)
+	}
+}
+
+func TestAlphaRoundtrip(t *testing.T) {
+	data := make([]byte, 1500)
+	rand.New(rand.NewSource(1202)).Read(data)
+
+	for _, opts := range []Options{
+		{UseAlpha: true, Config: pipeline.Config{BitDepth: 2}},
+		{UseAlpha: true, UseMask: true, Scatter: true, Config: pipeline.Config{BitDepth: 1, RSEnabled: true}},
+	} {
+		encoded := encodeToBytes(t, carrierPNGBytes(t, 120, 120, 1203), data, opts)
+
+		header, err := ReadHeader(bytes.NewReader(encoded))
+		if err != nil {
+			t.Fatalf("ReadHeader: %v", err)
+		}
+		if !header.AlphaChannel {
+			t.Error("header does not record alpha channel embedding")
+		}
+
+		// Alpha only ever moves within the low bits, so every pixel stays nearly opaque
+		img, err := png.Decode(bytes.NewReader(encoded))
+		if err != nil {
+			t.Fatalf("png.Decode: %v", err)
+		}
+		bounds := img.Bounds()
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				if _, _, _, a := img.At(x, y).RGBA(); a>>8 < 256-1<<opts.bitDepth() {
+					t.Fatalf("pixel (%d, %d) alpha dropped to %d", x, y, a>>8)
+				}
+			}
+		}
+
+		var decoded bytes.Buffer
+		if err := MultiCarrierDecode([]io.Reader{bytes.NewReader(encoded)}, &decoded, "integrity", Options{}); err != nil {
+			t.Fatalf("MultiCarrierDecode: %v", err)
+		}
+		if !bytes.Equal(decoded.Bytes(), data) {
+			t.Error("decoded data does not match the original")
+		}
+	}
+}
+
+func TestAlphaNeededForLargePayload(t *testing.T) {
+	carrier := carrierPNGBytes(t, 60, 50, 1204)
+	data := make([]byte, 900) // more than the 720 RGB bytes, less than the 960 with alpha
+
+	var out bytes.Buffer
+	rgbOnly := Options{Config: pipeline.Config{BitDepth: 2}}
+	if err := MultiCarrierEncode([]io.Reader{bytes.NewReader(carrier)}, bytes.NewReader(data), []io.Writer{&out}, 1, "alpha", rgbOnly); err == nil {
+		t.Fatal("expected the payload not to fit in the RGB channels")
+	}
+
+	withAlpha := Options{UseAlpha: true, Config: pipeline.Config{BitDepth: 2}}
+	encoded := encodeToBytes(t, carrier, data, withAlpha)
+	var decoded bytes.Buffer
+	if err := MultiCarrierDecodeStream([]io.Reader{bytes.NewReader(encoded)}, &decoded, "integrity", Options{}); err != nil {
+		t.Fatalf("MultiCarrierDecodeStream: %v", err)
+	}
+	if !bytes.Equal(decoded.Bytes(), data) {
+		t.Error("decoded data does not match the original")
+	}
+}
+
+func TestAlphaRejectedAboveBitDepth2(t *testing.T) {
+	carrier := carrierPNGBytes(t, 60, 60, 1205)
+	for _, bitDepth := range []int{3, 4} {
+		opts := Options{UseAlpha: true, Config: pipeline.Config{BitDepth: bitDepth}}
+		var encoded bytes.Buffer
+		if err := MultiCarrierEncode([]io.Reader{bytes.NewReader(carrier)}, bytes.NewReader([]byte("data")), []io.Writer{&encoded}, 1, "alpha", opts); err == nil {
+			t.Errorf("alpha channel embedding at bit depth %d should be rejected", bitDepth)
+		}
+		if _, err := Capacity([]io.Reader{bytes.NewReader(carrier)}, "alpha", opts); err == nil {
+			t.Errorf("capacity with the alpha channel at bit depth %d should be rejected", bitDepth)
+		}
+	}
+}
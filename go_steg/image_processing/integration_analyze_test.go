@@ -0,0 +1,79 @@
+package image_processing
+
+import (
+	"bytes"
+	"go-steg/go_steg/pipeline"
+	"go-steg/go_steg/steganalysis"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// smoothCarrier returns a carrier of smooth gradients with a little noise, which steganalysis can tell
+// apart from one with random low bits the way it can a photograph; the noise carriers of the other tests
+// already look fully embedded.
+func smoothCarrier(t *testing.T, width, height int, seed int64) []byte {
+	t.Helper()
+	rng := rand.New(rand.NewSource(seed))
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var c [3]uint8
+			for i := range c {
+				v := 128 + 60*math.Sin(float64(x+40*i)/17) + 40*math.Cos(float64(y)/23+float64(x)/31) + rng.NormFloat64()*3
+				c[i] = uint8(min(max(v, 0), 255))
+			}
+			img.SetNRGBA(x, y, color.NRGBA{R: c[0], G: c[1], B: c[2], A: 255})
+		}
+	}
+	return pngBytes(t, img)
+}
+
+// meanEmbeddingRate returns the embedding rate estimated for a carrier, averaged over its channels
+func meanEmbeddingRate(t *testing.T, carrier []byte) float64 {
+	t.Helper()
+	report, err := steganalysis.Analyze(bytes.NewReader(carrier))
+	if err != nil {
+		t.Fatalf("steganalysis.Analyze: %v", err)
+	}
+	var total float64
+	for _, channel := range report.Channels {
+		total += channel.EmbeddingRate
+	}
+	return total / float64(len(report.Channels))
+}
+
+// TestAnalyzeEncodedCarrier fills a carrier to capacity at bit depth 1 and checks that RS and sample pair
+// analysis see LSB replacement but not LSB matching
+func TestAnalyzeEncodedCarrier(t *testing.T) {
+	carrier := smoothCarrier(t, 200, 200, 1910)
+	if rate := meanEmbeddingRate(t, carrier); rate > 0.15 {
+		t.Errorf("clean carrier estimated at %.2f", rate)
+	}
+
+	tests := []struct {
+		opts     Options
+		min, max float64
+	}{
+		{Options{Config: pipeline.Config{BitDepth: 1}}, 0.6, 1},
+		{Options{Scatter: true, Config: pipeline.Config{BitDepth: 2}}, 0.6, 1},
+		{Options{LSBMatching: true, Config: pipeline.Config{BitDepth: 1}}, 0, 0.15},
+		{Options{LSBMatching: true, Scatter: true, Config: pipeline.Config{BitDepth: 1}}, 0, 0.15},
+	}
+	for _, tt := range tests {
+		capacities, err := Capacity([]io.Reader{bytes.NewReader(carrier)}, "integrity", tt.opts)
+		if err != nil {
+			t.Fatalf("Capacity: %v", err)
+		}
+		data := make([]byte, capacities[0].Bytes)
+		rand.New(rand.NewSource(1911)).Read(data)
+		// With no pipeline stages enabled the data passes through unchanged and fills the carrier exactly
+		encoded := encodeToBytes(t, carrier, data, tt.opts)
+		if rate := meanEmbeddingRate(t, encoded); rate < tt.min || rate > tt.max {
+			t.Errorf("%+v: estimated at %.2f, want %.2f-%.2f", tt.opts, rate, tt.min, tt.max)
+		}
+	}
+}
@@ -2,7 +2,6 @@ package image_processing
 
 import (
 	"bytes"
-	"go-steg/cli/helpers"
 	"go-steg/go_steg/pipeline"
 	"go-steg/go_steg/reed_solomon"
 	"os"
@@ -14,9 +13,6 @@ import (
 // TestCapacityNearFull encodes data filling ~95% of the carrier's raw capacity
 // (no pipeline processing) and verifies a successful roundtrip.
 func TestCapacityNearFull(t *testing.T) {
-	helpers.UseMask = false
-	defer func() { helpers.UseMask = false }()
-
 	tmpDir := t.TempDir()
 
 	carrierPath := filepath.Join(tmpDir, "carrier.png")
@@ -53,7 +49,7 @@ func TestCapacityNearFull(t *testing.T) {
 		1,
 		"",
 		encodeDir,
-		cfg,
+		Options{Config: cfg},
 	)
 	if err != nil {
 		t.Fatalf("encode near-full capacity failed: %v", err)
@@ -69,7 +65,7 @@ func TestCapacityNearFull(t *testing.T) {
 	decodeDir := filepath.Join(tmpDir, "decoded")
 	os.MkdirAll(decodeDir, 0755)
 
-	err = MultiCarrierDecodeByFileNames(matches, "", decodeDir)
+	err = MultiCarrierDecodeByFileNames(matches, "", decodeDir, Options{})
 	if err != nil {
 		t.Fatalf("decode near-full capacity failed: %v", err)
 	}
@@ -88,9 +84,6 @@ func TestCapacityNearFull(t *testing.T) {
 // TestCapacityExceeded verifies that encoding data larger than the carrier can
 // hold returns an appropriate error.
 func TestCapacityExceeded(t *testing.T) {
-	helpers.UseMask = false
-	defer func() { helpers.UseMask = false }()
-
 	tmpDir := t.TempDir()
 
 	carrierPath := filepath.Join(tmpDir, "carrier.png")
@@ -121,7 +114,7 @@ func TestCapacityExceeded(t *testing.T) {
 		1,
 		"",
 		encodeDir,
-		cfg,
+		Options{Config: cfg},
 	)
 	if err == nil {
 		t.Fatal("expected error when data exceeds carrier capacity, got nil")
@@ -135,9 +128,6 @@ func TestCapacityExceeded(t *testing.T) {
 // raw but after RS High encoding (~33.5% overhead per block, plus 8-byte prefix)
 // exceeds the carrier capacity.
 func TestCapacityPipelineExpansionOverflow(t *testing.T) {
-	helpers.UseMask = false
-	defer func() { helpers.UseMask = false }()
-
 	tmpDir := t.TempDir()
 
 	// Use a small carrier to make the boundary easier to hit.
@@ -183,7 +173,7 @@ func TestCapacityPipelineExpansionOverflow(t *testing.T) {
 		1,
 		"",
 		encodeDir,
-		cfg,
+		Options{Config: cfg},
 	)
 	if err == nil {
 		t.Fatal("expected error when pipeline-expanded data exceeds capacity, got nil")
@@ -195,9 +185,6 @@ func TestCapacityPipelineExpansionOverflow(t *testing.T) {
 
 // TestCapacityEmptyDataFile verifies behavior when encoding a zero-byte file.
 func TestCapacityEmptyDataFile(t *testing.T) {
-	helpers.UseMask = false
-	defer func() { helpers.UseMask = false }()
-
 	tmpDir := t.TempDir()
 
 	carrierPath := filepath.Join(tmpDir, "carrier.png")
@@ -222,7 +209,7 @@ func TestCapacityEmptyDataFile(t *testing.T) {
 		1,
 		"",
 		encodeDir,
-		cfg,
+		Options{Config: cfg},
 	)
 	// Empty data may succeed or return a meaningful error — either is acceptable.
 	if err != nil {
@@ -243,7 +230,7 @@ func TestCapacityEmptyDataFile(t *testing.T) {
 		decodeDir := filepath.Join(tmpDir, "decoded")
 		os.MkdirAll(decodeDir, 0755)
 
-		err = MultiCarrierDecodeByFileNames(matches, "", decodeDir)
+		err = MultiCarrierDecodeByFileNames(matches, "", decodeDir, Options{})
 		if err != nil {
 			t.Logf("decoding empty data returned error (acceptable): %v", err)
 		}
@@ -0,0 +1,91 @@
+package image_processing
+
+import (
+	"bytes"
+	"fmt"
+	"go-steg/go_steg/pipeline"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// carrierPNGBytes returns an in-memory PNG of the given dimensions filled with random pixel data.
+func carrierPNGBytes(t *testing.T, width, height int, seed int64) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	rng := rand.New(rand.NewSource(seed))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetRGBA(x, y, color.RGBA{
+				R: uint8(rng.Intn(256)),
+				G: uint8(rng.Intn(256)),
+				B: uint8(rng.Intn(256)),
+				A: 255,
+			})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode carrier PNG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// TestConcurrentMaskedAndUnmaskedRoundtrip encodes and decodes masked and unmasked carriers at the same
+// time. Before options were passed per call this raced on a package-level flag.
+func TestConcurrentMaskedAndUnmaskedRoundtrip(t *testing.T) {
+	const workers = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+
+	carriers := make([][]byte, workers)
+	for i := range carriers {
+		carriers[i] = carrierPNGBytes(t, 200, 200, int64(9000+i))
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			opts := Options{
+				UseMask: i%2 == 0,
+				Config:  pipeline.Config{BitDepth: 1 + i%4, FileExtension: "bin"},
+			}
+			password := fmt.Sprintf("worker-%d", i)
+			original := bytes.Repeat([]byte{byte(i), 0xA5, 0x3C}, 40)
+
+			var encoded bytes.Buffer
+			err := MultiCarrierEncode(
+				[]io.Reader{bytes.NewReader(carriers[i])},
+				bytes.NewReader(original),
+				[]io.Writer{&encoded},
+				1,
+				password,
+				opts,
+			)
+			if err != nil {
+				errs <- fmt.Errorf("worker %d encode: %w", i, err)
+				return
+			}
+
+			var decoded bytes.Buffer
+			if err := MultiCarrierDecode([]io.Reader{&encoded}, &decoded, password, Options{UseMask: opts.UseMask}); err != nil {
+				errs <- fmt.Errorf("worker %d decode: %w", i, err)
+				return
+			}
+			if !bytes.Equal(decoded.Bytes(), original) {
+				errs <- fmt.Errorf("worker %d (mask=%v): decoded data does not match original", i, opts.UseMask)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
@@ -2,7 +2,6 @@ package image_processing
 
 import (
 	"bytes"
-	"go-steg/cli/helpers"
 	"go-steg/go_steg/pipeline"
 	"go-steg/go_steg/reed_solomon"
 	"image"
@@ -40,8 +39,6 @@ func createCarrierPNGWithSize(t *testing.T, path string, width, height int, seed
 }
 
 func TestIntegrationCarrierTooSmall(t *testing.T) {
-	helpers.UseMask = false
-
 	tmpDir := t.TempDir()
 	// Image with height less than minCarrierHeight (34)
 	carrierPath := filepath.Join(tmpDir, "tiny.png")
@@ -60,7 +57,7 @@ func TestIntegrationCarrierTooSmall(t *testing.T) {
 		1,
 		"",
 		encodeOutDir,
-		cfg,
+		Options{Config: cfg},
 	)
 	if err == nil {
 		t.Error("expected error for carrier too small")
@@ -68,8 +65,6 @@ func TestIntegrationCarrierTooSmall(t *testing.T) {
 }
 
 func TestIntegrationMinimumCarrierHeight(t *testing.T) {
-	helpers.UseMask = false
-
 	tmpDir := t.TempDir()
 	// Exactly minCarrierHeight (34) pixels tall, wide enough to hold data
 	carrierPath := filepath.Join(tmpDir, "minimum.png")
@@ -88,7 +83,7 @@ func TestIntegrationMinimumCarrierHeight(t *testing.T) {
 		1,
 		"",
 		encodeOutDir,
-		cfg,
+		Options{Config: cfg},
 	)
 	// The carrier is 200px wide * 0px of data rows (34 - 34 = 0 data rows in x=0)
 	// Actually totalReservedPixels=34 and dy=34, so y starts at 34 which is >= 34,
@@ -107,8 +102,6 @@ func TestIntegrationMinimumCarrierHeight(t *testing.T) {
 }
 
 func TestIntegrationCarrierJustEnoughHeight(t *testing.T) {
-	helpers.UseMask = false
-
 	tmpDir := t.TempDir()
 	// 35 pixels tall: 1 row of data pixels
 	carrierPath := filepath.Join(tmpDir, "just_enough.png")
@@ -127,7 +120,7 @@ func TestIntegrationCarrierJustEnoughHeight(t *testing.T) {
 		1,
 		"",
 		encodeOutDir,
-		cfg,
+		Options{Config: cfg},
 	)
 	if err != nil {
 		t.Fatalf("EncodeByFileNames failed: %v", err)
@@ -142,6 +135,7 @@ func TestIntegrationCarrierJustEnoughHeight(t *testing.T) {
 		[]string{embeddedPath},
 		"",
 		decodeOutDir,
+		Options{},
 	)
 	if err != nil {
 		t.Fatalf("MultiCarrierDecodeByFileNames failed: %v", err)
@@ -158,8 +152,6 @@ func TestIntegrationCarrierJustEnoughHeight(t *testing.T) {
 }
 
 func TestIntegrationSingleByteData(t *testing.T) {
-	helpers.UseMask = false
-
 	tmpDir := t.TempDir()
 	carrierPath := filepath.Join(tmpDir, "carrier.png")
 	createCarrierPNG(t, carrierPath, 12345)
@@ -172,7 +164,7 @@ func TestIntegrationSingleByteData(t *testing.T) {
 
 	cfg := pipeline.Config{BitDepth: 2, FileExtension: "bin"}
 	err := EncodeByFileNames(
-		[]string{carrierPath}, dataPath, 1, "", encodeOutDir, cfg,
+		[]string{carrierPath}, dataPath, 1, "", encodeOutDir, Options{Config: cfg},
 	)
 	if err != nil {
 		t.Fatalf("EncodeByFileNames failed: %v", err)
@@ -182,7 +174,7 @@ func TestIntegrationSingleByteData(t *testing.T) {
 	decodeOutDir := filepath.Join(tmpDir, "decoded")
 	os.MkdirAll(decodeOutDir, 0755)
 
-	err = MultiCarrierDecodeByFileNames([]string{embeddedPath}, "", decodeOutDir)
+	err = MultiCarrierDecodeByFileNames([]string{embeddedPath}, "", decodeOutDir, Options{})
 	if err != nil {
 		t.Fatalf("decode failed: %v", err)
 	}
@@ -198,8 +190,6 @@ func TestIntegrationSingleByteData(t *testing.T) {
 }
 
 func TestIntegrationRoundtripWithRSHighLevel(t *testing.T) {
-	helpers.UseMask = false
-
 	tmpDir := t.TempDir()
 	carrierPath := filepath.Join(tmpDir, "carrier.png")
 	createCarrierPNG(t, carrierPath, 12345)
@@ -218,7 +208,7 @@ func TestIntegrationRoundtripWithRSHighLevel(t *testing.T) {
 		FileExtension:  "txt",
 	}
 	err := EncodeByFileNames(
-		[]string{carrierPath}, dataPath, 1, "", encodeOutDir, cfg,
+		[]string{carrierPath}, dataPath, 1, "", encodeOutDir, Options{Config: cfg},
 	)
 	if err != nil {
 		t.Fatalf("EncodeByFileNames failed: %v", err)
@@ -228,7 +218,7 @@ func TestIntegrationRoundtripWithRSHighLevel(t *testing.T) {
 	decodeOutDir := filepath.Join(tmpDir, "decoded")
 	os.MkdirAll(decodeOutDir, 0755)
 
-	err = MultiCarrierDecodeByFileNames([]string{embeddedPath}, "", decodeOutDir)
+	err = MultiCarrierDecodeByFileNames([]string{embeddedPath}, "", decodeOutDir, Options{})
 	if err != nil {
 		t.Fatalf("decode failed: %v", err)
 	}
@@ -245,7 +235,6 @@ func TestIntegrationRoundtripWithRSHighLevel(t *testing.T) {
 
 func TestIntegrationRoundtripBitDepth3(t *testing.T) {
 	// Bit depth 3 is the non-evenly-divisible case (3+3+2 bits)
-	helpers.UseMask = false
 
 	tmpDir := t.TempDir()
 	carrierPath := filepath.Join(tmpDir, "carrier.png")
@@ -263,7 +252,7 @@ func TestIntegrationRoundtripBitDepth3(t *testing.T) {
 		FileExtension: "txt",
 	}
 	err := EncodeByFileNames(
-		[]string{carrierPath}, dataPath, 1, "", encodeOutDir, cfg,
+		[]string{carrierPath}, dataPath, 1, "", encodeOutDir, Options{Config: cfg},
 	)
 	if err != nil {
 		t.Fatalf("EncodeByFileNames failed: %v", err)
@@ -273,7 +262,7 @@ func TestIntegrationRoundtripBitDepth3(t *testing.T) {
 	decodeOutDir := filepath.Join(tmpDir, "decoded")
 	os.MkdirAll(decodeOutDir, 0755)
 
-	err = MultiCarrierDecodeByFileNames([]string{embeddedPath}, "", decodeOutDir)
+	err = MultiCarrierDecodeByFileNames([]string{embeddedPath}, "", decodeOutDir, Options{})
 	if err != nil {
 		t.Fatalf("decode failed: %v", err)
 	}
@@ -290,14 +279,13 @@ func TestIntegrationRoundtripBitDepth3(t *testing.T) {
 
 func TestIntegrationEmptyCarrierList(t *testing.T) {
 	cfg := pipeline.Config{BitDepth: 2}
-	err := EncodeByFileNames([]string{}, "nonexistent.txt", 1, "", "/tmp", cfg)
+	err := EncodeByFileNames([]string{}, "nonexistent.txt", 1, "", "/tmp", Options{Config: cfg})
 	if err == nil {
 		t.Error("expected error for empty carrier list")
 	}
 }
 
 func TestIntegrationNonexistentDataFile(t *testing.T) {
-	helpers.UseMask = false
 	tmpDir := t.TempDir()
 	carrierPath := filepath.Join(tmpDir, "carrier.png")
 	createCarrierPNG(t, carrierPath, 12345)
@@ -309,7 +297,7 @@ func TestIntegrationNonexistentDataFile(t *testing.T) {
 	err := EncodeByFileNames(
 		[]string{carrierPath},
 		filepath.Join(tmpDir, "nonexistent.txt"),
-		1, "", encodeOutDir, cfg,
+		1, "", encodeOutDir, Options{Config: cfg},
 	)
 	if err == nil {
 		t.Error("expected error for nonexistent data file")
@@ -321,6 +309,7 @@ func TestIntegrationNonexistentCarrierForDecode(t *testing.T) {
 		[]string{"/nonexistent/path/carrier.png"},
 		"",
 		"/tmp",
+		Options{},
 	)
 	if err == nil {
 		t.Error("expected error for nonexistent carrier file")
@@ -328,8 +317,6 @@ func TestIntegrationNonexistentCarrierForDecode(t *testing.T) {
 }
 
 func TestIntegrationAllByteValuesRoundtrip(t *testing.T) {
-	helpers.UseMask = false
-
 	tmpDir := t.TempDir()
 	carrierPath := filepath.Join(tmpDir, "carrier.png")
 	createCarrierPNG(t, carrierPath, 12345)
@@ -347,7 +334,7 @@ func TestIntegrationAllByteValuesRoundtrip(t *testing.T) {
 
 	cfg := pipeline.Config{BitDepth: 2, FileExtension: "bin"}
 	err := EncodeByFileNames(
-		[]string{carrierPath}, dataPath, 1, "", encodeOutDir, cfg,
+		[]string{carrierPath}, dataPath, 1, "", encodeOutDir, Options{Config: cfg},
 	)
 	if err != nil {
 		t.Fatalf("EncodeByFileNames failed: %v", err)
@@ -357,7 +344,7 @@ func TestIntegrationAllByteValuesRoundtrip(t *testing.T) {
 	decodeOutDir := filepath.Join(tmpDir, "decoded")
 	os.MkdirAll(decodeOutDir, 0755)
 
-	err = MultiCarrierDecodeByFileNames([]string{embeddedPath}, "", decodeOutDir)
+	err = MultiCarrierDecodeByFileNames([]string{embeddedPath}, "", decodeOutDir, Options{})
 	if err != nil {
 		t.Fatalf("decode failed: %v", err)
 	}
@@ -0,0 +1,148 @@
+package image_processing
+
+import (
+	"bytes"
+	"errors"
+	"go-steg/go_steg/pipeline"
+	"image"
+	"image/png"
+	"io"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+func bmpBytes(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := bmp.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode carrier BMP: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func tiffBytes(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := tiff.Encode(&buf, img, nil); err != nil {
+		t.Fatalf("failed to encode carrier TIFF: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func opaqueCarrier(t *testing.T, width, height int, seed int64) image.Image {
+	t.Helper()
+	img, err := png.Decode(bytes.NewReader(carrierPNGBytes(t, width, height, seed)))
+	if err != nil {
+		t.Fatalf("png.Decode: %v", err)
+	}
+	return img
+}
+
+func TestBMPAndTIFFRoundtrip(t *testing.T) {
+	data := make([]byte, 400)
+	rand.New(rand.NewSource(1601)).Read(data)
+
+	carriers := []struct {
+		name    string
+		format  string
+		carrier []byte
+	}{
+		{"bmp rgb", "bmp", bmpBytes(t, opaqueCarrier(t, 140, 140, 1602))},
+		{"bmp paletted", "bmp", bmpBytes(t, palettedCarrier(150, 150, 200, 0, 1603))},
+		{"tiff rgb", "tiff", tiffBytes(t, opaqueCarrier(t, 140, 140, 1604))},
+		{"tiff translucent", "tiff", tiffBytes(t, transparentCarrier(140, 140, 1605))},
+		{"tiff 16-bit", "tiff", tiffBytes(t, carrier16(140, 140, 1606, true))},
+		{"tiff gray", "tiff", tiffBytes(t, grayCarrier(150, 150, 1607))},
+	}
+	for _, carrier := range carriers {
+		original, _, err := image.Decode(bytes.NewReader(carrier.carrier))
+		if err != nil {
+			t.Fatalf("image.Decode (%s): %v", carrier.name, err)
+		}
+		for _, opts := range []Options{
+			{Config: pipeline.Config{BitDepth: 2}},
+			{UseMask: true, Scatter: true, Config: pipeline.Config{BitDepth: 3, RSEnabled: true}},
+		} {
+			encoded := encodeToBytes(t, carrier.carrier, data, opts)
+
+			img, format, err := image.Decode(bytes.NewReader(encoded))
+			if err != nil {
+				t.Fatalf("image.Decode (%s): %v", carrier.name, err)
+			}
+			if format != carrier.format {
+				t.Errorf("%s carrier written back as %s", carrier.name, format)
+			}
+			if got, want := newCarrierImage(img).model, newCarrierImage(original).model; got != want {
+				t.Errorf("%s carrier written back as %v, want %v", carrier.name, got, want)
+			}
+
+			var decoded bytes.Buffer
+			if err := MultiCarrierDecode([]io.Reader{bytes.NewReader(encoded)}, &decoded, "integrity", Options{}); err != nil {
+				t.Fatalf("MultiCarrierDecode (%s, %+v): %v", carrier.name, opts, err)
+			}
+			if !bytes.Equal(decoded.Bytes(), data) {
+				t.Errorf("decoded data does not match the original (%s, %+v)", carrier.name, opts)
+			}
+		}
+	}
+}
+
+func TestTIFFStreamRoundtrip(t *testing.T) {
+	data := make([]byte, 300)
+	rand.New(rand.NewSource(1608)).Read(data)
+	opts := Options{UseAlpha: true, Config: pipeline.Config{BitDepth: 2}}
+
+	var encoded bytes.Buffer
+	carrier := tiffBytes(t, transparentCarrier(120, 120, 1609))
+	if err := MultiCarrierEncodeStream([]io.Reader{bytes.NewReader(carrier)}, bytes.NewReader(data), []io.Writer{&encoded}, 1, "integrity", opts); err != nil {
+		t.Fatalf("MultiCarrierEncodeStream: %v", err)
+	}
+	if _, format, err := image.DecodeConfig(bytes.NewReader(encoded.Bytes())); err != nil || format != "tiff" {
+		t.Fatalf("streamed carrier written as %q (%v), want tiff", format, err)
+	}
+
+	var decoded bytes.Buffer
+	if err := MultiCarrierDecodeStream([]io.Reader{bytes.NewReader(encoded.Bytes())}, &decoded, "integrity", Options{}); err != nil {
+		t.Fatalf("MultiCarrierDecodeStream: %v", err)
+	}
+	if !bytes.Equal(decoded.Bytes(), data) {
+		t.Error("streamed data does not match the original")
+	}
+}
+
+// TestBMPRejectsAlpha checks that alpha-channel embedding is refused for BMP carriers, whose writer drops the
+// alpha channel, instead of producing a carrier that cannot be decoded.
+func TestBMPRejectsAlpha(t *testing.T) {
+	carrier := bmpBytes(t, opaqueCarrier(t, 100, 100, 1610))
+	var encoded bytes.Buffer
+	err := MultiCarrierEncode([]io.Reader{bytes.NewReader(carrier)}, bytes.NewReader([]byte("alpha")), []io.Writer{&encoded}, 1, "integrity", Options{UseAlpha: true, Config: pipeline.Config{BitDepth: 2}})
+	var encErr *EncodingError
+	if !errors.As(err, &encErr) || encErr.Type != ErrInvalidFormat.Type {
+		t.Errorf("expected an %s, got %v", ErrInvalidFormat.Type, err)
+	}
+}
+
+func TestEmbeddedExtension(t *testing.T) {
+	tests := []struct {
+		in         string
+		jpegNative bool
+		want       string
+	}{
+		{".png", false, ".png"},
+		{".bmp", false, ".bmp"},
+		{".tif", false, ".tif"},
+		{".tiff", false, ".tiff"},
+		{".jpg", false, ".png"},
+		{".JPEG", false, ".png"},
+		{".jpg", true, ".jpg"},
+		{".png", true, ".png"},
+	}
+	for _, tt := range tests {
+		if got := embeddedExtension(tt.in, Options{JPEGNative: tt.jpegNative}); got != tt.want {
+			t.Errorf("embeddedExtension(%q, JPEGNative %v) = %q, want %q", tt.in, tt.jpegNative, got, tt.want)
+		}
+	}
+}
@@ -2,7 +2,6 @@ package image_processing
 
 import (
 	"bytes"
-	"go-steg/cli/helpers"
 	"go-steg/go_steg/pipeline"
 	"go-steg/go_steg/reed_solomon"
 	"image"
@@ -24,9 +23,6 @@ import (
 //
 // This test documents the current behavior; failures indicate pre-existing bugs.
 func TestIntegrationGapsMultiCarrier(t *testing.T) {
-	helpers.UseMask = false
-	defer func() { helpers.UseMask = false }()
-
 	tmpDir := t.TempDir()
 
 	carrier1Path := filepath.Join(tmpDir, "carrier1.png")
@@ -58,7 +54,7 @@ func TestIntegrationGapsMultiCarrier(t *testing.T) {
 		1,
 		"",
 		encodeOutDir,
-		cfg,
+		Options{UseMask: true, Config: cfg},
 	)
 	if encErr != nil {
 		// Multi-carrier encoding has known bugs; record and stop.
@@ -84,6 +80,7 @@ func TestIntegrationGapsMultiCarrier(t *testing.T) {
 		[]string{embedded1, embedded2},
 		"",
 		decodeOutDir,
+		Options{UseMask: true},
 	)
 	if decErr != nil {
 		t.Fatalf("MultiCarrierDecodeByFileNames failed: %v", decErr)
@@ -103,11 +100,8 @@ func TestIntegrationGapsMultiCarrier(t *testing.T) {
 	}
 }
 
-// TestIntegrationGapsMaskEnabled tests roundtrip with helpers.UseMask = true.
+// TestIntegrationGapsMaskEnabled tests roundtrip with Options.UseMask = true.
 func TestIntegrationGapsMaskEnabled(t *testing.T) {
-	helpers.UseMask = true
-	defer func() { helpers.UseMask = false }()
-
 	tmpDir := t.TempDir()
 
 	carrierPath := filepath.Join(tmpDir, "carrier.png")
@@ -134,7 +128,7 @@ func TestIntegrationGapsMaskEnabled(t *testing.T) {
 		1,
 		"masktest",
 		encodeOutDir,
-		cfg,
+		Options{UseMask: true, Config: cfg},
 	)
 	if err != nil {
 		t.Fatalf("EncodeByFileNames (mask) failed: %v", err)
@@ -154,6 +148,7 @@ func TestIntegrationGapsMaskEnabled(t *testing.T) {
 		[]string{embeddedPath},
 		"masktest",
 		decodeOutDir,
+		Options{UseMask: true},
 	)
 	if err != nil {
 		t.Fatalf("MultiCarrierDecodeByFileNames (mask) failed: %v", err)
@@ -175,9 +170,6 @@ func TestIntegrationGapsMaskEnabled(t *testing.T) {
 // TestIntegrationGapsLargePayload tests encoding ~10,000 bytes of random data into
 // a 200x200 carrier (capacity ~29,899 bytes at bit depth 2).
 func TestIntegrationGapsLargePayload(t *testing.T) {
-	helpers.UseMask = false
-	defer func() { helpers.UseMask = false }()
-
 	tmpDir := t.TempDir()
 
 	carrierPath := filepath.Join(tmpDir, "carrier.png")
@@ -207,7 +199,7 @@ func TestIntegrationGapsLargePayload(t *testing.T) {
 		1,
 		"",
 		encodeOutDir,
-		cfg,
+		Options{Config: cfg},
 	)
 	if err != nil {
 		t.Fatalf("EncodeByFileNames (large payload) failed: %v", err)
@@ -223,6 +215,7 @@ func TestIntegrationGapsLargePayload(t *testing.T) {
 		[]string{embeddedPath},
 		"",
 		decodeOutDir,
+		Options{},
 	)
 	if err != nil {
 		t.Fatalf("decode (large payload) failed: %v", err)
@@ -245,9 +238,6 @@ func TestIntegrationGapsLargePayload(t *testing.T) {
 // TestIntegrationGapsRSCorruptionRecovery encodes data with Reed-Solomon, corrupts
 // pixel data in the payload region, and verifies RS can correct the errors.
 func TestIntegrationGapsRSCorruptionRecovery(t *testing.T) {
-	helpers.UseMask = false
-	defer func() { helpers.UseMask = false }()
-
 	tmpDir := t.TempDir()
 
 	carrierPath := filepath.Join(tmpDir, "carrier.png")
@@ -275,7 +265,7 @@ func TestIntegrationGapsRSCorruptionRecovery(t *testing.T) {
 		1,
 		"",
 		encodeOutDir,
-		cfg,
+		Options{Config: cfg},
 	)
 	if err != nil {
 		t.Fatalf("EncodeByFileNames (RS) failed: %v", err)
@@ -296,6 +286,7 @@ func TestIntegrationGapsRSCorruptionRecovery(t *testing.T) {
 		[]string{corruptedPath},
 		"",
 		decodeOutDir,
+		Options{},
 	)
 	if err != nil {
 		t.Fatalf("decode from corrupted carrier failed: %v", err)
@@ -317,9 +308,6 @@ func TestIntegrationGapsRSCorruptionRecovery(t *testing.T) {
 // TestIntegrationGapsWrongPassword encodes with one password and decodes with another.
 // The decoded output should either error or differ from the original.
 func TestIntegrationGapsWrongPassword(t *testing.T) {
-	helpers.UseMask = false
-	defer func() { helpers.UseMask = false }()
-
 	tmpDir := t.TempDir()
 
 	carrierPath := filepath.Join(tmpDir, "carrier.png")
@@ -347,7 +335,7 @@ func TestIntegrationGapsWrongPassword(t *testing.T) {
 		1,
 		"correct",
 		encodeOutDir,
-		cfg,
+		Options{Config: cfg},
 	)
 	if err != nil {
 		t.Fatalf("EncodeByFileNames failed: %v", err)
@@ -364,6 +352,7 @@ func TestIntegrationGapsWrongPassword(t *testing.T) {
 		[]string{embeddedPath},
 		"wrong",
 		decodeOutDir,
+		Options{},
 	)
 	if decErr != nil {
 		// An error is acceptable — wrong password should not decode cleanly.
@@ -389,9 +378,6 @@ func TestIntegrationGapsWrongPassword(t *testing.T) {
 // TestIntegrationGapsFileExtensions verifies that various file extensions are preserved
 // through the encode/decode roundtrip.
 func TestIntegrationGapsFileExtensions(t *testing.T) {
-	helpers.UseMask = false
-	defer func() { helpers.UseMask = false }()
-
 	extensions := []struct {
 		ext  string
 		data []byte
@@ -430,7 +416,7 @@ func TestIntegrationGapsFileExtensions(t *testing.T) {
 				1,
 				"",
 				encodeOutDir,
-				cfg,
+				Options{Config: cfg},
 			)
 			if err != nil {
 				t.Fatalf("EncodeByFileNames failed for ext %q: %v", tc.ext, err)
@@ -446,6 +432,7 @@ func TestIntegrationGapsFileExtensions(t *testing.T) {
 				[]string{embeddedPath},
 				"",
 				decodeOutDir,
+				Options{},
 			)
 			if err != nil {
 				t.Fatalf("decode failed for ext %q: %v", tc.ext, err)
@@ -473,9 +460,6 @@ func TestIntegrationGapsFileExtensions(t *testing.T) {
 // TestIntegrationGapsJPEGCarrier tests that a JPEG carrier can be used for encoding.
 // The output is always PNG regardless of the input carrier format.
 func TestIntegrationGapsJPEGCarrier(t *testing.T) {
-	helpers.UseMask = false
-	defer func() { helpers.UseMask = false }()
-
 	tmpDir := t.TempDir()
 
 	// Create a JPEG carrier image.
@@ -502,15 +486,14 @@ func TestIntegrationGapsJPEGCarrier(t *testing.T) {
 		1,
 		"",
 		encodeOutDir,
-		cfg,
+		Options{Config: cfg},
 	)
 	if err != nil {
 		t.Fatalf("EncodeByFileNames (JPEG carrier) failed: %v", err)
 	}
 
-	// The output keeps the original carrier extension in the filename,
-	// but the file content is actually PNG.
-	embeddedPath := filepath.Join(encodeOutDir, "carrier-0-embedded.jpg")
+	// JPEG carriers are written as PNG, and the output is named to match.
+	embeddedPath := filepath.Join(encodeOutDir, "carrier-0-embedded.png")
 	if _, err := os.Stat(embeddedPath); os.IsNotExist(err) {
 		t.Fatalf("embedded carrier not found: %s", embeddedPath)
 	}
@@ -537,6 +520,7 @@ func TestIntegrationGapsJPEGCarrier(t *testing.T) {
 		[]string{embeddedPath},
 		"",
 		decodeOutDir,
+		Options{},
 	)
 	if err != nil {
 		t.Fatalf("decode (JPEG carrier) failed: %v", err)
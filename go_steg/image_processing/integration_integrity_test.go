@@ -0,0 +1,91 @@
+package image_processing
+
+import (
+	"bytes"
+	"errors"
+	"go-steg/go_steg/pipeline"
+	"go-steg/go_steg/reed_solomon"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"testing"
+)
+
+// flipPayloadBits flips the low bits of one payload channel well past the Reed-Solomon length prefix. For
+// an unmasked, sequentially embedded carrier that channel always carries data.
+func flipPayloadBits(t *testing.T, encoded []byte) []byte {
+	t.Helper()
+	img, err := png.Decode(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatalf("decode encoded carrier: %v", err)
+	}
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	rgba.Pix[rgba.PixOffset(0, totalReservedPixels+20)] ^= 0x03
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, rgba); err != nil {
+		t.Fatalf("encode corrupted carrier: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func encodeToBytes(t *testing.T, carrier, data []byte, opts Options) []byte {
+	t.Helper()
+	var encoded bytes.Buffer
+	err := MultiCarrierEncode([]io.Reader{bytes.NewReader(carrier)}, bytes.NewReader(data), []io.Writer{&encoded}, 1, "integrity", opts)
+	if err != nil {
+		t.Fatalf("MultiCarrierEncode: %v", err)
+	}
+	return encoded.Bytes()
+}
+
+func TestIntegrityHeaderRecorded(t *testing.T) {
+	original := []byte("integrity header contents")
+	encoded := encodeToBytes(t, carrierPNGBytes(t, 100, 100, 501), original, Options{Config: pipeline.Config{BitDepth: 2, FileExtension: "txt"}})
+
+	img, _, err := getCarrierImage(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatalf("getCarrierImage: %v", err)
+	}
+	header := readHeader(img)
+	if header.FormatVersion != currentFormatVersion || !header.HasIntegrity {
+		t.Fatalf("expected a version %d header with integrity fields, got %+v", currentFormatVersion, header)
+	}
+	if header.PayloadLength != uint32(len(original)) {
+		t.Errorf("PayloadLength: got %d, want %d", header.PayloadLength, len(original))
+	}
+}
+
+func TestIntegrityDetectsCorruptedPayload(t *testing.T) {
+	original := bytes.Repeat([]byte("corrupt me "), 20)
+	encoded := encodeToBytes(t, carrierPNGBytes(t, 100, 100, 502), original, Options{Config: pipeline.Config{BitDepth: 2, FileExtension: "txt"}})
+
+	var decoded bytes.Buffer
+	err := MultiCarrierDecode([]io.Reader{bytes.NewReader(flipPayloadBits(t, encoded))}, &decoded, "integrity", Options{})
+	if err == nil {
+		t.Fatal("expected decoding a corrupted carrier to fail")
+	}
+	var encErr *EncodingError
+	if !errors.As(err, &encErr) || encErr.Type != ErrIntegrityCheck.Type {
+		t.Errorf("expected an %s, got %v", ErrIntegrityCheck.Type, err)
+	}
+	if decoded.Len() != 0 {
+		t.Errorf("no output should be written for corrupted data, got %d bytes", decoded.Len())
+	}
+}
+
+func TestIntegrityAllowsReedSolomonCorrection(t *testing.T) {
+	original := bytes.Repeat([]byte("correct me "), 20)
+	cfg := pipeline.Config{BitDepth: 2, RSEnabled: true, RSLevel: reed_solomon.High, FileExtension: "txt"}
+	encoded := encodeToBytes(t, carrierPNGBytes(t, 100, 100, 503), original, Options{Config: cfg})
+
+	var decoded bytes.Buffer
+	if err := MultiCarrierDecode([]io.Reader{bytes.NewReader(flipPayloadBits(t, encoded))}, &decoded, "integrity", Options{}); err != nil {
+		t.Fatalf("Reed-Solomon protected payload should survive a corrupted byte: %v", err)
+	}
+	if !bytes.Equal(decoded.Bytes(), original) {
+		t.Error("decoded data does not match original")
+	}
+}
@@ -0,0 +1,168 @@
+package image_processing
+
+import (
+	"bytes"
+	"errors"
+	"go-steg/go_steg/jpeg_dct"
+	"go-steg/go_steg/pipeline"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// jpegCarrier encodes a noisy color image as a baseline JPEG
+func jpegCarrier(t *testing.T, width, height int, seed int64) []byte {
+	t.Helper()
+	rng := rand.New(rand.NewSource(seed))
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetRGBA(x, y, color.RGBA{R: uint8(x + rng.Intn(60)), G: uint8(y + rng.Intn(60)), B: uint8(rng.Intn(256)), A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatalf("jpeg.Encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestJPEGNativeRoundtrip(t *testing.T) {
+	data := make([]byte, 300)
+	rand.New(rand.NewSource(1711)).Read(data)
+	carrier := jpegCarrier(t, 256, 256, 1712)
+
+	for _, opts := range []Options{
+		{JPEGNative: true, Config: pipeline.Config{BitDepth: 1}},
+		{JPEGNative: true, UseMask: true, Scatter: true, Config: pipeline.Config{BitDepth: 1, RSEnabled: true}},
+		{JPEGNative: true, Config: pipeline.Config{BitDepth: 2, HuffmanEnabled: true, Encrypted: true, Password: "integrity"}},
+	} {
+		encoded := encodeToBytes(t, carrier, data, opts)
+
+		if _, format, err := image.DecodeConfig(bytes.NewReader(encoded)); err != nil || format != "jpeg" {
+			t.Fatalf("carrier written as %q (%v), want jpeg (%+v)", format, err, opts)
+		}
+		header, err := ReadHeader(bytes.NewReader(encoded))
+		if err != nil {
+			t.Fatalf("ReadHeader: %v", err)
+		}
+		if header.CarrierModel != CarrierModelJPEG {
+			t.Errorf("header records model %v, want jpeg", header.CarrierModel)
+		}
+
+		var decoded bytes.Buffer
+		if err := MultiCarrierDecode([]io.Reader{bytes.NewReader(encoded)}, &decoded, "integrity", Options{}); err != nil {
+			t.Fatalf("MultiCarrierDecode (%+v): %v", opts, err)
+		}
+		if !bytes.Equal(decoded.Bytes(), data) {
+			t.Errorf("decoded data does not match the original (%+v)", opts)
+		}
+
+		decoded.Reset()
+		if err := MultiCarrierDecodeStream([]io.Reader{bytes.NewReader(encoded)}, &decoded, "integrity", Options{}); err != nil {
+			t.Fatalf("MultiCarrierDecodeStream (%+v): %v", opts, err)
+		}
+		if !bytes.Equal(decoded.Bytes(), data) {
+			t.Errorf("streamed data does not match the original (%+v)", opts)
+		}
+	}
+}
+
+// TestJPEGNativeCoefficientChanges checks that the tables are written back unchanged and that only the low
+// bits of the magnitude of AC coefficients change: the low bit of the payload coefficients and the low two bits
+// of the header ones.
+func TestJPEGNativeCoefficientChanges(t *testing.T) {
+	carrier := jpegCarrier(t, 120, 120, 1713)
+	data := make([]byte, 300)
+	rand.New(rand.NewSource(1714)).Read(data)
+	encoded := encodeToBytes(t, carrier, data, Options{JPEGNative: true, Scatter: true, Config: pipeline.Config{BitDepth: 1}})
+
+	// Everything up to the scan data, quantization and Huffman tables included, is copied as it was
+	scanStart := bytes.Index(carrier, []byte{0xFF, 0xDA})
+	if !bytes.Equal(encoded[:scanStart], carrier[:scanStart]) {
+		t.Error("marker segments before the scan changed")
+	}
+
+	before, err := jpeg_dct.Decode(bytes.NewReader(carrier))
+	if err != nil {
+		t.Fatalf("jpeg_dct.Decode: %v", err)
+	}
+	after, err := jpeg_dct.Decode(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatalf("jpeg_dct.Decode: %v", err)
+	}
+	changed := 0
+	for i, v := range before.Coefficients {
+		w := after.Coefficients[i]
+		if v == w {
+			continue
+		}
+		changed++
+		if i%jpeg_dct.BlockSize == 0 || v > -2 && v < 2 || (coefficientMagnitude(v)^coefficientMagnitude(w))&^3 != 0 || (v < 0) != (w < 0) {
+			t.Fatalf("coefficient %d changed from %d to %d", i, v, w)
+		}
+	}
+	if changed == 0 {
+		t.Error("no coefficient changed")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(encoded)); err != nil {
+		t.Errorf("jpeg.Decode: %v", err)
+	}
+}
+
+func TestJPEGNativeCapacity(t *testing.T) {
+	carrier := jpegCarrier(t, 96, 96, 1715)
+	opts := Options{JPEGNative: true, Config: pipeline.Config{BitDepth: 1}}
+	capacities, err := Capacity([]io.Reader{bytes.NewReader(carrier)}, "", opts)
+	if err != nil {
+		t.Fatalf("Capacity: %v", err)
+	}
+	capacity := capacities[0].Bytes
+	if capacity <= 0 {
+		t.Fatalf("capacity = %d", capacity)
+	}
+
+	var encoded bytes.Buffer
+	full := make([]byte, capacity)
+	if err := MultiCarrierEncode([]io.Reader{bytes.NewReader(carrier)}, bytes.NewReader(full), []io.Writer{&encoded}, 1, "", opts); err != nil {
+		t.Fatalf("a payload of exactly the capacity should fit: %v", err)
+	}
+	err = MultiCarrierEncode([]io.Reader{bytes.NewReader(carrier)}, bytes.NewReader(make([]byte, capacity+1)), []io.Writer{&encoded}, 1, "", opts)
+	var encErr *EncodingError
+	if !errors.As(err, &encErr) || encErr.Type != ErrDataTooLarge.Type {
+		t.Errorf("expected a %s for one byte more, got %v", ErrDataTooLarge.Type, err)
+	}
+}
+
+// TestJPEGNativeMixedSet splits a payload over a JPEG-native carrier and a PNG carrier
+func TestJPEGNativeMixedSet(t *testing.T) {
+	data := make([]byte, 800)
+	rand.New(rand.NewSource(1716)).Read(data)
+	carriers := [][]byte{jpegCarrier(t, 128, 128, 1717), carrierPNGBytes(t, 60, 60, 1718)}
+	opts := Options{JPEGNative: true, Config: pipeline.Config{BitDepth: 1}}
+
+	results := []*bytes.Buffer{{}, {}}
+	err := MultiCarrierEncodeStream(
+		[]io.Reader{bytes.NewReader(carriers[0]), bytes.NewReader(carriers[1])},
+		bytes.NewReader(data), []io.Writer{results[0], results[1]}, 1, "integrity", opts)
+	if err != nil {
+		t.Fatalf("MultiCarrierEncodeStream: %v", err)
+	}
+	for i, want := range []string{"jpeg", "png"} {
+		if _, format, err := image.DecodeConfig(bytes.NewReader(results[i].Bytes())); err != nil || format != want {
+			t.Errorf("carrier %d written as %q (%v), want %s", i, format, err, want)
+		}
+	}
+
+	var decoded bytes.Buffer
+	// The carriers may be given in any order
+	if err := MultiCarrierDecode([]io.Reader{bytes.NewReader(results[1].Bytes()), bytes.NewReader(results[0].Bytes())}, &decoded, "integrity", Options{}); err != nil {
+		t.Fatalf("MultiCarrierDecode: %v", err)
+	}
+	if !bytes.Equal(decoded.Bytes(), data) {
+		t.Error("decoded data does not match the original")
+	}
+}
@@ -2,9 +2,10 @@ package image_processing
 
 import (
 	"bytes"
-	"go-steg/cli/helpers"
 	"go-steg/go_steg/pipeline"
 	"go-steg/go_steg/reed_solomon"
+	"io"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"testing"
@@ -12,9 +13,6 @@ import (
 
 // TestMaskBitDepth1 tests roundtrip encoding/decoding with UseMask=true and BitDepth=1.
 func TestMaskBitDepth1(t *testing.T) {
-	helpers.UseMask = true
-	defer func() { helpers.UseMask = false }()
-
 	tmpDir := t.TempDir()
 
 	carrierPath := filepath.Join(tmpDir, "carrier.png")
@@ -41,7 +39,7 @@ func TestMaskBitDepth1(t *testing.T) {
 		1,
 		"testpassword",
 		encodeOutDir,
-		cfg,
+		Options{UseMask: true, Config: cfg},
 	)
 	if err != nil {
 		t.Fatalf("EncodeByFileNames (mask + bit depth 1) failed: %v", err)
@@ -61,6 +59,7 @@ func TestMaskBitDepth1(t *testing.T) {
 		[]string{embeddedPath},
 		"testpassword",
 		decodeOutDir,
+		Options{UseMask: true},
 	)
 	if err != nil {
 		t.Fatalf("MultiCarrierDecodeByFileNames (mask + bit depth 1) failed: %v", err)
@@ -81,9 +80,6 @@ func TestMaskBitDepth1(t *testing.T) {
 
 // TestMaskBitDepth3 tests roundtrip encoding/decoding with UseMask=true and BitDepth=3.
 func TestMaskBitDepth3(t *testing.T) {
-	helpers.UseMask = true
-	defer func() { helpers.UseMask = false }()
-
 	tmpDir := t.TempDir()
 
 	carrierPath := filepath.Join(tmpDir, "carrier.png")
@@ -110,7 +106,7 @@ func TestMaskBitDepth3(t *testing.T) {
 		1,
 		"testpassword",
 		encodeOutDir,
-		cfg,
+		Options{UseMask: true, Config: cfg},
 	)
 	if err != nil {
 		t.Fatalf("EncodeByFileNames (mask + bit depth 3) failed: %v", err)
@@ -130,6 +126,7 @@ func TestMaskBitDepth3(t *testing.T) {
 		[]string{embeddedPath},
 		"testpassword",
 		decodeOutDir,
+		Options{UseMask: true},
 	)
 	if err != nil {
 		t.Fatalf("MultiCarrierDecodeByFileNames (mask + bit depth 3) failed: %v", err)
@@ -150,9 +147,6 @@ func TestMaskBitDepth3(t *testing.T) {
 
 // TestMaskBitDepth4 tests roundtrip encoding/decoding with UseMask=true and BitDepth=4.
 func TestMaskBitDepth4(t *testing.T) {
-	helpers.UseMask = true
-	defer func() { helpers.UseMask = false }()
-
 	tmpDir := t.TempDir()
 
 	carrierPath := filepath.Join(tmpDir, "carrier.png")
@@ -179,7 +173,7 @@ func TestMaskBitDepth4(t *testing.T) {
 		1,
 		"testpassword",
 		encodeOutDir,
-		cfg,
+		Options{UseMask: true, Config: cfg},
 	)
 	if err != nil {
 		t.Fatalf("EncodeByFileNames (mask + bit depth 4) failed: %v", err)
@@ -199,6 +193,7 @@ func TestMaskBitDepth4(t *testing.T) {
 		[]string{embeddedPath},
 		"testpassword",
 		decodeOutDir,
+		Options{UseMask: true},
 	)
 	if err != nil {
 		t.Fatalf("MultiCarrierDecodeByFileNames (mask + bit depth 4) failed: %v", err)
@@ -219,9 +214,6 @@ func TestMaskBitDepth4(t *testing.T) {
 
 // TestMaskHuffmanRS tests roundtrip with UseMask=true, HuffmanEnabled=true, and RSEnabled=true.
 func TestMaskHuffmanRS(t *testing.T) {
-	helpers.UseMask = true
-	defer func() { helpers.UseMask = false }()
-
 	tmpDir := t.TempDir()
 
 	carrierPath := filepath.Join(tmpDir, "carrier.png")
@@ -251,7 +243,7 @@ func TestMaskHuffmanRS(t *testing.T) {
 		1,
 		"testpassword",
 		encodeOutDir,
-		cfg,
+		Options{UseMask: true, Config: cfg},
 	)
 	if err != nil {
 		t.Fatalf("EncodeByFileNames (mask + huffman + RS) failed: %v", err)
@@ -271,6 +263,7 @@ func TestMaskHuffmanRS(t *testing.T) {
 		[]string{embeddedPath},
 		"testpassword",
 		decodeOutDir,
+		Options{UseMask: true},
 	)
 	if err != nil {
 		t.Fatalf("MultiCarrierDecodeByFileNames (mask + huffman + RS) failed: %v", err)
@@ -289,13 +282,70 @@ func TestMaskHuffmanRS(t *testing.T) {
 	}
 }
 
+// TestMaskAutoDetectedFromHeader encodes with the mask enabled and decodes without telling the decoder,
+// relying on the mask flag recorded in the header.
+func TestMaskAutoDetectedFromHeader(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	carrierPath := filepath.Join(tmpDir, "carrier.png")
+	createCarrierPNGWithSize(t, carrierPath, 300, 300, 60006)
+
+	originalData := []byte("the decoder should find the mask flag in the header")
+	dataPath := filepath.Join(tmpDir, "data.txt")
+	createDataFile(t, dataPath, originalData)
+
+	encodeOutDir := filepath.Join(tmpDir, "encoded")
+	if err := os.MkdirAll(encodeOutDir, 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	cfg := pipeline.Config{
+		BitDepth:      2,
+		FileExtension: "txt",
+		Password:      "testpassword",
+	}
+
+	err := EncodeByFileNames(
+		[]string{carrierPath},
+		dataPath,
+		1,
+		"testpassword",
+		encodeOutDir,
+		Options{UseMask: true, Config: cfg},
+	)
+	if err != nil {
+		t.Fatalf("EncodeByFileNames (mask) failed: %v", err)
+	}
+
+	embeddedPath := filepath.Join(encodeOutDir, "carrier-0-embedded.png")
+	decodeOutDir := filepath.Join(tmpDir, "decoded")
+	if err := os.MkdirAll(decodeOutDir, 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	err = MultiCarrierDecodeByFileNames(
+		[]string{embeddedPath},
+		"testpassword",
+		decodeOutDir,
+		Options{},
+	)
+	if err != nil {
+		t.Fatalf("MultiCarrierDecodeByFileNames (mask from header) failed: %v", err)
+	}
+
+	decodedData, err := os.ReadFile(findDecodedFile(t, decodeOutDir, "txt"))
+	if err != nil {
+		t.Fatalf("read decoded: %v", err)
+	}
+	if !bytes.Equal(decodedData, originalData) {
+		t.Errorf("mask auto-detect roundtrip mismatch:\n  original: %q\n  decoded:  %q", originalData, decodedData)
+	}
+}
+
 // TestMaskInsufficientCapacity tests that encoding fails with an error when the
 // carrier is too small to hold the data with mask enabled. The mask reduces
 // capacity significantly because it filters out pixels based on a password-derived pattern.
 func TestMaskInsufficientCapacity(t *testing.T) {
-	helpers.UseMask = true
-	defer func() { helpers.UseMask = false }()
-
 	tmpDir := t.TempDir()
 
 	// Use a small 40x40 carrier — mask will reduce capacity substantially.
@@ -330,7 +380,7 @@ func TestMaskInsufficientCapacity(t *testing.T) {
 		1,
 		"testpassword",
 		encodeOutDir,
-		cfg,
+		Options{UseMask: true, Config: cfg},
 	)
 	if err == nil {
 		t.Error("expected error for mask + insufficient capacity, but encoding succeeded")
@@ -338,3 +388,118 @@ func TestMaskInsufficientCapacity(t *testing.T) {
 		t.Logf("got expected error for mask + insufficient capacity: %v", err)
 	}
 }
+
+// TestMaskCandidateFallback encodes a payload the password's first mask candidate selects too few slots for
+// and checks that encode moves on to a later candidate, records it in the header and that both decoders
+// find it there. With this carrier and password candidate 0 holds about 1060 bytes and candidate 3 about 2800.
+func TestMaskCandidateFallback(t *testing.T) {
+	carrier := carrierPNGBytes(t, 120, 120, 60010)
+	data := make([]byte, 1500)
+	rand.New(rand.NewSource(60011)).Read(data)
+	opts := Options{UseMask: true, Config: pipeline.Config{BitDepth: 1}}
+
+	capacities, err := Capacity([]io.Reader{bytes.NewReader(carrier)}, "candidates", opts)
+	if err != nil {
+		t.Fatalf("Capacity: %v", err)
+	}
+	if capacities[0].MaskCandidate == 0 || capacities[0].Bytes < int64(len(data)) {
+		t.Fatalf("Capacity reports candidate %d with %d bytes, want a later candidate that holds the payload",
+			capacities[0].MaskCandidate, capacities[0].Bytes)
+	}
+
+	encoders := []struct {
+		name   string
+		encode func([]io.Reader, io.ReadSeeker, []io.Writer, uint64, string, Options) error
+	}{
+		{"MultiCarrierEncode", func(c []io.Reader, d io.ReadSeeker, r []io.Writer, id uint64, p string, o Options) error {
+			return MultiCarrierEncode(c, d, r, id, p, o)
+		}},
+		{"MultiCarrierEncodeStream", MultiCarrierEncodeStream},
+	}
+	for _, enc := range encoders {
+		var encoded bytes.Buffer
+		if err := enc.encode([]io.Reader{bytes.NewReader(carrier)}, bytes.NewReader(data), []io.Writer{&encoded}, 1, "candidates", opts); err != nil {
+			t.Fatalf("%s: %v", enc.name, err)
+		}
+		header, err := ReadHeader(bytes.NewReader(encoded.Bytes()))
+		if err != nil {
+			t.Fatalf("ReadHeader (%s): %v", enc.name, err)
+		}
+		if header.MaskCandidate == 0 {
+			t.Errorf("%s: header records mask candidate 0, which is too small for the payload", enc.name)
+		}
+
+		var decoded bytes.Buffer
+		if err := MultiCarrierDecode([]io.Reader{bytes.NewReader(encoded.Bytes())}, &decoded, "candidates", Options{}); err != nil {
+			t.Fatalf("MultiCarrierDecode (%s): %v", enc.name, err)
+		}
+		if !bytes.Equal(decoded.Bytes(), data) {
+			t.Errorf("decoded data does not match the original (%s)", enc.name)
+		}
+		decoded.Reset()
+		if err := MultiCarrierDecodeStream([]io.Reader{bytes.NewReader(encoded.Bytes())}, &decoded, "candidates", Options{}); err != nil {
+			t.Fatalf("MultiCarrierDecodeStream (%s): %v", enc.name, err)
+		}
+		if !bytes.Equal(decoded.Bytes(), data) {
+			t.Errorf("streamed data does not match the original (%s)", enc.name)
+		}
+	}
+}
+
+// TestMaskDensity checks that the share of channels the mask selects follows MaskDensity, that the density is
+// recorded in the header, and that a carrier filled to the capacity reported at a density decodes with both
+// decoders
+func TestMaskDensity(t *testing.T) {
+	carrier := carrierPNGBytes(t, 120, 120, 60020)
+	unmasked, err := Capacity([]io.Reader{bytes.NewReader(carrier)}, "density", Options{Config: pipeline.Config{BitDepth: 1}})
+	if err != nil {
+		t.Fatalf("Capacity: %v", err)
+	}
+
+	for _, density := range []uint8{1, 3, 6} {
+		opts := Options{UseMask: true, MaskDensity: density, Config: pipeline.Config{BitDepth: 1, FileExtension: "bin"}}
+		capacities, err := Capacity([]io.Reader{bytes.NewReader(carrier)}, "density", opts)
+		if err != nil {
+			t.Fatalf("Capacity (%d/8): %v", density, err)
+		}
+		ratio := float64(capacities[0].Slots) / float64(unmasked[0].Slots)
+		t.Logf("%d/8: %d of %d slots selected", density, capacities[0].Slots, unmasked[0].Slots)
+		if want := float64(density) / 8; ratio < want-0.1 || ratio > want+0.1 {
+			t.Errorf("%d/8: mask selects %.2f of the slots, want about %.2f", density, ratio, want)
+		}
+
+		data := make([]byte, capacities[0].Bytes)
+		rand.New(rand.NewSource(int64(density))).Read(data)
+		var encoded bytes.Buffer
+		if err := MultiCarrierEncode([]io.Reader{bytes.NewReader(carrier)}, bytes.NewReader(data), []io.Writer{&encoded}, 1, "density", opts); err != nil {
+			t.Fatalf("MultiCarrierEncode (%d/8, %d bytes): %v", density, len(data), err)
+		}
+		header, err := ReadHeader(bytes.NewReader(encoded.Bytes()))
+		if err != nil {
+			t.Fatalf("ReadHeader: %v", err)
+		}
+		if header.MaskAlgorithm != MaskAlgorithmDensity || header.MaskDensity != density {
+			t.Errorf("header records mask algorithm %d with density %d, want %d with %d", header.MaskAlgorithm, header.MaskDensity, MaskAlgorithmDensity, density)
+		}
+
+		var decoded bytes.Buffer
+		if err := MultiCarrierDecode([]io.Reader{bytes.NewReader(encoded.Bytes())}, &decoded, "density", Options{}); err != nil {
+			t.Fatalf("MultiCarrierDecode (%d/8): %v", density, err)
+		}
+		if !bytes.Equal(decoded.Bytes(), data) {
+			t.Errorf("decoded data does not match the original (%d/8)", density)
+		}
+		decoded.Reset()
+		if err := MultiCarrierDecodeStream([]io.Reader{bytes.NewReader(encoded.Bytes())}, &decoded, "density", Options{}); err != nil {
+			t.Fatalf("MultiCarrierDecodeStream (%d/8): %v", density, err)
+		}
+		if !bytes.Equal(decoded.Bytes(), data) {
+			t.Errorf("streamed data does not match the original (%d/8)", density)
+		}
+	}
+
+	opts := Options{UseMask: true, MaskDensity: maxMaskDensity + 1, Config: pipeline.Config{BitDepth: 1}}
+	if _, err := Capacity([]io.Reader{bytes.NewReader(carrier)}, "density", opts); err == nil {
+		t.Errorf("expected an error for a mask density of %d/8", opts.MaskDensity)
+	}
+}
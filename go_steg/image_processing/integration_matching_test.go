@@ -0,0 +1,115 @@
+package image_processing
+
+import (
+	"bytes"
+	"go-steg/go_steg/pipeline"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestLSBMatchingRoundtrip(t *testing.T) {
+	data := make([]byte, 400)
+	rand.New(rand.NewSource(1801)).Read(data)
+
+	carriers := []struct {
+		name    string
+		carrier []byte
+	}{
+		{"rgb", carrierPNGBytes(t, 120, 120, 1802)},
+		{"translucent", pngBytes(t, transparentCarrier(140, 140, 1803))},
+		{"16-bit", pngBytes(t, carrier16(120, 120, 1804, true))},
+		{"gray", pngBytes(t, grayCarrier(150, 150, 1805))},
+		{"paletted", pngBytes(t, palettedCarrier(150, 150, 200, 0, 1806))},
+		{"jpeg", jpegCarrier(t, 256, 256, 1807)},
+	}
+	for _, carrier := range carriers {
+		for _, opts := range []Options{
+			{LSBMatching: true, JPEGNative: true, Config: pipeline.Config{BitDepth: 1}},
+			{LSBMatching: true, JPEGNative: true, UseMask: true, Scatter: true, UseAlpha: carrier.name != "jpeg", Config: pipeline.Config{BitDepth: 2, RSEnabled: true}},
+		} {
+			encoded := encodeToBytes(t, carrier.carrier, data, opts)
+
+			header, err := ReadHeader(bytes.NewReader(encoded))
+			if err != nil {
+				t.Fatalf("ReadHeader (%s): %v", carrier.name, err)
+			}
+			if !header.LSBMatching {
+				t.Errorf("%s: header does not record LSB matching", carrier.name)
+			}
+
+			var decoded bytes.Buffer
+			if err := MultiCarrierDecode([]io.Reader{bytes.NewReader(encoded)}, &decoded, "integrity", Options{}); err != nil {
+				t.Fatalf("MultiCarrierDecode (%s, %+v): %v", carrier.name, opts, err)
+			}
+			if !bytes.Equal(decoded.Bytes(), data) {
+				t.Errorf("decoded data does not match the original (%s, %+v)", carrier.name, opts)
+			}
+		}
+	}
+}
+
+func TestLSBMatchingStreamRoundtrip(t *testing.T) {
+	data := make([]byte, 3000)
+	rand.New(rand.NewSource(1808)).Read(data)
+	opts := Options{LSBMatching: true, UseMask: true, Scatter: true, Config: pipeline.Config{BitDepth: 2}}
+
+	encoded := streamCarrierSet(t, [][2]int{{100, 100}, {90, 120}}, data, opts)
+	var decoded bytes.Buffer
+	if err := MultiCarrierDecodeStream(readers(encoded), &decoded, "stream", Options{}); err != nil {
+		t.Fatalf("MultiCarrierDecodeStream: %v", err)
+	}
+	if !bytes.Equal(decoded.Bytes(), data) {
+		t.Error("streamed data does not match the original")
+	}
+}
+
+// TestLSBMatchingChanges fills a carrier with saturated and mid-range samples at bit depth 1 and checks that
+// every sample moves by at most one without wrapping around, and that some of them leave the pair of values
+// they started in, which LSB replacement never does.
+func TestLSBMatchingChanges(t *testing.T) {
+	rng := rand.New(rand.NewSource(1809))
+	nrgba := image.NewNRGBA(image.Rect(0, 0, 80, 80))
+	for i := range nrgba.Pix {
+		nrgba.Pix[i] = []uint8{0, 255, uint8(rng.Intn(256))}[rng.Intn(3)]
+	}
+	for i := 3; i < len(nrgba.Pix); i += 4 {
+		nrgba.Pix[i] = 255
+	}
+	carrier := pngBytes(t, nrgba)
+	data := make([]byte, 500)
+	rng.Read(data)
+
+	for _, matching := range []bool{false, true} {
+		encoded := encodeToBytes(t, carrier, data, Options{LSBMatching: matching, Config: pipeline.Config{BitDepth: 1}})
+		img, err := png.Decode(bytes.NewReader(encoded))
+		if err != nil {
+			t.Fatalf("png.Decode: %v", err)
+		}
+		crossed := 0
+		for y := totalReservedPixels; y < 80; y++ {
+			for x := 0; x < 80; x++ {
+				before := nrgba.NRGBAAt(x, y)
+				after := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+				for i, pair := range [][2]uint8{{before.R, after.R}, {before.G, after.G}, {before.B, after.B}} {
+					diff := int(pair[1]) - int(pair[0])
+					if diff < -1 || diff > 1 {
+						t.Fatalf("channel %d of (%d, %d) moved from %d to %d", i, x, y, pair[0], pair[1])
+					}
+					if pair[0]>>1 != pair[1]>>1 {
+						crossed++
+					}
+				}
+			}
+		}
+		if matching && crossed == 0 {
+			t.Error("LSB matching never left a pair of values")
+		}
+		if !matching && crossed != 0 {
+			t.Errorf("LSB replacement left a pair of values %d times", crossed)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package image_processing
+
+import (
+	"bytes"
+	"go-steg/go_steg/pipeline"
+	"image"
+	"image/png"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestMatrixEmbeddingRoundtrip(t *testing.T) {
+	data := make([]byte, 200)
+	rand.New(rand.NewSource(2011)).Read(data)
+
+	carriers := []struct {
+		name    string
+		carrier []byte
+	}{
+		{"rgb", carrierPNGBytes(t, 120, 120, 2012)},
+		{"gray", pngBytes(t, grayCarrier(150, 150, 2013))},
+		{"jpeg", jpegCarrier(t, 256, 256, 2014)},
+	}
+	for _, carrier := range carriers {
+		for _, opts := range []Options{
+			{MatrixEmbedding: true, JPEGNative: true, Config: pipeline.Config{BitDepth: 1}},
+			{MatrixEmbedding: true, LSBMatching: true, JPEGNative: true, UseMask: true, Scatter: true, Config: pipeline.Config{BitDepth: 1, RSEnabled: true}},
+		} {
+			encoded := encodeToBytes(t, carrier.carrier, data, opts)
+
+			header, err := ReadHeader(bytes.NewReader(encoded))
+			if err != nil {
+				t.Fatalf("ReadHeader (%s): %v", carrier.name, err)
+			}
+			if !header.MatrixEmbedding || header.MatrixK < 1 {
+				t.Errorf("%s: header records matrix embedding %v with k = %d", carrier.name, header.MatrixEmbedding, header.MatrixK)
+			}
+
+			var decoded bytes.Buffer
+			if err := MultiCarrierDecode([]io.Reader{bytes.NewReader(encoded)}, &decoded, "integrity", Options{}); err != nil {
+				t.Fatalf("MultiCarrierDecode (%s, %+v): %v", carrier.name, opts, err)
+			}
+			if !bytes.Equal(decoded.Bytes(), data) {
+				t.Errorf("decoded data does not match the original (%s, %+v)", carrier.name, opts)
+			}
+
+			decoded.Reset()
+			if err := MultiCarrierDecodeStream([]io.Reader{bytes.NewReader(encoded)}, &decoded, "integrity", Options{}); err != nil {
+				t.Fatalf("MultiCarrierDecodeStream (%s, %+v): %v", carrier.name, opts, err)
+			}
+			if !bytes.Equal(decoded.Bytes(), data) {
+				t.Errorf("streamed data does not match the original (%s, %+v)", carrier.name, opts)
+			}
+		}
+	}
+}
+
+func TestMatrixEmbeddingStreamRoundtrip(t *testing.T) {
+	data := make([]byte, 1500)
+	rand.New(rand.NewSource(2015)).Read(data)
+	opts := Options{MatrixEmbedding: true, UseMask: true, Scatter: true, Config: pipeline.Config{BitDepth: 1}}
+
+	encoded := streamCarrierSet(t, [][2]int{{100, 100}, {90, 120}}, data, opts)
+	for i, carrier := range encoded {
+		header, err := ReadHeader(bytes.NewReader(carrier))
+		if err != nil {
+			t.Fatalf("ReadHeader: %v", err)
+		}
+		if !header.MatrixEmbedding || header.MatrixK < 2 {
+			t.Errorf("carrier %d: header records matrix embedding %v with k = %d, want k of 2 or more", i, header.MatrixEmbedding, header.MatrixK)
+		}
+	}
+
+	var decoded bytes.Buffer
+	if err := MultiCarrierDecodeStream(readers(encoded), &decoded, "stream", Options{}); err != nil {
+		t.Fatalf("MultiCarrierDecodeStream: %v", err)
+	}
+	if !bytes.Equal(decoded.Bytes(), data) {
+		t.Error("streamed data does not match the original")
+	}
+	decoded.Reset()
+	if err := MultiCarrierDecode(readers(encoded), &decoded, "stream", Options{}); err != nil {
+		t.Fatalf("MultiCarrierDecode: %v", err)
+	}
+	if !bytes.Equal(decoded.Bytes(), data) {
+		t.Error("decoded data does not match the original")
+	}
+}
+
+// TestMatrixEmbeddingChanges embeds the same payload with and without matrix embedding and checks that the
+// Hamming code changes fewer samples, and that a payload filling the carrier still fits at k = 1
+func TestMatrixEmbeddingChanges(t *testing.T) {
+	carrier := carrierPNGBytes(t, 150, 150, 2016)
+	original, err := png.Decode(bytes.NewReader(carrier))
+	if err != nil {
+		t.Fatalf("png.Decode: %v", err)
+	}
+	changes := func(encoded []byte) int {
+		img, err := png.Decode(bytes.NewReader(encoded))
+		if err != nil {
+			t.Fatalf("png.Decode: %v", err)
+		}
+		before, after := original.(*image.RGBA), img.(*image.RGBA)
+		changed := 0
+		for y := totalReservedPixels; y < 150; y++ {
+			for x := 0; x < 150; x++ {
+				for c := 0; c < 3; c++ {
+					if before.Pix[before.PixOffset(x, y)+c] != after.Pix[after.PixOffset(x, y)+c] {
+						changed++
+					}
+				}
+			}
+		}
+		return changed
+	}
+
+	data := make([]byte, 1000)
+	rand.New(rand.NewSource(2017)).Read(data)
+	plain := changes(encodeToBytes(t, carrier, data, Options{Config: pipeline.Config{BitDepth: 1}}))
+	matrix := changes(encodeToBytes(t, carrier, data, Options{MatrixEmbedding: true, Config: pipeline.Config{BitDepth: 1}}))
+	if matrix*3 > plain*2 {
+		t.Errorf("matrix embedding changed %d samples, plain embedding %d", matrix, plain)
+	}
+
+	opts := Options{MatrixEmbedding: true, Config: pipeline.Config{BitDepth: 1}}
+	capacities, err := Capacity([]io.Reader{bytes.NewReader(carrier)}, "integrity", opts)
+	if err != nil {
+		t.Fatalf("Capacity: %v", err)
+	}
+	full := make([]byte, capacities[0].Bytes)
+	encoded := encodeToBytes(t, carrier, full, opts)
+	if header, _ := ReadHeader(bytes.NewReader(encoded)); header.MatrixK != 1 {
+		t.Errorf("a payload filling the carrier was embedded with k = %d, want 1", header.MatrixK)
+	}
+}
+
+func TestMatrixEmbeddingNeedsBitDepth1(t *testing.T) {
+	var encoded bytes.Buffer
+	opts := Options{MatrixEmbedding: true, Config: pipeline.Config{BitDepth: 2}}
+	err := MultiCarrierEncode([]io.Reader{bytes.NewReader(carrierPNGBytes(t, 60, 60, 2018))}, bytes.NewReader([]byte("data")), []io.Writer{&encoded}, 1, "", opts)
+	if err == nil {
+		t.Error("matrix embedding at bit depth 2 should be rejected")
+	}
+}
@@ -0,0 +1,187 @@
+package image_processing
+
+import (
+	"bytes"
+	"errors"
+	"go-steg/go_steg/pipeline"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func grayCarrier(width, height int, seed int64) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	rand.New(rand.NewSource(seed)).Read(img.Pix)
+	return img
+}
+
+func gray16Carrier(width, height int, seed int64) *image.Gray16 {
+	img := image.NewGray16(image.Rect(0, 0, width, height))
+	rand.New(rand.NewSource(seed)).Read(img.Pix)
+	return img
+}
+
+// palettedCarrier builds a paletted carrier with colors random colors, the first transparent of which are
+// fully transparent, and random indices
+func palettedCarrier(width, height, colors, transparent int, seed int64) *image.Paletted {
+	rng := rand.New(rand.NewSource(seed))
+	palette := make(color.Palette, colors)
+	for i := range palette {
+		c := color.NRGBA{R: uint8(rng.Intn(256)), G: uint8(rng.Intn(256)), B: uint8(rng.Intn(256)), A: 255}
+		if i < transparent {
+			c.A = 0
+		}
+		palette[i] = c
+	}
+	img := image.NewPaletted(image.Rect(0, 0, width, height), palette)
+	for i := range img.Pix {
+		img.Pix[i] = uint8(rng.Intn(colors))
+	}
+	return img
+}
+
+func TestSingleChannelCarrierRoundtrip(t *testing.T) {
+	data := make([]byte, 400)
+	rand.New(rand.NewSource(1501)).Read(data)
+
+	carriers := []struct {
+		name  string
+		img   image.Image
+		model CarrierModel
+	}{
+		{"gray", grayCarrier(150, 150, 1502), CarrierModelGray},
+		{"gray16", gray16Carrier(150, 150, 1503), CarrierModelGray16},
+		{"paletted", palettedCarrier(150, 150, 200, 3, 1504), CarrierModelPaletted},
+	}
+	for _, carrier := range carriers {
+		for _, opts := range []Options{
+			{Config: pipeline.Config{BitDepth: 1}},
+			{UseAlpha: true, Config: pipeline.Config{BitDepth: 2}},
+			{UseMask: true, Scatter: true, Config: pipeline.Config{BitDepth: 3, RSEnabled: true}},
+			{Config: pipeline.Config{BitDepth: 4}},
+		} {
+			encoded := encodeToBytes(t, pngBytes(t, carrier.img), data, opts)
+
+			img, err := png.Decode(bytes.NewReader(encoded))
+			if err != nil {
+				t.Fatalf("png.Decode: %v", err)
+			}
+			if reflect.TypeOf(img) != reflect.TypeOf(carrier.img) {
+				t.Errorf("%s carrier written back as %T", carrier.name, img)
+			}
+			header, err := ReadHeader(bytes.NewReader(encoded))
+			if err != nil {
+				t.Fatalf("ReadHeader: %v", err)
+			}
+			if header.CarrierModel != carrier.model {
+				t.Errorf("%s carrier header records model %v", carrier.name, header.CarrierModel)
+			}
+
+			var decoded bytes.Buffer
+			if err := MultiCarrierDecode([]io.Reader{bytes.NewReader(encoded)}, &decoded, "integrity", Options{}); err != nil {
+				t.Fatalf("MultiCarrierDecode (%s, %+v): %v", carrier.name, opts, err)
+			}
+			if !bytes.Equal(decoded.Bytes(), data) {
+				t.Errorf("decoded data does not match the original (%s, %+v)", carrier.name, opts)
+			}
+
+			decoded.Reset()
+			if err := MultiCarrierDecodeStream([]io.Reader{bytes.NewReader(encoded)}, &decoded, "integrity", Options{}); err != nil {
+				t.Fatalf("MultiCarrierDecodeStream (%s, %+v): %v", carrier.name, opts, err)
+			}
+			if !bytes.Equal(decoded.Bytes(), data) {
+				t.Errorf("streamed data does not match the original (%s, %+v)", carrier.name, opts)
+			}
+		}
+	}
+}
+
+func TestGrayCapacity(t *testing.T) {
+	capacities, err := Capacity([]io.Reader{bytes.NewReader(pngBytes(t, grayCarrier(60, 50, 1505)))}, "", Options{UseAlpha: true, Config: pipeline.Config{BitDepth: 2}})
+	if err != nil {
+		t.Fatalf("Capacity: %v", err)
+	}
+	// One luminance slot per pixel; there is no alpha channel to add
+	if got := capacities[0]; got.Slots != 60*16 || got.Bytes != 240 {
+		t.Errorf("got %d slots and %d bytes, want %d and 240", got.Slots, got.Bytes, 60*16)
+	}
+}
+
+// TestPalettedTransparencyPreserved checks that embedding never moves a pixel between a visible and a
+// transparent palette entry, and that visible pixels keep their opacity.
+func TestPalettedTransparencyPreserved(t *testing.T) {
+	original := palettedCarrier(100, 100, 60, 2, 1506)
+	data := make([]byte, 300)
+	rand.New(rand.NewSource(1507)).Read(data)
+	encoded := encodeToBytes(t, pngBytes(t, original), data, Options{Config: pipeline.Config{BitDepth: 4}})
+
+	img, err := png.Decode(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatalf("png.Decode: %v", err)
+	}
+	result, ok := img.(*image.Paletted)
+	if !ok {
+		t.Fatalf("encoded carrier decoded as %T, want *image.Paletted", img)
+	}
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 100; x++ {
+			_, _, _, before := original.At(x, y).RGBA()
+			_, _, _, after := result.At(x, y).RGBA()
+			if before != after {
+				t.Fatalf("pixel (%d, %d) alpha changed from %d to %d", x, y, before>>8, after>>8)
+			}
+		}
+	}
+}
+
+func TestSortPaletteTooManyColors(t *testing.T) {
+	// 250 visible colors round up to 256 at bit depth 4, leaving no group for the transparent ones
+	img := newCarrierImage(palettedCarrier(10, 40, 256, 6, 1508))
+	err := img.sortPalette(4)
+	var encErr *EncodingError
+	if !errors.As(err, &encErr) || encErr.Type != ErrInvalidFormat.Type {
+		t.Errorf("expected an %s, got %v", ErrInvalidFormat.Type, err)
+	}
+	if err := newCarrierImage(palettedCarrier(10, 40, 256, 0, 1509)).sortPalette(4); err != nil {
+		t.Errorf("a full palette without transparency should fit: %v", err)
+	}
+}
+
+// TestInspectConvertedCarrier re-saves an 8-bit carrier as 16-bit. Every 8-bit sample v becomes v*257, which
+// keeps the low byte, so the carrier still decodes, but inspect points out the conversion.
+func TestInspectConvertedCarrier(t *testing.T) {
+	data := []byte("converted after encoding")
+	encoded := encodeToBytes(t, carrierPNGBytes(t, 60, 60, 1510), data, Options{Config: pipeline.Config{BitDepth: 2}})
+	img, err := png.Decode(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatalf("png.Decode: %v", err)
+	}
+	deep := image.NewNRGBA64(img.Bounds())
+	for y := 0; y < 60; y++ {
+		for x := 0; x < 60; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			deep.SetNRGBA64(x, y, color.NRGBA64{R: uint16(c.R) * 257, G: uint16(c.G) * 257, B: uint16(c.B) * 257, A: 0xFFFF})
+		}
+	}
+	converted := pngBytes(t, deep)
+
+	report, err := InspectCarrier(bytes.NewReader(converted), "")
+	if err != nil {
+		t.Fatalf("InspectCarrier: %v", err)
+	}
+	if report.Model != CarrierModelRGBA64 || !hasProblem(report, "header records a rgba carrier but the image is rgba64") {
+		t.Errorf("expected a conversion problem, got model %v and %v", report.Model, report.Problems)
+	}
+
+	var decoded bytes.Buffer
+	if err := MultiCarrierDecode([]io.Reader{bytes.NewReader(converted)}, &decoded, "integrity", Options{}); err != nil {
+		t.Fatalf("MultiCarrierDecode: %v", err)
+	}
+	if !bytes.Equal(decoded.Bytes(), data) {
+		t.Error("decoded data does not match the original")
+	}
+}
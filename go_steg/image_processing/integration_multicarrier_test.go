@@ -2,21 +2,20 @@ package image_processing
 
 import (
 	"bytes"
-	"go-steg/cli/helpers"
+	"errors"
 	"go-steg/go_steg/pipeline"
 	"go-steg/go_steg/reed_solomon"
+	"io"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
-// TestMultiCarrierWrongOrder encodes with [carrier1, carrier2] and decodes with
-// [carrier2, carrier1]. The decoded data should either error or differ from the original.
-func TestMultiCarrierWrongOrder(t *testing.T) {
-	helpers.UseMask = false
-	defer func() { helpers.UseMask = false }()
-
+// TestMultiCarrierAnyOrder encodes with [carrier1, carrier2] and decodes with
+// [carrier2, carrier1]. The chunks are reassembled by photo number, so the data must still match.
+func TestMultiCarrierAnyOrder(t *testing.T) {
 	tmpDir := t.TempDir()
 
 	carrier1Path := filepath.Join(tmpDir, "carrier1.png")
@@ -48,7 +47,7 @@ func TestMultiCarrierWrongOrder(t *testing.T) {
 		1,
 		"",
 		encodeOutDir,
-		cfg,
+		Options{Config: cfg},
 	)
 	if err != nil {
 		t.Fatalf("EncodeByFileNames failed: %v", err)
@@ -63,7 +62,7 @@ func TestMultiCarrierWrongOrder(t *testing.T) {
 		}
 	}
 
-	// Decode with carriers in WRONG order: [carrier2, carrier1] instead of [carrier1, carrier2]
+	// Decode with carriers in reverse order: [carrier2, carrier1] instead of [carrier1, carrier2]
 	decodeOutDir := filepath.Join(tmpDir, "decoded")
 	if err := os.MkdirAll(decodeOutDir, 0755); err != nil {
 		t.Fatalf("mkdir: %v", err)
@@ -73,24 +72,20 @@ func TestMultiCarrierWrongOrder(t *testing.T) {
 		[]string{embedded2, embedded1},
 		"",
 		decodeOutDir,
+		Options{},
 	)
 	if decErr != nil {
-		// Error is acceptable — wrong order should not decode cleanly.
-		t.Logf("decode with wrong carrier order returned error (expected): %v", decErr)
-		return
+		t.Fatalf("decode with reversed carrier order failed: %v", decErr)
 	}
 
-	// If no error, verify the output does NOT match the original.
 	decodedPath := findDecodedFile(t, decodeOutDir, "bin")
 	decodedData, err := os.ReadFile(decodedPath)
 	if err != nil {
 		t.Fatalf("read decoded: %v", err)
 	}
 
-	if bytes.Equal(decodedData, originalData) {
-		t.Error("decoded data matches original despite wrong carrier order — order should matter")
-	} else {
-		t.Logf("decoded data differs from original as expected (original len=%d, decoded len=%d)",
+	if !bytes.Equal(decodedData, originalData) {
+		t.Errorf("reversed carrier order roundtrip mismatch: original len=%d, decoded len=%d",
 			len(originalData), len(decodedData))
 	}
 }
@@ -98,9 +93,6 @@ func TestMultiCarrierWrongOrder(t *testing.T) {
 // TestMultiCarrierMissingCarrier encodes with 2 carriers but decodes with only 1.
 // The decoded data should either error or not match the original.
 func TestMultiCarrierMissingCarrier(t *testing.T) {
-	helpers.UseMask = false
-	defer func() { helpers.UseMask = false }()
-
 	tmpDir := t.TempDir()
 
 	carrier1Path := filepath.Join(tmpDir, "carrier1.png")
@@ -132,7 +124,7 @@ func TestMultiCarrierMissingCarrier(t *testing.T) {
 		1,
 		"",
 		encodeOutDir,
-		cfg,
+		Options{Config: cfg},
 	)
 	if err != nil {
 		t.Fatalf("EncodeByFileNames failed: %v", err)
@@ -154,6 +146,7 @@ func TestMultiCarrierMissingCarrier(t *testing.T) {
 		[]string{embedded1},
 		"",
 		decodeOutDir,
+		Options{},
 	)
 	if decErr != nil {
 		// Error is acceptable — missing a carrier should fail.
@@ -178,9 +171,6 @@ func TestMultiCarrierMissingCarrier(t *testing.T) {
 
 // TestMultiCarrierThreeCarriers encodes data split across 3 carriers and verifies roundtrip.
 func TestMultiCarrierThreeCarriers(t *testing.T) {
-	helpers.UseMask = false
-	defer func() { helpers.UseMask = false }()
-
 	tmpDir := t.TempDir()
 
 	carrier1Path := filepath.Join(tmpDir, "carrier1.png")
@@ -214,7 +204,7 @@ func TestMultiCarrierThreeCarriers(t *testing.T) {
 		1,
 		"",
 		encodeOutDir,
-		cfg,
+		Options{Config: cfg},
 	)
 	if err != nil {
 		t.Fatalf("EncodeByFileNames with 3 carriers failed: %v", err)
@@ -239,6 +229,7 @@ func TestMultiCarrierThreeCarriers(t *testing.T) {
 		[]string{embedded1, embedded2, embedded3},
 		"",
 		decodeOutDir,
+		Options{},
 	)
 	if decErr != nil {
 		t.Fatalf("MultiCarrierDecodeByFileNames with 3 carriers failed: %v", decErr)
@@ -261,9 +252,6 @@ func TestMultiCarrierThreeCarriers(t *testing.T) {
 // TestMultiCarrierWithPipelineFeatures encodes with 2 carriers + Huffman + RS enabled,
 // decodes, and verifies roundtrip.
 func TestMultiCarrierWithPipelineFeatures(t *testing.T) {
-	helpers.UseMask = false
-	defer func() { helpers.UseMask = false }()
-
 	tmpDir := t.TempDir()
 
 	carrier1Path := filepath.Join(tmpDir, "carrier1.png")
@@ -294,7 +282,7 @@ func TestMultiCarrierWithPipelineFeatures(t *testing.T) {
 		1,
 		"",
 		encodeOutDir,
-		cfg,
+		Options{Config: cfg},
 	)
 	if err != nil {
 		t.Fatalf("EncodeByFileNames with pipeline features failed: %v", err)
@@ -318,6 +306,7 @@ func TestMultiCarrierWithPipelineFeatures(t *testing.T) {
 		[]string{embedded1, embedded2},
 		"",
 		decodeOutDir,
+		Options{},
 	)
 	if decErr != nil {
 		t.Fatalf("MultiCarrierDecodeByFileNames with pipeline features failed: %v", decErr)
@@ -339,9 +328,6 @@ func TestMultiCarrierWithPipelineFeatures(t *testing.T) {
 // TestMultiCarrierUnevenDataSplit encodes data whose length is not evenly divisible
 // by the carrier count, and verifies roundtrip integrity.
 func TestMultiCarrierUnevenDataSplit(t *testing.T) {
-	helpers.UseMask = false
-	defer func() { helpers.UseMask = false }()
-
 	tmpDir := t.TempDir()
 
 	carrier1Path := filepath.Join(tmpDir, "carrier1.png")
@@ -374,7 +360,7 @@ func TestMultiCarrierUnevenDataSplit(t *testing.T) {
 		1,
 		"",
 		encodeOutDir,
-		cfg,
+		Options{Config: cfg},
 	)
 	if err != nil {
 		t.Fatalf("EncodeByFileNames with uneven data failed: %v", err)
@@ -398,6 +384,7 @@ func TestMultiCarrierUnevenDataSplit(t *testing.T) {
 		[]string{embedded1, embedded2},
 		"",
 		decodeOutDir,
+		Options{},
 	)
 	if decErr != nil {
 		t.Fatalf("MultiCarrierDecodeByFileNames with uneven data failed: %v", decErr)
@@ -416,3 +403,183 @@ func TestMultiCarrierUnevenDataSplit(t *testing.T) {
 		showSnippet(t, "decoded", decodedData)
 	}
 }
+
+// encodeCarrierSet encodes data across the given number of in-memory carriers and returns the embedded PNGs
+// in part order.
+func encodeCarrierSet(t *testing.T, parts int, photoID uint64, data []byte, seed int64) [][]byte {
+	t.Helper()
+	carriers := make([]io.Reader, parts)
+	buffers := make([]*bytes.Buffer, parts)
+	results := make([]io.Writer, parts)
+	for i := range carriers {
+		carriers[i] = bytes.NewReader(carrierPNGBytes(t, 100, 100, seed+int64(i)))
+		buffers[i] = &bytes.Buffer{}
+		results[i] = buffers[i]
+	}
+	opts := Options{Config: pipeline.Config{BitDepth: 2, FileExtension: "bin"}}
+	if err := MultiCarrierEncode(carriers, bytes.NewReader(data), results, photoID, "set", opts); err != nil {
+		t.Fatalf("MultiCarrierEncode: %v", err)
+	}
+	encoded := make([][]byte, parts)
+	for i, buf := range buffers {
+		encoded[i] = buf.Bytes()
+	}
+	return encoded
+}
+
+// decodeCarrierSet decodes the given embedded PNGs in the order given.
+func decodeCarrierSet(carriers ...[]byte) ([]byte, error) {
+	readers := make([]io.Reader, len(carriers))
+	for i, c := range carriers {
+		readers[i] = bytes.NewReader(c)
+	}
+	var decoded bytes.Buffer
+	err := MultiCarrierDecode(readers, &decoded, "set", Options{})
+	return decoded.Bytes(), err
+}
+
+// requireCarrierSetError checks that err is a carrier set error whose message mentions want.
+func requireCarrierSetError(t *testing.T, err error, want string) {
+	t.Helper()
+	var encErr *EncodingError
+	if !errors.As(err, &encErr) || encErr.Type != ErrCarrierSet.Type {
+		t.Fatalf("expected a %s, got %v", ErrCarrierSet.Type, err)
+	}
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("error %q should mention %q", err, want)
+	}
+}
+
+func TestMultiCarrierShuffledThreeCarriers(t *testing.T) {
+	original := bytes.Repeat([]byte("shuffled set "), 30)
+	set := encodeCarrierSet(t, 3, 77, original, 6001)
+
+	decoded, err := decodeCarrierSet(set[2], set[0], set[1])
+	if err != nil {
+		t.Fatalf("decode shuffled carriers: %v", err)
+	}
+	if !bytes.Equal(decoded, original) {
+		t.Error("shuffled carrier roundtrip mismatch")
+	}
+}
+
+func TestMultiCarrierMismatchedPhotoID(t *testing.T) {
+	original := bytes.Repeat([]byte("photo id "), 30)
+	first := encodeCarrierSet(t, 2, 77, original, 6101)
+	second := encodeCarrierSet(t, 2, 78, original, 6201)
+
+	_, err := decodeCarrierSet(first[0], second[1])
+	requireCarrierSetError(t, err, "photo ID 78")
+}
+
+func TestMultiCarrierDuplicatePart(t *testing.T) {
+	original := bytes.Repeat([]byte("duplicate "), 30)
+	set := encodeCarrierSet(t, 2, 77, original, 6301)
+
+	_, err := decodeCarrierSet(set[0], set[1], set[1])
+	requireCarrierSetError(t, err, "duplicate part numbers [1]")
+}
+
+func TestMultiCarrierMissingMiddlePart(t *testing.T) {
+	original := bytes.Repeat([]byte("missing "), 40)
+	set := encodeCarrierSet(t, 3, 77, original, 6401)
+
+	_, err := decodeCarrierSet(set[2], set[0])
+	requireCarrierSetError(t, err, "missing part numbers [1]")
+}
+
+func TestMultiCarrierMissingLastPart(t *testing.T) {
+	original := bytes.Repeat([]byte("missing last "), 40)
+	set := encodeCarrierSet(t, 3, 77, original, 6501)
+
+	_, err := decodeCarrierSet(set[1], set[0])
+	requireCarrierSetError(t, err, "missing part numbers [2], set has 3 parts")
+}
+
+func TestMultiCarrierMismatchedTotalParts(t *testing.T) {
+	original := bytes.Repeat([]byte("set size "), 40)
+	pair := encodeCarrierSet(t, 2, 77, original, 6601)
+	triple := encodeCarrierSet(t, 3, 77, original, 6701)
+
+	_, err := decodeCarrierSet(pair[0], triple[1])
+	requireCarrierSetError(t, err, "set of 3")
+}
+
+func TestMultiCarrierTooManyCarriers(t *testing.T) {
+	carriers := make([]io.Reader, maxCarriers+1)
+	results := make([]io.Writer, maxCarriers+1)
+	err := MultiCarrierEncode(carriers, bytes.NewReader([]byte("x")), results, 1, "", Options{})
+	requireCarrierSetError(t, err, "at most 64")
+}
+
+// TestMultiCarrierMixedSizes splits a payload over a large and a small carrier. An equal split would
+// overflow the small carrier; splitting by capacity fills both.
+func TestMultiCarrierMixedSizes(t *testing.T) {
+	large := carrierPNGBytes(t, 200, 200, 6801)
+	small := carrierPNGBytes(t, 40, 40, 6802)
+	opts := Options{UseMask: true, Config: pipeline.Config{BitDepth: 2, FileExtension: "bin"}}
+
+	capacities, err := Capacity([]io.Reader{bytes.NewReader(large), bytes.NewReader(small)}, "mixed", opts)
+	if err != nil {
+		t.Fatalf("Capacity: %v", err)
+	}
+	size := int(capacities[0].Bytes + capacities[1].Bytes - 10)
+	if size/2 <= int(capacities[1].Bytes) {
+		t.Fatalf("test setup: half of %d bytes should overflow the small carrier (%d bytes)", size, capacities[1].Bytes)
+	}
+
+	original := make([]byte, size)
+	rng := rand.New(rand.NewSource(680))
+	for i := range original {
+		original[i] = byte(rng.Intn(256))
+	}
+
+	var encodedLarge, encodedSmall bytes.Buffer
+	err = MultiCarrierEncode(
+		[]io.Reader{bytes.NewReader(large), bytes.NewReader(small)},
+		bytes.NewReader(original),
+		[]io.Writer{&encodedLarge, &encodedSmall},
+		1,
+		"mixed",
+		opts,
+	)
+	if err != nil {
+		t.Fatalf("MultiCarrierEncode with mixed carrier sizes: %v", err)
+	}
+
+	img, _, err := getCarrierImage(bytes.NewReader(encodedSmall.Bytes()))
+	if err != nil {
+		t.Fatalf("getCarrierImage: %v", err)
+	}
+	header := readHeader(img)
+	if !header.HasLayout || int(header.ChunkOffset) != size-int(header.DataCount)/4 {
+		t.Errorf("small carrier chunk offset %d (layout %v) does not line up with its %d chunks", header.ChunkOffset, header.HasLayout, header.DataCount)
+	}
+
+	var decoded bytes.Buffer
+	if err := MultiCarrierDecode([]io.Reader{&encodedSmall, &encodedLarge}, &decoded, "mixed", Options{}); err != nil {
+		t.Fatalf("MultiCarrierDecode: %v", err)
+	}
+	if !bytes.Equal(decoded.Bytes(), original) {
+		t.Error("mixed carrier size roundtrip mismatch")
+	}
+}
+
+func TestMultiCarrierRejectsOversizedPayloadUpFront(t *testing.T) {
+	carrier := carrierPNGBytes(t, 40, 40, 6901)
+	capacities, err := Capacity([]io.Reader{bytes.NewReader(carrier)}, "", Options{})
+	if err != nil {
+		t.Fatalf("Capacity: %v", err)
+	}
+
+	var result bytes.Buffer
+	data := make([]byte, capacities[0].Bytes+1)
+	err = MultiCarrierEncode([]io.Reader{bytes.NewReader(carrier)}, bytes.NewReader(data), []io.Writer{&result}, 1, "", Options{})
+	var encErr *EncodingError
+	if !errors.As(err, &encErr) || encErr.Type != ErrDataTooLarge.Type {
+		t.Fatalf("expected %s, got %v", ErrDataTooLarge.Type, err)
+	}
+	if result.Len() != 0 {
+		t.Error("nothing should be written when the payload cannot fit")
+	}
+}
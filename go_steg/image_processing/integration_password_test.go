@@ -2,7 +2,6 @@ package image_processing
 
 import (
 	"bytes"
-	"go-steg/cli/helpers"
 	"go-steg/go_steg/pipeline"
 	"os"
 	"path/filepath"
@@ -13,9 +12,6 @@ import (
 // TestPasswordUnicode tests roundtrip with a unicode password containing
 // Cyrillic, emoji, and CJK characters.
 func TestPasswordUnicode(t *testing.T) {
-	helpers.UseMask = true
-	defer func() { helpers.UseMask = false }()
-
 	tmpDir := t.TempDir()
 
 	carrierPath := filepath.Join(tmpDir, "carrier.png")
@@ -44,7 +40,7 @@ func TestPasswordUnicode(t *testing.T) {
 		1,
 		password,
 		encodeOutDir,
-		cfg,
+		Options{UseMask: true, Config: cfg},
 	)
 	if err != nil {
 		t.Fatalf("EncodeByFileNames (unicode password) failed: %v", err)
@@ -64,6 +60,7 @@ func TestPasswordUnicode(t *testing.T) {
 		[]string{embeddedPath},
 		password,
 		decodeOutDir,
+		Options{UseMask: true},
 	)
 	if err != nil {
 		t.Fatalf("MultiCarrierDecodeByFileNames (unicode password) failed: %v", err)
@@ -84,9 +81,6 @@ func TestPasswordUnicode(t *testing.T) {
 
 // TestPasswordVeryLong tests roundtrip with a 10,000-character password.
 func TestPasswordVeryLong(t *testing.T) {
-	helpers.UseMask = true
-	defer func() { helpers.UseMask = false }()
-
 	tmpDir := t.TempDir()
 
 	carrierPath := filepath.Join(tmpDir, "carrier.png")
@@ -115,7 +109,7 @@ func TestPasswordVeryLong(t *testing.T) {
 		1,
 		password,
 		encodeOutDir,
-		cfg,
+		Options{UseMask: true, Config: cfg},
 	)
 	if err != nil {
 		t.Fatalf("EncodeByFileNames (10KB password) failed: %v", err)
@@ -135,6 +129,7 @@ func TestPasswordVeryLong(t *testing.T) {
 		[]string{embeddedPath},
 		password,
 		decodeOutDir,
+		Options{UseMask: true},
 	)
 	if err != nil {
 		t.Fatalf("MultiCarrierDecodeByFileNames (10KB password) failed: %v", err)
@@ -155,9 +150,6 @@ func TestPasswordVeryLong(t *testing.T) {
 
 // TestPasswordNullBytes tests roundtrip with a password containing null bytes.
 func TestPasswordNullBytes(t *testing.T) {
-	helpers.UseMask = true
-	defer func() { helpers.UseMask = false }()
-
 	tmpDir := t.TempDir()
 
 	carrierPath := filepath.Join(tmpDir, "carrier.png")
@@ -196,7 +188,7 @@ func TestPasswordNullBytes(t *testing.T) {
 			1,
 			password,
 			encodeOutDir,
-			cfg,
+			Options{UseMask: true, Config: cfg},
 		)
 	}()
 	if encodeErr != nil {
@@ -226,6 +218,7 @@ func TestPasswordNullBytes(t *testing.T) {
 			[]string{embeddedPath},
 			password,
 			decodeOutDir,
+			Options{UseMask: true},
 		)
 	}()
 	if decodeErr != nil {
@@ -247,9 +240,6 @@ func TestPasswordNullBytes(t *testing.T) {
 
 // TestPasswordWhitespaceOnly tests roundtrip with a whitespace-only password.
 func TestPasswordWhitespaceOnly(t *testing.T) {
-	helpers.UseMask = true
-	defer func() { helpers.UseMask = false }()
-
 	tmpDir := t.TempDir()
 
 	carrierPath := filepath.Join(tmpDir, "carrier.png")
@@ -278,7 +268,7 @@ func TestPasswordWhitespaceOnly(t *testing.T) {
 		1,
 		password,
 		encodeOutDir,
-		cfg,
+		Options{UseMask: true, Config: cfg},
 	)
 	if err != nil {
 		t.Fatalf("EncodeByFileNames (whitespace password) failed: %v", err)
@@ -298,6 +288,7 @@ func TestPasswordWhitespaceOnly(t *testing.T) {
 		[]string{embeddedPath},
 		password,
 		decodeOutDir,
+		Options{UseMask: true},
 	)
 	if err != nil {
 		t.Fatalf("MultiCarrierDecodeByFileNames (whitespace password) failed: %v", err)
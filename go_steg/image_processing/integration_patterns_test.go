@@ -2,7 +2,6 @@ package image_processing
 
 import (
 	"bytes"
-	"go-steg/cli/helpers"
 	"go-steg/go_steg/pipeline"
 	"go-steg/go_steg/reed_solomon"
 	"os"
@@ -11,9 +10,6 @@ import (
 )
 
 func TestDataPatternRoundtrip(t *testing.T) {
-	helpers.UseMask = false
-	defer func() { helpers.UseMask = false }()
-
 	testCases := []struct {
 		name     string
 		makeData func() []byte
@@ -126,7 +122,7 @@ func TestDataPatternRoundtrip(t *testing.T) {
 				1,
 				tc.cfg.Password,
 				encodeOutDir,
-				tc.cfg,
+				Options{Config: tc.cfg},
 			)
 			if err != nil {
 				t.Fatalf("EncodeByFileNames failed: %v", err)
@@ -148,6 +144,7 @@ func TestDataPatternRoundtrip(t *testing.T) {
 				[]string{embeddedPath},
 				tc.cfg.Password,
 				decodeOutDir,
+				Options{},
 			)
 			if err != nil {
 				t.Fatalf("MultiCarrierDecodeByFileNames failed: %v", err)
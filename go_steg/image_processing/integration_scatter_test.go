@@ -0,0 +1,82 @@
+package image_processing
+
+import (
+	"bytes"
+	"go-steg/go_steg/pipeline"
+	"image"
+	"image/png"
+	"io"
+	"testing"
+)
+
+// TestScatterRoundtrip encodes with the scattered order (with and without the mask) and decodes without
+// any options, relying on the header flag to pick the order.
+func TestScatterRoundtrip(t *testing.T) {
+	for _, useMask := range []bool{false, true} {
+		for _, bitDepth := range []int{1, 2, 4} {
+			carrier := carrierPNGBytes(t, 200, 200, 31337)
+			original := bytes.Repeat([]byte("scattered payload "), 30)
+			opts := Options{
+				UseMask: useMask,
+				Scatter: true,
+				Config:  pipeline.Config{BitDepth: bitDepth, FileExtension: "txt"},
+			}
+
+			var encoded bytes.Buffer
+			err := MultiCarrierEncode([]io.Reader{bytes.NewReader(carrier)}, bytes.NewReader(original),
+				[]io.Writer{&encoded}, 1, "scatter-password", opts)
+			if err != nil {
+				t.Fatalf("mask=%v depth=%d: encode failed: %v", useMask, bitDepth, err)
+			}
+
+			var decoded bytes.Buffer
+			if err := MultiCarrierDecode([]io.Reader{bytes.NewReader(encoded.Bytes())}, &decoded, "scatter-password", Options{}); err != nil {
+				t.Fatalf("mask=%v depth=%d: decode failed: %v", useMask, bitDepth, err)
+			}
+			if !bytes.Equal(decoded.Bytes(), original) {
+				t.Errorf("mask=%v depth=%d: decoded data does not match original", useMask, bitDepth)
+			}
+		}
+	}
+}
+
+// TestScatterSpreadsChangesAcrossCarrier checks that a short scattered payload modifies pixels on the
+// right-hand side of the carrier, where the sequential order would never reach.
+func TestScatterSpreadsChangesAcrossCarrier(t *testing.T) {
+	carrier := carrierPNGBytes(t, 200, 200, 4242)
+	original := []byte("a short payload that fits in the first column sequentially")
+
+	changedRightHalf := func(scatter bool) int {
+		var encoded bytes.Buffer
+		opts := Options{Scatter: scatter, Config: pipeline.Config{BitDepth: 2, FileExtension: "txt"}}
+		if err := MultiCarrierEncode([]io.Reader{bytes.NewReader(carrier)}, bytes.NewReader(original),
+			[]io.Writer{&encoded}, 1, "spread", opts); err != nil {
+			t.Fatalf("scatter=%v: encode failed: %v", scatter, err)
+		}
+		before, err := png.Decode(bytes.NewReader(carrier))
+		if err != nil {
+			t.Fatalf("decode carrier: %v", err)
+		}
+		after, err := png.Decode(&encoded)
+		if err != nil {
+			t.Fatalf("decode embedded: %v", err)
+		}
+		changed := 0
+		b := before.Bounds()
+		for x := b.Dx() / 2; x < b.Dx(); x++ {
+			for y := totalReservedPixels; y < b.Dy(); y++ {
+				if before.(*image.RGBA).RGBAAt(x, y) != after.(*image.RGBA).RGBAAt(x, y) {
+					changed++
+				}
+			}
+		}
+		return changed
+	}
+
+	if n := changedRightHalf(false); n != 0 {
+		t.Errorf("sequential order changed %d pixels in the right half, want 0", n)
+	}
+	if n := changedRightHalf(true); n == 0 {
+		t.Error("scattered order changed no pixels in the right half")
+	}
+}
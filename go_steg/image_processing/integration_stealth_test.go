@@ -0,0 +1,116 @@
+package image_processing
+
+import (
+	"bytes"
+	"errors"
+	"go-steg/go_steg/pipeline"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// TestStealthHeaderRoundtrip checks that a carrier set with stealth headers decodes with both encoders and
+// both decoders, and that without the password the carriers show no version marker
+func TestStealthHeaderRoundtrip(t *testing.T) {
+	carriers := [][]byte{carrierPNGBytes(t, 100, 100, 2501), carrierPNGBytes(t, 80, 90, 2502)}
+	data := make([]byte, 3000)
+	rand.New(rand.NewSource(2503)).Read(data)
+	opts := Options{UseMask: true, Scatter: true, StealthHeader: true, Config: pipeline.Config{BitDepth: 2, FileExtension: "bin"}}
+
+	encoders := []struct {
+		name   string
+		encode func([]io.Reader, io.ReadSeeker, []io.Writer, uint64, string, Options) error
+	}{
+		{"MultiCarrierEncode", func(c []io.Reader, d io.ReadSeeker, r []io.Writer, id uint64, p string, o Options) error {
+			return MultiCarrierEncode(c, d, r, id, p, o)
+		}},
+		{"MultiCarrierEncodeStream", MultiCarrierEncodeStream},
+	}
+	for _, enc := range encoders {
+		encoded := []*bytes.Buffer{{}, {}}
+		if err := enc.encode([]io.Reader{bytes.NewReader(carriers[0]), bytes.NewReader(carriers[1])}, bytes.NewReader(data),
+			[]io.Writer{encoded[0], encoded[1]}, 77, "stealth", opts); err != nil {
+			t.Fatalf("%s: %v", enc.name, err)
+		}
+
+		for i, e := range encoded {
+			header, err := ReadHeader(bytes.NewReader(e.Bytes()))
+			if err != nil {
+				t.Fatalf("ReadHeader (%s): %v", enc.name, err)
+			}
+			if header.IsNewFormat || header.Stealth {
+				t.Errorf("%s: carrier %d shows a version %d header without the password", enc.name, i, header.FormatVersion)
+			}
+
+			report, err := InspectCarrier(bytes.NewReader(e.Bytes()), "stealth")
+			if err != nil {
+				t.Fatalf("InspectCarrier (%s): %v", enc.name, err)
+			}
+			if !report.Header.Stealth || report.Header.PhotoID != 77 || report.Header.PhotoNumber != uint16(i) || report.Header.FileExtension != "bin" {
+				t.Errorf("%s: carrier %d stealth header read as %+v", enc.name, i, report.Header)
+			}
+			if len(report.Problems) != 0 {
+				t.Errorf("%s: carrier %d problems: %v", enc.name, i, report.Problems)
+			}
+		}
+
+		// Reverse the carriers to check the stealth headers still order them
+		var decoded bytes.Buffer
+		if err := MultiCarrierDecode([]io.Reader{bytes.NewReader(encoded[1].Bytes()), bytes.NewReader(encoded[0].Bytes())}, &decoded, "stealth", Options{}); err != nil {
+			t.Fatalf("MultiCarrierDecode (%s): %v", enc.name, err)
+		}
+		if !bytes.Equal(decoded.Bytes(), data) {
+			t.Errorf("decoded data does not match the original (%s)", enc.name)
+		}
+		decoded.Reset()
+		if err := MultiCarrierDecodeStream([]io.Reader{bytes.NewReader(encoded[0].Bytes()), bytes.NewReader(encoded[1].Bytes())}, &decoded, "stealth", Options{StealthHeader: true}); err != nil {
+			t.Fatalf("MultiCarrierDecodeStream (%s): %v", enc.name, err)
+		}
+		if !bytes.Equal(decoded.Bytes(), data) {
+			t.Errorf("streamed data does not match the original (%s)", enc.name)
+		}
+	}
+}
+
+// TestStealthHeaderWrongPassword checks that a stealth header is not found with another password
+func TestStealthHeaderWrongPassword(t *testing.T) {
+	carrier := carrierPNGBytes(t, 100, 100, 2511)
+	data := []byte("only for the right password")
+	var encoded bytes.Buffer
+	opts := Options{StealthHeader: true, Config: pipeline.Config{BitDepth: 2}}
+	if err := MultiCarrierEncode([]io.Reader{bytes.NewReader(carrier)}, bytes.NewReader(data), []io.Writer{&encoded}, 1, "right", opts); err != nil {
+		t.Fatalf("MultiCarrierEncode: %v", err)
+	}
+
+	var decoded bytes.Buffer
+	err := MultiCarrierDecodeStream([]io.Reader{bytes.NewReader(encoded.Bytes())}, &decoded, "wrong", Options{StealthHeader: true})
+	var encErr *EncodingError
+	if !errors.As(err, &encErr) || encErr.Type != ErrHeaderNotFound.Type {
+		t.Fatalf("expected a %s, got %v", ErrHeaderNotFound.Type, err)
+	}
+
+	// Without StealthHeader the carrier reads as a legacy carrier, which does not give the data back
+	decoded.Reset()
+	if err := MultiCarrierDecode([]io.Reader{bytes.NewReader(encoded.Bytes())}, &decoded, "wrong", Options{}); err == nil && bytes.Equal(decoded.Bytes(), data) {
+		t.Error("the wrong password decoded the data")
+	}
+}
+
+func TestStealthHeaderRejectedCombinations(t *testing.T) {
+	carrier := carrierPNGBytes(t, 60, 60, 2521)
+	for _, c := range []struct {
+		password string
+		opts     Options
+	}{
+		{"", Options{StealthHeader: true, Config: pipeline.Config{BitDepth: 2}}},
+		{"password", Options{StealthHeader: true, JPEGNative: true, Config: pipeline.Config{BitDepth: 1}}},
+	} {
+		var encoded bytes.Buffer
+		if err := MultiCarrierEncode([]io.Reader{bytes.NewReader(carrier)}, bytes.NewReader([]byte("data")), []io.Writer{&encoded}, 1, c.password, c.opts); err == nil {
+			t.Errorf("options %+v with password %q should be rejected", c.opts, c.password)
+		}
+		if err := MultiCarrierEncodeStream([]io.Reader{bytes.NewReader(carrier)}, bytes.NewReader([]byte("data")), []io.Writer{&encoded}, 1, c.password, c.opts); err == nil {
+			t.Errorf("options %+v with password %q should be rejected by the streaming encoder", c.opts, c.password)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package image_processing
+
+import (
+	"bytes"
+	"errors"
+	"go-steg/go_steg/pipeline"
+	"go-steg/go_steg/reed_solomon"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// streamCarrierSet encodes data across in-memory carriers with MultiCarrierEncodeStream
+func streamCarrierSet(t *testing.T, sizes [][2]int, data []byte, opts Options) [][]byte {
+	t.Helper()
+	carriers := make([]io.Reader, len(sizes))
+	buffers := make([]*bytes.Buffer, len(sizes))
+	results := make([]io.Writer, len(sizes))
+	for i, size := range sizes {
+		carriers[i] = bytes.NewReader(carrierPNGBytes(t, size[0], size[1], int64(7100+i)))
+		buffers[i] = &bytes.Buffer{}
+		results[i] = buffers[i]
+	}
+	if err := MultiCarrierEncodeStream(carriers, bytes.NewReader(data), results, 42, "stream", opts); err != nil {
+		t.Fatalf("MultiCarrierEncodeStream: %v", err)
+	}
+	encoded := make([][]byte, len(buffers))
+	for i, buf := range buffers {
+		encoded[i] = buf.Bytes()
+	}
+	return encoded
+}
+
+func readers(carriers [][]byte) []io.Reader {
+	r := make([]io.Reader, len(carriers))
+	for i, c := range carriers {
+		r[i] = bytes.NewReader(c)
+	}
+	return r
+}
+
+func TestStreamingRoundtrip(t *testing.T) {
+	data := make([]byte, 3000)
+	rand.New(rand.NewSource(71)).Read(data)
+	sizes := [][2]int{{80, 80}, {120, 60}, {60, 100}}
+
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{"plain", Options{Config: pipeline.Config{BitDepth: 2}}},
+		{"bit depth 3 scattered", Options{Scatter: true, Config: pipeline.Config{BitDepth: 3}}},
+		{"masked huffman RS", Options{UseMask: true, Config: pipeline.Config{
+			BitDepth: 4, HuffmanEnabled: true, RSEnabled: true, RSLevel: reed_solomon.Standard,
+		}}},
+		{"encrypted", Options{Config: pipeline.Config{
+			BitDepth: 2, HuffmanEnabled: true, Encrypted: true, RSEnabled: true, RSLevel: reed_solomon.High,
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Config.Password = "stream"
+			encoded := streamCarrierSet(t, sizes, data, tt.opts)
+
+			var streamed bytes.Buffer
+			if err := MultiCarrierDecodeStream(readers(encoded), &streamed, "stream", Options{}); err != nil {
+				t.Fatalf("MultiCarrierDecodeStream: %v", err)
+			}
+			if !bytes.Equal(streamed.Bytes(), data) {
+				t.Error("streaming decode does not match the original data")
+			}
+
+			// The buffered decoder reads streamed carriers too
+			var buffered bytes.Buffer
+			if err := MultiCarrierDecode(readers(encoded), &buffered, "stream", Options{}); err != nil {
+				t.Fatalf("MultiCarrierDecode: %v", err)
+			}
+			if !bytes.Equal(buffered.Bytes(), data) {
+				t.Error("buffered decode does not match the original data")
+			}
+		})
+	}
+}
+
+func TestStreamingDecodesBufferedEncode(t *testing.T) {
+	original := bytes.Repeat([]byte("buffered into streamed "), 40)
+	set := encodeCarrierSet(t, 3, 9, original, 7201)
+
+	var decoded bytes.Buffer
+	if err := MultiCarrierDecodeStream(readers([][]byte{set[1], set[2], set[0]}), &decoded, "set", Options{}); err != nil {
+		t.Fatalf("MultiCarrierDecodeStream: %v", err)
+	}
+	if !bytes.Equal(decoded.Bytes(), original) {
+		t.Error("decoded data does not match the original")
+	}
+}
+
+func TestStreamingEmptyData(t *testing.T) {
+	opts := Options{Config: pipeline.Config{BitDepth: 2, HuffmanEnabled: true, Password: "stream"}}
+	encoded := streamCarrierSet(t, [][2]int{{60, 60}, {60, 60}}, nil, opts)
+
+	var decoded bytes.Buffer
+	if err := MultiCarrierDecodeStream(readers(encoded), &decoded, "stream", Options{}); err != nil {
+		t.Fatalf("MultiCarrierDecodeStream: %v", err)
+	}
+	if decoded.Len() != 0 {
+		t.Errorf("expected no data, got %d bytes", decoded.Len())
+	}
+}
+
+func TestStreamingDetectsCorruptedChunk(t *testing.T) {
+	opts := Options{Config: pipeline.Config{BitDepth: 2}}
+	encoded := streamCarrierSet(t, [][2]int{{100, 100}, {100, 100}}, bytes.Repeat([]byte{0x5A}, 3000), opts)
+	encoded[1] = flipPayloadBits(t, encoded[1])
+
+	// The corrupted chunk is the second one, so the first must not be written either
+	var decoded bytes.Buffer
+	err := MultiCarrierDecodeStream(readers(encoded), &decoded, "stream", Options{})
+	var encErr *EncodingError
+	if !errors.As(err, &encErr) || encErr.Type != ErrIntegrityCheck.Type {
+		t.Fatalf("expected an integrity error, got %v", err)
+	}
+	if decoded.Len() != 0 {
+		t.Errorf("no output should be written for corrupted data, got %d bytes", decoded.Len())
+	}
+}
+
+func TestStreamingCorrectsCorruptedChunk(t *testing.T) {
+	data := bytes.Repeat([]byte("correct me "), 200)
+	opts := Options{Config: pipeline.Config{BitDepth: 2, RSEnabled: true, RSLevel: reed_solomon.High}}
+	encoded := streamCarrierSet(t, [][2]int{{100, 100}, {100, 100}}, data, opts)
+	encoded[1] = flipPayloadBits(t, encoded[1])
+
+	var decoded bytes.Buffer
+	if err := MultiCarrierDecodeStream(readers(encoded), &decoded, "stream", Options{}); err != nil {
+		t.Fatalf("Reed-Solomon protected payload should survive a corrupted byte: %v", err)
+	}
+	if !bytes.Equal(decoded.Bytes(), data) {
+		t.Error("decoded data does not match the original")
+	}
+}
+
+func TestStreamingRejectsOversizedPayload(t *testing.T) {
+	carriers := []io.Reader{bytes.NewReader(carrierPNGBytes(t, 50, 50, 7301))}
+	var out bytes.Buffer
+	err := MultiCarrierEncodeStream(carriers, bytes.NewReader(make([]byte, 5000)), []io.Writer{&out}, 1, "stream", Options{})
+	var encErr *EncodingError
+	if !errors.As(err, &encErr) || encErr.Type != ErrDataTooLarge.Type {
+		t.Fatalf("expected a data size error, got %v", err)
+	}
+	if out.Len() != 0 {
+		t.Error("nothing should be written when the payload does not fit")
+	}
+}
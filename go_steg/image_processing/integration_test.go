@@ -2,7 +2,6 @@ package image_processing
 
 import (
 	"bytes"
-	"go-steg/cli/helpers"
 	"go-steg/go_steg/pipeline"
 	"go-steg/go_steg/reed_solomon"
 	"image"
@@ -66,7 +65,6 @@ func findDecodedFile(t *testing.T, dir, ext string) string {
 
 func TestIntegrationRoundtrip(t *testing.T) {
 	// Disable mask for deterministic, simpler tests.
-	helpers.UseMask = false
 
 	testCases := []struct {
 		name      string
@@ -148,6 +146,20 @@ func TestIntegrationRoundtrip(t *testing.T) {
 			dataExt:  "txt",
 			makeData: func() []byte { return []byte("Bit depth 4 with Huffman and Reed-Solomon encoding applied.") },
 		},
+		{
+			name: "encrypted_huffman_rs",
+			cfg: pipeline.Config{
+				BitDepth:       2,
+				HuffmanEnabled: true,
+				RSEnabled:      true,
+				RSLevel:        reed_solomon.Standard,
+				Encrypted:      true,
+				FileExtension:  "txt",
+				Password:       "encrypt-test",
+			},
+			dataExt:  "txt",
+			makeData: func() []byte { return []byte("Encrypted payload that must come back intact after AEAD and RS.") },
+		},
 		{
 			name: "binary_data_bin",
 			cfg: pipeline.Config{
@@ -193,7 +205,7 @@ func TestIntegrationRoundtrip(t *testing.T) {
 				1,
 				tc.cfg.Password,
 				encodeOutDir,
-				tc.cfg,
+				Options{Config: tc.cfg},
 			)
 			if err != nil {
 				t.Fatalf("EncodeByFileNames failed: %v", err)
@@ -215,6 +227,7 @@ func TestIntegrationRoundtrip(t *testing.T) {
 				[]string{embeddedPath},
 				tc.cfg.Password,
 				decodeOutDir,
+				Options{},
 			)
 			if err != nil {
 				t.Fatalf("MultiCarrierDecodeByFileNames failed: %v", err)
@@ -246,7 +259,6 @@ func TestIntegrationRoundtrip(t *testing.T) {
 func TestIntegrationLegacyCompatibility(t *testing.T) {
 	// Verify that the default config (bit depth 2, no features) encodes and
 	// decodes correctly, preserving backward compatibility.
-	helpers.UseMask = false
 
 	tmpDir := t.TempDir()
 
@@ -275,7 +287,7 @@ func TestIntegrationLegacyCompatibility(t *testing.T) {
 		42,
 		"",
 		encodeOutDir,
-		cfg,
+		Options{Config: cfg},
 	)
 	if err != nil {
 		t.Fatalf("EncodeByFileNames (legacy) failed: %v", err)
@@ -295,6 +307,7 @@ func TestIntegrationLegacyCompatibility(t *testing.T) {
 		[]string{embeddedPath},
 		"",
 		decodeOutDir,
+		Options{},
 	)
 	if err != nil {
 		t.Fatalf("MultiCarrierDecodeByFileNames (legacy) failed: %v", err)
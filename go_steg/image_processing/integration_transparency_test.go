@@ -0,0 +1,150 @@
+package image_processing
+
+import (
+	"bytes"
+	"go-steg/go_steg/pipeline"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// transparentCarrier builds an NRGBA carrier whose payload pixels are a random mix of opaque, translucent
+// and fully transparent ones. The header rows are translucent so the header has to survive as well.
+func transparentCarrier(width, height int, seed int64) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	rng := rand.New(rand.NewSource(seed))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			alpha := uint8(1 + rng.Intn(254))
+			if y >= totalReservedPixels {
+				switch rng.Intn(3) {
+				case 0:
+					alpha = 255
+				case 1:
+					alpha = 0
+				}
+			}
+			img.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(rng.Intn(256)),
+				G: uint8(rng.Intn(256)),
+				B: uint8(rng.Intn(256)),
+				A: alpha,
+			})
+		}
+	}
+	return img
+}
+
+func pngBytes(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode carrier PNG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestTransparentCarrierRoundtrip(t *testing.T) {
+	data := make([]byte, 600)
+	rand.New(rand.NewSource(1301)).Read(data)
+	carrier := pngBytes(t, transparentCarrier(140, 140, 1302))
+
+	for _, opts := range []Options{
+		{Config: pipeline.Config{BitDepth: 2}},
+		{Config: pipeline.Config{BitDepth: 4}},
+		{UseMask: true, Scatter: true, Config: pipeline.Config{BitDepth: 2, RSEnabled: true}},
+		{UseAlpha: true, Config: pipeline.Config{BitDepth: 2, Encrypted: true, Password: "integrity"}},
+	} {
+		encoded := encodeToBytes(t, carrier, data, opts)
+
+		var decoded bytes.Buffer
+		if err := MultiCarrierDecode([]io.Reader{bytes.NewReader(encoded)}, &decoded, "integrity", Options{}); err != nil {
+			t.Fatalf("MultiCarrierDecode with %+v: %v", opts, err)
+		}
+		if !bytes.Equal(decoded.Bytes(), data) {
+			t.Errorf("decoded data does not match the original with %+v", opts)
+		}
+
+		decoded.Reset()
+		if err := MultiCarrierDecodeStream([]io.Reader{bytes.NewReader(encoded)}, &decoded, "integrity", Options{}); err != nil {
+			t.Fatalf("MultiCarrierDecodeStream with %+v: %v", opts, err)
+		}
+		if !bytes.Equal(decoded.Bytes(), data) {
+			t.Errorf("streamed data does not match the original with %+v", opts)
+		}
+	}
+}
+
+func TestTransparentPixelsUntouched(t *testing.T) {
+	original := transparentCarrier(80, 80, 1303)
+	data := make([]byte, 400)
+	rand.New(rand.NewSource(1304)).Read(data)
+	encoded := encodeToBytes(t, pngBytes(t, original), data, Options{UseAlpha: true, Config: pipeline.Config{BitDepth: 2}})
+
+	img, err := png.Decode(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatalf("png.Decode: %v", err)
+	}
+	result, ok := img.(*image.NRGBA)
+	if !ok {
+		t.Fatalf("encoded carrier decoded as %T, want *image.NRGBA", img)
+	}
+	for y := totalReservedPixels; y < 80; y++ {
+		for x := 0; x < 80; x++ {
+			before, after := original.NRGBAAt(x, y), result.NRGBAAt(x, y)
+			if before.A == 0 && before != after {
+				t.Fatalf("transparent pixel (%d, %d) changed from %v to %v", x, y, before, after)
+			}
+			if before.A != 255 && before.A != after.A {
+				t.Fatalf("translucent pixel (%d, %d) alpha changed from %d to %d", x, y, before.A, after.A)
+			}
+		}
+	}
+}
+
+func TestTransparentCapacity(t *testing.T) {
+	img := transparentCarrier(60, 50, 1305)
+	var visible int64
+	for y := totalReservedPixels; y < 50; y++ {
+		for x := 0; x < 60; x++ {
+			if img.NRGBAAt(x, y).A != 0 {
+				visible++
+			}
+		}
+	}
+
+	capacities, err := Capacity([]io.Reader{bytes.NewReader(pngBytes(t, img))}, "", Options{Config: pipeline.Config{BitDepth: 2}})
+	if err != nil {
+		t.Fatalf("Capacity: %v", err)
+	}
+	if got := capacities[0].Slots; got != visible*channelsPerPixel {
+		t.Errorf("got %d slots, want %d: transparent pixels must not count", got, visible*channelsPerPixel)
+	}
+}
+
+func TestToNRGBAKeepsTranslucentColors(t *testing.T) {
+	src := transparentCarrier(10, 40, 1306)
+	sub := src.SubImage(image.Rect(2, 3, 9, 40)).(*image.NRGBA)
+	got := toNRGBA(sub)
+	if got.Bounds() != image.Rect(0, 0, 7, 37) {
+		t.Fatalf("bounds = %v, want the sub-image moved to the origin", got.Bounds())
+	}
+	for y := 0; y < 37; y++ {
+		for x := 0; x < 7; x++ {
+			if got.NRGBAAt(x, y) != src.NRGBAAt(x+2, y+3) {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got.NRGBAAt(x, y), src.NRGBAAt(x+2, y+3))
+			}
+		}
+	}
+
+	// A translucent 16-bit pixel keeps the high byte of every channel
+	deep := image.NewNRGBA64(image.Rect(0, 0, 1, 1))
+	deep.SetNRGBA64(0, 0, color.NRGBA64{R: 201<<8 | 5, G: 77 << 8, B: 13<<8 | 255, A: 40 << 8})
+	want := color.NRGBA{R: 201, G: 77, B: 13, A: 40}
+	if c := toNRGBA(deep).NRGBAAt(0, 0); c != want {
+		t.Errorf("16-bit pixel = %v, want %v", c, want)
+	}
+}
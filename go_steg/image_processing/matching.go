@@ -0,0 +1,69 @@
+package image_processing
+
+import (
+	mathrand "math/rand"
+)
+
+// LSB replacement overwrites the low bits of a sample, so at bit depth 1 a value only ever turns into the
+// other one of its pair (2k and 2k+1), which evens out how often the two occur. That asymmetry is what the
+// chi-square and RS attacks detect. LSB matching instead moves the sample to the nearest value holding the
+// payload bits, up or down: at bit depth 1 both are one step away and the password picks the direction.
+// Decode reads the low bits the same way either way.
+//
+// A move out of the block of 2^bitDepth values the sample started in changes the bits above the payload
+// bits, which the mask and the alpha rule look at. Such a move is only kept when it stays within the values
+// the sample can take and the slot still carries payload afterwards; otherwise the sample falls back to
+// replacement, which never changes those bits, so decode finds the same slots.
+
+// matcher writes payload bits into the slots of one carrier
+type matcher struct {
+	embed embedding
+	// rng picks the direction of LSB matching moves, nil when the low bits are replaced
+	rng *mathrand.Rand
+}
+
+// matcher returns the matcher for the carrier with the given part number. Every part gets its own
+// password-keyed direction stream.
+func (e embedding) matcher(part uint16) matcher {
+	m := matcher{embed: e}
+	if e.matching {
+		m.rng = mathrand.New(mathrand.NewSource(int64(e.mask.matchSeed + uint64(part))))
+	}
+	return m
+}
+
+// write stores value in the low bitDepth bits of a slot that carries payload
+func (m matcher) write(img *carrierImage, sl slot, value byte) {
+	m.writeBits(img, sl, m.embed.bitDepth, value)
+}
+
+// writeBits stores value in the low n bits of a slot that carries payload
+func (m matcher) writeBits(img *carrierImage, sl slot, n int, value byte) {
+	if m.rng == nil {
+		img.setLastBits(sl.x, sl.y, sl.channel, n, value)
+		return
+	}
+	current, lowest, highest := img.matchRange(sl.x, sl.y, sl.channel)
+	size := 1 << n
+	low := current & (size - 1)
+	if low == int(value) {
+		return
+	}
+
+	// replaced keeps the bits above the payload bits; other is the value with the same low bits in the
+	// neighbouring block, on the opposite side of current
+	replaced := current - low + int(value)
+	other := replaced + size
+	if replaced > current {
+		other = replaced - size
+	}
+	near := max(low-int(value), int(value)-low)
+	far := size - near
+	if other >= lowest && other <= highest && (far < near || far == near && m.rng.Intn(2) == 1) {
+		img.setSampleValue(sl.x, sl.y, sl.channel, other)
+		if m.embed.carries(img, sl) {
+			return
+		}
+	}
+	img.setSampleValue(sl.x, sl.y, sl.channel, replaced)
+}
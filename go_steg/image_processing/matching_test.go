@@ -0,0 +1,70 @@
+package image_processing
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestMatcherWrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    uint8
+		bitDepth int
+		payload  byte
+		want     []uint8 // every value the matcher may move the sample to
+	}{
+		{"low bit already set", 7, 1, 1, []uint8{7}},
+		{"even up or down", 6, 1, 1, []uint8{5, 7}},
+		{"odd up or down", 7, 1, 0, []uint8{6, 8}},
+		{"zero only goes up", 0, 1, 1, []uint8{1}},
+		{"255 only goes down", 255, 1, 0, []uint8{254}},
+		{"nearest at bit depth 2", 4, 2, 3, []uint8{3}},
+		{"replacement is nearer", 4, 2, 1, []uint8{5}},
+		{"tie at bit depth 2", 4, 2, 2, []uint8{2, 6}},
+		{"nearest is out of range", 255, 2, 0, []uint8{252}},
+	}
+	for _, tt := range tests {
+		seen := map[uint8]bool{}
+		for part := range uint16(32) {
+			nrgba := image.NewNRGBA(image.Rect(0, 0, 1, totalReservedPixels+1))
+			nrgba.SetNRGBA(0, totalReservedPixels, color.NRGBA{R: tt.value, A: 255})
+			img := newCarrierImage(nrgba)
+			embed := embedding{bitDepth: tt.bitDepth, matching: true, mask: generateMaskingInfo("matching")}
+
+			embed.matcher(part).write(img, slot{y: totalReservedPixels}, tt.payload)
+			got := nrgba.NRGBAAt(0, totalReservedPixels).R
+			if got&(1<<tt.bitDepth-1) != tt.payload {
+				t.Fatalf("%s: low bits of %d are not %d", tt.name, got, tt.payload)
+			}
+			seen[got] = true
+		}
+		if len(seen) != len(tt.want) {
+			t.Errorf("%s: moved to %v, want %v", tt.name, seen, tt.want)
+		}
+		for _, v := range tt.want {
+			if !seen[v] {
+				t.Errorf("%s: never moved to %d (got %v)", tt.name, v, seen)
+			}
+		}
+	}
+}
+
+// TestMatcherKeepsSelection checks that a move into the neighbouring block that would drop the slot from
+// the mask or the alpha rule is replaced by plain replacement.
+func TestMatcherKeepsSelection(t *testing.T) {
+	nrgba := image.NewNRGBA(image.Rect(0, 0, 1, totalReservedPixels+1))
+	nrgba.SetNRGBA(0, totalReservedPixels, color.NRGBA{A: 252})
+	img := newCarrierImage(nrgba)
+	embed := embedding{bitDepth: 2, alpha: true, matching: true}
+	sl := slot{y: totalReservedPixels, channel: alphaChannel}
+
+	// 251 is nearer to 252 than 255, but no longer nearly opaque
+	embed.matcher(0).write(img, sl, 3)
+	if got := nrgba.NRGBAAt(0, totalReservedPixels).A; got != 255 {
+		t.Errorf("alpha = %d, want 255", got)
+	}
+	if !embed.carries(img, sl) {
+		t.Error("the alpha channel stopped carrying payload")
+	}
+}
@@ -0,0 +1,148 @@
+package image_processing
+
+// Matrix embedding (Crandall's Hamming code construction, as used by F5) hides k payload bits in the low bits
+// of a group of n = 2^k-1 slots by changing at most one of them. The k bits are the syndrome of the group:
+// the XOR of the 1-based positions of the slots whose low bit is set. To embed a value the encoder XORs it
+// with the syndrome the group already has and flips the low bit at the resulting position, or leaves the
+// group alone when that is zero. Writing k bits one per slot changes k/2 slots on average; a group changes
+// one slot with probability 1-2^-k. The price is n/k slots per bit instead of one, so encode picks the
+// largest k the payload still fits with.
+//
+// Matrix embedding only applies at bit depth 1. The flip goes through the matcher, so it composes with LSB
+// matching, and it never changes the bits the mask and the alpha rule look at, so decode finds the same
+// groups.
+
+// maxMatrixK is the largest code parameter encode picks. Larger codes save little: at k = 7 a group of 127
+// slots already changes less than once per 7 bits.
+const maxMatrixK = 7
+
+// matrixGroupSize returns the number of slots a group of the code with parameter k takes
+func matrixGroupSize(k int) int {
+	return 1<<k - 1
+}
+
+// matrixBytes returns how many whole payload bytes the given number of slots holds with parameter k
+func matrixBytes(slots int64, k int) int64 {
+	return slots / int64(matrixGroupSize(k)) * int64(k) / 8
+}
+
+// chooseMatrixK returns the largest code parameter with which a payload of the given size fits into the
+// carriers, along with their capacities at it. When it does not even fit at k = 1 it returns 1 and the
+// capacities as they are, and encoding reports the payload as too large.
+func chooseMatrixK(size int64, capacities []CarrierCapacity) (uint8, []CarrierCapacity) {
+	for k := maxMatrixK; k > 1; k-- {
+		scaled := make([]CarrierCapacity, len(capacities))
+		for i, c := range capacities {
+			scaled[i] = c
+			scaled[i].Bytes = matrixBytes(c.Slots, k)
+		}
+		if Fits(scaled, size) {
+			return uint8(k), scaled
+		}
+	}
+	return 1, capacities
+}
+
+// syndrome returns the XOR of the 1-based positions of the slots in group whose low bit is set
+func syndrome(img *carrierImage, group []slot) int {
+	s := 0
+	for i, sl := range group {
+		if img.lastBits(sl.x, sl.y, sl.channel, 1) == 1 {
+			s ^= i + 1
+		}
+	}
+	return s
+}
+
+// writeGroup embeds value into a full group of slots, flipping the low bit of at most one of them
+func (m matcher) writeGroup(img *carrierImage, group []slot, value int) {
+	position := syndrome(img, group) ^ value
+	if position == 0 {
+		return
+	}
+	sl := group[position-1]
+	m.write(img, sl, img.lastBits(sl.x, sl.y, sl.channel, 1)^1)
+}
+
+// groupWriter collects payload bits into k-bit values and embeds each one into the next group of slots. It is
+// the bitWriter of matrix embedding.
+type groupWriter struct {
+	matcher matcher
+	img     *carrierImage
+	// next returns the next slot of img that carries payload
+	next  func() (slot, error)
+	group []slot
+	value int
+	bits  int
+}
+
+// newGroupWriter returns a groupWriter for the code parameter of m's embedding
+func newGroupWriter(m matcher, img *carrierImage, next func() (slot, error)) *groupWriter {
+	return &groupWriter{matcher: m, img: img, next: next, group: make([]slot, 0, matrixGroupSize(m.embed.matrixK))}
+}
+
+// writeBit adds one payload bit, embedding the group once it holds k of them
+func (w *groupWriter) writeBit(bit byte) error {
+	w.value = w.value<<1 | int(bit&1)
+	w.bits++
+	if w.bits == w.matcher.embed.matrixK {
+		return w.flush()
+	}
+	return nil
+}
+
+// flush embeds the bits collected so far into the next group, padding them with zero bits to k. The first
+// bit goes into the most significant bit of the syndrome.
+func (w *groupWriter) flush() error {
+	if w.bits == 0 {
+		return nil
+	}
+	k := w.matcher.embed.matrixK
+	group := w.group[:0]
+	for len(group) < matrixGroupSize(k) {
+		sl, err := w.next()
+		if err != nil {
+			return err
+		}
+		group = append(group, sl)
+	}
+	w.matcher.writeGroup(w.img, group, w.value<<(k-w.bits))
+	w.value, w.bits = 0, 0
+	return nil
+}
+
+// extractGroups reads dataCount payload bits back out of the groups of a matrix embedded carrier, one bit
+// per byte as extractChunk expects them
+func extractGroups(img *carrierImage, dataCount int, embed embedding) []byte {
+	k := embed.matrixK
+	bits := make([]byte, 0, dataCount)
+	group := make([]slot, 0, matrixGroupSize(k))
+	for sl := range embed.slots(img) {
+		if len(bits) >= dataCount {
+			break
+		}
+		if !embed.carries(img, sl) {
+			continue
+		}
+		group = append(group, sl)
+		if len(group) < matrixGroupSize(k) {
+			continue
+		}
+		s := syndrome(img, group)
+		for i := k - 1; i >= 0 && len(bits) < dataCount; i-- {
+			bits = append(bits, byte(s>>i&1))
+		}
+		group = group[:0]
+	}
+	return bits
+}
+
+// slotsUsed returns how many payload slots dataCount chunks take up: one each, or whole groups of the code
+// with matrix embedding
+func (e embedding) slotsUsed(dataCount int64) int64 {
+	if e.matrixK == 0 {
+		return dataCount
+	}
+	k := int64(e.matrixK)
+	return (dataCount + k - 1) / k * int64(matrixGroupSize(e.matrixK))
+}
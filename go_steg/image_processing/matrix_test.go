@@ -0,0 +1,64 @@
+package image_processing
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+// TestMatrixWriteGroup embeds every value into groups of every code size and checks the syndrome reads it
+// back with at most one sample changed
+func TestMatrixWriteGroup(t *testing.T) {
+	rng := rand.New(rand.NewSource(2001))
+	for k := 1; k <= maxMatrixK; k++ {
+		n := matrixGroupSize(k)
+		for value := 0; value < 1<<k; value++ {
+			nrgba := image.NewNRGBA(image.Rect(0, 0, n, totalReservedPixels+1))
+			group := make([]slot, n)
+			for x := range group {
+				nrgba.SetNRGBA(x, totalReservedPixels, color.NRGBA{R: uint8(rng.Intn(256)), A: 255})
+				group[x] = slot{x: x, y: totalReservedPixels}
+			}
+			before := append([]uint8(nil), nrgba.Pix...)
+			img := newCarrierImage(nrgba)
+			embed := embedding{bitDepth: 1, matrixK: k}
+
+			embed.matcher(0).writeGroup(img, group, value)
+			if got := syndrome(img, group); got != value {
+				t.Fatalf("k = %d: embedded %d, syndrome is %d", k, value, got)
+			}
+			changed := 0
+			for i := range before {
+				if before[i] != nrgba.Pix[i] {
+					changed++
+				}
+			}
+			if changed > 1 {
+				t.Fatalf("k = %d: embedding %d changed %d samples", k, value, changed)
+			}
+		}
+	}
+}
+
+func TestChooseMatrixK(t *testing.T) {
+	capacities := []CarrierCapacity{{Slots: 20000, Bytes: 2500}, {Slots: 10000, Bytes: 1250}}
+	tests := []struct {
+		size int64
+		want uint8
+	}{
+		{3750, 1},
+		{2000, 2},
+		{100, 7},
+		{500, 5},
+	}
+	for _, tt := range tests {
+		k, scaled := chooseMatrixK(tt.size, capacities)
+		if k != tt.want {
+			t.Errorf("size %d: k = %d, want %d", tt.size, k, tt.want)
+		}
+		if !Fits(scaled, tt.size) {
+			t.Errorf("size %d does not fit the capacities at k = %d", tt.size, k)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package image_processing
+
+import (
+	"fmt"
+	"go-steg/go_steg/pipeline"
+)
+
+// Options holds the per-call settings for encoding and decoding. Nothing in here is shared between calls, so
+// carriers with different settings can be encoded or decoded concurrently from the same process.
+type Options struct {
+	// UseMask enables the password-derived indiscernibility mask when choosing which channels carry data
+	UseMask bool
+	// MaskDensity is the share of channel values the mask selects, in eighths from 1 to 6. Fewer channels
+	// spread a small payload more thinly, more leave room for a large one. 0 keeps the index pair rule, which
+	// selects about a quarter or three quarters of them depending on the password.
+	MaskDensity uint8
+	// Scatter spreads the payload over the whole carrier in a password-keyed pseudorandom order instead of
+	// packing it into the left-hand columns
+	Scatter bool
+	// UseAlpha adds the alpha channel as a fourth payload channel on pixels that are nearly opaque, so
+	// changing its low bits leaves the image looking the same. A carrier that was fully opaque comes out with
+	// an alpha channel that varies slightly, so it is only allowed up to maxAlphaBitDepth.
+	UseAlpha bool
+	// LSBMatching moves a sample up or down to the nearest value holding the payload bits, picking the
+	// direction with the password when both are as near, instead of overwriting its low bits
+	LSBMatching bool
+	// MatrixEmbedding hides the payload with a Hamming code, k bits in the low bits of 2^k-1 channels with at
+	// most one of them changed, picking the largest k the payload fits with. It needs bit depth 1.
+	MatrixEmbedding bool
+	// Adaptive embeds by texture: channels in smooth regions carry nothing, moderately textured ones one bit
+	// and busy ones the full bit depth. It cannot be combined with LSBMatching, MatrixEmbedding or JPEGNative.
+	Adaptive bool
+	// AdaptiveThresholds are the texture levels, on a 0-255 scale, at which a channel starts to carry one bit
+	// and the full bit depth. Leaving both at zero picks the defaults.
+	AdaptiveThresholds [2]uint8
+	// JPEGNative embeds JPEG carriers in their quantized DCT coefficients and writes them back as JPEGs,
+	// instead of embedding in their pixels and writing them as PNGs
+	JPEGNative bool
+	// StealthHeader writes the header whitened at password-chosen places in the reserved rows instead of in
+	// column 0, so the carrier holds no fixed marker. It needs a password and cannot be combined with
+	// JPEGNative.
+	StealthHeader bool
+	// Config holds the bit depth and the pipeline (Huffman, Reed-Solomon) settings
+	Config pipeline.Config
+}
+
+// bitDepth returns the configured bit depth, falling back to 2 when it is outside the supported 1-4 range.
+func (o Options) bitDepth() int {
+	if o.Config.BitDepth < 1 || o.Config.BitDepth > 4 {
+		return 2
+	}
+	return o.Config.BitDepth
+}
+
+// validate checks the options for combinations encoding cannot honour
+func (o Options) validate() error {
+	if o.MatrixEmbedding && o.bitDepth() != 1 {
+		return fmt.Errorf("matrix embedding needs bit depth 1, not %d", o.bitDepth())
+	}
+	if o.Adaptive && (o.LSBMatching || o.MatrixEmbedding || o.JPEGNative) {
+		return fmt.Errorf("adaptive embedding cannot be combined with LSB matching, matrix embedding or JPEG-native embedding")
+	}
+	if o.UseAlpha && o.bitDepth() > maxAlphaBitDepth {
+		return fmt.Errorf("alpha channel embedding needs bit depth %d or less, not %d", maxAlphaBitDepth, o.bitDepth())
+	}
+	if o.StealthHeader && o.JPEGNative {
+		return fmt.Errorf("a stealth header cannot be combined with JPEG-native embedding")
+	}
+	if o.MaskDensity > maxMaskDensity {
+		return fmt.Errorf("mask density %d/8 is above the maximum of %d/8", o.MaskDensity, maxMaskDensity)
+	}
+	if t := o.adaptiveThresholds(); o.Adaptive && t[0] > t[1] {
+		return fmt.Errorf("adaptive embedding thresholds %v are out of order", t)
+	}
+	return nil
+}
+
+// validatePassword checks that the password can key the settings that need one
+func (o Options) validatePassword(password string) error {
+	if o.StealthHeader && password == "" {
+		return fmt.Errorf("a stealth header needs a password")
+	}
+	return nil
+}
+
+// adaptiveThresholds returns the configured adaptive embedding thresholds, or the defaults when both are zero
+func (o Options) adaptiveThresholds() [2]uint8 {
+	if o.AdaptiveThresholds == [2]uint8{} {
+		return defaultAdaptiveThresholds
+	}
+	return o.AdaptiveThresholds
+}
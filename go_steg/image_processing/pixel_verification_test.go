@@ -2,7 +2,6 @@ package image_processing
 
 import (
 	"bytes"
-	"go-steg/cli/helpers"
 	"go-steg/go_steg/bit_manipulation"
 	"go-steg/go_steg/pipeline"
 	"go-steg/go_steg/reed_solomon"
@@ -20,8 +19,6 @@ import (
 // from R/G/B channels, and verifies they match the expected pipeline-encoded
 // data chunks.
 func TestPixelLSBVerification(t *testing.T) {
-	helpers.UseMask = false
-
 	testCases := []struct {
 		name string
 		cfg  pipeline.Config
@@ -75,7 +72,7 @@ func TestPixelLSBVerification(t *testing.T) {
 				42,
 				"",
 				outputDir,
-				tc.cfg,
+				Options{Config: tc.cfg},
 			)
 			if err != nil {
 				t.Fatalf("EncodeByFileNames failed: %v", err)
@@ -156,8 +153,6 @@ func TestPixelLSBVerification(t *testing.T) {
 // readHeader() on the image, and verifies all header fields match what was
 // encoded.
 func TestHeaderFieldsVerification(t *testing.T) {
-	helpers.UseMask = false
-
 	testCases := []struct {
 		name          string
 		cfg           pipeline.Config
@@ -218,7 +213,7 @@ func TestHeaderFieldsVerification(t *testing.T) {
 				tc.photoID,
 				"",
 				outputDir,
-				tc.cfg,
+				Options{Config: tc.cfg},
 			)
 			if err != nil {
 				t.Fatalf("EncodeByFileNames failed: %v", err)
@@ -236,14 +231,14 @@ func TestHeaderFieldsVerification(t *testing.T) {
 				t.Fatalf("failed to decode embedded PNG: %v", err)
 			}
 			bounds := img.Bounds()
-			rgbaImg := image.NewRGBA(bounds)
+			rgbaImg := image.NewNRGBA(bounds)
 			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
 				for x := bounds.Min.X; x < bounds.Max.X; x++ {
 					rgbaImg.Set(x, y, img.At(x, y))
 				}
 			}
 
-			header := readHeader(rgbaImg)
+			header := readHeader(newCarrierImage(rgbaImg))
 
 			// Compute expected values
 			pipelineOutput, err := pipeline.Encode(tc.data, tc.cfg)
@@ -307,8 +302,6 @@ func TestHeaderFieldsVerification(t *testing.T) {
 // data into it, and verifies that the upper bits (above bit depth) of each
 // pixel remain unchanged.
 func TestUpperBitsPreserved(t *testing.T) {
-	helpers.UseMask = false
-
 	testCases := []struct {
 		name     string
 		bitDepth int
@@ -389,7 +382,7 @@ func TestUpperBitsPreserved(t *testing.T) {
 				123,
 				"",
 				outputDir,
-				cfg,
+				Options{Config: cfg},
 			)
 			if err != nil {
 				t.Fatalf("EncodeByFileNames failed: %v", err)
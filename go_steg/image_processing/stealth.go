@@ -0,0 +1,194 @@
+package image_processing
+
+// The header in column 0 starts with a fixed version marker, so anyone can tell a go-steg carrier from any
+// other image without knowing the password. A stealth header holds the same header record, but nothing of it
+// sits at a fixed place or in the clear. Each copy is built like a protected header copy, the packed header
+// samples and the extension record as one Reed-Solomon codeword, except that it ends in a keyed tag instead
+// of a CRC-32. The codewords are XORed with an AES-CTR keystream and their 2-bit values written to reserved
+// samples in a shuffled order, so the low bits of the reserved rows look like noise and column 0 keeps
+// whatever the cover image had there.
+//
+// The keystream, the order and the tag key are derived from the password and from a nonce: the SHA-256 of
+// every reserved sample with the two bits the header writes cleared. Two carriers embedded with the same
+// password therefore get different positions and keystreams, and the decoder, which sees the same high
+// bits, derives them again. Decode tries the stealth header first and takes a copy to be a header only when
+// its tag verifies; without the password a stealth carrier reads as a legacy carrier with garbage in it.
+// JPEG-native carriers have too few header coefficients for a stealth header.
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"go-steg/go_steg/bit_manipulation"
+	"go-steg/go_steg/reed_solomon"
+)
+
+// stealthTagLen is the size of the keyed tag at the end of the data part of a stealth header copy
+const stealthTagLen = 8
+
+// stealthHeaderKey derives the key of a password's stealth headers from the password hash
+func stealthHeaderKey(hashedPassword []byte) [32]byte {
+	var key [32]byte
+	copy(key[:], keyedHash(hashedPassword, []byte("stealth header")))
+	return key
+}
+
+// keyedHash returns the HMAC-SHA256 of the concatenated parts under key
+func keyedHash(key []byte, parts ...[]byte) []byte {
+	h := hmac.New(sha256.New, key)
+	for _, part := range parts {
+		h.Write(part)
+	}
+	return h.Sum(nil)
+}
+
+// stealthLayout is where and how the stealth header copies of one carrier are written
+type stealthLayout struct {
+	copies int
+	// positions holds the reserved sample of every 2-bit value of the copies, copy after copy
+	positions []uint32
+	// keystream holds the bytes every codeword byte is XORed with, copy after copy
+	keystream []byte
+	tagKey    []byte
+}
+
+// newStealthLayout derives the stealth header layout of a carrier from the mask's header key and the high
+// bits of the carrier's reserved samples. It holds no copies when the reserved rows are too small for one.
+func newStealthLayout(img *carrierImage, mask Mask) stealthLayout {
+	reserved := img.reservedSamples()
+	layout := stealthLayout{copies: min(headerCopies, reserved/(headerCopyLen*4))}
+	if layout.copies == 0 {
+		return layout
+	}
+
+	nonce := sha256.New()
+	var buf [2]byte
+	for i := range reserved {
+		x, y, channel := img.reservedSample(i)
+		binary.BigEndian.PutUint16(buf[:], img.sample(x, y, channel)>>2)
+		nonce.Write(buf[:])
+	}
+	sum := nonce.Sum(nil)
+
+	seed := keyedHash(mask.headerKey[:], []byte("positions"), sum)
+	layout.positions = shuffledOrder(reserved, binary.BigEndian.Uint64(seed))[:layout.copies*headerCopyLen*4]
+
+	block, err := aes.NewCipher(keyedHash(mask.headerKey[:], []byte("keystream"), sum))
+	if err != nil {
+		// A 32 byte key is always a valid AES key
+		panic(err)
+	}
+	layout.keystream = make([]byte, layout.copies*headerCopyLen)
+	cipher.NewCTR(block, make([]byte, aes.BlockSize)).XORKeyStream(layout.keystream, layout.keystream)
+
+	layout.tagKey = keyedHash(mask.headerKey[:], []byte("tag"), sum)
+	return layout
+}
+
+// tag returns the keyed tag of the data part of a stealth header copy
+func (l stealthLayout) tag(data []byte) []byte {
+	return keyedHash(l.tagKey, data[:headerCopyDataLen-stealthTagLen])[:stealthTagLen]
+}
+
+// position returns the reserved sample that holds 2-bit value k of byte j of copy i
+func (l stealthLayout) position(i, j, k int) int {
+	return int(l.positions[(i*headerCopyLen+j)*4+k])
+}
+
+// writeStealthHeader writes info as a stealth header keyed by mask, leaving column 0 as it is
+func writeStealthHeader(img *carrierImage, info HeaderInfo, mask Mask) error {
+	if img.dct != nil {
+		return wrapError(nil, ErrHeaderSpace, "JPEG-native carriers cannot hold a stealth header")
+	}
+	layout := newStealthLayout(img, mask)
+	if layout.copies == 0 {
+		return wrapError(nil, ErrHeaderSpace, fmt.Sprintf("carrier width %d too small for a stealth header", img.Bounds().Dx()))
+	}
+
+	info.HeaderCopies = uint8(layout.copies)
+	samples := make(headerSampleValues, headerSamples)
+	encodeHeaderSamples(samples, info, versionMarkerV2Bytes)
+	data, err := packHeaderRecord(headerRecord{samples: samples, extension: encodeExtension(info)}, stealthTagLen)
+	if err != nil {
+		return err
+	}
+	copy(data[headerCopyDataLen-stealthTagLen:], layout.tag(data))
+
+	codeword, err := reed_solomon.EncodeCodeword(data, headerCopyParity)
+	if err != nil {
+		return err
+	}
+	for i := range layout.copies {
+		for j, b := range codeword {
+			for k, q := range bit_manipulation.SplitByteIntoQuarters(b ^ layout.keystream[i*headerCopyLen+j]) {
+				img.setHeaderSample(layout.position(i, j, k), q)
+			}
+		}
+	}
+	return nil
+}
+
+// readCopy reads and repairs stealth header copy i, returning the header it holds and the number of
+// bytes Reed-Solomon corrected
+func (l stealthLayout) readCopy(img *carrierImage, i int) (headerRecord, int, error) {
+	codeword := make([]byte, headerCopyLen)
+	for j := range codeword {
+		var quarters [4]byte
+		for k := range quarters {
+			quarters[k] = img.headerSample(l.position(i, j, k))
+		}
+		codeword[j] = bit_manipulation.ConstructByteFromQuartersAsSlice(quarters[:]) ^ l.keystream[i*headerCopyLen+j]
+	}
+	data, corrected, err := reed_solomon.DecodeCodeword(codeword, headerCopyParity)
+	if err != nil {
+		return headerRecord{}, 0, err
+	}
+	if !hmac.Equal(data[headerCopyDataLen-stealthTagLen:], l.tag(data)) {
+		return headerRecord{}, 0, fmt.Errorf("stealth header copy fails its tag check")
+	}
+	record, err := unpackHeaderRecord(data, stealthTagLen)
+	return record, corrected, err
+}
+
+// readStealthHeader reads the stealth header keyed by mask, reporting whether any copy of it verified
+func readStealthHeader(img *carrierImage, mask Mask) (HeaderInfo, bool) {
+	if img.dct != nil {
+		return HeaderInfo{}, false
+	}
+	layout := newStealthLayout(img, mask)
+	copies, damage, unreadable := readCopies(layout.copies, func(i int) (headerRecord, int, error) {
+		return layout.readCopy(img, i)
+	})
+	if len(copies) == 0 {
+		return HeaderInfo{}, false
+	}
+	record, votes := voteCopies(copies, unreadable)
+	info := parseHeader(record)
+	info.Stealth = true
+	info.HeaderDamage = append(damage, votes...)
+	return info, true
+}
+
+// writeCarrierHeader writes the header of a carrier, as a stealth header keyed by mask when info.Stealth is
+// set and in column 0 otherwise
+func writeCarrierHeader(img *carrierImage, info HeaderInfo, mask Mask) error {
+	if info.Stealth {
+		return writeStealthHeader(img, info, mask)
+	}
+	return writeHeader(img, info)
+}
+
+// readCarrierHeader reads the stealth header keyed by mask, or the header in column 0 when the carrier has
+// none. With opts.StealthHeader set a carrier without a stealth header is an error instead.
+func readCarrierHeader(img *carrierImage, mask Mask, opts Options) (HeaderInfo, error) {
+	if info, ok := readStealthHeader(img, mask); ok {
+		return info, nil
+	}
+	if opts.StealthHeader {
+		return HeaderInfo{}, wrapError(nil, ErrHeaderNotFound, "the carrier has no stealth header or the password is wrong")
+	}
+	return readHeader(img), nil
+}
@@ -0,0 +1,408 @@
+package image_processing
+
+import (
+	"bytes"
+	"fmt"
+	"go-steg/go_steg/bit_manipulation"
+	"go-steg/go_steg/pipeline"
+	"hash"
+	"hash/crc32"
+	"io"
+	"iter"
+)
+
+// MultiCarrierEncodeStream does the same job as MultiCarrierEncode without holding the payload in memory.
+// The data is read twice: once to measure the size of every pipeline stage, since the Huffman and
+// Reed-Solomon stages write their input length first, and once to stream it through the pipeline
+// straight into the carriers. Only the decoded carrier images are held in memory. With encryption enabled
+// the payload is sealed in chunks, see pipeline.NewEncoder.
+func MultiCarrierEncodeStream(carriers []io.Reader, data io.ReadSeeker, results []io.Writer, uniquePhotoID uint64, password string, opts Options) error {
+	if len(carriers) > maxCarriers {
+		return wrapError(nil, ErrCarrierSet, fmt.Sprintf("%d carriers given, at most %d are supported", len(carriers), maxCarriers))
+	}
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	if err := opts.validatePassword(password); err != nil {
+		return err
+	}
+
+	images, formats, err := loadCarriers(carriers, opts)
+	if err != nil {
+		return err
+	}
+
+	sizes, err := pipeline.MeasureSizes(data, opts.Config)
+	if err != nil {
+		return fmt.Errorf("error measuring data: %w", err)
+	}
+
+	//Generate the mask information, moving on to the next candidate while the mask selects too few slots
+	mask, capacities, totalCapacity := chooseMask(images, sizes.Output, generateMaskingInfo(password), opts)
+	if sizes.Output > totalCapacity {
+		return wrapError(nil, ErrDataTooLarge, fmt.Sprintf("%d byte payload, carriers hold %d bytes", sizes.Output, totalCapacity))
+	}
+	if _, err := data.Seek(0, io.SeekStart); err != nil {
+		return wrapError(err, ErrIOOperation, "rewinding data")
+	}
+
+	var matrixK uint8
+	if opts.MatrixEmbedding {
+		matrixK, capacities = chooseMatrixK(sizes.Output, capacities)
+		logger.Debugf("Matrix embedding with k = %v", matrixK)
+	}
+
+	split := splitSizes(int(sizes.Output), capacities)
+	embedder := newCarrierEmbedder(images, split, mask, opts)
+	embedder.embed.matrixK = int(matrixK)
+	defer embedder.finish()
+	encoder, err := pipeline.NewEncoder(embedder, sizes, opts.Config)
+	if err != nil {
+		return fmt.Errorf("error in pipeline encode: %w", err)
+	}
+	dataHash := crc32.NewIEEE()
+	if _, err := io.Copy(encoder, io.TeeReader(data, dataHash)); err != nil {
+		return fmt.Errorf("error in pipeline encode: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return fmt.Errorf("error in pipeline encode: %w", err)
+	}
+	embedder.finish()
+	if embedder.written != sizes.Output {
+		return wrapError(nil, ErrIOOperation, fmt.Sprintf("pipeline wrote %d bytes, measured %d; was the data changed during encoding?", embedder.written, sizes.Output))
+	}
+
+	payload := PayloadInfo{
+		Checksum:     computeChecksum(embedder.head),
+		ByteCountMod: uint16(sizes.Output % 4096),
+		Length:       uint32(sizes.Output),
+		CRC32:        embedder.payloadHash.Sum32(),
+		DataCRC32:    dataHash.Sum32(),
+		TotalParts:   uint16(len(carriers)),
+		MatrixK:      matrixK,
+	}
+	start := 0
+	for i, img := range images {
+		logger.Debugf("Picture number - %v - Data count for encoding - %v", i, embedder.dataCounts[i])
+		payload.ChunkOffset = uint32(start)
+		header := newHeaderInfo(uint16(i), uniquePhotoID, embedder.dataCounts[i], embedder.chunkCRCs[i], img.model, opts, payload)
+		header.MaskCandidate = mask.number
+		if err := writeCarrierHeader(img, header, mask); err != nil {
+			return fmt.Errorf("error encoding chunk with index %d: %w", i, err)
+		}
+		if err := writeCarrier(img, formats[i], results[i]); err != nil {
+			return fmt.Errorf("error encoding chunk with index %d: %w", i, err)
+		}
+		start += split[i]
+	}
+	return nil
+}
+
+// carrierEmbedder is the writer at the end of the streaming pipeline. It embeds the pipeline output into the
+// carriers in order, moving on to the next carrier once its share is in, and keeps the counts and checksums
+// that go into the headers.
+type carrierEmbedder struct {
+	images []*carrierImage
+	sizes  []int
+	embed  embedding
+
+	current   int
+	remaining int
+	matcher   matcher
+	bits      bitWriter
+	nextSlot  func() (slot, bool)
+	stopSlots func()
+	chunkHash hash.Hash32
+
+	dataCounts  []uint32
+	chunkCRCs   []uint32
+	payloadHash hash.Hash32
+	head        []byte
+	written     int64
+}
+
+// newCarrierEmbedder returns an embedder that writes sizes[i] bytes into images[i]
+func newCarrierEmbedder(images []*carrierImage, sizes []int, mask Mask, opts Options) *carrierEmbedder {
+	return &carrierEmbedder{
+		images:      images,
+		sizes:       sizes,
+		embed:       opts.embedding(mask),
+		current:     -1,
+		chunkHash:   crc32.NewIEEE(),
+		dataCounts:  make([]uint32, len(images)),
+		chunkCRCs:   make([]uint32, len(images)),
+		payloadHash: crc32.NewIEEE(),
+	}
+}
+
+// finishCarrier records the chunk CRC of the current carrier and releases its slot iterator
+func (e *carrierEmbedder) finishCarrier() {
+	if e.current < 0 || e.current >= len(e.images) {
+		return
+	}
+	e.chunkCRCs[e.current] = e.chunkHash.Sum32()
+	if e.stopSlots != nil {
+		e.stopSlots()
+		e.stopSlots = nil
+	}
+}
+
+// nextCarrier moves on to the next carrier that should receive data
+func (e *carrierEmbedder) nextCarrier() error {
+	e.finishCarrier()
+	e.current++
+	if e.current >= len(e.images) {
+		return wrapError(nil, ErrDataTooLarge, "pipeline produced more data than was measured")
+	}
+	img := e.images[e.current]
+	e.remaining = e.sizes[e.current]
+	e.chunkHash.Reset()
+	e.matcher = e.embed.matcher(uint16(e.current))
+	e.nextSlot, e.stopSlots = iter.Pull(e.embed.slots(img))
+	e.bits = e.embed.bitWriter(e.matcher, img, func() (slot, error) { return e.nextChannel(img) })
+	return nil
+}
+
+// Write embeds p, splitting every byte over as many channel slots as the bit depth needs.
+func (e *carrierEmbedder) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		for e.remaining == 0 {
+			if err := e.nextCarrier(); err != nil {
+				return n, err
+			}
+		}
+		img := e.images[e.current]
+		part := p[:min(len(p), e.remaining)]
+		for _, b := range part {
+			for _, chunk := range bit_manipulation.SplitByte(b, e.embed.chunkBits()) {
+				if err := e.writeChunk(img, chunk); err != nil {
+					return n, err
+				}
+				e.dataCounts[e.current]++
+			}
+		}
+		// The bits a bitWriter still holds for a carrier are embedded before moving on, padded if need be
+		if e.bits != nil && e.remaining == len(part) {
+			if err := e.bits.flush(); err != nil {
+				return n, err
+			}
+		}
+		e.chunkHash.Write(part)
+		e.payloadHash.Write(part)
+		e.head = append(e.head, part[:min(len(part), 4-len(e.head))]...)
+		e.remaining -= len(part)
+		e.written += int64(len(part))
+		n += len(part)
+		p = p[len(part):]
+	}
+	return n, nil
+}
+
+// writeChunk embeds one chunk into the next slot of the current carrier, or hands it to the bitWriter of
+// matrix or adaptive embedding
+func (e *carrierEmbedder) writeChunk(img *carrierImage, chunk byte) error {
+	if e.bits != nil {
+		return e.bits.writeBit(chunk)
+	}
+	sl, err := e.nextChannel(img)
+	if err != nil {
+		return err
+	}
+	e.matcher.write(img, sl, chunk)
+	return nil
+}
+
+// nextChannel returns the next slot of the current carrier that carries payload
+func (e *carrierEmbedder) nextChannel(img *carrierImage) (slot, error) {
+	for {
+		sl, ok := e.nextSlot()
+		if !ok {
+			return slot{}, wrapError(nil, ErrDataTooLarge, fmt.Sprintf("carrier for part %d is full", e.current))
+		}
+		if e.embed.carries(img, sl) {
+			return sl, nil
+		}
+	}
+}
+
+// finish closes off the carrier being written. Carriers after it get no data.
+func (e *carrierEmbedder) finish() {
+	e.finishCarrier()
+	e.current = len(e.images)
+}
+
+// MultiCarrierDecodeStream does the same job as MultiCarrierDecode without holding the payload in memory.
+// The carriers are decoded and ordered up front, then every chunk is extracted once to check it against the
+// chunk CRC-32 in its header, and the payload length and CRC-32 are checked, before anything is written.
+// Only then is each chunk extracted again, passed through the pipeline and written to result, one carrier at
+// a time.
+//
+// A payload that fails its CRC checks is only decoded when Reed-Solomon correction is enabled. Its decoded
+// data is then held in memory and written to result once it matches the CRC-32 of the original data.
+// Carriers that record no checksums are written as they are decoded.
+func MultiCarrierDecodeStream(carriers []io.Reader, result io.Writer, password string, opts Options) error {
+	mask := generateMaskingInfo(password)
+
+	parts := make([]carrierPart, 0, len(carriers))
+	for i, carrier := range carriers {
+		img, _, err := getCarrierImage(carrier)
+		if err != nil {
+			logger.Errorf("Error decoding chunk: %v", err)
+			return fmt.Errorf("error decoding chunk with index %d: %v", i, err)
+		}
+		header, err := readCarrierHeader(img, mask, opts)
+		if err != nil {
+			return fmt.Errorf("error decoding chunk with index %d: %w", i, err)
+		}
+		parts = append(parts, carrierPart{index: i, header: header, img: img})
+	}
+
+	logHeaderDamage(parts)
+	if err := orderCarrierParts(parts); err != nil {
+		return err
+	}
+	firstHeader := parts[0].header
+
+	extractor, err := newCarrierExtractor(parts, mask, opts)
+	if err != nil {
+		return err
+	}
+	damaged, err := extractor.verify()
+	if err != nil {
+		return err
+	}
+
+	var decoded io.Reader = extractor
+	if firstHeader.IsNewFormat {
+		decoded = pipeline.NewDecoder(extractor, pipeline.Config{
+			BitDepth:       firstHeader.BitDepth,
+			HuffmanEnabled: firstHeader.HuffmanEnabled,
+			RSEnabled:      firstHeader.RSEnabled,
+			RSLevel:        firstHeader.RSLevel,
+			Encrypted:      firstHeader.Encrypted,
+			Password:       password,
+		})
+	}
+
+	// Data corrected by Reed-Solomon is only trusted once its CRC-32 matches
+	out := result
+	var held bytes.Buffer
+	if damaged {
+		out = &held
+	}
+	dataHash := crc32.NewIEEE()
+	if _, err := io.Copy(io.MultiWriter(out, dataHash), decoded); err != nil {
+		return fmt.Errorf("error in pipeline decode: %w", err)
+	}
+	if firstHeader.HasIntegrity && dataHash.Sum32() != firstHeader.DataCRC32 {
+		return wrapError(nil, ErrIntegrityCheck, "CRC-32 of the decoded data does not match the header")
+	}
+	if damaged {
+		if _, err := held.WriteTo(result); err != nil {
+			logger.Errorf("Error writing result file: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// carrierExtractor reads the pipeline output back out of ordered carriers, one chunk at a time
+type carrierExtractor struct {
+	parts []carrierPart
+	mask  Mask
+	opts  Options
+	// total is the payload length the carrier headers add up to
+	total int64
+
+	next  int
+	chunk []byte
+}
+
+// newCarrierExtractor checks the settings of every carrier and works out how many bytes each one holds
+func newCarrierExtractor(parts []carrierPart, mask Mask, opts Options) (*carrierExtractor, error) {
+	e := &carrierExtractor{parts: parts, mask: mask, opts: opts}
+	for _, part := range parts {
+		embed, err := headerEmbedding(part.header, opts, mask)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding chunk with index %d: %v", part.index, err)
+		}
+		per := chunksPerByte(embed.chunkBits())
+		e.total += (int64(part.header.DataCount) + per - 1) / per
+	}
+	return e, nil
+}
+
+// verify extracts every chunk without keeping it and checks the chunk offsets and CRC-32s and the payload
+// length and CRC-32 against the headers. It reports whether the payload failed a CRC check that was left to
+// Reed-Solomon correction.
+func (e *carrierExtractor) verify() (bool, error) {
+	first := e.parts[0].header
+	if err := verifyPayloadLength(e.total, first); err != nil {
+		return false, err
+	}
+
+	damaged := false
+	payloadHash := crc32.NewIEEE()
+	var offset int64
+	for _, part := range e.parts {
+		header := part.header
+		if header.HasLayout && int64(header.ChunkOffset) != offset {
+			return false, wrapError(nil, ErrIntegrityCheck, fmt.Sprintf("chunk of part %d starts at byte %d, header records %d",
+				header.PhotoNumber, offset, header.ChunkOffset))
+		}
+		chunk, err := e.extract(part)
+		if err != nil {
+			return false, err
+		}
+		sum := crc32.ChecksumIEEE(chunk)
+		if err := verifyChunkCRC(sum, header, part.index); err != nil {
+			return false, err
+		}
+		damaged = damaged || header.HasIntegrity && sum != header.ChunkCRC32
+		payloadHash.Write(chunk)
+		offset += int64(len(chunk))
+	}
+
+	if err := verifyPayloadLength(offset, first); err != nil {
+		return false, err
+	}
+	if err := verifyPayloadCRC(payloadHash.Sum32(), first); err != nil {
+		return false, err
+	}
+	return damaged || first.HasIntegrity && payloadHash.Sum32() != first.PayloadCRC32, nil
+}
+
+// extract extracts the chunk of one carrier
+func (e *carrierExtractor) extract(part carrierPart) ([]byte, error) {
+	embed, err := headerEmbedding(part.header, e.opts, e.mask)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debugf("Data count for this carrier: %v", part.header.DataCount)
+	if part.header.IsNewFormat {
+		return extractChunk(part.img, int(part.header.DataCount), embed), nil
+	}
+	return decodeLegacy(part.img, int(part.header.DataCount), e.mask, embed.useMask), nil
+}
+
+// Read returns extracted payload bytes, moving through the carriers in part order and dropping each carrier
+// image once its chunk is out.
+func (e *carrierExtractor) Read(p []byte) (int, error) {
+	for len(e.chunk) == 0 {
+		if e.next == len(e.parts) {
+			return 0, io.EOF
+		}
+		part := &e.parts[e.next]
+		e.next++
+		chunk, err := e.extract(*part)
+		if err != nil {
+			return 0, err
+		}
+		part.img = nil
+		e.chunk = chunk
+	}
+	n := copy(p, e.chunk)
+	e.chunk = e.chunk[n:]
+	return n, nil
+}
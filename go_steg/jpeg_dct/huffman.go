@@ -0,0 +1,103 @@
+package jpeg_dct
+
+import (
+	"fmt"
+)
+
+// huffmanTable is a Huffman table as stored in a DHT segment: the number of codes of every length from 1 to
+// 16 bits and the symbols in code order. The codes themselves follow from those, as described in annex C of
+// the JPEG standard.
+type huffmanTable struct {
+	counts  [16]int
+	symbols []byte
+
+	// Decoding tables, indexed by code length: the smallest and largest code of every length, and the index
+	// in symbols of the symbol of the smallest one. maxCode is -1 for lengths without codes.
+	minCode, maxCode [17]int32
+	valPtr           [17]int
+
+	// Encoding tables, indexed by symbol. A size of 0 means the symbol has no code.
+	code [256]uint16
+	size [256]uint8
+}
+
+// parseHuffmanTables reads the tables of a DHT segment payload, calling set for each one.
+func parseHuffmanTables(payload []byte, set func(class, id byte, t *huffmanTable) error) error {
+	for len(payload) > 0 {
+		if len(payload) < 17 {
+			return fmt.Errorf("%w: short DHT segment", ErrFormat)
+		}
+		class, id := payload[0]>>4, payload[0]&0x0F
+		if class > 1 || id > 3 {
+			return fmt.Errorf("%w: bad Huffman table %d of class %d", ErrFormat, id, class)
+		}
+		t := &huffmanTable{}
+		total := 0
+		for i := range t.counts {
+			t.counts[i] = int(payload[1+i])
+			total += t.counts[i]
+		}
+		if total > 256 || len(payload) < 17+total {
+			return fmt.Errorf("%w: bad Huffman table %d of class %d", ErrFormat, id, class)
+		}
+		t.symbols = payload[17 : 17+total]
+		if err := t.build(); err != nil {
+			return err
+		}
+		if err := set(class, id, t); err != nil {
+			return err
+		}
+		payload = payload[17+total:]
+	}
+	return nil
+}
+
+// build derives the decoding and encoding tables from the code counts and symbols
+func (t *huffmanTable) build() error {
+	code, k := int32(0), 0
+	for length := 1; length <= 16; length++ {
+		n := t.counts[length-1]
+		t.valPtr[length] = k
+		t.minCode[length] = code
+		t.maxCode[length] = -1
+		if n > 0 {
+			t.maxCode[length] = code + int32(n) - 1
+		}
+		for i := 0; i < n; i++ {
+			t.code[t.symbols[k]] = uint16(code)
+			t.size[t.symbols[k]] = uint8(length)
+			code++
+			k++
+		}
+		if code > 1<<length {
+			return fmt.Errorf("%w: Huffman table has more codes than fit", ErrFormat)
+		}
+		code <<= 1
+	}
+	return nil
+}
+
+// decode reads one Huffman coded symbol
+func (t *huffmanTable) decode(r *bitReader) (byte, error) {
+	code := int32(0)
+	for length := 1; length <= 16; length++ {
+		bit, err := r.bit()
+		if err != nil {
+			return 0, err
+		}
+		code = code<<1 | int32(bit)
+		if code <= t.maxCode[length] {
+			return t.symbols[t.valPtr[length]+int(code-t.minCode[length])], nil
+		}
+	}
+	return 0, fmt.Errorf("%w: bad Huffman code", ErrFormat)
+}
+
+// encode writes the Huffman code of symbol
+func (t *huffmanTable) encode(w *bitWriter, symbol byte) error {
+	if t.size[symbol] == 0 {
+		return fmt.Errorf("jpeg_dct: symbol %#02x has no code in the scan's Huffman table", symbol)
+	}
+	w.writeBits(uint32(t.code[symbol]), int(t.size[symbol]))
+	return nil
+}
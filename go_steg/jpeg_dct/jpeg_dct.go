@@ -0,0 +1,109 @@
+// Package jpeg_dct reads and writes the quantized DCT coefficients of baseline JPEG images. The standard
+// library only decodes JPEGs to pixels, which runs the inverse DCT and rounds the result, so coefficients
+// changed by a steganographic embedder cannot be recovered from it.
+//
+// Decode keeps every marker segment of the file, and Encode writes them back unchanged with the scans
+// Huffman coded again from the coefficients, using the same quantization and Huffman tables. An image that is
+// decoded and encoded again without changes decodes to the same pixels.
+package jpeg_dct
+
+import (
+	"errors"
+)
+
+// BlockSize is the number of coefficients in an 8x8 block
+const BlockSize = 64
+
+// ErrUnsupported is returned for JPEGs that are valid but not baseline sequential Huffman coded JPEGs with
+// 8-bit samples, such as progressive or arithmetic coded ones.
+var ErrUnsupported = errors.New("jpeg_dct: only baseline sequential JPEGs are supported")
+
+// ErrFormat is returned for data that is not a well-formed JPEG.
+var ErrFormat = errors.New("jpeg_dct: invalid JPEG")
+
+// Image holds the quantized DCT coefficients of a JPEG along with everything needed to write it back.
+type Image struct {
+	Width, Height int
+	Components    []Component
+	// Coefficients holds the coefficients of every coded block, BlockSize per block in zigzag order, so index
+	// 0 of every block is its DC coefficient. Components follow each other in the order they are first coded,
+	// and the blocks of a component are stored row by row.
+	Coefficients []int32
+
+	segments []segment
+	// trailer holds whatever follows the end of image marker
+	trailer []byte
+}
+
+// Component describes one color component of the image and where its blocks are in Coefficients.
+type Component struct {
+	ID byte
+	// H and V are the horizontal and vertical sampling factors
+	H, V int
+	// BlocksWide and BlocksHigh are the dimensions of the grid of coded blocks
+	BlocksWide, BlocksHigh int
+	// Offset is the index in Coefficients of the component's first coefficient
+	Offset int
+
+	quant byte
+}
+
+// Block returns the coefficients of the block in column bx and row by of the component's block grid.
+func (m *Image) Block(component, bx, by int) []int32 {
+	c := &m.Components[component]
+	start := c.Offset + (by*c.BlocksWide+bx)*BlockSize
+	return m.Coefficients[start : start+BlockSize]
+}
+
+// segment is one marker segment of the file. Scans keep their SOS segment in raw and the state needed to
+// code them again in scan.
+type segment struct {
+	raw  []byte
+	scan *scan
+}
+
+// scan is a start of scan segment with the tables and restart interval in effect when it was read
+type scan struct {
+	components []scanComponent
+	restart    int
+}
+
+// scanComponent is one component of a scan and the Huffman tables it is coded with
+type scanComponent struct {
+	index  int
+	dc, ac *huffmanTable
+}
+
+// Marker codes
+const (
+	markerSOF0 = 0xC0 // baseline
+	markerSOF1 = 0xC1 // extended sequential, Huffman coded
+	markerDHT  = 0xC4
+	markerRST0 = 0xD0
+	markerRST7 = 0xD7
+	markerSOI  = 0xD8
+	markerEOI  = 0xD9
+	markerSOS  = 0xDA
+	markerDQT  = 0xDB
+	markerDNL  = 0xDC
+	markerDRI  = 0xDD
+)
+
+// mcuGrid returns the number of MCU columns and rows of an interleaved scan
+func (m *Image) mcuGrid() (wide, high int) {
+	hmax, vmax := m.maxSampling()
+	return ceilDiv(m.Width, 8*hmax), ceilDiv(m.Height, 8*vmax)
+}
+
+// maxSampling returns the largest horizontal and vertical sampling factors of the image
+func (m *Image) maxSampling() (h, v int) {
+	for _, c := range m.Components {
+		h, v = max(h, c.H), max(v, c.V)
+	}
+	return h, v
+}
+
+// ceilDiv returns a/b rounded up
+func ceilDiv(a, b int) int {
+	return (a + b - 1) / b
+}
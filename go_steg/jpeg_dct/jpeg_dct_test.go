@@ -0,0 +1,192 @@
+package jpeg_dct
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// testJPEG encodes a noisy gradient with the standard library encoder, which writes a baseline JPEG with 4:2:0
+// chroma subsampling for color images
+func testJPEG(t *testing.T, width, height int, gray bool, quality int, seed int64) []byte {
+	t.Helper()
+	rng := rand.New(rand.NewSource(seed))
+	var img image.Image
+	if gray {
+		g := image.NewGray(image.Rect(0, 0, width, height))
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				g.SetGray(x, y, color.Gray{Y: uint8(x*3 + y + rng.Intn(40))})
+			}
+		}
+		img = g
+	} else {
+		c := image.NewRGBA(image.Rect(0, 0, width, height))
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				c.SetRGBA(x, y, color.RGBA{R: uint8(x*2 + rng.Intn(50)), G: uint8(y*2 + rng.Intn(50)), B: uint8(rng.Intn(256)), A: 255})
+			}
+		}
+		img = c
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatalf("jpeg.Encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func encodeBytes(t *testing.T, img *Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := img.Encode(&buf); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundtripUnchanged(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		gray          bool
+		quality       int
+	}{
+		{"color", 64, 48, false, 75},
+		{"color odd size", 37, 23, false, 90},
+		{"gray", 40, 40, true, 75},
+		{"gray odd size", 17, 9, true, 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := testJPEG(t, tt.width, tt.height, tt.gray, tt.quality, 1701)
+			img, err := Decode(bytes.NewReader(original))
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if img.Width != tt.width || img.Height != tt.height {
+				t.Errorf("size = %dx%d, want %dx%d", img.Width, img.Height, tt.width, tt.height)
+			}
+			if got := encodeBytes(t, img); !bytes.Equal(got, original) {
+				t.Error("re-encoded JPEG differs from the original")
+			}
+		})
+	}
+}
+
+// TestChangedCoefficients checks that changed coefficients are written and read back, and that the result
+// decodes with the standard library.
+func TestChangedCoefficients(t *testing.T) {
+	img, err := Decode(bytes.NewReader(testJPEG(t, 64, 64, false, 80, 1702)))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	changed := 0
+	for i, v := range img.Coefficients {
+		if i%BlockSize != 0 && (v >= 2 || v <= -2) {
+			img.Coefficients[i] = v ^ 1
+			changed++
+		}
+	}
+	if changed == 0 {
+		t.Fatal("no coefficients to change")
+	}
+	encoded := encodeBytes(t, img)
+
+	again, err := Decode(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !slices.Equal(again.Coefficients, img.Coefficients) {
+		t.Error("coefficients read back differ from the ones written")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(encoded)); err != nil {
+		t.Errorf("jpeg.Decode: %v", err)
+	}
+}
+
+// TestRestartInterval adds a restart interval to a JPEG and checks that the restart markers are written and
+// read back in the right places.
+func TestRestartInterval(t *testing.T) {
+	original := testJPEG(t, 70, 50, false, 75, 1703)
+	img, err := Decode(bytes.NewReader(original))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	for i, seg := range img.segments {
+		if seg.scan != nil {
+			seg.scan.restart = 3
+			dri := segment{raw: []byte{0xFF, markerDRI, 0, 4, 0, 3}}
+			img.segments = slices.Insert(img.segments, i, dri)
+			break
+		}
+	}
+	encoded := encodeBytes(t, img)
+	if !bytes.Contains(encoded, []byte{0xFF, markerRST0 + 1}) {
+		t.Fatal("no restart markers written")
+	}
+
+	again, err := Decode(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !slices.Equal(again.Coefficients, img.Coefficients) {
+		t.Error("coefficients read back differ from the ones written")
+	}
+	want, _ := jpeg.Decode(bytes.NewReader(original))
+	got, err := jpeg.Decode(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatalf("jpeg.Decode: %v", err)
+	}
+	if !bytes.Equal(got.(*image.YCbCr).Y, want.(*image.YCbCr).Y) {
+		t.Error("restart markers changed the decoded pixels")
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	valid := testJPEG(t, 16, 16, true, 75, 1704)
+	progressive := bytes.Replace(valid, []byte{0xFF, markerSOF0}, []byte{0xFF, 0xC2}, 1)
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"not a JPEG", []byte("\x89PNG\r\n\x1a\n"), ErrFormat},
+		{"truncated", valid[:len(valid)/2], ErrFormat},
+		{"progressive", progressive, ErrUnsupported},
+	}
+	for _, tt := range tests {
+		if _, err := Decode(bytes.NewReader(tt.data)); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestMagnitude(t *testing.T) {
+	tests := []struct {
+		v     int32
+		size  int
+		value uint32
+	}{
+		{0, 0, 0},
+		{1, 1, 1},
+		{-1, 1, 0},
+		{2, 2, 2},
+		{-3, 2, 0},
+		{-2, 2, 1},
+		{255, 8, 255},
+		{-255, 8, 0},
+		{1023, 10, 1023},
+	}
+	for _, tt := range tests {
+		if size, value := magnitude(tt.v); size != tt.size || value != tt.value {
+			t.Errorf("magnitude(%d) = %d, %d, want %d, %d", tt.v, size, value, tt.size, tt.value)
+		}
+	}
+}
@@ -0,0 +1,381 @@
+package jpeg_dct
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// decoder holds the state of Decode as it walks through the marker segments of a file
+type decoder struct {
+	data []byte
+	pos  int
+	img  *Image
+
+	dc, ac    [4]*huffmanTable
+	restart   int
+	frameRead bool
+	// coded records which components have had their blocks allocated by a scan
+	coded []bool
+}
+
+// Decode reads a baseline JPEG and returns its quantized DCT coefficients. Progressive, lossless, arithmetic
+// coded and 12-bit JPEGs are rejected with ErrUnsupported.
+func Decode(r io.Reader) (*Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	d := &decoder{data: data, img: &Image{}}
+	if err := d.decode(); err != nil {
+		return nil, err
+	}
+	return d.img, nil
+}
+
+func (d *decoder) decode() error {
+	if len(d.data) < 2 || d.data[0] != 0xFF || d.data[1] != markerSOI {
+		return fmt.Errorf("%w: missing start of image marker", ErrFormat)
+	}
+	d.pos = 2
+	for {
+		marker, err := d.nextMarker()
+		if err != nil {
+			return err
+		}
+		if marker == markerEOI {
+			d.img.trailer = d.data[d.pos:]
+			break
+		}
+		if marker == 0x01 || (marker >= markerRST0 && marker <= markerRST7) {
+			return fmt.Errorf("%w: unexpected marker %#02x", ErrFormat, marker)
+		}
+		if d.pos+2 > len(d.data) {
+			return fmt.Errorf("%w: truncated segment", ErrFormat)
+		}
+		length := int(binary.BigEndian.Uint16(d.data[d.pos:]))
+		if length < 2 || d.pos+length > len(d.data) {
+			return fmt.Errorf("%w: truncated segment", ErrFormat)
+		}
+		raw := d.data[d.pos-2 : d.pos+length]
+		payload := d.data[d.pos+2 : d.pos+length]
+		d.pos += length
+
+		seg := segment{raw: raw}
+		switch {
+		case marker == markerSOF0 || marker == markerSOF1:
+			err = d.readFrame(payload)
+		case marker >= 0xC2 && marker <= 0xCF && marker != markerDHT && marker != 0xC8:
+			// Progressive, lossless, hierarchical and arithmetic coded frames
+			err = ErrUnsupported
+		case marker == markerDHT:
+			err = parseHuffmanTables(payload, func(class, id byte, t *huffmanTable) error {
+				if class == 0 {
+					d.dc[id] = t
+				} else {
+					d.ac[id] = t
+				}
+				return nil
+			})
+		case marker == markerDRI:
+			if len(payload) != 2 {
+				return fmt.Errorf("%w: bad DRI segment", ErrFormat)
+			}
+			d.restart = int(binary.BigEndian.Uint16(payload))
+		case marker == markerDNL:
+			err = ErrUnsupported
+		case marker == markerSOS:
+			seg.scan, err = d.readScanHeader(payload)
+			if err == nil {
+				err = d.readScan(seg.scan)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		d.img.segments = append(d.img.segments, seg)
+	}
+	if !d.frameRead {
+		return fmt.Errorf("%w: no frame header", ErrFormat)
+	}
+	return nil
+}
+
+// nextMarker reads the marker at the current position, skipping fill bytes, and returns its code
+func (d *decoder) nextMarker() (byte, error) {
+	if d.pos >= len(d.data) || d.data[d.pos] != 0xFF {
+		return 0, fmt.Errorf("%w: expected a marker at byte %d", ErrFormat, d.pos)
+	}
+	for d.pos < len(d.data) && d.data[d.pos] == 0xFF {
+		d.pos++
+	}
+	if d.pos >= len(d.data) {
+		return 0, fmt.Errorf("%w: missing end of image marker", ErrFormat)
+	}
+	marker := d.data[d.pos]
+	d.pos++
+	return marker, nil
+}
+
+// readFrame reads a baseline or extended sequential frame header
+func (d *decoder) readFrame(payload []byte) error {
+	if d.frameRead {
+		return fmt.Errorf("%w: more than one frame", ErrUnsupported)
+	}
+	if len(payload) < 6 {
+		return fmt.Errorf("%w: short frame header", ErrFormat)
+	}
+	if payload[0] != 8 {
+		return fmt.Errorf("%w: %d-bit samples", ErrUnsupported, payload[0])
+	}
+	d.img.Height = int(binary.BigEndian.Uint16(payload[1:]))
+	d.img.Width = int(binary.BigEndian.Uint16(payload[3:]))
+	if d.img.Height == 0 {
+		return fmt.Errorf("%w: height defined by a DNL marker", ErrUnsupported)
+	}
+	if d.img.Width == 0 {
+		return fmt.Errorf("%w: zero width", ErrFormat)
+	}
+	n := int(payload[5])
+	if n == 0 || len(payload) != 6+3*n {
+		return fmt.Errorf("%w: bad frame header", ErrFormat)
+	}
+	for i := 0; i < n; i++ {
+		c := payload[6+3*i:]
+		h, v := int(c[1]>>4), int(c[1]&0x0F)
+		if h < 1 || h > 4 || v < 1 || v > 4 || c[2] > 3 {
+			return fmt.Errorf("%w: bad frame component", ErrFormat)
+		}
+		d.img.Components = append(d.img.Components, Component{ID: c[0], H: h, V: v, quant: c[2]})
+	}
+	d.coded = make([]bool, n)
+	d.frameRead = true
+	return nil
+}
+
+// readScanHeader reads a start of scan segment and allocates the blocks of the components it codes
+func (d *decoder) readScanHeader(payload []byte) (*scan, error) {
+	if !d.frameRead {
+		return nil, fmt.Errorf("%w: scan before the frame header", ErrFormat)
+	}
+	if len(payload) < 1 {
+		return nil, fmt.Errorf("%w: short scan header", ErrFormat)
+	}
+	n := int(payload[0])
+	if n < 1 || n > 4 || len(payload) != 4+2*n {
+		return nil, fmt.Errorf("%w: bad scan header", ErrFormat)
+	}
+	if ss, se, a := payload[1+2*n], payload[2+2*n], payload[3+2*n]; ss != 0 || se != 63 || a != 0 {
+		return nil, fmt.Errorf("%w: spectral selection or successive approximation", ErrUnsupported)
+	}
+
+	s := &scan{restart: d.restart}
+	for i := 0; i < n; i++ {
+		id, tables := payload[1+2*i], payload[2+2*i]
+		index := -1
+		for j, c := range d.img.Components {
+			if c.ID == id {
+				index = j
+			}
+		}
+		if index < 0 {
+			return nil, fmt.Errorf("%w: scan codes unknown component %d", ErrFormat, id)
+		}
+		if d.coded[index] {
+			return nil, fmt.Errorf("%w: component %d coded twice", ErrFormat, id)
+		}
+		td, ta := tables>>4, tables&0x0F
+		if td > 3 || ta > 3 || d.dc[td] == nil || d.ac[ta] == nil {
+			return nil, fmt.Errorf("%w: scan uses an undefined Huffman table", ErrFormat)
+		}
+		s.components = append(s.components, scanComponent{index: index, dc: d.dc[td], ac: d.ac[ta]})
+	}
+
+	hmax, vmax := d.img.maxSampling()
+	mcusWide, mcusHigh := d.img.mcuGrid()
+	for _, sc := range s.components {
+		c := &d.img.Components[sc.index]
+		if n == 1 {
+			c.BlocksWide = ceilDiv(ceilDiv(d.img.Width*c.H, hmax), 8)
+			c.BlocksHigh = ceilDiv(ceilDiv(d.img.Height*c.V, vmax), 8)
+		} else {
+			c.BlocksWide, c.BlocksHigh = mcusWide*c.H, mcusHigh*c.V
+		}
+		c.Offset = len(d.img.Coefficients)
+		d.img.Coefficients = append(d.img.Coefficients, make([]int32, c.BlocksWide*c.BlocksHigh*BlockSize)...)
+		d.coded[sc.index] = true
+	}
+	return s, nil
+}
+
+// readScan decodes the entropy coded data of a scan into the coefficients of its components
+func (d *decoder) readScan(s *scan) error {
+	r := &bitReader{data: d.data, pos: d.pos}
+	var predictors [4]int32
+	err := d.img.walkScan(s, func(mcu int) error {
+		if s.restart > 0 && mcu > 0 && mcu%s.restart == 0 {
+			if err := r.restart(); err != nil {
+				return err
+			}
+			predictors = [4]int32{}
+		}
+		return nil
+	}, func(i int, block []int32) error {
+		sc := s.components[i]
+		return decodeBlock(r, block, &predictors[i], sc.dc, sc.ac)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Skip the padding bits and anything else up to the next marker
+	d.pos = r.pos
+	for d.pos+1 < len(d.data) && (d.data[d.pos] != 0xFF || d.data[d.pos+1] == 0 || d.data[d.pos+1] == 0xFF ||
+		(d.data[d.pos+1] >= markerRST0 && d.data[d.pos+1] <= markerRST7)) {
+		d.pos++
+	}
+	return nil
+}
+
+// decodeBlock reads the coefficients of one block
+func decodeBlock(r *bitReader, block []int32, predictor *int32, dc, ac *huffmanTable) error {
+	size, err := dc.decode(r)
+	if err != nil {
+		return err
+	}
+	if size > 11 {
+		return fmt.Errorf("%w: bad DC coefficient size", ErrFormat)
+	}
+	diff, err := r.receive(int(size))
+	if err != nil {
+		return err
+	}
+	*predictor += diff
+	block[0] = *predictor
+
+	for k := 1; k < BlockSize; {
+		symbol, err := ac.decode(r)
+		if err != nil {
+			return err
+		}
+		run, size := int(symbol>>4), int(symbol&0x0F)
+		if size == 0 {
+			if run != 15 {
+				break // end of block
+			}
+			k += 16
+			continue
+		}
+		k += run
+		if k >= BlockSize {
+			return fmt.Errorf("%w: coefficient run past the end of a block", ErrFormat)
+		}
+		if block[k], err = r.receive(size); err != nil {
+			return err
+		}
+		k++
+	}
+	return nil
+}
+
+// bitReader reads the entropy coded data of a scan, removing the zero bytes stuffed after 0xFF bytes. Once it
+// reaches a marker it returns zero bits without moving past it.
+type bitReader struct {
+	data []byte
+	pos  int
+	acc  byte
+	n    int
+}
+
+// bit returns the next bit
+func (r *bitReader) bit() (int, error) {
+	if r.n == 0 {
+		if r.pos >= len(r.data) {
+			return 0, fmt.Errorf("%w: unexpected end of scan data", ErrFormat)
+		}
+		c := r.data[r.pos]
+		if c == 0xFF {
+			if r.pos+1 < len(r.data) && r.data[r.pos+1] == 0 {
+				r.pos += 2
+			} else {
+				c = 0 // a marker: leave it for the caller
+			}
+		} else {
+			r.pos++
+		}
+		r.acc, r.n = c, 8
+	}
+	r.n--
+	return int(r.acc>>r.n) & 1, nil
+}
+
+// receive reads a size bit value and extends it to a signed coefficient, as in section F.2.2.1 of the standard
+func (r *bitReader) receive(size int) (int32, error) {
+	var v int32
+	for i := 0; i < size; i++ {
+		bit, err := r.bit()
+		if err != nil {
+			return 0, err
+		}
+		v = v<<1 | int32(bit)
+	}
+	if size > 0 && v < 1<<(size-1) {
+		v += -1<<size + 1
+	}
+	return v, nil
+}
+
+// restart drops the remaining padding bits and reads the restart marker that follows them
+func (r *bitReader) restart() error {
+	r.n = 0
+	if r.pos+1 >= len(r.data) || r.data[r.pos] != 0xFF {
+		return fmt.Errorf("%w: missing restart marker", ErrFormat)
+	}
+	for r.pos < len(r.data) && r.data[r.pos] == 0xFF {
+		r.pos++
+	}
+	if r.pos >= len(r.data) || r.data[r.pos] < markerRST0 || r.data[r.pos] > markerRST7 {
+		return fmt.Errorf("%w: missing restart marker", ErrFormat)
+	}
+	r.pos++
+	return nil
+}
+
+// walkScan visits the blocks of a scan in coding order. mcu is called before every MCU with its index, and
+// block for every block of it with the index of its component within the scan.
+func (m *Image) walkScan(s *scan, mcu func(index int) error, block func(component int, coefficients []int32) error) error {
+	if len(s.components) == 1 {
+		c := &m.Components[s.components[0].index]
+		for by := 0; by < c.BlocksHigh; by++ {
+			for bx := 0; bx < c.BlocksWide; bx++ {
+				if err := mcu(by*c.BlocksWide + bx); err != nil {
+					return err
+				}
+				if err := block(0, m.Block(s.components[0].index, bx, by)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	mcusWide, mcusHigh := m.mcuGrid()
+	for my := 0; my < mcusHigh; my++ {
+		for mx := 0; mx < mcusWide; mx++ {
+			if err := mcu(my*mcusWide + mx); err != nil {
+				return err
+			}
+			for i, sc := range s.components {
+				c := &m.Components[sc.index]
+				for v := 0; v < c.V; v++ {
+					for h := 0; h < c.H; h++ {
+						if err := block(i, m.Block(sc.index, mx*c.H+h, my*c.V+v)); err != nil {
+							return err
+						}
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package jpeg_dct
+
+import (
+	"bufio"
+	"io"
+	"math/bits"
+)
+
+// Encode writes the image as a JPEG. Every marker segment is written back as it was read, and every scan is
+// Huffman coded again from Coefficients with the tables and restart interval it was read with. Changing a
+// coefficient only works when the scan's Huffman tables have a code for its new size; coefficients that keep
+// their magnitude category always do.
+func (m *Image) Encode(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.Write([]byte{0xFF, markerSOI})
+	for _, seg := range m.segments {
+		bw.Write(seg.raw)
+		if seg.scan != nil {
+			if err := m.writeScan(bw, seg.scan); err != nil {
+				return err
+			}
+		}
+	}
+	bw.Write([]byte{0xFF, markerEOI})
+	bw.Write(m.trailer)
+	return bw.Flush()
+}
+
+// writeScan Huffman codes the blocks of a scan
+func (m *Image) writeScan(out *bufio.Writer, s *scan) error {
+	w := &bitWriter{out: out}
+	var predictors [4]int32
+	restarts := 0
+	err := m.walkScan(s, func(mcu int) error {
+		if s.restart > 0 && mcu > 0 && mcu%s.restart == 0 {
+			w.flush()
+			out.Write([]byte{0xFF, markerRST0 + byte(restarts%8)})
+			restarts++
+			predictors = [4]int32{}
+		}
+		return nil
+	}, func(i int, block []int32) error {
+		sc := s.components[i]
+		return encodeBlock(w, block, &predictors[i], sc.dc, sc.ac)
+	})
+	if err != nil {
+		return err
+	}
+	w.flush()
+	return nil
+}
+
+// encodeBlock writes the coefficients of one block
+func encodeBlock(w *bitWriter, block []int32, predictor *int32, dc, ac *huffmanTable) error {
+	diff := block[0] - *predictor
+	*predictor = block[0]
+	size, value := magnitude(diff)
+	if err := dc.encode(w, byte(size)); err != nil {
+		return err
+	}
+	w.writeBits(value, size)
+
+	run := 0
+	for k := 1; k < BlockSize; k++ {
+		if block[k] == 0 {
+			run++
+			continue
+		}
+		for ; run > 15; run -= 16 {
+			if err := ac.encode(w, 0xF0); err != nil {
+				return err
+			}
+		}
+		size, value := magnitude(block[k])
+		if err := ac.encode(w, byte(run<<4|size)); err != nil {
+			return err
+		}
+		w.writeBits(value, size)
+		run = 0
+	}
+	if run > 0 {
+		return ac.encode(w, 0x00)
+	}
+	return nil
+}
+
+// magnitude returns the size category of a coefficient and the bits that code its value within it
+func magnitude(v int32) (size int, value uint32) {
+	if v < 0 {
+		size = bits.Len32(uint32(-v))
+		return size, uint32(v-1) & (1<<size - 1)
+	}
+	return bits.Len32(uint32(v)), uint32(v)
+}
+
+// bitWriter writes entropy coded data, stuffing a zero byte after every 0xFF byte
+type bitWriter struct {
+	out *bufio.Writer
+	acc uint32
+	n   int
+}
+
+// writeBits writes the low size bits of value, most significant first
+func (w *bitWriter) writeBits(value uint32, size int) {
+	for size > 0 {
+		take := min(size, 8)
+		size -= take
+		w.acc = w.acc<<take | (value>>size)&(1<<take-1)
+		w.n += take
+		for w.n >= 8 {
+			w.n -= 8
+			w.putByte(byte(w.acc >> w.n))
+		}
+	}
+}
+
+// putByte writes one byte of entropy coded data
+func (w *bitWriter) putByte(b byte) {
+	w.out.WriteByte(b)
+	if b == 0xFF {
+		w.out.WriteByte(0)
+	}
+}
+
+// flush pads the last byte with one bits, as the standard asks before a marker
+func (w *bitWriter) flush() {
+	if w.n > 0 {
+		w.writeBits(1<<(8-w.n)-1, 8-w.n)
+	}
+	w.acc = 0
+}
@@ -1,6 +1,7 @@
 package pipeline
 
 import (
+	"go-steg/go_steg/encryption"
 	"go-steg/go_steg/huffman"
 	"go-steg/go_steg/reed_solomon"
 )
@@ -10,6 +11,7 @@ type Config struct {
 	HuffmanEnabled bool
 	RSEnabled      bool
 	RSLevel        reed_solomon.RedundancyLevel
+	Encrypted      bool
 	FileExtension  string
 	Password       string
 }
@@ -19,6 +21,12 @@ func Encode(data []byte, cfg Config) ([]byte, error) {
 	if cfg.HuffmanEnabled {
 		result = huffman.HuffmanEncode(result, cfg.Password)
 	}
+	// Encrypt after compression (ciphertext does not compress) and before RS so corrections happen on ciphertext
+	if cfg.Encrypted {
+		var err error
+		result, err = encryption.Encrypt(result, cfg.Password)
+		if err != nil { return nil, err }
+	}
 	if cfg.RSEnabled {
 		var err error
 		result, err = reed_solomon.RSEncode(result, cfg.RSLevel)
@@ -34,6 +42,11 @@ func Decode(data []byte, cfg Config) ([]byte, error) {
 		result, err = reed_solomon.RSDecode(result, cfg.RSLevel)
 		if err != nil { return nil, err }
 	}
+	if cfg.Encrypted {
+		var err error
+		result, err = encryption.Decrypt(result, cfg.Password)
+		if err != nil { return nil, err }
+	}
 	if cfg.HuffmanEnabled {
 		var err error
 		result, err = huffman.HuffmanDecode(result, cfg.Password)
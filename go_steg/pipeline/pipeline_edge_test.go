@@ -78,6 +78,43 @@ func TestPipelineDecodeWrongPassword(t *testing.T) {
 	}
 }
 
+func TestPipelineEncryptedWrongPasswordFails(t *testing.T) {
+	data := []byte("secret message for pipeline encryption test")
+	cfg := Config{Encrypted: true, RSEnabled: true, Password: "rightPassword"}
+	encoded, err := Encode(data, cfg)
+	if err != nil {
+		t.Fatalf("Encode error: %v", err)
+	}
+	if bytes.Contains(encoded, data) {
+		t.Error("encrypted pipeline output contains the plaintext")
+	}
+
+	wrongCfg := Config{Encrypted: true, RSEnabled: true, Password: "wrongPassword"}
+	if _, err := Decode(encoded, wrongCfg); err == nil {
+		t.Error("expected authentication error when decrypting with the wrong password")
+	}
+}
+
+func TestPipelineEncryptedRSCorrectsCiphertext(t *testing.T) {
+	data := []byte("RS should repair ciphertext before the tag is checked")
+	cfg := Config{Encrypted: true, RSEnabled: true, RSLevel: reed_solomon.Standard, Password: "pw"}
+	encoded, err := Encode(data, cfg)
+	if err != nil {
+		t.Fatalf("Encode error: %v", err)
+	}
+	// Corrupt a few bytes inside the first RS block (after the 8-byte RS prefix)
+	for _, i := range []int{10, 20, 30} {
+		encoded[i] ^= 0xFF
+	}
+	decoded, err := Decode(encoded, cfg)
+	if err != nil {
+		t.Fatalf("Decode error: %v", err)
+	}
+	if !bytes.Equal(decoded, data) {
+		t.Errorf("roundtrip failed: got %q, want %q", decoded, data)
+	}
+}
+
 func TestPipelineDecodeRSErrorPropagation(t *testing.T) {
 	// Feed garbage data to Decode with RS enabled - should propagate RS error.
 	garbage := []byte{0, 0, 0, 0, 0, 0, 0, 0} // valid prefix but no blocks
@@ -47,6 +47,19 @@ func TestPipelineEncodeDecodeRoundtrip(t *testing.T) {
 				return b
 			}(),
 		},
+		{
+			name: "encryption only",
+			config: Config{Encrypted: true, Password: "test"},
+			data: []byte("hello world"),
+		},
+		{
+			name: "huffman + encryption + RS",
+			config: Config{
+				HuffmanEnabled: true, Encrypted: true, RSEnabled: true,
+				RSLevel: reed_solomon.Standard, Password: "test",
+			},
+			data: []byte("hello world"),
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
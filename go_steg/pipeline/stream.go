@@ -0,0 +1,105 @@
+package pipeline
+
+import (
+	"errors"
+	"io"
+
+	"go-steg/go_steg/encryption"
+	"go-steg/go_steg/huffman"
+	"go-steg/go_steg/reed_solomon"
+)
+
+// Sizes holds the length of the data after each pipeline stage. Huffman and Reed-Solomon write the length of
+// their input at the front of their output, so a streaming encode needs these before the first byte is written.
+type Sizes struct {
+	Data       int64
+	Compressed int64
+	Encrypted  int64
+	Output     int64
+}
+
+// MeasureSizes reads r to the end and returns the stage sizes a streaming encode of it produces with cfg.
+// Sizes for a streaming encode differ from Encode when encryption is enabled, since the streaming envelope
+// seals the data in chunks.
+func MeasureSizes(r io.Reader, cfg Config) (Sizes, error) {
+	var sizes Sizes
+	var err error
+	if cfg.HuffmanEnabled {
+		sizes.Data, sizes.Compressed, err = huffman.EncodedLen(r, cfg.Password)
+	} else {
+		sizes.Data, err = io.Copy(io.Discard, r)
+		sizes.Compressed = sizes.Data
+	}
+	if err != nil {
+		return Sizes{}, err
+	}
+	sizes.Encrypted = sizes.Compressed
+	if cfg.Encrypted {
+		sizes.Encrypted = encryption.StreamLen(sizes.Compressed)
+	}
+	sizes.Output = sizes.Encrypted
+	if cfg.RSEnabled {
+		sizes.Output = reed_solomon.EncodedLen(sizes.Encrypted, cfg.RSLevel)
+	}
+	return sizes, nil
+}
+
+// encoder chains the enabled stage writers. Closing it closes them from the data side outward so every
+// stage flushes into the next.
+type encoder struct {
+	io.Writer
+	stages []io.Closer
+}
+
+func (e *encoder) Close() error {
+	var errs []error
+	for _, stage := range e.stages {
+		errs = append(errs, stage.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// NewEncoder returns a writer that runs everything written to it through the pipeline into w. Exactly
+// sizes.Data bytes must be written, and Close must be called to flush the last stage blocks.
+func NewEncoder(w io.Writer, sizes Sizes, cfg Config) (io.WriteCloser, error) {
+	var stages []io.Closer
+	out := w
+	if cfg.RSEnabled {
+		rw := reed_solomon.NewWriter(out, sizes.Encrypted, cfg.RSLevel)
+		stages = append(stages, rw)
+		out = rw
+	}
+	if cfg.Encrypted {
+		ew, err := encryption.NewWriter(out, cfg.Password)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, ew)
+		out = ew
+	}
+	if cfg.HuffmanEnabled {
+		hw := huffman.NewWriter(out, sizes.Data, cfg.Password)
+		stages = append(stages, hw)
+		out = hw
+	}
+	// Close innermost first: Huffman flushes into encryption, which seals its final chunk into Reed-Solomon
+	for i, j := 0, len(stages)-1; i < j; i, j = i+1, j-1 {
+		stages[i], stages[j] = stages[j], stages[i]
+	}
+	return &encoder{Writer: out, stages: stages}, nil
+}
+
+// NewDecoder returns a reader that undoes the pipeline on the data read from r, one block at a time.
+// It accepts the output of both Encode and NewEncoder.
+func NewDecoder(r io.Reader, cfg Config) io.Reader {
+	if cfg.RSEnabled {
+		r = reed_solomon.NewReader(r, cfg.RSLevel)
+	}
+	if cfg.Encrypted {
+		r = encryption.NewReader(r, cfg.Password)
+	}
+	if cfg.HuffmanEnabled {
+		r = huffman.NewReader(r, cfg.Password)
+	}
+	return r
+}
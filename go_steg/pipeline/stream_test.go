@@ -0,0 +1,82 @@
+package pipeline
+
+import (
+	"bytes"
+	"go-steg/go_steg/reed_solomon"
+	"io"
+	"testing"
+)
+
+func encodeStream(t *testing.T, data []byte, cfg Config) ([]byte, Sizes) {
+	t.Helper()
+	sizes, err := MeasureSizes(bytes.NewReader(data), cfg)
+	if err != nil {
+		t.Fatalf("MeasureSizes: %v", err)
+	}
+	var out bytes.Buffer
+	enc, err := NewEncoder(&out, sizes, cfg)
+	if err != nil {
+		t.Fatalf("NewEncoder: %v", err)
+	}
+	if _, err := io.Copy(enc, bytes.NewReader(data)); err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return out.Bytes(), sizes
+}
+
+func TestStreamRoundtrip(t *testing.T) {
+	data := bytes.Repeat([]byte("streaming pipeline data "), 5000)
+	configs := []Config{
+		{Password: "test"},
+		{HuffmanEnabled: true, Password: "test"},
+		{RSEnabled: true, RSLevel: reed_solomon.High, Password: "test"},
+		{HuffmanEnabled: true, Encrypted: true, RSEnabled: true, RSLevel: reed_solomon.Standard, Password: "test"},
+	}
+	for _, cfg := range configs {
+		for _, input := range [][]byte{nil, data} {
+			encoded, sizes := encodeStream(t, input, cfg)
+			if int64(len(encoded)) != sizes.Output {
+				t.Errorf("%+v: output length = %d, measured %d", cfg, len(encoded), sizes.Output)
+			}
+			decoded, err := io.ReadAll(NewDecoder(bytes.NewReader(encoded), cfg))
+			if err != nil {
+				t.Fatalf("%+v: decode: %v", cfg, err)
+			}
+			if !bytes.Equal(decoded, input) {
+				t.Errorf("%+v: roundtrip mismatch for %d bytes", cfg, len(input))
+			}
+		}
+	}
+}
+
+func TestStreamMatchesEncodeWithoutEncryption(t *testing.T) {
+	cfg := Config{HuffmanEnabled: true, RSEnabled: true, RSLevel: reed_solomon.Standard, Password: "test"}
+	data := []byte("same bytes either way")
+	want, err := Encode(data, cfg)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	got, _ := encodeStream(t, data, cfg)
+	if !bytes.Equal(got, want) {
+		t.Error("streaming output differs from Encode")
+	}
+}
+
+func TestDecoderReadsEncodeOutput(t *testing.T) {
+	cfg := Config{HuffmanEnabled: true, Encrypted: true, RSEnabled: true, RSLevel: reed_solomon.High, Password: "test"}
+	data := []byte("written by the buffered pipeline")
+	encoded, err := Encode(data, cfg)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	decoded, err := io.ReadAll(NewDecoder(bytes.NewReader(encoded), cfg))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !bytes.Equal(decoded, data) {
+		t.Error("roundtrip mismatch")
+	}
+}
@@ -116,3 +116,33 @@ func RSDecode(data []byte, level RedundancyLevel) ([]byte, error) {
 
 	return result[:origLen], nil
 }
+
+// EncodeCodeword returns data followed by nsym parity bytes: a single shortened codeword, for messages too
+// small to spend one of RSEncode's 255-byte blocks on. len(data)+nsym must not exceed 255.
+func EncodeCodeword(data []byte, nsym int) ([]byte, error) {
+	if len(data)+nsym > codewordLen {
+		return nil, fmt.Errorf("reed_solomon: %d data bytes and %d parity bytes exceed a %d byte codeword", len(data), nsym, codewordLen)
+	}
+	codeword := make([]byte, 0, len(data)+nsym)
+	codeword = append(codeword, data...)
+	return append(codeword, encodeBlock(data, nsym)...), nil
+}
+
+// DecodeCodeword corrects up to nsym/2 byte errors in a codeword from EncodeCodeword and returns its data
+// bytes along with the number of bytes that were corrected.
+func DecodeCodeword(codeword []byte, nsym int) ([]byte, int, error) {
+	if len(codeword) < nsym || len(codeword) > codewordLen {
+		return nil, 0, fmt.Errorf("reed_solomon: %d byte codeword does not fit %d parity bytes", len(codeword), nsym)
+	}
+	corrected, err := decodeBlock(codeword, nsym)
+	if err != nil {
+		return nil, 0, err
+	}
+	changed := 0
+	for i := range codeword {
+		if codeword[i] != corrected[i] {
+			changed++
+		}
+	}
+	return corrected[:len(codeword)-nsym], changed, nil
+}
@@ -0,0 +1,178 @@
+package reed_solomon
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// codewordLen is the size of one encoded block (data + parity)
+const codewordLen = 255
+
+// blockCount returns how many blocks RSEncode uses for size bytes of data
+func blockCount(size int64, level RedundancyLevel) int64 {
+	dataPerBlock, _ := paramsForLevel(level)
+	numBlocks := size / int64(dataPerBlock)
+	if size%int64(dataPerBlock) != 0 || size == 0 {
+		numBlocks++
+	}
+	return numBlocks
+}
+
+// EncodedLen returns the length of the RSEncode output for size bytes of data.
+func EncodedLen(size int64, level RedundancyLevel) int64 {
+	return prefixLen + blockCount(size, level)*codewordLen
+}
+
+// Writer is the streaming form of RSEncode. The block count and data length go at the front of the output,
+// so the data size has to be given up front. Only one block is held in memory at a time.
+type Writer struct {
+	w         io.Writer
+	level     RedundancyLevel
+	size      int64
+	written   int64
+	block     []byte
+	codeword  []byte
+	prefixOut bool
+}
+
+// NewWriter returns a Writer that encodes exactly size bytes into w.
+func NewWriter(w io.Writer, size int64, level RedundancyLevel) *Writer {
+	initTables()
+	dataPerBlock, _ := paramsForLevel(level)
+	return &Writer{
+		w:        w,
+		level:    level,
+		size:     size,
+		block:    make([]byte, 0, dataPerBlock),
+		codeword: make([]byte, codewordLen),
+	}
+}
+
+// writePrefix writes the block count and length prefix before the first block
+func (rw *Writer) writePrefix() error {
+	if rw.prefixOut {
+		return nil
+	}
+	rw.prefixOut = true
+	var prefix [prefixLen]byte
+	binary.LittleEndian.PutUint32(prefix[0:4], uint32(blockCount(rw.size, rw.level)))
+	binary.LittleEndian.PutUint32(prefix[4:8], uint32(rw.size))
+	_, err := rw.w.Write(prefix[:])
+	return err
+}
+
+// flushBlock zero-pads the pending block, appends its parity and writes the codeword
+func (rw *Writer) flushBlock() error {
+	dataPerBlock, parityPerBlock := paramsForLevel(rw.level)
+	clear(rw.codeword)
+	copy(rw.codeword, rw.block)
+	copy(rw.codeword[dataPerBlock:], encodeBlock(rw.codeword[:dataPerBlock], parityPerBlock))
+	rw.block = rw.block[:0]
+	_, err := rw.w.Write(rw.codeword)
+	return err
+}
+
+// Write buffers p into blocks, writing each codeword as its block fills.
+func (rw *Writer) Write(p []byte) (int, error) {
+	if rw.written+int64(len(p)) > rw.size {
+		return 0, fmt.Errorf("reed_solomon: writing more than the declared %d bytes", rw.size)
+	}
+	if err := rw.writePrefix(); err != nil {
+		return 0, err
+	}
+	n := 0
+	for len(p) > 0 {
+		take := min(len(p), cap(rw.block)-len(rw.block))
+		rw.block = append(rw.block, p[:take]...)
+		p = p[take:]
+		n += take
+		rw.written += int64(take)
+		if len(rw.block) == cap(rw.block) {
+			if err := rw.flushBlock(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Close writes the final, zero-padded block. It does not close the underlying writer.
+func (rw *Writer) Close() error {
+	if rw.written != rw.size {
+		return fmt.Errorf("reed_solomon: %d bytes written, %d declared", rw.written, rw.size)
+	}
+	if err := rw.writePrefix(); err != nil {
+		return err
+	}
+	// RSEncode always emits at least one block, and a partial block whenever the size is not a multiple
+	if len(rw.block) > 0 || rw.size == 0 {
+		return rw.flushBlock()
+	}
+	return nil
+}
+
+// Reader is the streaming form of RSDecode, correcting one block at a time.
+type Reader struct {
+	r         io.Reader
+	level     RedundancyLevel
+	started   bool
+	blocks    int64
+	block     int64
+	remaining int64
+	codeword  []byte
+	out       []byte
+}
+
+// NewReader returns a Reader that decodes RSEncode output read from r.
+func NewReader(r io.Reader, level RedundancyLevel) *Reader {
+	initTables()
+	return &Reader{r: r, level: level, codeword: make([]byte, codewordLen)}
+}
+
+// Read returns corrected data, returning io.EOF once the original length has been produced.
+func (rr *Reader) Read(p []byte) (int, error) {
+	dataPerBlock, _ := paramsForLevel(rr.level)
+	if !rr.started {
+		var prefix [prefixLen]byte
+		if _, err := io.ReadFull(rr.r, prefix[:]); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return 0, errors.New("reed_solomon: data too short for prefix")
+			}
+			return 0, err
+		}
+		rr.blocks = int64(binary.LittleEndian.Uint32(prefix[0:4]))
+		rr.remaining = int64(binary.LittleEndian.Uint32(prefix[4:8]))
+		if rr.remaining > rr.blocks*int64(dataPerBlock) {
+			return 0, fmt.Errorf("reed_solomon: original length %d exceeds decoded data %d", rr.remaining, rr.blocks*int64(dataPerBlock))
+		}
+		rr.started = true
+	}
+
+	n := 0
+	for n < len(p) && rr.remaining > 0 {
+		if len(rr.out) == 0 {
+			if _, err := io.ReadFull(rr.r, rr.codeword); err != nil {
+				if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+					return n, fmt.Errorf("reed_solomon: input ended in block %d of %d", rr.block, rr.blocks)
+				}
+				return n, err
+			}
+			decoded, err := decodeBlock(rr.codeword, codewordLen-dataPerBlock)
+			if err != nil {
+				return n, fmt.Errorf("reed_solomon: block %d: %w", rr.block, err)
+			}
+			rr.block++
+			rr.out = decoded[:min(int64(dataPerBlock), rr.remaining)]
+		}
+		m := copy(p[n:], rr.out)
+		rr.out = rr.out[m:]
+		n += m
+		rr.remaining -= int64(m)
+	}
+	if rr.remaining == 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
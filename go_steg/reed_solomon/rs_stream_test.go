@@ -0,0 +1,78 @@
+package reed_solomon
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+)
+
+func TestWriterMatchesRSEncode(t *testing.T) {
+	rng := rand.New(rand.NewSource(11))
+	for _, level := range []RedundancyLevel{Standard, High} {
+		for _, size := range []int{0, 1, 190, 191, 223, 224, 5000} {
+			data := make([]byte, size)
+			rng.Read(data)
+			want, err := RSEncode(data, level)
+			if err != nil {
+				t.Fatalf("RSEncode: %v", err)
+			}
+
+			var got bytes.Buffer
+			w := NewWriter(&got, int64(size), level)
+			for rest := data; len(rest) > 0; {
+				n := min(len(rest), 1+rng.Intn(300))
+				if _, err := w.Write(rest[:n]); err != nil {
+					t.Fatalf("Write: %v", err)
+				}
+				rest = rest[n:]
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			if !bytes.Equal(got.Bytes(), want) {
+				t.Errorf("level %d size %d: Writer output differs from RSEncode", level, size)
+			}
+			if EncodedLen(int64(size), level) != int64(len(want)) {
+				t.Errorf("level %d size %d: EncodedLen = %d, want %d", level, size, EncodedLen(int64(size), level), len(want))
+			}
+		}
+	}
+}
+
+func TestReaderCorrectsErrors(t *testing.T) {
+	data := make([]byte, 3000)
+	rand.New(rand.NewSource(12)).Read(data)
+	encoded, err := RSEncode(data, Standard)
+	if err != nil {
+		t.Fatalf("RSEncode: %v", err)
+	}
+	// Damage a few bytes in every block, within the 16 byte correction limit
+	for block := 0; block*255+prefixLen < len(encoded); block++ {
+		for i := 0; i < 5; i++ {
+			encoded[prefixLen+block*255+i*31] ^= 0xFF
+		}
+	}
+
+	got, err := io.ReadAll(NewReader(iotest.OneByteReader(bytes.NewReader(encoded)), Standard))
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("Reader did not correct the damaged blocks")
+	}
+}
+
+func TestReaderTruncatedInput(t *testing.T) {
+	encoded, err := RSEncode(bytes.Repeat([]byte{1}, 1000), High)
+	if err != nil {
+		t.Fatalf("RSEncode: %v", err)
+	}
+	if _, err := io.ReadAll(NewReader(bytes.NewReader(encoded[:len(encoded)-10]), High)); err == nil {
+		t.Error("expected an error for a truncated block")
+	}
+	if _, err := io.ReadAll(NewReader(bytes.NewReader(encoded[:5]), High)); err == nil {
+		t.Error("expected an error for a truncated prefix")
+	}
+}
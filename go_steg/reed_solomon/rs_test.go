@@ -161,3 +161,36 @@ func TestParamsForLevel(t *testing.T) {
 		t.Errorf("High: got (%d, %d), want (191, 64)", d, p)
 	}
 }
+
+func TestCodewordCorrection(t *testing.T) {
+	data := make([]byte, 80)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	codeword, err := EncodeCodeword(data, 32)
+	if err != nil {
+		t.Fatalf("EncodeCodeword: %v", err)
+	}
+	if len(codeword) != 112 {
+		t.Fatalf("codeword length: got %d, want 112", len(codeword))
+	}
+
+	// Up to 16 errors anywhere in the shortened codeword are corrected and counted
+	for i := 0; i < 16; i++ {
+		codeword[i*7] ^= 0x5C
+	}
+	decoded, corrected, err := DecodeCodeword(codeword, 32)
+	if err != nil {
+		t.Fatalf("DecodeCodeword: %v", err)
+	}
+	if !bytes.Equal(decoded, data) {
+		t.Error("failed to recover from corruption")
+	}
+	if corrected != 16 {
+		t.Errorf("corrected: got %d, want 16", corrected)
+	}
+
+	if _, err := EncodeCodeword(make([]byte, 230), 32); err == nil {
+		t.Error("expected an error for a codeword longer than 255 bytes")
+	}
+}
@@ -0,0 +1,137 @@
+package steganalysis
+
+import (
+	"go-steg/go_steg/jpeg_dct"
+	"math"
+	"slices"
+)
+
+// minExpected is the smallest expected count a pair of values needs to take part in the chi-square test.
+// Rarer pairs make the statistic unreliable.
+const minExpected = 5
+
+// chiSquareSteps is the number of ever longer prefixes the chi-square attack measures
+const chiSquareSteps = 100
+
+// chiSquarePlane runs the chi-square attack on a channel read column by column, left to right
+func chiSquarePlane(p *plane) (probability, rate float64) {
+	keys := make([]int, 0, len(p.values))
+	for x := 0; x < p.width; x++ {
+		for y := 0; y < p.height; y++ {
+			keys = append(keys, p.at(x, y))
+		}
+	}
+	return chiSquareScan(keys)
+}
+
+// analyzeCoefficients runs the chi-square attack on the AC coefficients of a JPEG whose magnitude is at
+// least 2. Embedding swaps magnitudes 2k and 2k+1 of the same sign, so those make up the pairs.
+func analyzeCoefficients(dct *jpeg_dct.Image) *CoefficientReport {
+	var keys []int
+	for i, v := range dct.Coefficients {
+		if i%jpeg_dct.BlockSize == 0 || v > -2 && v < 2 {
+			continue
+		}
+		magnitude, negative := int(v), 0
+		if v < 0 {
+			magnitude, negative = -magnitude, 1
+		}
+		keys = append(keys, (magnitude>>1<<1|negative)<<1|magnitude&1)
+	}
+	report := &CoefficientReport{Coefficients: len(keys)}
+	report.ChiSquareProbability, report.ChiSquareRate = chiSquareScan(keys)
+	return report
+}
+
+// chiSquareScan runs the chi-square attack on values whose keys are 2k and 2k+1 for the two values of pair
+// k. It returns the probability over the whole sequence and the largest prefix, in steps of
+// 1/chiSquareSteps of it, over which the probability stays above one half.
+func chiSquareScan(keys []int) (probability, rate float64) {
+	if len(keys) == 0 {
+		return 0, 0
+	}
+	pairs := make([][2]int, slices.Max(keys)/2+1)
+	next := 0
+	for step := 1; step <= chiSquareSteps; step++ {
+		end := len(keys) * step / chiSquareSteps
+		for ; next < end; next++ {
+			pairs[keys[next]>>1][keys[next]&1]++
+		}
+		probability = chiSquareProbability(pairs)
+		if probability > 0.5 {
+			rate = float64(end) / float64(len(keys))
+		}
+	}
+	return probability, rate
+}
+
+// chiSquareProbability compares how often the first value of every pair occurs with the mean of the pair,
+// which it tends to after random data has been written into the low bits, and returns the probability that
+// it did
+func chiSquareProbability(pairs [][2]int) float64 {
+	var statistic float64
+	categories := 0
+	for _, pair := range pairs {
+		expected := float64(pair[0]+pair[1]) / 2
+		if expected < minExpected {
+			continue
+		}
+		difference := float64(pair[0]) - expected
+		statistic += difference * difference / expected
+		categories++
+	}
+	if categories < 2 {
+		return 0
+	}
+	return upperGamma(float64(categories-1)/2, statistic/2)
+}
+
+// upperGamma returns the regularized upper incomplete gamma function Q(a, x), which for a = k/2 and x = s/2
+// is the probability that a chi-square statistic with k degrees of freedom exceeds s. It uses the series for
+// P(a, x) below x = a+1 and a continued fraction above it.
+func upperGamma(a, x float64) float64 {
+	if x <= 0 {
+		return 1
+	}
+	logGamma, _ := math.Lgamma(a)
+	scale := math.Exp(a*math.Log(x) - x - logGamma)
+
+	if x < a+1 {
+		term := 1 / a
+		sum := term
+		for n := 1; n < 1000; n++ {
+			term *= x / (a + float64(n))
+			sum += term
+			if term < sum*1e-15 {
+				break
+			}
+		}
+		return max(1-sum*scale, 0)
+	}
+
+	// Modified Lentz evaluation of the continued fraction
+	const tiny = 1e-300
+	b := x + 1 - a
+	c := 1 / tiny
+	d := 1 / b
+	h := d
+	for i := 1; i < 1000; i++ {
+		an := -float64(i) * (float64(i) - a)
+		b += 2
+		d = an*d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + an/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		h *= delta
+		if math.Abs(delta-1) < 1e-15 {
+			break
+		}
+	}
+	return scale * h
+}
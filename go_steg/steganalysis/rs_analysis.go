@@ -0,0 +1,81 @@
+package steganalysis
+
+// rsMask is the flipping mask RS analysis applies to every group of len(rsMask) neighbouring samples of a
+// row: 1 flips the low bit of a sample (2k and 2k+1 swap), -1 shifts it the other way (2k-1 and 2k swap)
+// and 0 leaves it alone.
+var rsMask = [...]int{0, 1, 1, 0}
+
+// rsRate estimates the embedding rate of a channel by RS analysis. A group is regular when flipping it with
+// the mask makes it less smooth and singular when it makes it smoother. In a clean image flipping with the
+// mask or its negation gives about as many regular groups, but random low bits push the two apart in a way
+// that can be solved for the rate, by comparing the channel with a copy whose low bits are all flipped.
+func rsRate(p *plane) float64 {
+	rm, sm, rn, sn := rsCounts(p, false)
+	rmFlipped, smFlipped, rnFlipped, snFlipped := rsCounts(p, true)
+	d0, d1 := rm-sm, rmFlipped-smFlipped
+	dn0, dn1 := rn-sn, rnFlipped-snFlipped
+
+	x, ok := smallerRoot(2*(d1+d0), dn0-dn1-d1-3*d0, d0-dn0)
+	if !ok {
+		return 0
+	}
+	return clampRate(x / (x - 0.5))
+}
+
+// rsCounts returns the fractions of groups of a channel that are regular and singular under the mask and
+// under its negation. With flipped set, the low bit of every sample is flipped first.
+func rsCounts(p *plane, flipped bool) (regular, singular, negRegular, negSingular float64) {
+	var group, changed [len(rsMask)]int
+	var counts [4]int
+	groups := 0
+	for y := 0; y < p.height; y++ {
+		for x := 0; x+len(rsMask) <= p.width; x += len(rsMask) {
+			for i := range group {
+				group[i] = p.at(x+i, y)
+				if flipped {
+					group[i] ^= 1
+				}
+			}
+			smoothness := variation(group[:])
+			for sign := range 2 {
+				for i, m := range rsMask {
+					changed[i] = applyFlip(group[i], m*(1-2*sign))
+				}
+				switch after := variation(changed[:]); {
+				case after > smoothness:
+					counts[2*sign]++
+				case after < smoothness:
+					counts[2*sign+1]++
+				}
+			}
+			groups++
+		}
+	}
+	if groups == 0 {
+		return 0, 0, 0, 0
+	}
+	n := float64(groups)
+	return float64(counts[0]) / n, float64(counts[1]) / n, float64(counts[2]) / n, float64(counts[3]) / n
+}
+
+// applyFlip applies one entry of the mask to a sample
+func applyFlip(v, m int) int {
+	switch m {
+	case 1:
+		return v ^ 1
+	case -1:
+		return ((v + 1) ^ 1) - 1
+	default:
+		return v
+	}
+}
+
+// variation returns the sum of the differences between neighbouring samples of a group, which is lower the
+// smoother the group is
+func variation(group []int) int {
+	total := 0
+	for i := 1; i < len(group); i++ {
+		total += max(group[i]-group[i-1], group[i-1]-group[i])
+	}
+	return total
+}
@@ -0,0 +1,62 @@
+package steganalysis
+
+// samplePairRate estimates the embedding rate of a channel by sample pair analysis. Every pair of
+// horizontally or vertically neighbouring samples (u, v) falls into the trace set C_m of pairs whose values
+// halved, u/2 and v/2 rounded down, differ by m; embedding never moves a pair to another trace set. Within
+// C_m a pair whose difference is 2m+1 has an even smaller value, and one whose difference is 2m-1 an odd
+// smaller value. In a clean image a difference of 2m+1 is about as likely to start at an even value as at an
+// odd one, whichever trace set that puts the pair in. Random low bits move pairs between the differences
+// inside each trace set at a known rate, which breaks that balance by an amount that can be solved for the
+// rate.
+func samplePairRate(p *plane) float64 {
+	sets := p.max/2 + 2
+	// traces[m] counts C_m, even[m] the pairs with difference 2m+1 and an even smaller value (in C_m), and
+	// odd[m] those with an odd one (in C_m+1)
+	traces, even, odd := make([]float64, sets), make([]float64, sets), make([]float64, sets)
+	count := func(u, v int) {
+		if u > v {
+			u, v = v, u
+		}
+		traces[v>>1-u>>1]++
+		if d := v - u; d&1 == 1 {
+			if u&1 == 0 {
+				even[d>>1]++
+			} else {
+				odd[d>>1]++
+			}
+		}
+	}
+	for y := 0; y < p.height; y++ {
+		for x := 0; x < p.width; x++ {
+			if x+1 < p.width {
+				count(p.at(x, y), p.at(x+1, y))
+			}
+			if y+1 < p.height {
+				count(p.at(x, y), p.at(x, y+1))
+			}
+		}
+	}
+
+	// With s = 1 - rate, s² times the clean count of every set is a quadratic in s. Summing the even sets
+	// minus the odd ones and setting the result to 0 gives c2 s² + c1 s + c0 = 0.
+	var c0, c1, c2 float64
+	add := func(sign, constant, linear, trace float64) {
+		c0 += sign * (constant - trace/2)
+		c1 += sign * linear
+		c2 += sign * trace / 2
+	}
+	add(1, even[0], 0, traces[0])
+	for m := 1; m < sets-1; m++ {
+		add(1, (even[m]+odd[m-1])/2, (even[m]-odd[m-1])/2, traces[m]/2)
+	}
+	for m := 0; m < sets-1; m++ {
+		add(-1, (even[m+1]+odd[m])/2, (odd[m]-even[m+1])/2, traces[m+1]/2)
+	}
+
+	// Substitute s = 1 - rate
+	rate, ok := smallerRoot(c2, -(2*c2 + c1), c2+c1+c0)
+	if !ok {
+		return 0
+	}
+	return clampRate(rate)
+}
@@ -0,0 +1,232 @@
+// Package steganalysis estimates how much of an image's least significant bit plane has been replaced by
+// hidden data, using three classic attacks on LSB embedding:
+//
+//   - the chi-square attack (Westfeld and Pfitzmann), which tests whether the values 2k and 2k+1 occur
+//     equally often, as they do after their low bits were overwritten with random data
+//   - RS analysis (Fridrich, Goljan and Du), which measures how flipping low bits changes the smoothness of
+//     small groups of samples
+//   - sample pair analysis (Dumitrescu, Wu and Wang), which compares pairs of neighbouring samples whose
+//     difference is odd within and across the pairs of values embedding swaps between
+//
+// Every channel is analyzed on its own, at the precision of the image. The estimated embedding rate is the
+// fraction of samples whose low bit carries data, from 0 for a clean image to 1 for a fully used one.
+package steganalysis
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go-steg/go_steg/jpeg_dct"
+	"image"
+	"image/color"
+	"io"
+	"math"
+
+	// Register the decoders of every carrier format go-steg reads
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+)
+
+// ErrEmptyImage is returned for images without a single sample to analyze
+var ErrEmptyImage = errors.New("steganalysis: image has no pixels")
+
+// Report holds the analysis of one image
+type Report struct {
+	Width  int
+	Height int
+	// Channels holds the analysis of every channel of the image: R, G and B for color images, plus A when
+	// the image is not fully opaque, Y for gray images and Index for paletted ones
+	Channels []ChannelReport
+	// Coefficients holds the chi-square attack on the quantized DCT coefficients of a baseline JPEG, where
+	// JPEG steganography hides its data; nil for every other image
+	Coefficients *CoefficientReport
+}
+
+// ChannelReport holds the results of every attack on one channel
+type ChannelReport struct {
+	Channel string
+	// Samples is the number of samples in the channel
+	Samples int
+	// ChiSquareProbability is the probability the chi-square attack gives that the whole channel carries
+	// data in its low bits. It only detects embedding that uses most of the channel.
+	ChiSquareProbability float64
+	// ChiSquareRate is the largest fraction of the channel, read column by column from the left as go-steg
+	// fills a carrier without --scatter, over which the chi-square probability stays above one half
+	ChiSquareRate float64
+	// RSRate is the embedding rate estimated by RS analysis
+	RSRate float64
+	// SamplePairRate is the embedding rate estimated by sample pair analysis
+	SamplePairRate float64
+	// EmbeddingRate is the mean of RSRate and SamplePairRate, the two attacks that estimate the rate of
+	// embedding spread over the whole channel
+	EmbeddingRate float64
+}
+
+// CoefficientReport holds the chi-square attack on the AC coefficients of a JPEG whose magnitude is 2 or
+// more, the ones JSteg-style embedding changes
+type CoefficientReport struct {
+	Coefficients         int
+	ChiSquareProbability float64
+	// ChiSquareRate is the largest fraction of the coefficients, in the order they are coded, over which the
+	// chi-square probability stays above one half
+	ChiSquareRate float64
+}
+
+// plane holds the samples of one channel, row by row, along with the largest value a sample can take
+type plane struct {
+	name          string
+	width, height int
+	values        []int
+	max           int
+}
+
+// at returns the sample in column x of row y
+func (p *plane) at(x, y int) int {
+	return p.values[y*p.width+x]
+}
+
+// Analyze decodes an image and analyzes every channel. PNG, JPEG, BMP and TIFF images are supported; the
+// coefficients of baseline JPEGs are analyzed as well.
+func Analyze(r io.Reader) (Report, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return Report{}, fmt.Errorf("steganalysis: reading image: %w", err)
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Report{}, fmt.Errorf("steganalysis: decoding image: %w", err)
+	}
+	report, err := AnalyzeImage(img)
+	if err != nil {
+		return Report{}, err
+	}
+	if format == "jpeg" {
+		if dct, err := jpeg_dct.Decode(bytes.NewReader(data)); err == nil {
+			report.Coefficients = analyzeCoefficients(dct)
+		}
+	}
+	return report, nil
+}
+
+// AnalyzeImage analyzes every channel of an already decoded image
+func AnalyzeImage(img image.Image) (Report, error) {
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return Report{}, ErrEmptyImage
+	}
+	report := Report{Width: bounds.Dx(), Height: bounds.Dy()}
+	for _, p := range planes(img) {
+		report.Channels = append(report.Channels, analyzePlane(p))
+	}
+	return report, nil
+}
+
+// analyzePlane runs every attack on one channel
+func analyzePlane(p *plane) ChannelReport {
+	report := ChannelReport{
+		Channel:        p.name,
+		Samples:        len(p.values),
+		RSRate:         rsRate(p),
+		SamplePairRate: samplePairRate(p),
+	}
+	report.ChiSquareProbability, report.ChiSquareRate = chiSquarePlane(p)
+	report.EmbeddingRate = (report.RSRate + report.SamplePairRate) / 2
+	return report
+}
+
+// planes splits an image into its channels. Gray and paletted images keep their single sample; every other
+// image is read as non-premultiplied color at 8 or 16 bits, whichever its color model has.
+func planes(img image.Image) []*plane {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	newPlane := func(name string, max int) *plane {
+		return &plane{name: name, width: width, height: height, values: make([]int, width*height), max: max}
+	}
+
+	switch src := img.(type) {
+	case *image.Gray:
+		p := newPlane("Y", 0xFF)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				p.values[y*width+x] = int(src.GrayAt(bounds.Min.X+x, bounds.Min.Y+y).Y)
+			}
+		}
+		return []*plane{p}
+	case *image.Gray16:
+		p := newPlane("Y", 0xFFFF)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				p.values[y*width+x] = int(src.Gray16At(bounds.Min.X+x, bounds.Min.Y+y).Y)
+			}
+		}
+		return []*plane{p}
+	case *image.Paletted:
+		p := newPlane("Index", 0xFF)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				p.values[y*width+x] = int(src.ColorIndexAt(bounds.Min.X+x, bounds.Min.Y+y))
+			}
+		}
+		return []*plane{p}
+	}
+
+	sixteen := img.ColorModel() == color.RGBA64Model || img.ColorModel() == color.NRGBA64Model
+	max := 0xFF
+	if sixteen {
+		max = 0xFFFF
+	}
+	channels := []*plane{newPlane("R", max), newPlane("G", max), newPlane("B", max), newPlane("A", max)}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			// Both models return colors already in them unchanged, so NRGBA images are read exactly
+			c := img.At(bounds.Min.X+x, bounds.Min.Y+y)
+			var samples [4]int
+			if sixteen {
+				n := color.NRGBA64Model.Convert(c).(color.NRGBA64)
+				samples = [4]int{int(n.R), int(n.G), int(n.B), int(n.A)}
+			} else {
+				n := color.NRGBAModel.Convert(c).(color.NRGBA)
+				samples = [4]int{int(n.R), int(n.G), int(n.B), int(n.A)}
+			}
+			for i, channel := range channels {
+				channel.values[y*width+x] = samples[i]
+			}
+		}
+	}
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return channels[:3]
+	}
+	return channels
+}
+
+// smallerRoot returns the root of a x² + b x + c with the smaller absolute value. When the roots are complex
+// it returns the real part they share.
+func smallerRoot(a, b, c float64) (float64, bool) {
+	if a == 0 {
+		if b == 0 {
+			return 0, false
+		}
+		return -c / b, true
+	}
+	discriminant := b*b - 4*a*c
+	if discriminant < 0 {
+		return -b / (2 * a), true
+	}
+	root := math.Sqrt(discriminant)
+	first, second := (-b+root)/(2*a), (-b-root)/(2*a)
+	if math.Abs(first) < math.Abs(second) {
+		return first, true
+	}
+	return second, true
+}
+
+// clampRate limits an estimated embedding rate to the range it can have
+func clampRate(rate float64) float64 {
+	if math.IsNaN(rate) {
+		return 0
+	}
+	return min(max(rate, 0), 1)
+}
@@ -0,0 +1,184 @@
+package steganalysis
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// smoothGray returns a gray image of smooth gradients with a little noise, as a stand-in for a photograph.
+// With combed set, every value is made even first, the uneven histogram a contrast stretch leaves behind,
+// which the chi-square attack needs to tell a clean image from one with random low bits.
+func smoothGray(width, height int, combed bool, seed int64) *image.Gray {
+	rng := rand.New(rand.NewSource(seed))
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := 128 + 60*math.Sin(float64(x)/17) + 40*math.Cos(float64(y)/23+float64(x)/31) + rng.NormFloat64()*3
+			g := uint8(min(max(v, 0), 255))
+			if combed {
+				g &^= 1
+			}
+			img.SetGray(x, y, color.Gray{Y: g})
+		}
+	}
+	return img
+}
+
+// embedLSB replaces the low bit of a fraction rate of the samples with a random bit
+func embedLSB(img *image.Gray, rate float64, seed int64) *image.Gray {
+	rng := rand.New(rand.NewSource(seed))
+	out := image.NewGray(img.Rect)
+	copy(out.Pix, img.Pix)
+	for i := range out.Pix {
+		if rng.Float64() < rate {
+			out.Pix[i] = out.Pix[i]&^1 | uint8(rng.Intn(2))
+		}
+	}
+	return out
+}
+
+func analyzeGray(t *testing.T, img *image.Gray) ChannelReport {
+	t.Helper()
+	report, err := AnalyzeImage(img)
+	if err != nil {
+		t.Fatalf("AnalyzeImage: %v", err)
+	}
+	if len(report.Channels) != 1 || report.Channels[0].Channel != "Y" {
+		t.Fatalf("expected a single Y channel, got %+v", report.Channels)
+	}
+	return report.Channels[0]
+}
+
+func TestUpperGamma(t *testing.T) {
+	tests := []struct {
+		a, x, want float64
+	}{
+		{1, 2, math.Exp(-2)},
+		{5, 3, math.Exp(-3) * (1 + 3 + 4.5 + 4.5 + 3.375)},
+		{2, 10, math.Exp(-10) * 11},
+		{0.5, 0, 1},
+	}
+	for _, tt := range tests {
+		if got := upperGamma(tt.a, tt.x); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("upperGamma(%v, %v) = %v, want %v", tt.a, tt.x, got, tt.want)
+		}
+	}
+}
+
+func TestEstimatedRates(t *testing.T) {
+	cover := smoothGray(256, 256, false, 1901)
+	for _, rate := range []float64{0, 0.25, 0.5, 0.75} {
+		channel := analyzeGray(t, embedLSB(cover, rate, 1902))
+		for name, got := range map[string]float64{"RS": channel.RSRate, "sample pair": channel.SamplePairRate, "combined": channel.EmbeddingRate} {
+			if math.Abs(got-rate) > 0.1 {
+				t.Errorf("%s estimate %.3f for an embedding rate of %.2f", name, got, rate)
+			}
+		}
+	}
+}
+
+func TestChiSquare(t *testing.T) {
+	cover := smoothGray(200, 200, true, 1903)
+	if channel := analyzeGray(t, cover); channel.ChiSquareProbability > 0.01 || channel.ChiSquareRate > 0.1 {
+		t.Errorf("clean image: probability %.3f over %.2f of it", channel.ChiSquareProbability, channel.ChiSquareRate)
+	}
+	if channel := analyzeGray(t, embedLSB(cover, 1, 1904)); channel.ChiSquareProbability < 0.99 || channel.ChiSquareRate != 1 {
+		t.Errorf("fully embedded image: probability %.3f over %.2f of it", channel.ChiSquareProbability, channel.ChiSquareRate)
+	}
+
+	// Fill the left 40% column by column, the way go-steg fills an unscattered carrier
+	partial := embedLSB(cover, 0, 0)
+	rng := rand.New(rand.NewSource(1905))
+	for x := 0; x < 80; x++ {
+		for y := 0; y < 200; y++ {
+			partial.Pix[partial.PixOffset(x, y)] |= uint8(rng.Intn(2))
+		}
+	}
+	if channel := analyzeGray(t, partial); math.Abs(channel.ChiSquareRate-0.4) > 0.05 {
+		t.Errorf("chi-square rate %.2f for the left 40%% embedded", channel.ChiSquareRate)
+	}
+}
+
+func TestAnalyzeChannels(t *testing.T) {
+	gray := smoothGray(64, 64, false, 1906)
+	opaque := image.NewNRGBA(gray.Rect)
+	translucent := image.NewNRGBA(gray.Rect)
+	deep := image.NewNRGBA64(gray.Rect)
+	paletted := image.NewPaletted(gray.Rect, color.Palette{color.Black, color.White})
+	for i, v := range gray.Pix {
+		opaque.Pix[4*i], opaque.Pix[4*i+3] = v, 255
+		translucent.Pix[4*i], translucent.Pix[4*i+3] = v, v
+		deep.Pix[8*i+1], deep.Pix[8*i+6], deep.Pix[8*i+7] = v, 255, 255
+	}
+
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, opaque, nil); err != nil {
+		t.Fatalf("jpeg.Encode: %v", err)
+	}
+	jpegReport, err := Analyze(bytes.NewReader(encoded.Bytes()))
+	if err != nil {
+		t.Fatalf("Analyze: %v", err)
+	}
+	if jpegReport.Coefficients == nil || jpegReport.Coefficients.Coefficients == 0 {
+		t.Error("the coefficients of a baseline JPEG were not analyzed")
+	}
+
+	encoded.Reset()
+	if err := png.Encode(&encoded, deep); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+	deepReport, err := Analyze(bytes.NewReader(encoded.Bytes()))
+	if err != nil {
+		t.Fatalf("Analyze: %v", err)
+	}
+	if deepReport.Coefficients != nil {
+		t.Error("a PNG has no coefficients")
+	}
+
+	tests := []struct {
+		name string
+		img  image.Image
+		want []string
+	}{
+		{"opaque", opaque, []string{"R", "G", "B"}},
+		{"translucent", translucent, []string{"R", "G", "B", "A"}},
+		{"paletted", paletted, []string{"Index"}},
+	}
+	for _, tt := range tests {
+		report, err := AnalyzeImage(tt.img)
+		if err != nil {
+			t.Fatalf("AnalyzeImage (%s): %v", tt.name, err)
+		}
+		var got []string
+		for _, channel := range report.Channels {
+			got = append(got, channel.Channel)
+			if channel.Samples != 64*64 {
+				t.Errorf("%s: channel %s has %d samples", tt.name, channel.Channel, channel.Samples)
+			}
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: channels %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// The red channel holds the gray values in the low byte of each 16-bit sample, and is analyzed as such
+	if got, want := deepReport.Channels[0].RSRate, analyzeGray(t, gray).RSRate; got != want {
+		t.Errorf("16-bit RS estimate %.3f, want %.3f", got, want)
+	}
+}
+
+func TestAnalyzeRejects(t *testing.T) {
+	if _, err := AnalyzeImage(image.NewGray(image.Rect(0, 0, 0, 0))); !errors.Is(err, ErrEmptyImage) {
+		t.Errorf("empty image: got %v, want %v", err, ErrEmptyImage)
+	}
+	if _, err := Analyze(bytes.NewReader([]byte("not an image"))); err == nil {
+		t.Error("expected an error for data that is not an image")
+	}
+}